@@ -1,10 +1,12 @@
 package node
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/FinnTew/FincasKV/cluster/command"
 	"github.com/FinnTew/FincasKV/cluster/fsm"
 	"github.com/FinnTew/FincasKV/database"
+	"github.com/FinnTew/FincasKV/network/protocol"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 	"log"
@@ -28,6 +30,8 @@ type Config struct {
 	RaftBind  string
 	JoinAddr  string
 	Bootstrap bool
+	// RepairPeers 其他节点的服务地址，后台校验发现损坏的键时从其中的领导者取回，为空时只报告不恢复
+	RepairPeers []string
 }
 
 func New(db *database.FincasDB, conf *Config) (*Node, error) {
@@ -42,6 +46,9 @@ func New(db *database.FincasDB, conf *Config) (*Node, error) {
 	if err := node.setupRaft(); err != nil {
 		return nil, fmt.Errorf("failed to setup raft node: %v", err)
 	}
+	if len(conf.RepairPeers) > 0 {
+		db.SetScrubRepair(node.fetchFromPeers)
+	}
 
 	return node, nil
 }
@@ -155,12 +162,89 @@ func (n *Node) IsLeader() bool {
 	return n.raft.State() == raft.Leader
 }
 
+// ReadBarrier 确认本节点仍是领导者，并等待此前提交的日志全部应用到状态机；
+// 之后读到的值不会旧于任何节点已应用的值
+func (n *Node) ReadBarrier() error {
+	if !n.IsLeader() {
+		return fmt.Errorf("raft is not leader")
+	}
+	if err := n.raft.Barrier(10 * time.Second).Error(); err != nil {
+		return fmt.Errorf("failed to wait for raft barrier: %v", err)
+	}
+	return nil
+}
+
 func (n *Node) GetLeaderAddr() string {
 	addr, _ := n.raft.LeaderWithID()
 	return string(addr)
 }
 
 func (n *Node) Shutdown() error {
+	if len(n.conf.RepairPeers) > 0 {
+		n.db.SetScrubRepair(nil)
+	}
 	future := n.raft.Shutdown()
 	return future.Error()
 }
+
+// fetchFromPeers 通过 CLUSTER FETCH 向 RepairPeers 中的领导者取回键的值
+// 跟随者上的值可能旧于本节点损坏前的值，只有领导者在 ReadBarrier 之后的回复会被接受，其他节点拒绝请求；
+// 本节点是领导者时没有更新的副本可用，不做恢复
+func (n *Node) fetchFromPeers(keys []string) (map[string][]byte, error) {
+	if n.IsLeader() {
+		return nil, fmt.Errorf("local node is the leader, no up-to-date replica to repair from")
+	}
+	var lastErr error
+	for _, peer := range n.conf.RepairPeers {
+		values, err := fetchKeys(peer, keys)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return values, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no repair peer configured")
+	}
+	return nil, fmt.Errorf("failed to fetch %d keys from leader: %v", len(keys), lastErr)
+}
+
+// fetchKeys 向 addr 发送一次 CLUSTER FETCH，返回对方存在的键；对方不是领导者时返回错误
+func fetchKeys(addr string, keys []string) (map[string][]byte, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		return nil, err
+	}
+
+	req := make([][]byte, 0, len(keys)+2)
+	req = append(req, []byte("CLUSTER"), []byte("FETCH"))
+	for _, key := range keys {
+		req = append(req, []byte(key))
+	}
+	w := bufio.NewWriter(conn)
+	if err := protocol.NewWriter(w).WriteArray(req); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	reply, err := protocol.NewParser(conn).ParseBulkArray()
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != len(keys) {
+		return nil, fmt.Errorf("unexpected CLUSTER FETCH reply length %d, want %d", len(reply), len(keys))
+	}
+	values := make(map[string][]byte, len(keys))
+	for i, value := range reply {
+		if value != nil {
+			values[keys[i]] = value
+		}
+	}
+	return values, nil
+}
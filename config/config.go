@@ -9,7 +9,9 @@ import (
 )
 
 type BaseConfig struct {
-	DataDir string
+	DataDir    string
+	BackupDir  string
+	RestoreDir string
 }
 
 type NetworkConfig struct {
@@ -23,6 +25,8 @@ type NetworkConfig struct {
 type MemIndexConfig struct {
 	DataStructure         string
 	ShardCount            int
+	Sharding              string
+	RangeBounds           []string
 	BTreeDegree           int
 	SwissTableInitialSize int
 }
@@ -31,18 +35,44 @@ type MemCacheConfig struct {
 	Enable        bool
 	DataStructure string
 	Size          int
+	CapacityBytes int64
+}
+
+type BloomFilterConfig struct {
+	ExpectedElements  uint64
+	FalsePositiveRate float64
 }
 
 type FileManagerConfig struct {
 	MaxSize      int
 	MaxOpened    int
 	SyncInterval time.Duration
+	Durability   string
+	Recovery     string
+	MmapReads    bool
+	MmapBudget   int64
+	WriteLanes   int
+
+	Compression       string
+	CompressThreshold int
+
+	EncryptionKeyFile string
+
+	BlobThreshold int
 }
 
 type MergeConfig struct {
 	Auto     bool
 	Interval time.Duration
 	MinRatio float64
+
+	BlobGCRatio float64
+}
+
+type ScrubConfig struct {
+	Interval    time.Duration
+	Rate        int64
+	RepairPeers []string
 }
 
 type Config struct {
@@ -50,8 +80,10 @@ type Config struct {
 	Network     NetworkConfig
 	MemIndex    MemIndexConfig
 	MemCache    MemCacheConfig
+	BloomFilter BloomFilterConfig
 	FileManager FileManagerConfig
 	Merge       MergeConfig
+	Scrub       ScrubConfig
 }
 
 var (
@@ -70,9 +102,13 @@ func loadConfig(v *viper.Viper) *Config {
 	cfg := &Config{}
 
 	cfg.Base.DataDir = v.GetString("base.data_dir")
+	cfg.Base.BackupDir = v.GetString("base.backup_dir")
+	cfg.Base.RestoreDir = v.GetString("base.restore_dir")
 
 	cfg.MemIndex.DataStructure = v.GetString("mem_index.data_structure")
 	cfg.MemIndex.ShardCount = v.GetInt("mem_index.shard_count")
+	cfg.MemIndex.Sharding = v.GetString("mem_index.sharding")
+	cfg.MemIndex.RangeBounds = v.GetStringSlice("mem_index.range_bounds")
 	cfg.MemIndex.BTreeDegree = v.GetInt("mem_index.btree_degree")
 	cfg.MemIndex.SwissTableInitialSize = v.GetInt("mem_index.swiss_table_initial_size")
 
@@ -85,14 +121,32 @@ func loadConfig(v *viper.Viper) *Config {
 	cfg.MemCache.Enable = v.GetBool("mem_cache.enable")
 	cfg.MemCache.DataStructure = v.GetString("mem_cache.data_structure")
 	cfg.MemCache.Size = v.GetInt("mem_cache.size")
+	cfg.MemCache.CapacityBytes = v.GetInt64("mem_cache.capacity_bytes")
+
+	cfg.BloomFilter.ExpectedElements = v.GetUint64("bloom_filter.expected_elements")
+	cfg.BloomFilter.FalsePositiveRate = v.GetFloat64("bloom_filter.false_positive_rate")
 
 	cfg.FileManager.MaxSize = v.GetInt("file_manager.max_size")
 	cfg.FileManager.MaxOpened = v.GetInt("file_manager.max_opened")
 	cfg.FileManager.SyncInterval = v.GetDuration("file_manager.sync_interval")
+	cfg.FileManager.Durability = v.GetString("file_manager.durability")
+	cfg.FileManager.Recovery = v.GetString("file_manager.recovery")
+	cfg.FileManager.MmapReads = v.GetBool("file_manager.mmap_reads")
+	cfg.FileManager.MmapBudget = v.GetInt64("file_manager.mmap_budget")
+	cfg.FileManager.WriteLanes = v.GetInt("file_manager.write_lanes")
+	cfg.FileManager.Compression = v.GetString("file_manager.compression")
+	cfg.FileManager.CompressThreshold = v.GetInt("file_manager.compress_threshold")
+	cfg.FileManager.EncryptionKeyFile = v.GetString("file_manager.encryption_key_file")
+	cfg.FileManager.BlobThreshold = v.GetInt("file_manager.blob_threshold")
 
 	cfg.Merge.Auto = v.GetBool("merge.auto")
 	cfg.Merge.Interval = v.GetDuration("merge.interval")
 	cfg.Merge.MinRatio = v.GetFloat64("merge.min_ratio")
+	cfg.Merge.BlobGCRatio = v.GetFloat64("merge.blob_gc_ratio")
+
+	cfg.Scrub.Interval = v.GetDuration("scrub.interval")
+	cfg.Scrub.Rate = v.GetInt64("scrub.rate")
+	cfg.Scrub.RepairPeers = v.GetStringSlice("scrub.repair_peers")
 
 	return cfg
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/FinnTew/FincasKV/err_def"
+	"github.com/FinnTew/FincasKV/storage/bitcask"
 	"sort"
 	"sync"
 	"time"
@@ -284,79 +285,43 @@ func (wb *WriteBatch) executeOperations(ctx context.Context) error {
 		return fmt.Errorf("batch execution cancelled: %w", err)
 	}
 
+	// 数据操作作为一个批次原子写入，崩溃后不会只留下一部分
+	ops := make([]bitcask.BatchOp, 0, len(wb.operations))
 	for _, op := range wb.operations {
-		select {
-		case <-ctx.Done():
-			wb.rollback(op)
-			return ctx.Err()
-		default:
-			if err := wb.executeOperation(op); err != nil {
-				wb.rollback(op)
-				return err
-			}
-		}
-	}
-
-	if wb.db.needFlush && wb.db.dbOpts.FlushTTLOnChange {
-		if err := wb.db.saveTTLMetadata(); err != nil {
-			return fmt.Errorf("failed to save TTL metadata: %w", err)
+		switch op.typ {
+		case OpPut:
+			ops = append(ops, bitcask.BatchOp{Key: op.key, Value: []byte(op.value)})
+		case OpDelete:
+			ops = append(ops, bitcask.BatchOp{Key: op.key, Delete: true})
 		}
-		wb.db.needFlush = false
 	}
-
-	wb.committed = true
-	return nil
-}
-
-func (wb *WriteBatch) executeOperation(op operation) error {
-	var err error
-	switch op.typ {
-	case OpPut:
-		err = wb.db.bc.Put(op.key, []byte(op.value))
-	case OpDelete:
-		err = wb.db.bc.Del(op.key)
-		if err == nil {
-			delete(wb.db.expireMap, op.key)
-		}
-	case OpExpire:
-		expireAt := op.created.Add(op.ttl)
-		wb.db.expireMap[op.key] = expireAt
-		wb.db.needFlush = true
-	case OpPersist:
-		delete(wb.db.expireMap, op.key)
-		wb.db.needFlush = true
+	if err := wb.db.bc.ApplyBatch(ops); err != nil {
+		return err
 	}
 
-	return err
-}
-
-func (wb *WriteBatch) rollback(failedOp operation) {
-	// 倒序遍历已执行的操作回滚
-	for i := len(wb.operations) - 1; i >= 0; i-- {
-		op := wb.operations[i]
-		if op == failedOp {
-			break
-		}
-
+	// 数据写入成功后再更新过期信息
+	for _, op := range wb.operations {
 		switch op.typ {
-		case OpPut:
-			// 删除已写入的数据
-			_ = wb.db.bc.Del(op.key)
 		case OpDelete:
-			// 恢复删除的数据
-			if val, err := wb.db.Get(op.key); err == nil {
-				_ = wb.db.bc.Put(op.key, []byte(val))
-			}
-		case OpExpire:
-			// 取消过期
 			delete(wb.db.expireMap, op.key)
+		case OpExpire:
+			wb.db.expireMap[op.key] = op.created.Add(op.ttl)
+			wb.db.needFlush = true
 		case OpPersist:
-			// 恢复过期
-			if expAt, ok := wb.db.expireMap[op.key]; ok {
-				wb.db.expireMap[op.key] = expAt
-			}
+			delete(wb.db.expireMap, op.key)
+			wb.db.needFlush = true
 		}
 	}
+
+	if wb.db.needFlush && wb.db.dbOpts.FlushTTLOnChange {
+		wb.db.needFlush = false
+		if err := wb.db.saveTTLMetadataLocked(); err != nil {
+			return fmt.Errorf("failed to save TTL metadata: %w", err)
+		}
+	}
+
+	wb.committed = true
+	return nil
 }
 
 func (wb *WriteBatch) Clear() {
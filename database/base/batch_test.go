@@ -0,0 +1,33 @@
+package base
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestWriteBatchFlushesTTL(t *testing.T) {
+	opts := DefaultBaseDBOptions()
+	opts.FlushTTLOnChange = true
+	db := openTestDB(t, opts)
+
+	require.NoError(t, db.Put("a", "1"))
+
+	// 批次在持有 expireMu 的同时写入 TTL 元数据
+	wb := db.NewWriteBatch(nil)
+	defer wb.Release()
+	require.NoError(t, wb.Put("b", "2"))
+	require.NoError(t, wb.Expire("a", time.Hour))
+	require.NoError(t, wb.Commit())
+
+	db.expireMu.Lock()
+	db.expireMap = make(map[string]time.Time)
+	db.expireMu.Unlock()
+	require.NoError(t, db.loadTTLMetadata())
+	db.expireMu.RLock()
+	_, ok := db.expireMap["a"]
+	db.expireMu.RUnlock()
+	assert.True(t, ok)
+	assert.False(t, db.isExpired("b"))
+}
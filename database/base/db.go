@@ -2,11 +2,13 @@ package base
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/FinnTew/FincasKV/err_def"
 	"github.com/FinnTew/FincasKV/storage"
 	"github.com/FinnTew/FincasKV/storage/bitcask"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -141,6 +143,17 @@ func (db *DB) Expire(key string, ttl time.Duration) error {
 }
 
 func (db *DB) Keys(pattern string) ([]string, error) {
+	// 纯前缀匹配时优先使用有序迭代器，只遍历前缀范围内的键
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, "*?[]\\") {
+		keys, err := db.scanPrefix(prefix)
+		if err == nil {
+			return keys, nil
+		}
+		if !errors.Is(err, err_def.ErrIndexUnordered) {
+			return nil, err
+		}
+	}
+
 	allKeys, err := db.bc.ListKeys()
 	if err != nil {
 		return nil, err
@@ -190,6 +203,29 @@ func (db *DB) Keys(pattern string) ([]string, error) {
 	return results, nil
 }
 
+// scanPrefix 通过有序迭代器列出带前缀且未过期的键，索引无序时返回 ErrIndexUnordered
+func (db *DB) scanPrefix(prefix string) ([]string, error) {
+	it, err := db.bc.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var results []string
+	now := time.Now()
+	for ; it.Valid(); it.Next() {
+		k := it.Key()
+		db.expireMu.RLock()
+		expAt, ok := db.expireMap[k]
+		db.expireMu.RUnlock()
+		if ok && now.After(expAt) {
+			continue
+		}
+		results = append(results, k)
+	}
+	return results, nil
+}
+
 func (db *DB) Type(key string) (string, error) {
 	if db.isExpired(key) {
 		_ = db.deleteExpiredKey(key)
@@ -250,12 +286,14 @@ func (db *DB) expirationWorker(interval time.Duration) {
 		case <-ticker.C:
 			db.evictExpiredKeys()
 
+			// saveTTLMetadata 内部会获取读锁，不能在持有写锁时调用
 			db.expireMu.Lock()
-			if db.needFlush {
+			flush := db.needFlush
+			db.needFlush = false
+			db.expireMu.Unlock()
+			if flush {
 				_ = db.saveTTLMetadata()
-				db.needFlush = false
 			}
-			db.expireMu.Unlock()
 		}
 	}
 }
@@ -295,13 +333,17 @@ func (db *DB) deleteExpiredKey(key string) error {
 }
 
 func (db *DB) loadTTLMetadata() error {
-	f, err := os.Open(db.ttlPath)
+	data, err := os.ReadFile(db.ttlPath)
+	if err != nil {
+		return err
+	}
+	// 开启静态加密时 TTL 元数据同样加密
+	data, err = db.bc.OpenMetadata(data)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		line := scanner.Text()
 		parts := strings.SplitN(line, " ", 2)
@@ -319,26 +361,46 @@ func (db *DB) loadTTLMetadata() error {
 }
 
 func (db *DB) saveTTLMetadata() error {
-	tmpFile := db.ttlPath + ".tmp"
-	f, err := os.Create(tmpFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return db.writeTTLMetadata(db.ttlPath)
+}
 
-	writer := bufio.NewWriter(f)
+// saveTTLMetadataLocked 与 saveTTLMetadata 相同，调用方需持有 expireMu
+func (db *DB) saveTTLMetadataLocked() error {
+	return db.writeTTLFile(db.ttlPath, db.encodeTTLMetadata())
+}
 
+// writeTTLMetadata 将 TTL 元数据写入 path，只在编码期间持有 expireMu 读锁
+func (db *DB) writeTTLMetadata(path string) error {
 	db.expireMu.RLock()
+	plain := db.encodeTTLMetadata()
+	db.expireMu.RUnlock()
+	return db.writeTTLFile(path, plain)
+}
+
+// encodeTTLMetadata 编码过期时间表，调用方需持有 expireMu
+func (db *DB) encodeTTLMetadata() []byte {
+	var buf bytes.Buffer
 	for k, expAt := range db.expireMap {
-		line := fmt.Sprintf("%s %d\n", k, expAt.UnixNano())
-		if _, werr := writer.WriteString(line); werr != nil {
-			db.expireMu.RUnlock()
-			return werr
-		}
+		fmt.Fprintf(&buf, "%s %d\n", k, expAt.UnixNano())
 	}
-	db.expireMu.RUnlock()
+	return buf.Bytes()
+}
+
+// writeTTLFile 加密后写入 path，先写临时文件再原子重命名
+func (db *DB) writeTTLFile(path string, plain []byte) error {
+	data, err := db.bc.SealMetadata(plain)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := path + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	if err := writer.Flush(); err != nil {
+	if _, err := f.Write(data); err != nil {
 		return err
 	}
 	if err := f.Sync(); err != nil {
@@ -348,5 +410,45 @@ func (db *DB) saveTTLMetadata() error {
 		return err
 	}
 
-	return os.Rename(tmpFile, db.ttlPath)
+	return os.Rename(tmpFile, path)
+}
+
+// Checkpoint 在 dir 下创建包含数据文件与 TTL 元数据的一致性检查点
+// TTL 元数据在检查点描述文件之前写入，写入失败时检查点不生效
+func (db *DB) Checkpoint(dir string) (*file_manager.Checkpoint, error) {
+	return db.bc.Checkpoint(dir, func(dir string) error {
+		if err := db.writeTTLMetadata(filepath.Join(dir, db.dbOpts.TTLMetadataFile)); err != nil {
+			return fmt.Errorf("failed to save TTL metadata: %w", err)
+		}
+		return nil
+	})
+}
+
+// Subscribe 订阅 from 之后已提交的变更，事件中的键为存储层编码后的键
+func (db *DB) Subscribe(from bitcask.Position) (*bitcask.Subscription, error) {
+	return db.bc.Subscribe(from)
+}
+
+// LastSequence 返回最近一次写入的序列号
+func (db *DB) LastSequence() uint64 {
+	return db.bc.LastSequence()
+}
+
+// SetScrubRepair 设置后台校验发现损坏时取回键值的方式
+func (db *DB) SetScrubRepair(fn bitcask.ScrubRepairFunc) {
+	db.bc.SetScrubRepair(fn)
+}
+
+// FetchRaw 读取存储层的原始值，供其他节点恢复损坏的键；不存在或已过期的键不在结果中
+func (db *DB) FetchRaw(keys []string) map[string][]byte {
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if db.isExpired(key) {
+			continue
+		}
+		if value, err := db.bc.Get(key); err == nil {
+			values[key] = value
+		}
+	}
+	return values
 }
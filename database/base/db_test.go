@@ -0,0 +1,51 @@
+package base
+
+import (
+	"github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/bitcask"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointTTL(t *testing.T) {
+	opts := DefaultBaseDBOptions()
+	db := openTestDB(t, opts)
+	require.NoError(t, db.Put("a", "1"))
+	require.NoError(t, db.Put("b", "2"))
+	require.NoError(t, db.Expire("a", time.Hour))
+
+	cpDir := filepath.Join(t.TempDir(), "cp")
+	_, err := db.Checkpoint(cpDir)
+	require.NoError(t, err)
+
+	// 恢复后过期时间仍在
+	restoreDir := filepath.Join(t.TempDir(), "restore")
+	_, err = bitcask.RestoreCheckpoint(cpDir, restoreDir)
+	require.NoError(t, err)
+	restored, err := NewDB(DefaultBaseDBOptions(), storage.WithDataDir(restoreDir), storage.WithAutoMerge(false))
+	require.NoError(t, err)
+	defer restored.Close()
+	restored.expireMu.RLock()
+	expAt, ok := restored.expireMap["a"]
+	restored.expireMu.RUnlock()
+	require.True(t, ok)
+	assert.True(t, expAt.After(time.Now()))
+	val, err := restored.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", val)
+
+	// TTL 元数据写入失败时目录中没有检查点描述文件，不能被恢复
+	opts.TTLMetadataFile = filepath.Join("missing", "ttl.data")
+	failDir := filepath.Join(t.TempDir(), "fail")
+	_, err = db.Checkpoint(failDir)
+	require.Error(t, err)
+	_, err = os.Stat(filepath.Join(failDir, file_manager.CheckpointFileName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = bitcask.RestoreCheckpoint(failDir, filepath.Join(t.TempDir(), "restore-fail"))
+	assert.Error(t, err)
+}
@@ -6,6 +6,7 @@ type BaseDBOptions struct {
 	ExpireCheckInterval time.Duration
 	TTLMetadataFile     string
 	FlushTTLOnChange    bool
+	TxnMaxRetries       int // 事务冲突时的最大重试次数
 }
 
 func DefaultBaseDBOptions() *BaseDBOptions {
@@ -13,5 +14,6 @@ func DefaultBaseDBOptions() *BaseDBOptions {
 		ExpireCheckInterval: 1 * time.Minute,
 		TTLMetadataFile:     "ttl.data",
 		FlushTTLOnChange:    false,
+		TxnMaxRetries:       100,
 	}
 }
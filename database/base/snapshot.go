@@ -0,0 +1,54 @@
+package base
+
+import (
+	"github.com/FinnTew/FincasKV/err_def"
+	"github.com/FinnTew/FincasKV/storage/bitcask"
+)
+
+// Snapshot 固定在创建时刻的只读视图，多键读取在同一个快照上进行可看到一致的状态
+// 过期按读取时的时间判断，快照不会删除过期键
+type Snapshot struct {
+	db   *DB
+	snap *bitcask.Snapshot
+}
+
+// NewSnapshot 创建快照，用完后必须调用 Release
+func (db *DB) NewSnapshot() (*Snapshot, error) {
+	snap, err := db.bc.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{db: db, snap: snap}, nil
+}
+
+func (s *Snapshot) Get(key string) (string, error) {
+	if s.db.isExpired(key) {
+		return "", err_def.ErrKeyNotFound
+	}
+
+	val, err := s.snap.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+// Keys 返回快照中带有 prefix 前缀且未过期的键
+func (s *Snapshot) Keys(prefix string) ([]string, error) {
+	keys, err := s.snap.Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	results := keys[:0]
+	for _, k := range keys {
+		if !s.db.isExpired(k) {
+			results = append(results, k)
+		}
+	}
+	return results, nil
+}
+
+func (s *Snapshot) Release() {
+	s.snap.Release()
+}
@@ -0,0 +1,162 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	"github.com/FinnTew/FincasKV/storage/bitcask"
+	"runtime"
+	"time"
+)
+
+// Txn 乐观读写事务
+// 读取时记录键的版本，写入先缓存在事务中，提交时在键锁下校验读过的键未被修改，再原子写入全部修改
+type Txn struct {
+	db     *DB
+	reads  map[string]bitcask.BatchCond
+	writes map[string]txnWrite
+	order  []string // 写入键的首次写入顺序
+}
+
+type txnWrite struct {
+	value  string
+	delete bool
+}
+
+// Update 在事务中执行 fn，fn 返回 nil 时提交
+// 提交时发现冲突会重新执行 fn，超过 TxnMaxRetries 次仍冲突时返回 ErrTxnConflict，
+// 因此 fn 可能被执行多次，不应有事务之外的副作用
+func (db *DB) Update(fn func(txn *Txn) error) error {
+	for attempt := 0; ; attempt++ {
+		txn := &Txn{
+			db:     db,
+			reads:  make(map[string]bitcask.BatchCond),
+			writes: make(map[string]txnWrite),
+		}
+		if err := fn(txn); err != nil {
+			return err
+		}
+
+		err := txn.commit()
+		if !errors.Is(err, err_def.ErrTxnConflict) {
+			return err
+		}
+		if attempt >= db.dbOpts.TxnMaxRetries {
+			return err
+		}
+		runtime.Gosched()
+	}
+}
+
+// Get 读取键值，能读到本事务中尚未提交的写入
+func (txn *Txn) Get(key string) (string, error) {
+	if len(key) == 0 {
+		return "", err_def.ErrEmptyKey
+	}
+	if w, ok := txn.writes[key]; ok {
+		if w.delete {
+			return "", err_def.ErrKeyNotFound
+		}
+		return w.value, nil
+	}
+
+	if txn.db.isExpired(key) {
+		_ = txn.db.deleteExpiredKey(key)
+	}
+
+	val, seq, err := txn.db.bc.GetVersion(key)
+	if err != nil {
+		if !errors.Is(err, err_def.ErrKeyNotFound) {
+			return "", err
+		}
+		txn.observe(key, 0, false)
+		return "", err_def.ErrKeyNotFound
+	}
+	txn.observe(key, seq, true)
+	return string(val), nil
+}
+
+func (txn *Txn) Exists(key string) (bool, error) {
+	_, err := txn.Get(key)
+	if err != nil {
+		if errors.Is(err, err_def.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (txn *Txn) Put(key, value string) error {
+	if len(key) == 0 {
+		return err_def.ErrEmptyKey
+	}
+	txn.write(key, txnWrite{value: value})
+	return nil
+}
+
+func (txn *Txn) Delete(key string) error {
+	if len(key) == 0 {
+		return err_def.ErrEmptyKey
+	}
+	txn.write(key, txnWrite{delete: true})
+	return nil
+}
+
+// observe 记录键第一次被读取时的版本
+func (txn *Txn) observe(key string, seq uint64, exists bool) {
+	if _, ok := txn.reads[key]; !ok {
+		txn.reads[key] = bitcask.BatchCond{Key: key, Seq: seq, Exists: exists}
+	}
+}
+
+func (txn *Txn) write(key string, w txnWrite) {
+	if _, ok := txn.writes[key]; !ok {
+		txn.order = append(txn.order, key)
+	}
+	txn.writes[key] = w
+}
+
+// commit 校验读集合并原子写入，只读事务无需校验
+func (txn *Txn) commit() error {
+	if len(txn.writes) == 0 {
+		return nil
+	}
+
+	ops := make([]bitcask.BatchOp, 0, len(txn.order))
+	for _, key := range txn.order {
+		w := txn.writes[key]
+		ops = append(ops, bitcask.BatchOp{Key: key, Value: []byte(w.value), Delete: w.delete})
+	}
+	conds := make([]bitcask.BatchCond, 0, len(txn.reads))
+	for _, c := range txn.reads {
+		conds = append(conds, c)
+	}
+
+	db := txn.db
+	db.expireMu.Lock()
+	defer db.expireMu.Unlock()
+
+	// 读取时存在、提交前已过期的键视为已被删除，与其他修改一样按冲突处理
+	now := time.Now()
+	for _, c := range conds {
+		if expAt, ok := db.expireMap[c.Key]; ok && c.Exists && now.After(expAt) {
+			return err_def.ErrTxnConflict
+		}
+	}
+
+	if err := db.bc.ApplyBatchIf(ops, conds); err != nil {
+		if errors.Is(err, err_def.ErrTxnConflict) {
+			return err
+		}
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+
+	// 被删除的键不再保留过期时间，与 WriteBatch 一致
+	for _, key := range txn.order {
+		if txn.writes[key].delete {
+			delete(db.expireMap, key)
+		}
+	}
+	return nil
+}
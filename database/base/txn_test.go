@@ -0,0 +1,126 @@
+package base
+
+import (
+	"errors"
+	"github.com/FinnTew/FincasKV/err_def"
+	"github.com/FinnTew/FincasKV/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T, dbOpts *BaseDBOptions) *DB {
+	t.Helper()
+	db, err := NewDB(dbOpts, storage.WithDataDir(t.TempDir()), storage.WithAutoMerge(false))
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	return db
+}
+
+// incr 在事务中把键的整数值加一，键不存在时视为 0
+func incr(txn *Txn, key string) error {
+	val, err := txn.Get(key)
+	n := 0
+	switch {
+	case err == nil:
+		if n, err = strconv.Atoi(val); err != nil {
+			return err
+		}
+	case !errors.Is(err, err_def.ErrKeyNotFound):
+		return err
+	}
+	return txn.Put(key, strconv.Itoa(n+1))
+}
+
+func TestTxnConcurrentIncr(t *testing.T) {
+	opts := DefaultBaseDBOptions()
+	opts.TxnMaxRetries = 10000
+	db := openTestDB(t, opts)
+
+	const workers, rounds = 8, 50
+	var attempts sync.Map
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				err := db.Update(func(txn *Txn) error {
+					n, _ := attempts.LoadOrStore(w, new(int))
+					*n.(*int)++
+					return incr(txn, "counter")
+				})
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 冲突的事务重试后不会丢失任何一次自增
+	val, err := db.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers*rounds), val)
+	total := 0
+	attempts.Range(func(_, n any) bool {
+		total += *n.(*int)
+		return true
+	})
+	assert.GreaterOrEqual(t, total, workers*rounds)
+}
+
+func TestTxnRetryExhausted(t *testing.T) {
+	opts := DefaultBaseDBOptions()
+	opts.TxnMaxRetries = 3
+	db := openTestDB(t, opts)
+	require.NoError(t, db.Put("counter", "0"))
+
+	// 每次执行期间都有事务之外的写入修改读过的键
+	calls := 0
+	err := db.Update(func(txn *Txn) error {
+		calls++
+		if err := incr(txn, "counter"); err != nil {
+			return err
+		}
+		return db.Put("counter", strconv.Itoa(1000+calls))
+	})
+	assert.ErrorIs(t, err, err_def.ErrTxnConflict)
+	assert.Equal(t, opts.TxnMaxRetries+1, calls)
+
+	val, err := db.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(1000+calls), val)
+}
+
+func TestTxnKeyExpiresMidTransaction(t *testing.T) {
+	db := openTestDB(t, nil)
+	require.NoError(t, db.Put("counter", "41"))
+	require.NoError(t, db.Expire("counter", 50*time.Millisecond))
+
+	// 第一次执行读到键后键过期，期间没有其他访问触发删除，提交时仍视为冲突；
+	// 重试时键已不存在，从 0 开始自增
+	var seen []string
+	err := db.Update(func(txn *Txn) error {
+		val, err := txn.Get("counter")
+		if err != nil {
+			seen = append(seen, "missing")
+			return txn.Put("counter", "1")
+		}
+		seen = append(seen, val)
+		time.Sleep(100 * time.Millisecond)
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		return txn.Put("counter", strconv.Itoa(n+1))
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"41", "missing"}, seen)
+
+	val, err := db.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, "1", val)
+	assert.False(t, db.isExpired("counter"))
+}
@@ -1,13 +1,25 @@
 package database
 
 import (
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/FinnTew/FincasKV/config"
 	redis2 "github.com/FinnTew/FincasKV/database/redis"
+	"github.com/FinnTew/FincasKV/err_def"
 	"github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/bitcask"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type FincasDB struct {
+	dw *redis2.DBWrapper
+
 	*redis2.RString
 	*redis2.RHash
 	*redis2.RList
@@ -20,9 +32,16 @@ func NewFincasDB(dataDir string) *FincasDB {
 	conf := config.Get()
 
 	if conf.Base.DataDir != "" && dataDir == "" {
-		bcOpts = append(bcOpts, storage.WithDataDir(conf.Base.DataDir))
-	} else if dataDir != "" {
+		dataDir = conf.Base.DataDir
+	}
+	if dataDir != "" {
 		bcOpts = append(bcOpts, storage.WithDataDir(dataDir))
+	} else {
+		dataDir = storage.DefaultOptions().DataDir
+	}
+
+	if conf.Base.RestoreDir != "" {
+		restoreCheckpoint(conf.Base.RestoreDir, dataDir)
 	}
 
 	if conf.MemIndex.DataStructure != "" {
@@ -36,12 +55,22 @@ func NewFincasDB(dataDir string) *FincasDB {
 		case "swisstable":
 			memDS = storage.SwissTable
 			bcOpts = append(bcOpts, storage.WithBTreeDegree(max(conf.MemIndex.SwissTableInitialSize, 1024)))
+		case "art":
+			memDS = storage.ART
 		default:
 			log.Fatal("Unsupported MemIndex data structure: " + conf.MemIndex.DataStructure)
 		}
 		bcOpts = append(bcOpts, storage.WithMemIndexDS(memDS))
 	}
 	bcOpts = append(bcOpts, storage.WithMemIndexShardCount(max(conf.MemIndex.ShardCount, 128)))
+	if conf.MemIndex.Sharding != "" {
+		switch mode := storage.ShardingMode(conf.MemIndex.Sharding); mode {
+		case storage.HashSharding, storage.RangeSharding:
+			bcOpts = append(bcOpts, storage.WithMemIndexSharding(mode, conf.MemIndex.RangeBounds))
+		default:
+			log.Fatal("Unsupported MemIndex sharding: " + conf.MemIndex.Sharding)
+		}
+	}
 
 	if conf.MemCache.Enable {
 		bcOpts = append(bcOpts, storage.WithOpenMemCache(true))
@@ -49,6 +78,11 @@ func NewFincasDB(dataDir string) *FincasDB {
 		case "lru":
 			bcOpts = append(bcOpts, storage.WithMemCacheDS(storage.LRU))
 			bcOpts = append(bcOpts, storage.WithMemCacheSize(max(conf.MemCache.Size, 1024)))
+		case "lfu", "arc", "wtinylfu":
+			bcOpts = append(bcOpts, storage.WithMemCacheDS(storage.MemCacheType(conf.MemCache.DataStructure)))
+			if conf.MemCache.CapacityBytes > 0 {
+				bcOpts = append(bcOpts, storage.WithMemCacheBytes(conf.MemCache.CapacityBytes))
+			}
 		default:
 			log.Fatal("Unsupported MemCache data structure: " + conf.MemCache.DataStructure)
 		}
@@ -56,9 +90,73 @@ func NewFincasDB(dataDir string) *FincasDB {
 		bcOpts = append(bcOpts, storage.WithOpenMemCache(false))
 	}
 
+	bloomExpected, bloomFPR := storage.DefaultOptions().BloomExpectedElements, storage.DefaultOptions().BloomFalsePositiveRate
+	if conf.BloomFilter.ExpectedElements > 0 {
+		bloomExpected = conf.BloomFilter.ExpectedElements
+	}
+	if conf.BloomFilter.FalsePositiveRate > 0 && conf.BloomFilter.FalsePositiveRate < 1 {
+		bloomFPR = conf.BloomFilter.FalsePositiveRate
+	}
+	bcOpts = append(bcOpts, storage.WithBloomFilter(bloomExpected, bloomFPR))
+
 	bcOpts = append(bcOpts, storage.WithMaxFileSize(max(storage.DefaultOptions().MaxFileSize, int64(conf.FileManager.MaxSize))))
 	bcOpts = append(bcOpts, storage.WithMaxOpenFiles(max(storage.DefaultOptions().MaxOpenFiles, conf.FileManager.MaxOpened)))
 	bcOpts = append(bcOpts, storage.WithSyncInterval(max(storage.DefaultOptions().SyncInterval, conf.FileManager.SyncInterval)))
+	if conf.FileManager.Durability != "" {
+		switch mode := storage.DurabilityMode(conf.FileManager.Durability); mode {
+		case storage.DurabilityNone, storage.DurabilityInterval, storage.DurabilityAlways, storage.DurabilityGroup:
+			bcOpts = append(bcOpts, storage.WithDurability(mode))
+		default:
+			log.Fatal("Unsupported durability mode: " + conf.FileManager.Durability)
+		}
+	}
+	if conf.FileManager.Recovery != "" {
+		switch policy := storage.RecoveryPolicy(conf.FileManager.Recovery); policy {
+		case storage.RecoveryFail, storage.RecoverySkip, storage.RecoverySalvage:
+			bcOpts = append(bcOpts, storage.WithRecoveryPolicy(policy))
+		default:
+			log.Fatal("Unsupported recovery policy: " + conf.FileManager.Recovery)
+		}
+	}
+	if conf.FileManager.MmapReads {
+		budget := conf.FileManager.MmapBudget
+		if budget <= 0 {
+			budget = storage.DefaultOptions().MmapBudget
+		}
+		bcOpts = append(bcOpts, storage.WithMmapReads(true, budget))
+	}
+	if conf.FileManager.WriteLanes > 1 {
+		bcOpts = append(bcOpts, storage.WithWriteLanes(conf.FileManager.WriteLanes))
+	}
+	if conf.FileManager.Compression != "" {
+		switch compression := storage.CompressionType(conf.FileManager.Compression); compression {
+		case storage.CompressionNone, storage.CompressionFlate, storage.CompressionZlib:
+			threshold := conf.FileManager.CompressThreshold
+			if threshold <= 0 {
+				threshold = storage.DefaultOptions().CompressThreshold
+			}
+			bcOpts = append(bcOpts, storage.WithCompression(compression, threshold))
+		default:
+			log.Fatal("Unsupported compression: " + conf.FileManager.Compression)
+		}
+	}
+	if conf.FileManager.EncryptionKeyFile != "" {
+		raw, err := os.ReadFile(conf.FileManager.EncryptionKeyFile)
+		if err != nil {
+			log.Fatal("Failed to read encryption key file: " + err.Error())
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil {
+			log.Fatal("Invalid encryption key file: " + err.Error())
+		}
+		bcOpts = append(bcOpts, storage.WithEncryptionKey(key))
+	}
+	if conf.FileManager.BlobThreshold > 0 {
+		bcOpts = append(bcOpts, storage.WithBlobThreshold(conf.FileManager.BlobThreshold))
+	}
+	if conf.Merge.BlobGCRatio > 0 {
+		bcOpts = append(bcOpts, storage.WithBlobGCRatio(conf.Merge.BlobGCRatio))
+	}
 
 	if conf.Merge.Auto {
 		bcOpts = append(bcOpts, storage.WithAutoMerge(true))
@@ -68,8 +166,17 @@ func NewFincasDB(dataDir string) *FincasDB {
 		bcOpts = append(bcOpts, storage.WithAutoMerge(false))
 	}
 
+	if conf.Scrub.Interval > 0 {
+		rate := conf.Scrub.Rate
+		if rate <= 0 {
+			rate = storage.DefaultOptions().ScrubRate
+		}
+		bcOpts = append(bcOpts, storage.WithScrubber(conf.Scrub.Interval, rate))
+	}
+
 	dw := redis2.NewBDWrapper(nil, bcOpts...)
 	return &FincasDB{
+		dw:      dw,
 		RString: redis2.NewRString(dw),
 		RHash:   redis2.NewRHash(dw),
 		RList:   redis2.NewRList(dw),
@@ -78,6 +185,74 @@ func NewFincasDB(dataDir string) *FincasDB {
 	}
 }
 
+// restoreCheckpoint 数据目录为空时从检查点恢复，已有数据时跳过，避免每次重启都覆盖
+func restoreCheckpoint(src, dataDir string) {
+	ids, err := file_manager.ListDataFileIDs(dataDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal("Failed to read data directory: " + err.Error())
+	}
+	if len(ids) > 0 {
+		log.Printf("Data directory %s is not empty, skip restoring from %s", dataDir, src)
+		return
+	}
+	cp, err := bitcask.RestoreCheckpoint(src, dataDir)
+	if err != nil {
+		log.Fatal("Failed to restore checkpoint: " + err.Error())
+	}
+	log.Printf("Restored checkpoint %s created at %s", src, cp.CreatedAt.Format(time.RFC3339))
+}
+
+// Checkpoint 在配置的备份目录下创建一致性检查点，返回检查点目录
+// name 为备份目录下的相对路径，为空时按时间生成；绝对路径或越出备份目录的 name 返回 ErrInvalidBackupDir
+func (db *FincasDB) Checkpoint(name string) (string, error) {
+	if err := CheckBackupName(name); err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = fmt.Sprintf("checkpoint-%d", time.Now().UnixNano())
+	}
+	backupDir := config.Get().Base.BackupDir
+	if backupDir == "" {
+		backupDir = "./fincas-backup"
+	}
+	dir := filepath.Join(backupDir, name)
+	if _, err := db.dw.GetDB().Checkpoint(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// CheckBackupName 检查 name 能否作为备份目录下的检查点目录：只接受不越出备份目录的相对路径，为空表示按时间生成
+func CheckBackupName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if !filepath.IsLocal(name) || filepath.Clean(name) == "." {
+		return fmt.Errorf("%w: %s", err_def.ErrInvalidBackupDir, name)
+	}
+	return nil
+}
+
+// Subscribe 订阅 from 之后已提交的变更
+func (db *FincasDB) Subscribe(from bitcask.Position) (*bitcask.Subscription, error) {
+	return db.dw.GetDB().Subscribe(from)
+}
+
+// LastSequence 返回最近一次写入的序列号，从它开始订阅只会收到之后的变更
+func (db *FincasDB) LastSequence() uint64 {
+	return db.dw.GetDB().LastSequence()
+}
+
+// SetScrubRepair 设置后台校验发现损坏时取回键值的方式，键为存储层编码后的键
+func (db *FincasDB) SetScrubRepair(fn bitcask.ScrubRepairFunc) {
+	db.dw.GetDB().SetScrubRepair(fn)
+}
+
+// FetchRaw 按存储层编码后的键读取原始值，不存在或已过期的键不在结果中
+func (db *FincasDB) FetchRaw(keys []string) map[string][]byte {
+	return db.dw.GetDB().FetchRaw(keys)
+}
+
 func (db *FincasDB) Close() {
 	db.RString.Release()
 	db.RHash.Release()
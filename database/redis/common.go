@@ -21,3 +21,8 @@ func NewBDWrapper(dbOpts *base2.BaseDBOptions, bcOpts ...storage.Option) *DBWrap
 func (db *DBWrapper) GetDB() *base2.DB {
 	return db.db
 }
+
+// kvReader 读取键值的来源，DB 直接读取，Snapshot 读取固定时刻的状态，Txn 读取时记录版本用于提交时的冲突检测
+type kvReader interface {
+	Get(key string) (string, error)
+}
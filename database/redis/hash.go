@@ -3,6 +3,7 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"github.com/FinnTew/FincasKV/database/base"
 	"github.com/FinnTew/FincasKV/err_def"
 	"strconv"
 	"strings"
@@ -29,61 +30,62 @@ func (rh *RHash) Release() {
 	hashPool.Put(rh)
 }
 
-func (rh *RHash) batchSetFields(key string, fields map[string]string, nx bool) error {
+// batchSetFields 在事务中写入多个字段并维护字段数，nx 为 true 时只写入不存在的字段，返回新增的字段数
+func (rh *RHash) batchSetFields(key string, fields map[string]string, nx bool) (int64, error) {
 	if len(key) == 0 {
-		return err_def.ErrEmptyKey
+		return 0, err_def.ErrEmptyKey
 	}
 	if len(fields) == 0 {
-		return nil
+		return 0, nil
 	}
 
-	wb := rh.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	lenKey := GetHashLenKey(key)
-	currentLen, err := rh.HLen(key)
-	if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
-		return err
-	}
-
-	newFields := 0
-	for field, value := range fields {
-		hashKey := GetHashFieldKey(key, field)
-
-		if nx {
-			exists, err := rh.dw.GetDB().Exists(hashKey)
+	var newFields int64
+	err := rh.dw.GetDB().Update(func(txn *base.Txn) error {
+		newFields = 0
+		for field, value := range fields {
+			hashKey := GetHashFieldKey(key, field)
+			exists, err := txn.Exists(hashKey)
 			if err != nil {
 				return err
 			}
-			if exists {
+			if exists && nx {
 				continue
 			}
-		}
 
-		if err := wb.Put(hashKey, value); err != nil {
-			return err
-		}
-
-		if !nx {
-			exists, err := rh.dw.GetDB().Exists(hashKey)
-			if err != nil {
+			if err := txn.Put(hashKey, value); err != nil {
 				return err
 			}
 			if !exists {
 				newFields++
 			}
-		} else {
-			newFields++
 		}
+		return rh.addLen(txn, key, newFields)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newFields, nil
+}
+
+// addLen 在事务中调整哈希的字段数
+func (rh *RHash) addLen(txn *base.Txn, key string, delta int64) error {
+	if delta == 0 {
+		return nil
 	}
 
-	if newFields > 0 {
-		if err := wb.Put(lenKey, strconv.FormatInt(currentLen+int64(newFields), 10)); err != nil {
+	lenKey := GetHashLenKey(key)
+	currentLen := int64(0)
+	val, err := txn.Get(lenKey)
+	if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
+		return err
+	}
+	if err == nil {
+		if currentLen, err = strconv.ParseInt(val, 10, 64); err != nil {
 			return err
 		}
 	}
-
-	return wb.Commit()
+	return txn.Put(lenKey, strconv.FormatInt(currentLen+delta, 10))
 }
 
 func (rh *RHash) HSet(key, field, value string) error {
@@ -99,7 +101,8 @@ func (rh *RHash) HGet(key, field string) (string, error) {
 }
 
 func (rh *RHash) HMSet(key string, fields map[string]string) error {
-	return rh.batchSetFields(key, fields, false)
+	_, err := rh.batchSetFields(key, fields, false)
+	return err
 }
 
 func (rh *RHash) HMGet(key string, fields ...string) (map[string]string, error) {
@@ -150,37 +153,27 @@ func (rh *RHash) HDel(key string, fields ...string) (int64, error) {
 		return 0, err_def.ErrEmptyKey
 	}
 
-	wb := rh.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
 	var deleted int64
-	for _, field := range fields {
-		hashKey := GetHashFieldKey(key, field)
-		exists, err := rh.dw.GetDB().Exists(hashKey)
-		if err != nil {
-			return 0, err
-		}
-		if !exists {
-			continue
-		}
-
-		if err := wb.Delete(hashKey); err != nil {
-			return 0, err
-		}
-		deleted++
-	}
+	err := rh.dw.GetDB().Update(func(txn *base.Txn) error {
+		deleted = 0
+		for _, field := range fields {
+			hashKey := GetHashFieldKey(key, field)
+			exists, err := txn.Exists(hashKey)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
 
-	if deleted > 0 {
-		currLen, err := rh.HLen(key)
-		if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
-			return 0, err
-		}
-		if err := wb.Put(GetHashLenKey(key), strconv.FormatInt(currLen-deleted, 10)); err != nil {
-			return 0, err
+			if err := txn.Delete(hashKey); err != nil {
+				return err
+			}
+			deleted++
 		}
-	}
-
-	if err := wb.Commit(); err != nil {
+		return rh.addLen(txn, key, -deleted)
+	})
+	if err != nil {
 		return 0, err
 	}
 
@@ -286,39 +279,26 @@ func (rh *RHash) HIncrBy(key, field string, incr int64) (int64, error) {
 	}
 
 	hashKey := GetHashFieldKey(key, field)
-	wb := rh.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	val, err := rh.dw.GetDB().Get(hashKey)
-	if err != nil {
-		if !errors.Is(err, err_def.ErrKeyNotFound) {
-			return 0, err
-		}
-		if err := wb.Put(hashKey, strconv.FormatInt(incr, 10)); err != nil {
-			return 0, err
-		}
-
-		if err := wb.Put(GetHashLenKey(key), "1"); err != nil {
-			return 0, err
+	var result int64
+	err := rh.dw.GetDB().Update(func(txn *base.Txn) error {
+		val, err := txn.Get(hashKey)
+		if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
+			return err
 		}
 
-		if err := wb.Commit(); err != nil {
-			return 0, err
+		current := int64(0)
+		if err == nil {
+			if current, err = strconv.ParseInt(val, 10, 64); err != nil {
+				return err_def.ErrValueNotInteger
+			}
+		} else if err := rh.addLen(txn, key, 1); err != nil {
+			return err
 		}
-		return incr, nil
-	}
 
-	current, err := strconv.ParseInt(val, 10, 64)
+		result = current + incr
+		return txn.Put(hashKey, strconv.FormatInt(result, 10))
+	})
 	if err != nil {
-		return 0, err_def.ErrValueNotInteger
-	}
-
-	result := current + incr
-	if err := wb.Put(hashKey, strconv.FormatInt(result, 10)); err != nil {
-		return 0, err
-	}
-
-	if err := wb.Commit(); err != nil {
 		return 0, err
 	}
 
@@ -331,39 +311,26 @@ func (rh *RHash) HIncrByFloat(key, field string, incr float64) (float64, error)
 	}
 
 	hashKey := GetHashFieldKey(key, field)
-	wb := rh.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	val, err := rh.dw.GetDB().Get(hashKey)
-	if err != nil {
-		if !errors.Is(err, err_def.ErrKeyNotFound) {
-			return 0, err
-		}
-		if err := wb.Put(hashKey, strconv.FormatFloat(incr, 'f', -1, 64)); err != nil {
-			return 0, err
-		}
-
-		if err := wb.Put(GetHashLenKey(key), "1"); err != nil {
-			return 0, err
+	var result float64
+	err := rh.dw.GetDB().Update(func(txn *base.Txn) error {
+		val, err := txn.Get(hashKey)
+		if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
+			return err
 		}
 
-		if err := wb.Commit(); err != nil {
-			return 0, err
+		current := float64(0)
+		if err == nil {
+			if current, err = strconv.ParseFloat(val, 64); err != nil {
+				return err_def.ErrValueNotFloat
+			}
+		} else if err := rh.addLen(txn, key, 1); err != nil {
+			return err
 		}
-		return incr, nil
-	}
 
-	current, err := strconv.ParseFloat(val, 64)
+		result = current + incr
+		return txn.Put(hashKey, strconv.FormatFloat(result, 'f', -1, 64))
+	})
 	if err != nil {
-		return 0, err_def.ErrValueNotFloat
-	}
-
-	result := current + incr
-	if err := wb.Put(hashKey, strconv.FormatFloat(result, 'f', -1, 64)); err != nil {
-		return 0, err
-	}
-
-	if err := wb.Commit(); err != nil {
 		return 0, err
 	}
 
@@ -375,17 +342,12 @@ func (rh *RHash) HSetNX(key, field, value string) (bool, error) {
 		return false, err_def.ErrEmptyKey
 	}
 
-	err := rh.batchSetFields(key, map[string]string{field: value}, true)
-	if err != nil {
-		return false, err
-	}
-
-	exists, err := rh.HExists(key, field)
+	added, err := rh.batchSetFields(key, map[string]string{field: value}, true)
 	if err != nil {
 		return false, err
 	}
 
-	return !exists, nil
+	return added > 0, nil
 }
 
 func (rh *RHash) HStrLen(key, field string) (int64, error) {
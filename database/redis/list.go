@@ -30,8 +30,8 @@ func (rl *RList) Release() {
 	listPool.Put(rl)
 }
 
-func (rl *RList) getListLen(key string) (int64, error) {
-	lenStr, err := rl.dw.GetDB().Get(GetListLenKey(key))
+func (rl *RList) getListLen(r kvReader, key string) (int64, error) {
+	lenStr, err := r.Get(GetListLenKey(key))
 	if err != nil {
 		if errors.Is(err, err_def.ErrKeyNotFound) {
 			return 0, nil
@@ -41,12 +41,12 @@ func (rl *RList) getListLen(key string) (int64, error) {
 	return strconv.ParseInt(lenStr, 10, 64)
 }
 
-func (rl *RList) setListLen(wb *base.WriteBatch, key string, length int64) error {
-	return wb.Put(GetListLenKey(key), strconv.FormatInt(length, 10))
+func (rl *RList) setListLen(txn *base.Txn, key string, length int64) error {
+	return txn.Put(GetListLenKey(key), strconv.FormatInt(length, 10))
 }
 
-func (rl *RList) getListPointers(key string) (head, tail int64, err error) {
-	headStr, err := rl.dw.GetDB().Get(GetListHeadKey(key))
+func (rl *RList) getListPointers(r kvReader, key string) (head, tail int64, err error) {
+	headStr, err := r.Get(GetListHeadKey(key))
 	if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
 		return 0, 0, err
 	}
@@ -54,7 +54,7 @@ func (rl *RList) getListPointers(key string) (head, tail int64, err error) {
 		headStr = "0"
 	}
 
-	tailStr, err := rl.dw.GetDB().Get(GetListTailKey(key))
+	tailStr, err := r.Get(GetListTailKey(key))
 	if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
 		return 0, 0, err
 	}
@@ -72,40 +72,38 @@ func (rl *RList) LPush(key string, values ...string) (int64, error) {
 		return 0, nil
 	}
 
-	wb := rl.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	length, err := rl.getListLen(key)
-	if err != nil {
-		return 0, err
-	}
-
-	head, tail, err := rl.getListPointers(key)
-	if err != nil {
-		return 0, err
-	}
+	var length int64
+	err := rl.dw.GetDB().Update(func(txn *base.Txn) error {
+		var err error
+		length, err = rl.getListLen(txn, key)
+		if err != nil {
+			return err
+		}
 
-	for _, value := range values {
-		head--
-		if err := wb.Put(GetListItemKey(key, head), value); err != nil {
-			return 0, err
+		head, tail, err := rl.getListPointers(txn, key)
+		if err != nil {
+			return err
 		}
-		length++
-	}
 
-	if err := wb.Put(GetListHeadKey(key), strconv.FormatInt(head, 10)); err != nil {
-		return 0, err
-	}
-	if tail == 0 {
-		if err := wb.Put(GetListTailKey(key), strconv.FormatInt(head+int64(len(values))-1, 10)); err != nil {
-			return 0, err
+		for _, value := range values {
+			head--
+			if err := txn.Put(GetListItemKey(key, head), value); err != nil {
+				return err
+			}
+			length++
 		}
-	}
-	if err := rl.setListLen(wb, key, length); err != nil {
-		return 0, err
-	}
 
-	if err := wb.Commit(); err != nil {
+		if err := txn.Put(GetListHeadKey(key), strconv.FormatInt(head, 10)); err != nil {
+			return err
+		}
+		if tail == 0 {
+			if err := txn.Put(GetListTailKey(key), strconv.FormatInt(head+int64(len(values))-1, 10)); err != nil {
+				return err
+			}
+		}
+		return rl.setListLen(txn, key, length)
+	})
+	if err != nil {
 		return 0, err
 	}
 
@@ -117,45 +115,43 @@ func (rl *RList) RPush(key string, values ...string) (int64, error) {
 		return 0, nil
 	}
 
-	wb := rl.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	length, err := rl.getListLen(key)
-	if err != nil {
-		return 0, err
-	}
-
-	head, tail, err := rl.getListPointers(key)
-	if err != nil {
-		return 0, err
-	}
+	var length int64
+	err := rl.dw.GetDB().Update(func(txn *base.Txn) error {
+		var err error
+		length, err = rl.getListLen(txn, key)
+		if err != nil {
+			return err
+		}
 
-	if length == 0 {
-		head = 0
-		tail = -1
-	}
+		head, tail, err := rl.getListPointers(txn, key)
+		if err != nil {
+			return err
+		}
 
-	for _, value := range values {
-		tail++
-		if err := wb.Put(GetListItemKey(key, tail), value); err != nil {
-			return 0, err
+		if length == 0 {
+			head = 0
+			tail = -1
 		}
-		length++
-	}
 
-	if err := wb.Put(GetListTailKey(key), strconv.FormatInt(tail, 10)); err != nil {
-		return 0, err
-	}
-	if head == 0 && length == int64(len(values)) {
-		if err := wb.Put(GetListHeadKey(key), "0"); err != nil {
-			return 0, err
+		for _, value := range values {
+			tail++
+			if err := txn.Put(GetListItemKey(key, tail), value); err != nil {
+				return err
+			}
+			length++
 		}
-	}
-	if err := rl.setListLen(wb, key, length); err != nil {
-		return 0, err
-	}
 
-	if err := wb.Commit(); err != nil {
+		if err := txn.Put(GetListTailKey(key), strconv.FormatInt(tail, 10)); err != nil {
+			return err
+		}
+		if head == 0 && length == int64(len(values)) {
+			if err := txn.Put(GetListHeadKey(key), "0"); err != nil {
+				return err
+			}
+		}
+		return rl.setListLen(txn, key, length)
+	})
+	if err != nil {
 		return 0, err
 	}
 
@@ -163,105 +159,63 @@ func (rl *RList) RPush(key string, values ...string) (int64, error) {
 }
 
 func (rl *RList) LPop(key string) (string, error) {
-	wb := rl.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	length, err := rl.getListLen(key)
-	if err != nil {
-		return "", err
-	}
-	if length == 0 {
-		return "", err_def.ErrKeyNotFound
-	}
-
-	head, _, err := rl.getListPointers(key)
-	if err != nil {
-		return "", err
-	}
-
-	value, err := rl.dw.GetDB().Get(GetListItemKey(key, head))
-	if err != nil {
-		return "", err
-	}
-
-	if err := wb.Delete(GetListItemKey(key, head)); err != nil {
-		return "", err
-	}
-
-	head++
-	length--
-
-	if length > 0 {
-		if err := wb.Put(GetListHeadKey(key), strconv.FormatInt(head, 10)); err != nil {
-			return "", err
-		}
-	} else {
-		if err := wb.Delete(GetListHeadKey(key)); err != nil {
-			return "", err
-		}
-		if err := wb.Delete(GetListTailKey(key)); err != nil {
-			return "", err
-		}
-	}
-
-	if err := rl.setListLen(wb, key, length); err != nil {
-		return "", err
-	}
-
-	if err := wb.Commit(); err != nil {
-		return "", err
-	}
-
-	return value, nil
+	return rl.pop(key, true)
 }
 
 func (rl *RList) RPop(key string) (string, error) {
-	wb := rl.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	length, err := rl.getListLen(key)
-	if err != nil {
-		return "", err
-	}
-	if length == 0 {
-		return "", err_def.ErrKeyNotFound
-	}
-
-	_, tail, err := rl.getListPointers(key)
-	if err != nil {
-		return "", err
-	}
-
-	value, err := rl.dw.GetDB().Get(GetListItemKey(key, tail))
-	if err != nil {
-		return "", err
-	}
+	return rl.pop(key, false)
+}
 
-	if err := wb.Delete(GetListItemKey(key, tail)); err != nil {
-		return "", err
-	}
+// pop 在事务中弹出列表头部或尾部的元素
+func (rl *RList) pop(key string, left bool) (string, error) {
+	var value string
+	err := rl.dw.GetDB().Update(func(txn *base.Txn) error {
+		length, err := rl.getListLen(txn, key)
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			return err_def.ErrKeyNotFound
+		}
 
-	tail--
-	length--
+		head, tail, err := rl.getListPointers(txn, key)
+		if err != nil {
+			return err
+		}
 
-	if length > 0 {
-		if err := wb.Put(GetListTailKey(key), strconv.FormatInt(tail, 10)); err != nil {
-			return "", err
+		idx := tail
+		if left {
+			idx = head
 		}
-	} else {
-		if err := wb.Delete(GetListHeadKey(key)); err != nil {
-			return "", err
+		value, err = txn.Get(GetListItemKey(key, idx))
+		if err != nil {
+			return err
 		}
-		if err := wb.Delete(GetListTailKey(key)); err != nil {
-			return "", err
+		if err := txn.Delete(GetListItemKey(key, idx)); err != nil {
+			return err
 		}
-	}
-
-	if err := rl.setListLen(wb, key, length); err != nil {
-		return "", err
-	}
 
-	if err := wb.Commit(); err != nil {
+		length--
+		switch {
+		case length == 0:
+			if err := txn.Delete(GetListHeadKey(key)); err != nil {
+				return err
+			}
+			if err := txn.Delete(GetListTailKey(key)); err != nil {
+				return err
+			}
+		case left:
+			if err := txn.Put(GetListHeadKey(key), strconv.FormatInt(head+1, 10)); err != nil {
+				return err
+			}
+		default:
+			if err := txn.Put(GetListTailKey(key), strconv.FormatInt(tail-1, 10)); err != nil {
+				return err
+			}
+		}
+		return rl.setListLen(txn, key, length)
+	})
+	if err != nil {
 		return "", err
 	}
 
@@ -269,11 +223,18 @@ func (rl *RList) RPop(key string) (string, error) {
 }
 
 func (rl *RList) LLen(key string) (int64, error) {
-	return rl.getListLen(key)
+	return rl.getListLen(rl.dw.GetDB(), key)
 }
 
 func (rl *RList) LRange(key string, start, stop int) ([]string, error) {
-	length, err := rl.getListLen(key)
+	// 在同一个快照上读取元数据与元素，避免并发修改时读到不一致的范围
+	snap, err := rl.dw.GetDB().NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	length, err := rl.getListLen(snap, key)
 	if err != nil {
 		return nil, err
 	}
@@ -281,7 +242,7 @@ func (rl *RList) LRange(key string, start, stop int) ([]string, error) {
 		return []string{}, nil
 	}
 
-	head, _, err := rl.getListPointers(key)
+	head, _, err := rl.getListPointers(snap, key)
 	if err != nil {
 		return nil, err
 	}
@@ -305,7 +266,7 @@ func (rl *RList) LRange(key string, start, stop int) ([]string, error) {
 
 	result := make([]string, 0, stop-start+1)
 	for i := start; i <= stop; i++ {
-		value, err := rl.dw.GetDB().Get(GetListItemKey(key, head+int64(i)))
+		value, err := snap.Get(GetListItemKey(key, head+int64(i)))
 		if err != nil {
 			return nil, err
 		}
@@ -316,79 +277,72 @@ func (rl *RList) LRange(key string, start, stop int) ([]string, error) {
 }
 
 func (rl *RList) LTrim(key string, start, stop int) error {
-	wb := rl.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	length, err := rl.getListLen(key)
-	if err != nil {
-		return err
-	}
-	if length == 0 {
-		return nil
-	}
+	return rl.dw.GetDB().Update(func(txn *base.Txn) error {
+		length, err := rl.getListLen(txn, key)
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			return nil
+		}
 
-	head, tail, err := rl.getListPointers(key)
-	if err != nil {
-		return err
-	}
+		head, tail, err := rl.getListPointers(txn, key)
+		if err != nil {
+			return err
+		}
 
-	if start < 0 {
-		start = int(length) + start
-	}
-	if stop < 0 {
-		stop = int(length) + stop
-	}
+		start, stop := start, stop
+		if start < 0 {
+			start = int(length) + start
+		}
+		if stop < 0 {
+			stop = int(length) + stop
+		}
 
-	if start < 0 {
-		start = 0
-	}
-	if stop >= int(length) {
-		stop = int(length) - 1
-	}
-	if start > stop {
-		for i := head; i <= tail; i++ {
-			if err := wb.Delete(GetListItemKey(key, i)); err != nil {
+		if start < 0 {
+			start = 0
+		}
+		if stop >= int(length) {
+			stop = int(length) - 1
+		}
+		if start > stop {
+			for i := head; i <= tail; i++ {
+				if err := txn.Delete(GetListItemKey(key, i)); err != nil {
+					return err
+				}
+			}
+			if err := txn.Delete(GetListHeadKey(key)); err != nil {
 				return err
 			}
+			if err := txn.Delete(GetListTailKey(key)); err != nil {
+				return err
+			}
+			return rl.setListLen(txn, key, 0)
 		}
-		if err := wb.Delete(GetListHeadKey(key)); err != nil {
-			return err
-		}
-		if err := wb.Delete(GetListTailKey(key)); err != nil {
-			return err
+
+		for i := head; i < head+int64(start); i++ {
+			if err := txn.Delete(GetListItemKey(key, i)); err != nil {
+				return err
+			}
 		}
-		if err := rl.setListLen(wb, key, 0); err != nil {
-			return err
+		for i := head + int64(stop) + 1; i <= tail; i++ {
+			if err := txn.Delete(GetListItemKey(key, i)); err != nil {
+				return err
+			}
 		}
-		return wb.Commit()
-	}
 
-	for i := head; i < head+int64(start); i++ {
-		if err := wb.Delete(GetListItemKey(key, i)); err != nil {
+		newHead := head + int64(start)
+		newTail := head + int64(stop)
+		newLength := int64(stop - start + 1)
+
+		if err := txn.Put(GetListHeadKey(key), strconv.FormatInt(newHead, 10)); err != nil {
 			return err
 		}
-	}
-	for i := head + int64(stop) + 1; i <= tail; i++ {
-		if err := wb.Delete(GetListItemKey(key, i)); err != nil {
+		if err := txn.Put(GetListTailKey(key), strconv.FormatInt(newTail, 10)); err != nil {
 			return err
 		}
-	}
-
-	newHead := head + int64(start)
-	newTail := head + int64(stop)
-	newLength := int64(stop - start + 1)
-
-	if err := wb.Put(GetListHeadKey(key), strconv.FormatInt(newHead, 10)); err != nil {
-		return err
-	}
-	if err := wb.Put(GetListTailKey(key), strconv.FormatInt(newTail, 10)); err != nil {
-		return err
-	}
-	if err := rl.setListLen(wb, key, newLength); err != nil {
-		return err
-	}
-
-	return wb.Commit()
+		return rl.setListLen(txn, key, newLength)
+	})
 }
 
 func (rl *RList) BLPop(timeout time.Duration, keys ...string) (map[string]string, error) {
@@ -460,70 +414,70 @@ func (rl *RList) LInsertAfter(key, pivot, value string) (int64, error) {
 }
 
 func (rl *RList) lInsert(key, pivot, value string, before bool) (int64, error) {
-	wb := rl.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	length, err := rl.getListLen(key)
-	if err != nil {
-		return 0, err
-	}
-	if length == 0 {
-		return -1, nil
-	}
-
-	head, tail, err := rl.getListPointers(key)
-	if err != nil {
-		return 0, err
-	}
-
-	var pivotIdx int64
-	found := false
-	for i := head; i <= tail; i++ {
-		val, err := rl.dw.GetDB().Get(GetListItemKey(key, i))
+	var length int64
+	err := rl.dw.GetDB().Update(func(txn *base.Txn) error {
+		var err error
+		length, err = rl.getListLen(txn, key)
 		if err != nil {
-			return 0, err
+			return err
 		}
-		if val == pivot {
-			pivotIdx = i
-			found = true
-			break
+		if length == 0 {
+			length = -1
+			return nil
 		}
-	}
 
-	if !found {
-		return -1, nil
-	}
+		head, tail, err := rl.getListPointers(txn, key)
+		if err != nil {
+			return err
+		}
 
-	insertIdx := pivotIdx
-	if !before {
-		insertIdx++
-	}
+		var pivotIdx int64
+		found := false
+		for i := head; i <= tail; i++ {
+			val, err := txn.Get(GetListItemKey(key, i))
+			if err != nil {
+				return err
+			}
+			if val == pivot {
+				pivotIdx = i
+				found = true
+				break
+			}
+		}
 
-	for i := tail; i >= insertIdx; i-- {
-		val, err := rl.dw.GetDB().Get(GetListItemKey(key, i))
-		if err != nil {
-			return 0, err
+		if !found {
+			length = -1
+			return nil
 		}
-		if err := wb.Put(GetListItemKey(key, i+1), val); err != nil {
-			return 0, err
+
+		insertIdx := pivotIdx
+		if !before {
+			insertIdx++
 		}
-	}
 
-	if err := wb.Put(GetListItemKey(key, insertIdx), value); err != nil {
-		return 0, err
-	}
+		for i := tail; i >= insertIdx; i-- {
+			val, err := txn.Get(GetListItemKey(key, i))
+			if err != nil {
+				return err
+			}
+			if err := txn.Put(GetListItemKey(key, i+1), val); err != nil {
+				return err
+			}
+		}
 
-	tail++
-	length++
+		if err := txn.Put(GetListItemKey(key, insertIdx), value); err != nil {
+			return err
+		}
 
-	if err := wb.Put(GetListTailKey(key), strconv.FormatInt(tail, 10)); err != nil {
-		return 0, err
-	}
-	if err := rl.setListLen(wb, key, length); err != nil {
-		return 0, err
-	}
+		tail++
+		length++
 
-	if err := wb.Commit(); err != nil {
+		if err := txn.Put(GetListTailKey(key), strconv.FormatInt(tail, 10)); err != nil {
+			return err
+		}
+		return rl.setListLen(txn, key, length)
+	})
+	if err != nil {
 		return 0, err
 	}
 
@@ -1,7 +1,9 @@
 package redis
 
 import (
+	"errors"
 	"fmt"
+	base2 "github.com/FinnTew/FincasKV/database/base"
 	"github.com/FinnTew/FincasKV/err_def"
 	"math/rand"
 	"strconv"
@@ -38,49 +40,56 @@ func (rs *RSet) SAdd(key string, members ...string) (int64, error) {
 		return 0, nil
 	}
 
-	wb := rs.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	var added int64
-	setLenKey := GetSetLenKey(key)
-
-	currLen := int64(0)
-	if val, err := rs.dw.GetDB().Get(setLenKey); err == nil {
-		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
-			currLen = n
-		}
-	}
-
 	uniqueMembers := make(map[string]struct{}, len(members))
 	for _, member := range members {
 		uniqueMembers[member] = struct{}{}
 	}
 
-	for member := range uniqueMembers {
-		memberKey := GetSetMemberKey(key, member)
-		exists, err := rs.dw.GetDB().Exists(memberKey)
-		if err != nil && !strings.HasPrefix(err.Error(), "no value found") {
-			return 0, err
-		}
-		if !exists {
-			if err := wb.Put(memberKey, "1"); err != nil {
-				return 0, err
+	var added int64
+	err := rs.dw.GetDB().Update(func(txn *base2.Txn) error {
+		added = 0
+		for member := range uniqueMembers {
+			memberKey := GetSetMemberKey(key, member)
+			exists, err := txn.Exists(memberKey)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				if err := txn.Put(memberKey, "1"); err != nil {
+					return err
+				}
+				added++
 			}
-			added++
 		}
+		return addSetLen(txn, key, added)
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	if added > 0 {
-		if err := wb.Put(setLenKey, strconv.FormatInt(currLen+added, 10)); err != nil {
-			return 0, err
-		}
+	return added, nil
+}
+
+// addSetLen 在事务中调整集合的成员数，成员数减为 0 时删除计数
+func addSetLen(txn *base2.Txn, key string, delta int64) error {
+	if delta == 0 {
+		return nil
 	}
 
-	if err := wb.Commit(); err != nil {
-		return 0, err
+	setLenKey := GetSetLenKey(key)
+	currLen := int64(0)
+	if val, err := txn.Get(setLenKey); err == nil {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			currLen = n
+		}
+	} else if !errors.Is(err, err_def.ErrKeyNotFound) {
+		return err
 	}
 
-	return added, nil
+	if newLen := currLen + delta; newLen > 0 {
+		return txn.Put(setLenKey, strconv.FormatInt(newLen, 10))
+	}
+	return txn.Delete(setLenKey)
 }
 
 func (rs *RSet) SRem(key string, members ...string) (int64, error) {
@@ -91,51 +100,36 @@ func (rs *RSet) SRem(key string, members ...string) (int64, error) {
 		return 0, nil
 	}
 
-	wb := rs.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
 	var removed int64
-	setLenKey := GetSetLenKey(key)
-
-	currLen := int64(0)
-	if val, err := rs.dw.GetDB().Get(setLenKey); err == nil {
-		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
-			currLen = n
-		}
+	err := rs.dw.GetDB().Update(func(txn *base2.Txn) error {
+		var err error
+		removed, err = removeMembers(txn, key, members)
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
+	return removed, nil
+}
+
+// removeMembers 在事务中删除存在的成员并调整成员数，返回删除的成员数
+func removeMembers(txn *base2.Txn, key string, members []string) (int64, error) {
+	var removed int64
 	for _, member := range members {
 		memberKey := GetSetMemberKey(key, member)
-		exists, err := rs.dw.GetDB().Exists(memberKey)
+		exists, err := txn.Exists(memberKey)
 		if err != nil {
 			return 0, err
 		}
 		if exists {
-			if err := wb.Delete(memberKey); err != nil {
+			if err := txn.Delete(memberKey); err != nil {
 				return 0, err
 			}
 			removed++
 		}
 	}
-
-	if removed > 0 {
-		newLen := currLen - removed
-		if newLen > 0 {
-			if err := wb.Put(setLenKey, strconv.FormatInt(newLen, 10)); err != nil {
-				return 0, err
-			}
-		} else {
-			if err := wb.Delete(setLenKey); err != nil {
-				return 0, err
-			}
-		}
-	}
-
-	if err := wb.Commit(); err != nil {
-		return 0, err
-	}
-
-	return removed, nil
+	return removed, addSetLen(txn, key, -removed)
 }
 
 func (rs *RSet) SIsMember(key, member string) (bool, error) {
@@ -175,6 +169,29 @@ func (rs *RSet) SMembers(key string) ([]string, error) {
 	return members, nil
 }
 
+// snapshotMembers 在快照上读取集合成员，多个集合的运算在同一个快照上进行
+func snapshotMembers(snap *base2.Snapshot, key string) ([]string, error) {
+	if len(key) == 0 {
+		return nil, err_def.ErrEmptyKey
+	}
+
+	prefix := fmt.Sprintf("%s:%s:", SetPrefix, key)
+	keys, err := snap.Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if strings.HasSuffix(k, "_len_") {
+			continue
+		}
+		members = append(members, strings.TrimPrefix(k, prefix))
+	}
+
+	return members, nil
+}
+
 func (rs *RSet) SCard(key string) (int64, error) {
 	if len(key) == 0 {
 		return 0, err_def.ErrEmptyKey
@@ -210,25 +227,28 @@ func (rs *RSet) SPopN(key string, count int) ([]string, error) {
 		return []string{}, nil
 	}
 
-	members, err := rs.SMembers(key)
-	if err != nil {
-		return nil, err
-	}
-	if len(members) == 0 {
-		return []string{}, nil
-	}
-
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	r.Shuffle(len(members), func(i, j int) {
-		members[i], members[j] = members[j], members[i]
-	})
+	var popped []string
+	err := rs.dw.GetDB().Update(func(txn *base2.Txn) error {
+		// 被选中的成员在事务中读取，提交前被其他客户端删除时会冲突重试
+		members, err := rs.SMembers(key)
+		if err != nil {
+			return err
+		}
 
-	if count > len(members) {
-		count = len(members)
-	}
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		r.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
 
-	popped := members[:count]
-	if _, err := rs.SRem(key, popped...); err != nil {
+		n := count
+		if n > len(members) {
+			n = len(members)
+		}
+		popped = members[:n]
+		_, err = removeMembers(txn, key, popped)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -282,7 +302,13 @@ func (rs *RSet) SDiff(keys ...string) ([]string, error) {
 		return nil, nil
 	}
 
-	members, err := rs.SMembers(keys[0])
+	snap, err := rs.dw.GetDB().NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	members, err := snapshotMembers(snap, keys[0])
 	if err != nil {
 		return nil, err
 	}
@@ -297,7 +323,7 @@ func (rs *RSet) SDiff(keys ...string) ([]string, error) {
 	}
 
 	for _, key := range keys[1:] {
-		otherMembers, err := rs.SMembers(key)
+		otherMembers, err := snapshotMembers(snap, key)
 		if err != nil {
 			return nil, err
 		}
@@ -319,10 +345,16 @@ func (rs *RSet) SUnion(keys ...string) ([]string, error) {
 		return nil, nil
 	}
 
+	snap, err := rs.dw.GetDB().NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
 	result := make(map[string]struct{})
 
 	for _, key := range keys {
-		members, err := rs.SMembers(key)
+		members, err := snapshotMembers(snap, key)
 		if err != nil {
 			return nil, err
 		}
@@ -344,7 +376,13 @@ func (rs *RSet) SInter(keys ...string) ([]string, error) {
 		return nil, nil
 	}
 
-	members, err := rs.SMembers(keys[0])
+	snap, err := rs.dw.GetDB().NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	members, err := snapshotMembers(snap, keys[0])
 	if err != nil {
 		return nil, err
 	}
@@ -359,7 +397,7 @@ func (rs *RSet) SInter(keys ...string) ([]string, error) {
 	}
 
 	for _, key := range keys[1:] {
-		otherMembers, err := rs.SMembers(key)
+		otherMembers, err := snapshotMembers(snap, key)
 		if err != nil {
 			return nil, err
 		}
@@ -385,66 +423,33 @@ func (rs *RSet) SMove(source, destination, member string) (bool, error) {
 		return false, err_def.ErrEmptyKey
 	}
 
-	wb := rs.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	srcMemberKey := GetSetMemberKey(source, member)
-	exists, err := rs.dw.GetDB().Exists(srcMemberKey)
-	if err != nil {
-		return false, err
-	}
-	if !exists {
-		return false, nil
-	}
-
-	srcLenKey := GetSetLenKey(source)
-	srcLen := int64(0)
-	if val, err := rs.dw.GetDB().Get(srcLenKey); err == nil {
-		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
-			srcLen = n
-		}
-	}
-
-	if err := wb.Delete(srcMemberKey); err != nil {
-		return false, err
-	}
-
-	if srcLen > 1 {
-		if err := wb.Put(srcLenKey, strconv.FormatInt(srcLen-1, 10)); err != nil {
-			return false, err
+	var moved bool
+	err := rs.dw.GetDB().Update(func(txn *base2.Txn) error {
+		removed, err := removeMembers(txn, source, []string{member})
+		if err != nil {
+			return err
 		}
-	} else {
-		if err := wb.Delete(srcLenKey); err != nil {
-			return false, err
+		moved = removed > 0
+		if !moved {
+			return nil
 		}
-	}
-
-	dstMemberKey := GetSetMemberKey(destination, member)
-	exists, err = rs.dw.GetDB().Exists(dstMemberKey)
-	if err != nil {
-		return false, err
-	}
 
-	if !exists {
-		dstLenKey := GetSetLenKey(destination)
-		dstLen := int64(0)
-		if val, err := rs.dw.GetDB().Get(dstLenKey); err == nil {
-			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
-				dstLen = n
-			}
+		dstMemberKey := GetSetMemberKey(destination, member)
+		exists, err := txn.Exists(dstMemberKey)
+		if err != nil {
+			return err
 		}
-
-		if err := wb.Put(dstMemberKey, "1"); err != nil {
-			return false, err
+		if exists {
+			return nil
 		}
-		if err := wb.Put(dstLenKey, strconv.FormatInt(dstLen+1, 10)); err != nil {
-			return false, err
+		if err := txn.Put(dstMemberKey, "1"); err != nil {
+			return err
 		}
-	}
-
-	if err := wb.Commit(); err != nil {
+		return addSetLen(txn, destination, 1)
+	})
+	if err != nil {
 		return false, err
 	}
 
-	return true, nil
+	return moved, nil
 }
@@ -3,6 +3,7 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"github.com/FinnTew/FincasKV/database/base"
 	"github.com/FinnTew/FincasKV/err_def"
 	"strconv"
 	"strings"
@@ -70,32 +71,26 @@ func (rs *RString) IncrBy(key string, value int64) (int64, error) {
 	}
 
 	strKey := GetStringKey(key)
-	wb := rs.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
+	var result int64
+	err := rs.dw.GetDB().Update(func(txn *base.Txn) error {
+		val, err := txn.Get(strKey)
+		if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
+			return err
+		}
 
-	val, err := rs.dw.GetDB().Get(strKey)
-	if err != nil {
 		// Key不存在时设为初始值
-		if err := wb.Put(strKey, strconv.FormatInt(value, 10)); err != nil {
-			return 0, err
-		}
-		if err := wb.Commit(); err != nil {
-			return 0, err
+		current := int64(0)
+		if err == nil {
+			// 尝试转换为int64
+			if current, err = strconv.ParseInt(val, 10, 64); err != nil {
+				return err_def.ErrValueNotInteger
+			}
 		}
-		return value, nil
-	}
 
-	// 尝试转换为int64
-	current, err := strconv.ParseInt(val, 10, 64)
+		result = current + value
+		return txn.Put(strKey, strconv.FormatInt(result, 10))
+	})
 	if err != nil {
-		return 0, err_def.ErrValueNotInteger
-	}
-
-	result := current + value
-	if err := wb.Put(strKey, strconv.FormatInt(result, 10)); err != nil {
-		return 0, err
-	}
-	if err := wb.Commit(); err != nil {
 		return 0, err
 	}
 
@@ -116,29 +111,22 @@ func (rs *RString) Append(key, value string) (int64, error) {
 	}
 
 	strKey := GetStringKey(key)
-	wb := rs.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	val, err := rs.dw.GetDB().Get(strKey)
-	if err != nil {
-		if err := wb.Put(strKey, value); err != nil {
-			return 0, err
-		}
-		if err := wb.Commit(); err != nil {
-			return 0, err
+	var newLen int64
+	err := rs.dw.GetDB().Update(func(txn *base.Txn) error {
+		val, err := txn.Get(strKey)
+		if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
+			return err
 		}
-		return int64(len(value)), nil
-	}
 
-	newVal := val + value
-	if err := wb.Put(strKey, newVal); err != nil {
-		return 0, err
-	}
-	if err := wb.Commit(); err != nil {
+		newVal := val + value
+		newLen = int64(len(newVal))
+		return txn.Put(strKey, newVal)
+	})
+	if err != nil {
 		return 0, err
 	}
 
-	return int64(len(newVal)), nil
+	return newLen, nil
 }
 
 func (rs *RString) GetSet(key, value string) (string, error) {
@@ -147,18 +135,17 @@ func (rs *RString) GetSet(key, value string) (string, error) {
 	}
 
 	strKey := GetStringKey(key)
-	wb := rs.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	oldVal, err := rs.dw.GetDB().Get(strKey)
-	if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
-		return "", err
-	}
+	var oldVal string
+	err := rs.dw.GetDB().Update(func(txn *base.Txn) error {
+		val, err := txn.Get(strKey)
+		if err != nil && !errors.Is(err, err_def.ErrKeyNotFound) {
+			return err
+		}
 
-	if err := wb.Put(strKey, value); err != nil {
-		return "", err
-	}
-	if err := wb.Commit(); err != nil {
+		oldVal = val
+		return txn.Put(strKey, value)
+	})
+	if err != nil {
 		return "", err
 	}
 
@@ -171,25 +158,23 @@ func (rs *RString) SetNX(key, value string) (bool, error) {
 	}
 
 	strKey := GetStringKey(key)
-	wb := rs.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
-	exists, err := rs.dw.GetDB().Exists(strKey)
+	var set bool
+	err := rs.dw.GetDB().Update(func(txn *base.Txn) error {
+		exists, err := txn.Exists(strKey)
+		if err != nil {
+			return err
+		}
+		set = !exists
+		if exists {
+			return nil
+		}
+		return txn.Put(strKey, value)
+	})
 	if err != nil {
 		return false, err
 	}
-	if exists {
-		return false, nil
-	}
 
-	if err := wb.Put(strKey, value); err != nil {
-		return false, err
-	}
-	if err := wb.Commit(); err != nil {
-		return false, err
-	}
-
-	return true, nil
+	return set, nil
 }
 
 func (rs *RString) MSet(pairs map[string]string) error {
@@ -217,6 +202,13 @@ func (rs *RString) MGet(keys ...string) (map[string]string, error) {
 		return make(map[string]string), nil
 	}
 
+	// 所有键在同一个快照上读取，结果对应同一时刻的状态
+	snap, err := rs.dw.GetDB().NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
 	result := make(map[string]string, len(keys))
 	var errs []string
 
@@ -232,7 +224,7 @@ func (rs *RString) MGet(keys ...string) (map[string]string, error) {
 		wg.Add(1)
 		go func(k string) {
 			defer wg.Done()
-			val, err := rs.dw.GetDB().Get(GetStringKey(k))
+			val, err := snap.Get(GetStringKey(k))
 			if err != nil {
 				if !errors.Is(err, err_def.ErrKeyNotFound) {
 					mu.Lock()
@@ -3,6 +3,7 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"github.com/FinnTew/FincasKV/database/base"
 	"github.com/FinnTew/FincasKV/err_def"
 	"sort"
 	"strconv"
@@ -16,8 +17,7 @@ type ZMember struct {
 }
 
 type RZSet struct {
-	dw       *DBWrapper
-	zsetLock sync.RWMutex
+	dw *DBWrapper
 }
 
 var zsetPool = sync.Pool{
@@ -45,8 +45,8 @@ func (rz *RZSet) getKeyExists(key string) (bool, error) {
 	return rz.dw.GetDB().Exists(GetZSetMemberScoreKey(key, ""))
 }
 
-func (rz *RZSet) getMemberScore(key, member string) (float64, bool, error) {
-	val, err := rz.dw.GetDB().Get(GetZSetMemberScoreKey(key, member))
+func (rz *RZSet) getMemberScore(r kvReader, key, member string) (float64, bool, error) {
+	val, err := r.Get(GetZSetMemberScoreKey(key, member))
 	if err != nil {
 		if errors.Is(err, err_def.ErrKeyNotFound) {
 			return 0, false, nil
@@ -68,19 +68,28 @@ func (rz *RZSet) ZAdd(key string, members ...ZMember) (int64, error) {
 		return 0, nil
 	}
 
-	//rz.zsetLock.Lock()
-	//defer rz.zsetLock.Unlock()
+	var added int64
+	err := rz.dw.GetDB().Update(func(txn *base.Txn) error {
+		var err error
+		added, err = rz.addMembers(txn, key, members)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	wb := rz.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
+	return added, nil
+}
 
+// addMembers 在事务中写入成员分数并维护排序键，返回新增的成员数
+func (rz *RZSet) addMembers(txn *base.Txn, key string, members []ZMember) (int64, error) {
 	var added int64
 	for _, m := range members {
 		if len(m.Member) == 0 {
 			continue
 		}
 
-		oldScore, exists, err := rz.getMemberScore(key, m.Member)
+		oldScore, exists, err := rz.getMemberScore(txn, key, m.Member)
 		if err != nil {
 			return 0, err
 		}
@@ -93,26 +102,21 @@ func (rz *RZSet) ZAdd(key string, members ...ZMember) (int64, error) {
 
 		if exists {
 			oldSortKey := GetZSetSortKey(key, oldScore, m.Member)
-			if err := wb.Delete(oldSortKey); err != nil {
+			if err := txn.Delete(oldSortKey); err != nil {
 				return 0, err
 			}
 		}
 
 		memberScoreKey := GetZSetMemberScoreKey(key, m.Member)
-		if err := wb.Put(memberScoreKey, strconv.FormatFloat(m.Score, 'f', -1, 64)); err != nil {
+		if err := txn.Put(memberScoreKey, strconv.FormatFloat(m.Score, 'f', -1, 64)); err != nil {
 			return 0, err
 		}
 
 		sortKey := GetZSetSortKey(key, m.Score, m.Member)
-		if err := wb.Put(sortKey, ""); err != nil {
+		if err := txn.Put(sortKey, ""); err != nil {
 			return 0, err
 		}
 	}
-
-	if err := wb.Commit(); err != nil {
-		return 0, err
-	}
-
 	return added, nil
 }
 
@@ -137,9 +141,6 @@ func (rz *RZSet) zrangeGeneric(key string, start, stop int, reverse, withScores
 		return nil, err_def.ErrEmptyKey
 	}
 
-	rz.zsetLock.RLock()
-	defer rz.zsetLock.RUnlock()
-
 	// 获取所有键
 	prefix := fmt.Sprintf("%s:%s:s:", ZSetPrefix, key)
 	keys, err := rz.dw.GetDB().Keys(prefix + "*")
@@ -183,7 +184,7 @@ func (rz *RZSet) zrangeGeneric(key string, start, stop int, reverse, withScores
 
 		member := parts[len(parts)-1]
 		if withScores {
-			score, _, err := rz.getMemberScore(key, member)
+			score, _, err := rz.getMemberScore(rz.dw.GetDB(), key, member)
 			if err != nil {
 				return nil, err
 			}
@@ -209,10 +210,7 @@ func (rz *RZSet) zrankGeneric(key, member string, reverse bool) (int64, error) {
 		return -1, err_def.ErrEmptyKey
 	}
 
-	rz.zsetLock.RLock()
-	defer rz.zsetLock.RUnlock()
-
-	score, exists, err := rz.getMemberScore(key, member)
+	score, exists, err := rz.getMemberScore(rz.dw.GetDB(), key, member)
 	if err != nil {
 		return -1, err
 	}
@@ -247,40 +245,37 @@ func (rz *RZSet) ZRem(key string, members ...string) (int64, error) {
 		return 0, nil
 	}
 
-	rz.zsetLock.Lock()
-	defer rz.zsetLock.Unlock()
-
-	wb := rz.dw.GetDB().NewWriteBatch(nil)
-	defer wb.Release()
-
 	var removed int64
-	for _, member := range members {
-		if len(member) == 0 {
-			continue
-		}
-
-		score, exists, err := rz.getMemberScore(key, member)
-		if err != nil {
-			return 0, err
-		}
-		if !exists {
-			continue
-		}
+	err := rz.dw.GetDB().Update(func(txn *base.Txn) error {
+		removed = 0
+		for _, member := range members {
+			if len(member) == 0 {
+				continue
+			}
 
-		memberScoreKey := GetZSetMemberScoreKey(key, member)
-		sortKey := GetZSetSortKey(key, score, member)
+			score, exists, err := rz.getMemberScore(txn, key, member)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
 
-		if err := wb.Delete(memberScoreKey); err != nil {
-			return 0, err
-		}
-		if err := wb.Delete(sortKey); err != nil {
-			return 0, err
-		}
+			memberScoreKey := GetZSetMemberScoreKey(key, member)
+			sortKey := GetZSetSortKey(key, score, member)
 
-		removed++
-	}
+			if err := txn.Delete(memberScoreKey); err != nil {
+				return err
+			}
+			if err := txn.Delete(sortKey); err != nil {
+				return err
+			}
 
-	if err := wb.Commit(); err != nil {
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
 		return 0, err
 	}
 
@@ -292,9 +287,6 @@ func (rz *RZSet) ZCard(key string) (int64, error) {
 		return 0, err_def.ErrEmptyKey
 	}
 
-	rz.zsetLock.RLock()
-	defer rz.zsetLock.RUnlock()
-
 	prefix := fmt.Sprintf("%s:%s:", ZSetPrefix, key)
 	keys, err := rz.dw.GetDB().Keys(prefix + "*")
 	if err != nil {
@@ -316,10 +308,7 @@ func (rz *RZSet) ZScore(key, member string) (float64, error) {
 		return 0, err_def.ErrEmptyKey
 	}
 
-	rz.zsetLock.RLock()
-	defer rz.zsetLock.RUnlock()
-
-	score, exists, err := rz.getMemberScore(key, member)
+	score, exists, err := rz.getMemberScore(rz.dw.GetDB(), key, member)
 	if err != nil {
 		return 0, err
 	}
@@ -335,20 +324,21 @@ func (rz *RZSet) ZIncrBy(key, member string, increment float64) (float64, error)
 		return 0, err_def.ErrEmptyKey
 	}
 
-	rz.zsetLock.Lock()
-	defer rz.zsetLock.Unlock()
-
-	oldScore, exists, err := rz.getMemberScore(key, member)
-	if err != nil {
-		return 0, err
-	}
+	var newScore float64
+	err := rz.dw.GetDB().Update(func(txn *base.Txn) error {
+		oldScore, exists, err := rz.getMemberScore(txn, key, member)
+		if err != nil {
+			return err
+		}
 
-	newScore := increment
-	if exists {
-		newScore += oldScore
-	}
+		newScore = increment
+		if exists {
+			newScore += oldScore
+		}
 
-	_, err = rz.ZAdd(key, ZMember{Member: member, Score: newScore})
+		_, err = rz.addMembers(txn, key, []ZMember{{Member: member, Score: newScore}})
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -369,9 +359,6 @@ func (rz *RZSet) zrangeByScoreGeneric(key string, min, max float64, withScores b
 		return nil, err_def.ErrEmptyKey
 	}
 
-	rz.zsetLock.RLock()
-	defer rz.zsetLock.RUnlock()
-
 	prefix := fmt.Sprintf("%s:%s:s:", ZSetPrefix, key)
 	keys, err := rz.dw.GetDB().Keys(prefix + "*")
 	if err != nil {
@@ -386,7 +373,7 @@ func (rz *RZSet) zrangeByScoreGeneric(key string, min, max float64, withScores b
 		}
 
 		member := parts[len(parts)-1]
-		score, _, err := rz.getMemberScore(key, member)
+		score, _, err := rz.getMemberScore(rz.dw.GetDB(), key, member)
 		if err != nil {
 			return nil, err
 		}
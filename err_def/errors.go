@@ -6,19 +6,27 @@ import (
 )
 
 var (
-	ErrKeyNotFound       = errors.New("key not found")
-	ErrChecksumInvalid   = errors.New("checksum invalid")
-	ErrDBClosed          = errors.New("database is closed")
-	ErrWriteFailed       = errors.New("write failed")
-	ErrReadFailed        = errors.New("read failed")
-	ErrFileNotFound      = errors.New("file not found")
-	ErrNilRecord         = errors.New("nil record")
-	ErrKeyTooLarge       = errors.New("key too large")
-	ErrValueTooLarge     = errors.New("value too large")
-	ErrEmptyKey          = errors.New("empty key")
-	ErrChecksumMismatch  = errors.New("checksum mismatch")
-	ErrInsufficientData  = errors.New("insufficient data")
-	ErrDataLengthInvalid = errors.New("invalid data length")
-	ErrValueNotInteger   = fmt.Errorf("value is not an integer")
-	ErrValueNotFloat     = fmt.Errorf("value is not a float")
+	ErrKeyNotFound            = errors.New("key not found")
+	ErrChecksumInvalid        = errors.New("checksum invalid")
+	ErrDBClosed               = errors.New("database is closed")
+	ErrWriteFailed            = errors.New("write failed")
+	ErrReadFailed             = errors.New("read failed")
+	ErrFileNotFound           = errors.New("file not found")
+	ErrNilRecord              = errors.New("nil record")
+	ErrKeyTooLarge            = errors.New("key too large")
+	ErrValueTooLarge          = errors.New("value too large")
+	ErrEmptyKey               = errors.New("empty key")
+	ErrChecksumMismatch       = errors.New("checksum mismatch")
+	ErrInsufficientData       = errors.New("insufficient data")
+	ErrDataLengthInvalid      = errors.New("invalid data length")
+	ErrIndexUnordered         = errors.New("memory index does not support ordered iteration")
+	ErrSnapshotReleased       = errors.New("snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict")
+	ErrDirLocked              = errors.New("data directory is locked by another process")
+	ErrReadOnly               = errors.New("database is opened read-only")
+	ErrFileHeaderCorrupt      = errors.New("data file header corrupt")
+	ErrFileVersionUnsupported = errors.New("unsupported data file version")
+	ErrInvalidBackupDir       = errors.New("backup directory must be a relative path inside the backup root")
+	ErrValueNotInteger        = fmt.Errorf("value is not an integer")
+	ErrValueNotFloat          = fmt.Errorf("value is not a float")
 )
@@ -72,6 +72,16 @@ func (c *Connection) IsClosed() bool {
 	return c.closed
 }
 
+// IsActive 连接未关闭且对端仍在线
+func (c *Connection) IsActive() bool {
+	return !c.IsClosed() && c.conn.IsActive()
+}
+
+// Done 连接关闭时关闭
+func (c *Connection) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
 func (c *Connection) ReadCommand() (*protocol.Command, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -7,8 +7,11 @@ import (
 	"github.com/FinnTew/FincasKV/database/redis"
 	"github.com/FinnTew/FincasKV/network/conn"
 	"github.com/FinnTew/FincasKV/network/protocol"
+	"github.com/FinnTew/FincasKV/storage/bitcask"
+	"log"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +22,8 @@ var (
 
 type Handler struct {
 	db *database.FincasDB
+
+	saving atomic.Bool // 是否有进行中的后台检查点
 }
 
 func New(db *database.FincasDB) *Handler {
@@ -158,6 +163,13 @@ func (h *Handler) Handle(conn *conn.Connection, cmd *protocol.Command) error {
 		return h.handleZRemRangeByRank(conn, cmd)
 	case "ZREMRANGEBYSCORE":
 		return h.handleZRemRangeByScore(conn, cmd)
+	// Server commands
+	case "BGSAVE":
+		return h.handleBGSave(conn, cmd)
+	case "BACKUP":
+		return h.handleBackup(conn, cmd)
+	case "CDC":
+		return h.handleCDC(conn, cmd)
 	default:
 		return conn.WriteError(errors.New("unknown command"))
 	}
@@ -175,6 +187,135 @@ func (h *Handler) handlePing(conn *conn.Connection, cmd *protocol.Command) error
 	return conn.WriteString("PONG")
 }
 
+// handleBGSave BGSAVE [dir]，在后台创建检查点，dir 为配置的备份目录下的相对路径，未指定时按时间生成
+func (h *Handler) handleBGSave(conn *conn.Connection, cmd *protocol.Command) error {
+	if len(cmd.Args) > 1 {
+		return conn.WriteError(ErrWrongArgCount)
+	}
+
+	var dir string
+	if len(cmd.Args) == 1 {
+		dir = string(cmd.Args[0])
+	}
+	if err := database.CheckBackupName(dir); err != nil {
+		return conn.WriteError(err)
+	}
+
+	if !h.saving.CompareAndSwap(false, true) {
+		return conn.WriteError(errors.New("background save already in progress"))
+	}
+	go func() {
+		defer h.saving.Store(false)
+		if path, err := h.db.Checkpoint(dir); err != nil {
+			log.Printf("background save failed: %v", err)
+		} else {
+			log.Printf("background save finished: %s", path)
+		}
+	}()
+
+	return conn.WriteString("Background saving started")
+}
+
+// handleBackup BACKUP <dir>，同步创建检查点，dir 为配置的备份目录下的相对路径
+func (h *Handler) handleBackup(conn *conn.Connection, cmd *protocol.Command) error {
+	if len(cmd.Args) != 1 {
+		return conn.WriteError(ErrWrongArgCount)
+	}
+
+	if _, err := h.db.Checkpoint(string(cmd.Args[0])); err != nil {
+		return conn.WriteError(err)
+	}
+
+	return conn.WriteString("OK")
+}
+
+// cdcLivenessInterval 变更流空闲时检查客户端是否断开的间隔
+const cdcLivenessInterval = time.Second
+
+// handleCDC CDC [SEQ <seq> | POS <fileID> <offset> <seq>]，持续推送已提交的变更直到客户端断开
+// 每条变更为数组 [change, op, key, value, seq, timestamp, fileID, offset]，删除的 value 为空，
+// 用最后收到的 POS <fileID> <offset> <seq> 重新订阅可从断开处继续；不带参数时只推送之后的变更
+func (h *Handler) handleCDC(conn *conn.Connection, cmd *protocol.Command) error {
+	from, err := h.parseCDCPosition(cmd.Args)
+	if err != nil {
+		return conn.WriteError(err)
+	}
+
+	sub, err := h.db.Subscribe(from)
+	if err != nil {
+		return conn.WriteError(err)
+	}
+	defer sub.Close()
+
+	ticker := time.NewTicker(cdcLivenessInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					return conn.WriteError(err)
+				}
+				return nil
+			}
+			if err := conn.WriteArray(cdcEventReply(ev)); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if !conn.IsActive() {
+				return nil
+			}
+		case <-conn.Done():
+			return nil
+		}
+	}
+}
+
+func (h *Handler) parseCDCPosition(args [][]byte) (bitcask.Position, error) {
+	if len(args) == 0 {
+		return bitcask.FromLatest(), nil
+	}
+
+	switch strings.ToUpper(string(args[0])) {
+	case "SEQ":
+		if len(args) != 2 {
+			return bitcask.Position{}, ErrWrongArgCount
+		}
+		seq, err := strconv.ParseUint(string(args[1]), 10, 64)
+		if err != nil {
+			return bitcask.Position{}, ErrSyntax
+		}
+		return bitcask.FromSequence(seq), nil
+	case "POS":
+		if len(args) != 4 {
+			return bitcask.Position{}, ErrWrongArgCount
+		}
+		// 回放历史记录期间的游标文件编号为 -1，此时按序列号恢复
+		fileID, err1 := strconv.Atoi(string(args[1]))
+		offset, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+		seq, err3 := strconv.ParseUint(string(args[3]), 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || offset < 0 {
+			return bitcask.Position{}, ErrSyntax
+		}
+		return bitcask.Position{FileID: fileID, Offset: offset, Seq: seq}, nil
+	default:
+		return bitcask.Position{}, ErrSyntax
+	}
+}
+
+func cdcEventReply(ev bitcask.ChangeEvent) [][]byte {
+	return [][]byte{
+		[]byte("change"),
+		[]byte(ev.Op.String()),
+		[]byte(ev.Key),
+		ev.Value,
+		[]byte(strconv.FormatUint(ev.Position.Seq, 10)),
+		[]byte(strconv.FormatInt(ev.Timestamp, 10)),
+		[]byte(strconv.Itoa(ev.Position.FileID)),
+		[]byte(strconv.FormatInt(ev.Position.Offset, 10)),
+	}
+}
+
 func (h *Handler) handleSet(conn *conn.Connection, cmd *protocol.Command) error {
 	if len(cmd.Args) != 2 {
 		return conn.WriteError(ErrWrongArgCount)
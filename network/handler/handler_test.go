@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/FinnTew/FincasKV/config"
+	"github.com/FinnTew/FincasKV/database"
+	"github.com/FinnTew/FincasKV/err_def"
+	"github.com/FinnTew/FincasKV/network/conn"
+	"github.com/FinnTew/FincasKV/network/protocol"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"github.com/cloudwego/netpoll"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backupRoot 测试配置中的备份目录
+var backupRoot string
+
+func TestMain(m *testing.M) {
+	root, err := os.MkdirTemp("", "fincas-handler")
+	if err != nil {
+		panic(err)
+	}
+	backupRoot = filepath.Join(root, "backup")
+	confPath := filepath.Join(root, "conf.yaml")
+	conf := fmt.Sprintf("base:\n  data_dir: %q\n  backup_dir: %q\n", filepath.Join(root, "data"), backupRoot)
+	if err := os.WriteFile(confPath, []byte(conf), 0644); err != nil {
+		panic(err)
+	}
+	if err := config.Init(confPath); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(root)
+	os.Exit(code)
+}
+
+// fakeConn 只实现写入的连接，记录回复
+type fakeConn struct {
+	netpoll.Connection
+	out bytes.Buffer
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+// exec 执行一条命令并返回回复
+func exec(t *testing.T, h *Handler, name string, args ...string) string {
+	t.Helper()
+	fc := &fakeConn{}
+	cmd := &protocol.Command{Name: name}
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, []byte(arg))
+	}
+	require.NoError(t, h.Handle(conn.New(fc), cmd))
+	return fc.out.String()
+}
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	db := database.NewFincasDB(t.TempDir())
+	t.Cleanup(db.Close)
+	return New(db)
+}
+
+func assertCheckpoint(t *testing.T, dir string) {
+	t.Helper()
+	_, err := os.Stat(filepath.Join(dir, file_manager.CheckpointFileName))
+	assert.NoError(t, err, dir)
+}
+
+func TestBackup(t *testing.T) {
+	h := newTestHandler(t)
+	assert.Equal(t, "+OK\r\n", exec(t, h, "SET", "k", "v"))
+
+	assert.Equal(t, "+OK\r\n", exec(t, h, "BACKUP", "nightly/1"))
+	assertCheckpoint(t, filepath.Join(backupRoot, "nightly", "1"))
+
+	// 目录已存在检查点时拒绝覆盖
+	assert.True(t, strings.HasPrefix(exec(t, h, "BACKUP", "nightly/1"), "-"))
+
+	// 只接受备份目录下的相对路径
+	outside := filepath.Join(t.TempDir(), "outside")
+	for _, dir := range []string{outside, "../escape", "a/../../escape", "."} {
+		reply := exec(t, h, "BACKUP", dir)
+		assert.Equal(t, "-"+fmt.Errorf("%w: %s", err_def.ErrInvalidBackupDir, dir).Error()+"\r\n", reply, dir)
+	}
+	_, err := os.Stat(outside)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(filepath.Dir(backupRoot), "escape"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.Equal(t, "-"+ErrWrongArgCount.Error()+"\r\n", exec(t, h, "BACKUP"))
+}
+
+func TestBGSave(t *testing.T) {
+	h := newTestHandler(t)
+	assert.Equal(t, "+OK\r\n", exec(t, h, "SET", "k", "v"))
+
+	waitSaved := func() {
+		require.Eventually(t, func() bool { return !h.saving.Load() }, 5*time.Second, 10*time.Millisecond)
+	}
+
+	assert.Equal(t, "+Background saving started\r\n", exec(t, h, "BGSAVE", "bg"))
+	waitSaved()
+	assertCheckpoint(t, filepath.Join(backupRoot, "bg"))
+
+	// 不指定目录时在备份目录下按时间生成
+	before, _ := os.ReadDir(backupRoot)
+	assert.Equal(t, "+Background saving started\r\n", exec(t, h, "BGSAVE"))
+	waitSaved()
+	after, err := os.ReadDir(backupRoot)
+	require.NoError(t, err)
+	require.Len(t, after, len(before)+1)
+	for _, e := range after {
+		if strings.HasPrefix(e.Name(), "checkpoint-") {
+			assertCheckpoint(t, filepath.Join(backupRoot, e.Name()))
+		}
+	}
+
+	// 越出备份目录的路径同步返回错误，不启动后台任务
+	reply := exec(t, h, "BGSAVE", "../escape")
+	assert.True(t, strings.HasPrefix(reply, "-"+err_def.ErrInvalidBackupDir.Error()), reply)
+	assert.False(t, h.saving.Load())
+
+	// 已有进行中的后台检查点
+	h.saving.Store(true)
+	assert.Equal(t, "-background save already in progress\r\n", exec(t, h, "BGSAVE", "busy"))
+	h.saving.Store(false)
+	_, err = os.Stat(filepath.Join(backupRoot, "busy"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.Equal(t, "-"+ErrWrongArgCount.Error()+"\r\n", exec(t, h, "BGSAVE", "a", "b"))
+}
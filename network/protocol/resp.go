@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -51,6 +52,49 @@ func (p *Parser) Parse() (*Command, error) {
 	}
 }
 
+// ParseBulkArray 解析由批量字符串组成的数组回复，元素可以为空，错误回复以 error 返回
+func (p *Parser) ParseBulkArray() ([][]byte, error) {
+	typ, err := p.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case ARRAY:
+	case ERROR:
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New(strings.TrimSuffix(line, "\r\n"))
+	default:
+		return nil, ErrInvalidRESP
+	}
+
+	length, err := p.parseInteger()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, nil
+	}
+
+	arr := make([][]byte, length)
+	for i := range arr {
+		typ, err := p.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if typ != BULK {
+			return nil, ErrInvalidRESP
+		}
+		if arr[i], err = p.parseBulkString(); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
 func (p *Parser) parseArray() (*Command, error) {
 	length, err := p.parseInteger()
 	if err != nil {
@@ -83,6 +83,31 @@ func (s *Server) handleClusterCommand(conn *conn.Connection, cmd *protocol.Comma
 
 			return conn.WriteBulk(data)
 
+		case "FETCH":
+			// CLUSTER FETCH <key> [key ...]，按存储层编码后的键返回原始值，供其他节点恢复损坏的键
+			// 只有领导者应答，并在读取前等待已提交的日志全部应用，返回的值不旧于请求方损坏前的值
+			if s.node == nil {
+				return conn.WriteError(fmt.Errorf("cluster not initialized"))
+			}
+			if len(cmd.Args) < 2 {
+				return conn.WriteError(fmt.Errorf("CLUSTER FETCH requires at least one key"))
+			}
+			if err := s.node.ReadBarrier(); err != nil {
+				return conn.WriteError(err)
+			}
+
+			keys := make([]string, len(cmd.Args)-1)
+			for i, arg := range cmd.Args[1:] {
+				keys[i] = string(arg)
+			}
+			values := s.db.FetchRaw(keys)
+			reply := make([][]byte, len(keys))
+			for i, key := range keys {
+				reply[i] = values[key]
+			}
+
+			return conn.WriteArray(reply)
+
 		default:
 			return conn.WriteError(fmt.Errorf("unknown cluster command: %s", cmd.Args[0]))
 		}
@@ -159,7 +159,7 @@ func (s *Server) Stop() error {
 	}
 
 	s.conns.Range(func(key, value interface{}) bool {
-		if c, ok := value.(conn.Connection); ok {
+		if c, ok := value.(*conn.Connection); ok {
 			c.Close()
 		}
 		return true
@@ -215,7 +215,7 @@ func (s *Server) handleConnection(ctx context.Context, c netpoll.Connection) err
 			// 禁止非Leader节点处理写操作
 			cmdP, ok := isWriteCommand(cmd.Name)
 			if ok && s.node != nil && !s.node.IsLeader() {
-				leaderAddr := s.node
+				leaderAddr := s.node.GetLeaderAddr()
 				return connection.WriteError(fmt.Errorf("redirect to leader: %s", leaderAddr))
 			}
 
@@ -259,7 +259,7 @@ func (s *Server) collectMetrics() {
 	var totalWriteBytes int64
 
 	s.conns.Range(func(key, value interface{}) bool {
-		if c, ok := value.(conn.Connection); ok {
+		if c, ok := value.(*conn.Connection); ok {
 			stats := c.Stats()
 			atomic.AddInt64(&totalReadBytes, stats.ReadBytes)
 			atomic.AddInt64(&totalWriteBytes, stats.WriteBytes)
@@ -281,6 +281,7 @@ func (s *Server) collectMetrics() {
 }
 
 func (s *Server) initCluster(conf *node.Config) error {
+	conf.RepairPeers = config.Get().Scrub.RepairPeers
 	n, err := node.New(s.db, conf)
 	if err != nil {
 		return fmt.Errorf("failed to create node: %v", err)
@@ -0,0 +1,218 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"slices"
+	"time"
+)
+
+// 批量写入的落盘格式:
+//
+//	record(FlagBatch) ... record(FlagBatch) | commit(FlagBatchCommit, key=batchID(8), value=count(4))
+//
+// 所有记录与提交标记在一次写入中追加到同一个文件的连续位置，
+// 加载时只有紧跟着匹配提交标记的批量记录才会生效，崩溃留下的半个批次被整体忽略
+
+// BatchOp 批量写入中的一个操作
+type BatchOp struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+// BatchCond 批量写入的前置条件，提交时键必须仍处于读取时的版本
+// Exists 为 false 表示键必须不存在，否则键最后一次写入的序列号必须等于 Seq
+type BatchCond struct {
+	Key    string
+	Seq    uint64
+	Exists bool
+}
+
+// ApplyBatch 原子地写入一组操作，崩溃后要么全部生效，要么全部不生效
+// 同一个键出现多次时以最后一次为准
+func (db *Bitcask) ApplyBatch(ops []BatchOp) error {
+	return db.ApplyBatchIf(ops, nil)
+}
+
+// ApplyBatchIf 在所有前置条件成立时原子地写入一组操作，任一条件不成立时返回 ErrTxnConflict 且不写入
+// 条件检查与写入在同一组键锁下完成，期间其他写入无法修改涉及的键
+func (db *Bitcask) ApplyBatchIf(ops []BatchOp, conds []BatchCond) error {
+	if db.closed {
+		return err_def.ErrDBClosed
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(ops)+len(conds))
+	for _, op := range ops {
+		if len(op.Key) == 0 {
+			return err_def.ErrEmptyKey
+		}
+		keys = append(keys, op.Key)
+	}
+	for _, c := range conds {
+		keys = append(keys, c.Key)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	unlock := db.lockKeys(keys)
+	defer unlock()
+
+	for _, c := range conds {
+		entry, err := db.memIndex.Get(c.Key)
+		if exists := err == nil; exists != c.Exists || (exists && entry.Seq != c.Seq) {
+			return err_def.ErrTxnConflict
+		}
+	}
+
+	now := time.Now().UnixNano()
+	records := make([]*storage2.Record, 0, len(ops)+1)
+	if slices.ContainsFunc(ops, func(op BatchOp) bool { return !op.Delete && db.separated(op.Value) }) {
+		defer db.pinBlobs()()
+	}
+	for _, op := range ops {
+		flags := FlagNormal
+		value := op.Value
+		if op.Delete {
+			flags, value = FlagDeleted, nil
+		}
+		record := &storage2.Record{
+			Timestamp: now,
+			Flags:     flags | storage2.FlagBatch,
+			KVItem: storage2.KVItem{
+				Key:   []byte(op.Key),
+				Value: value,
+			},
+		}
+		// 批次未提交时已写入的 blob 不被引用，由 blob GC 回收
+		if !op.Delete {
+			if err := db.separateValue(record); err != nil {
+				return err
+			}
+		}
+		records = append(records, record)
+	}
+	records = append(records, newBatchCommit(db.batchID.Add(1), len(ops), now))
+
+	resp := <-db.fm.WriteBatchAsync(records)
+	if resp.Err != nil {
+		return fmt.Errorf("write batch failed: %w", resp.Err)
+	}
+
+	// 提交标记本身不含数据，直接计为无效
+	marker := resp.Entries[len(ops)]
+	db.stats.addTotal(marker)
+	db.stats.addDead(marker)
+
+	// 整个批次在同一次 commitMu 读锁内更新索引，快照不会看到只应用了一部分的批次
+	defer db.commitLock()()
+	for i, op := range ops {
+		entry := resp.Entries[i]
+		if !op.Delete {
+			if err := db.commitPut(op.Key, op.Value, records[i], entry); err != nil {
+				return err
+			}
+			continue
+		}
+		// 批量删除不存在的键不视为错误，删除标记直接计为无效
+		if _, err := db.memIndex.Get(op.Key); err != nil {
+			db.stats.addTotal(entry)
+			db.stats.addDead(entry)
+			continue
+		}
+		if err := db.commitDel(op.Key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newBatchCommit 构造批次的提交标记
+func newBatchCommit(batchID uint64, count int, timestamp int64) *storage2.Record {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, batchID)
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, uint32(count))
+	return &storage2.Record{
+		Timestamp: timestamp,
+		Flags:     FlagBatchCommit,
+		KVItem: storage2.KVItem{
+			Key:   key,
+			Value: value,
+		},
+	}
+}
+
+// pendingRecord 扫描时等待提交标记的批量记录
+type pendingRecord struct {
+	key   []byte
+	value []byte
+	flags uint32
+	entry storage2.Entry
+	hint  *file_manager.HintEntry
+}
+
+// batchReplay 全量扫描数据文件时按提交标记回放批量写入
+type batchReplay struct {
+	db      *Bitcask
+	pending []pendingRecord
+	next    int64 // 下一条记录的期望位置，不连续说明跳过了损坏区域，之前的批次不完整
+}
+
+// add 处理扫描到的一条已解密记录，返回需要写入 hint 的记录
+func (b *batchReplay) add(r *storage2.Record, entry storage2.Entry, hint *file_manager.HintEntry) ([]file_manager.HintEntry, error) {
+	if entry.Offset != b.next {
+		b.discard()
+	}
+	b.next = entry.Offset + int64(entry.Size)
+
+	switch {
+	case r.Flags&storage2.FlagBatch != 0:
+		b.pending = append(b.pending, pendingRecord{key: r.Key, value: r.Value, flags: r.Flags, entry: entry, hint: hint})
+		return nil, nil
+
+	case storage2.RecordType(r.Flags) == FlagBatchCommit:
+		b.db.stats.addTotal(entry)
+		b.db.stats.addDead(entry)
+		if len(r.Value) != 4 || int(binary.BigEndian.Uint32(r.Value)) != len(b.pending) {
+			b.discard()
+			return nil, nil
+		}
+		var hints []file_manager.HintEntry
+		for _, p := range b.pending {
+			if err := b.db.applyEntry(p.key, p.flags, p.value, p.entry); err != nil {
+				return nil, err
+			}
+			if p.hint != nil {
+				hints = append(hints, *p.hint)
+			}
+		}
+		b.pending = b.pending[:0]
+		return hints, nil
+
+	default:
+		b.discard()
+		if err := b.db.applyEntry(r.Key, r.Flags, r.Value, entry); err != nil {
+			return nil, err
+		}
+		if hint != nil {
+			return []file_manager.HintEntry{*hint}, nil
+		}
+		return nil, nil
+	}
+}
+
+// discard 丢弃没有提交标记的批量记录，其空间计为无效
+func (b *batchReplay) discard() {
+	for _, p := range b.pending {
+		b.db.stats.addTotal(p.entry)
+		b.db.stats.addDead(p.entry)
+	}
+	b.pending = b.pending[:0]
+}
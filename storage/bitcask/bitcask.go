@@ -1,7 +1,6 @@
 package bitcask
 
 import (
-	"encoding/binary"
 	"fmt"
 	"github.com/FinnTew/FincasKV/err_def"
 	storage2 "github.com/FinnTew/FincasKV/storage"
@@ -9,18 +8,20 @@ import (
 	"github.com/FinnTew/FincasKV/storage/file_manager"
 	"github.com/FinnTew/FincasKV/storage/index"
 	"github.com/FinnTew/FincasKV/util"
-	"io"
-	"os"
-	"path/filepath"
+	"hash/fnv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// Flag 标记位，用于标识记录的状态
+// keyLockCount 写入时按键分段加锁的段数
+const keyLockCount = 256
+
+// Flag 记录类型，占用 Flags 的低 8 位，其余位见 storage.FlagTypeMask 等定义
 const (
 	FlagNormal uint32 = iota
 	FlagDeleted
+	FlagBatchCommit // 批量写入的提交标记，键为批次编号，值为批次内的记录数
 )
 
 // Bitcask 实现
@@ -32,12 +33,39 @@ type Bitcask struct {
 	memIndex *index.MemIndexShard[string, storage2.Entry]
 	memCache storage2.MemCache[string, []byte]
 
-	filter *util.ShardedBloomFilter
+	// 计数布隆过滤器，删除键时同步移除；合并后可能按键数重建，因此原子替换
+	filter atomic.Pointer[util.CountingBloomFilter]
+
+	stats *fileStats // 按文件统计的无效数据，用于挑选待合并文件
+	blobs *blobRefs  // 值分离存储的键引用的 blob，用于 blob 文件的 GC
+
+	recovery RecoveryReport // 打开时的损坏恢复报告
+
+	// 加载期间已删除的键及删除标记的序列号，多个写入通道的文件交错时避免较早的写入在删除之后被应用
+	loadDeleted map[string]uint64
+
+	batchID atomic.Uint64 // 批量写入的批次编号，以打开时间为起点
+
+	// 未释放的快照，存在快照时写入需为其保存旧索引项，合并留下的旧文件推迟删除
+	snapMu    sync.Mutex
+	snapshots map[*Snapshot]struct{}
+	snapCount atomic.Int32
+
+	// 写入落盘后更新索引的阶段持有 commitMu 读锁，创建快照时持有写锁，只等待进行中的索引更新而不等待落盘
+	commitMu  sync.RWMutex
+	commitSeq atomic.Uint64 // 已更新到索引的最大序列号
 
 	mergeRunning  atomic.Bool
+	mergeMu       sync.Mutex // 合并期间持有，关闭时等待合并结束
 	mergeTicker   *time.Ticker
 	mergeStopChan chan struct{}
 
+	scrub scrubber // 后台校验已封存数据文件的校验和
+
+	// 写入持有 mu 读锁与键所在分段的锁，不同键的写入可并发进入写队列以便 group commit 合并；
+	// 合并切换索引、关闭时持有 mu 写锁
+	keyLocks [keyLockCount]sync.Mutex
+
 	closed bool
 	mu     sync.RWMutex
 }
@@ -51,17 +79,34 @@ func Open(options ...storage2.Option) (*Bitcask, error) {
 	}
 
 	// 创建文件管理器
+	fmOpts := []file_manager.Option{
+		file_manager.WithDurability(cfg.Durability),
+		file_manager.WithCompression(cfg.Compression, cfg.CompressThreshold),
+		file_manager.WithKeyProvider(cfg.KeyProvider),
+		file_manager.WithWriteLanes(cfg.WriteLanes),
+	}
+	if cfg.MmapReads {
+		fmOpts = append(fmOpts, file_manager.WithMmap(cfg.MmapBudget))
+	}
+	if cfg.ReadOnly {
+		fmOpts = append(fmOpts, file_manager.WithReadOnly())
+	}
 	fm, err := file_manager.NewFileManager(
 		cfg.DataDir,
 		cfg.MaxFileSize,
 		cfg.MaxOpenFiles,
 		cfg.SyncInterval,
+		fmOpts...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("create file manager failed: %w", err)
 	}
 
 	// 创建内存索引
+	var shardOpts []index.ShardOption[string]
+	if cfg.MemIndexSharding == storage2.RangeSharding {
+		shardOpts = append(shardOpts, index.WithRangeSharding(cfg.MemIndexBounds))
+	}
 	memIndex := index.NewMemIndexShard[string, storage2.Entry](
 		cfg.MemIndexDS,
 		cfg.MemIndexShardCount,
@@ -70,6 +115,7 @@ func Open(options ...storage2.Option) (*Bitcask, error) {
 		cfg.SkipListRandSource,
 		cfg.SkipListComparator,
 		cfg.SwissTableSize,
+		shardOpts...,
 	)
 
 	var memCache storage2.MemCache[string, []byte]
@@ -77,59 +123,87 @@ func Open(options ...storage2.Option) (*Bitcask, error) {
 		switch cfg.MemCacheDS {
 		case storage2.LRU:
 			memCache = cache.NewLRUCache[string, []byte](cfg.MemCacheSize)
+		case storage2.LFU:
+			memCache = cache.NewLFUCache[string, []byte](cfg.MemCacheBytes, cacheEntrySize)
+		case storage2.ARC:
+			memCache = cache.NewARCCache[string, []byte](cfg.MemCacheBytes, cacheEntrySize)
+		case storage2.WTinyLFU:
+			memCache = cache.NewWTinyLFUCache[string, []byte](cfg.MemCacheBytes, cacheEntrySize)
 		default:
 			return nil, fmt.Errorf("unsopported memcache DS: %s", cfg.MemCacheDS)
 		}
 	}
 
-	filter, err := util.NewShardedBloomFilter(util.BloomConfig{
-		ExpectedElements:  1 << 10,
-		FalsePositiveRate: 0.01,
-		AutoScale:         true,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("create filter failed: %w", err)
-	}
-
 	db := &Bitcask{
 		cfg:           cfg,
 		fm:            fm,
 		memIndex:      memIndex,
 		memCache:      memCache,
-		filter:        filter,
+		stats:         newFileStats(),
+		blobs:         newBlobRefs(),
+		snapshots:     make(map[*Snapshot]struct{}),
 		mergeStopChan: make(chan struct{}),
 	}
+	db.scrub.stop = make(chan struct{})
+	db.batchID.Store(uint64(time.Now().UnixNano()))
 
 	// 加载数据文件，重建内存索引
 	if err := db.loadDataFiles(); err != nil {
+		_ = fm.Close()
 		return nil, fmt.Errorf("load data files failed: %w", err)
 	}
+	db.commitSeq.Store(fm.LastSequence())
+	if err := db.loadFilter(); err != nil {
+		_ = fm.Close()
+		return nil, err
+	}
 
 	// 启动自动 Merge
-	if cfg.AutoMerge {
+	if cfg.AutoMerge && !cfg.ReadOnly {
 		db.mergeTicker = time.NewTicker(cfg.MergeInterval)
 		go db.autoMerge()
 	}
+	if cfg.ScrubInterval > 0 {
+		db.StartScrub(cfg.ScrubInterval)
+	}
 
 	return db, nil
 }
 
 // loadDataFiles 从磁盘加载所有数据文件并重建内存索引
+// 优先读取 hint 文件，hint 缺失或损坏时回退到全量扫描数据文件
 func (db *Bitcask) loadDataFiles() error {
-	files, err := os.ReadDir(db.cfg.DataDir)
-	if err != nil {
-		return fmt.Errorf("read data directory failed: %w", err)
-	}
+	// 按清单顺序处理，保证后写入的记录覆盖先写入的；不同写入通道的记录按序列号判断新旧
+	fileIDs := db.fm.LiveFileIDs()
+
+	db.loadDeleted = make(map[string]uint64)
+	defer func() {
+		db.loadDeleted = nil
+	}()
 
-	// 按文件ID排序处理
-	for _, file := range files {
-		var fileID int
-		_, err := fmt.Sscanf(file.Name(), storage2.FilePrefix+"%d"+storage2.FileSuffix, &fileID)
+	for _, fileID := range fileIDs {
+		// 校验文件头，旧格式的文件在合并时按当前格式重写
+		header, err := db.fm.FileHeader(fileID)
 		if err != nil {
-			continue // 跳过不符合命名规则的文件
+			return fmt.Errorf("load data file %d failed: %w", fileID, err)
+		}
+		if header.Outdated() {
+			db.stats.markOutdated(fileID)
 		}
 
-		if err := db.loadDataFile(fileID); err != nil {
+		active := db.fm.IsActive(fileID)
+		if !active {
+			hints, err := file_manager.ReadHintFile(db.cfg.DataDir, fileID)
+			if err == nil {
+				if err := db.loadHintEntries(fileID, hints); err != nil {
+					return fmt.Errorf("load hint file %d failed: %w", fileID, err)
+				}
+				continue
+			}
+		}
+
+		// 只读打开时不补写 hint
+		if err := db.loadDataFile(fileID, !active && !db.cfg.ReadOnly); err != nil {
 			return fmt.Errorf("load data file %d failed: %w", fileID, err)
 		}
 	}
@@ -137,75 +211,118 @@ func (db *Bitcask) loadDataFiles() error {
 	return nil
 }
 
-// loadDataFile 加载单个数据文件，重建索引
-func (db *Bitcask) loadDataFile(fileID int) error {
-	file, err := db.fm.GetFile(fileID)
-	if err != nil {
-		return err
-	}
-
-	var offset int64
-	for {
-		// 读取头部信息
-		header := make([]byte, storage2.HeaderSize)
-		n, err := file.ReadAt(header, offset)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return fmt.Errorf("read header failed: %w", err)
+// loadHintEntries 根据 hint 记录重建索引
+func (db *Bitcask) loadHintEntries(fileID int, hints []file_manager.HintEntry) error {
+	for _, h := range hints {
+		entry := storage2.Entry{
+			FileID:    fileID,
+			Offset:    h.Offset,
+			Size:      h.Size,
+			Timestamp: h.Timestamp,
+			Seq:       h.Seq,
 		}
-		if n < storage2.HeaderSize {
-			break
+		db.fm.ObserveSequence(h.Seq)
+		if db.fm.NeedsRekey(h.Flags, h.Key) {
+			db.stats.markStale(fileID)
 		}
-
-		// 解析头部
-		keyLen := binary.BigEndian.Uint32(header[12:16])
-		valueLen := binary.BigEndian.Uint32(header[16:20])
-		recordSize := int64(storage2.HeaderSize) + int64(keyLen) + int64(valueLen) + 8 // +8 for checksum
-
-		// 读取完整记录
-		record := make([]byte, recordSize)
-		n, err = file.ReadAt(record, offset)
+		key, err := db.fm.OpenHintKey(fileID, h)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return fmt.Errorf("read record failed: %w", err)
+			return err
 		}
-		if n < int(recordSize) {
-			break
-		}
-
-		// 解码记录
-		r, err := file_manager.DecodeRecord(record)
-		if err != nil {
-			return fmt.Errorf("decode record failed: %w", err)
+		if err := db.applyEntry(key, h.Flags, h.Value, entry); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		// 更新内存索引
+// loadDataFile 全量扫描单个数据文件，重建索引，损坏记录按恢复策略处理
+// writeHint 为 true 时，扫描完成后为该文件补写 hint 文件，下次启动即可跳过扫描
+func (db *Bitcask) loadDataFile(fileID int, writeHint bool) error {
+	var hints []file_manager.HintEntry
+	replay := &batchReplay{db: db}
+	err := db.scanDataFile(fileID, func(r *storage2.Record, offset int64, size uint32) error {
 		entry := storage2.Entry{
 			FileID:    fileID,
 			Offset:    offset,
-			Size:      uint32(recordSize),
+			Size:      size,
 			Timestamp: r.Timestamp,
+			Seq:       r.Seq,
 		}
-
-		// 如果是删除标记，则删除索引
-		if r.Flags == FlagDeleted {
-			db.memIndex.Del(string(r.Key))
-		} else {
-			if err := db.memIndex.Put(string(r.Key), entry); err != nil {
-				return fmt.Errorf("update index failed: %w", err)
-			}
-			if err := db.filter.Add(r.Key); err != nil {
-				return fmt.Errorf("update filter failed: %w", err)
+		db.fm.ObserveSequence(r.Seq)
+		if db.fm.NeedsRekey(r.Flags, r.Key) {
+			db.stats.markStale(fileID)
+		}
+		if err := db.fm.OpenRecord(r, fileID, offset); err != nil {
+			return err
+		}
+		var hint *file_manager.HintEntry
+		if writeHint && storage2.RecordType(r.Flags) != FlagBatchCommit {
+			h, err := db.fm.NewHintEntry(r, fileID, offset, size)
+			if err != nil {
+				return err
 			}
+			hint = &h
 		}
+		// 批量记录等到提交标记出现后才生效
+		applied, err := replay.add(r, entry, hint)
+		hints = append(hints, applied...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	replay.discard()
 
-		offset += recordSize
+	if writeHint {
+		// hint 仅用于加速启动，写入失败时下次启动继续全量扫描即可
+		_ = file_manager.WriteHintFile(db.cfg.DataDir, fileID, hints)
 	}
+	return nil
+}
 
+// applyEntry 将一条加载出的记录应用到内存索引，并更新文件统计
+// value 只在 FlagBlob 记录中使用，为 blob 指针
+func (db *Bitcask) applyEntry(key []byte, flags uint32, value []byte, entry storage2.Entry) error {
+	db.stats.addTotal(entry)
+	// hint 中的提交标记所在批次必然完整，标记本身不含数据
+	if storage2.RecordType(flags) == FlagBatchCommit {
+		db.stats.addDead(entry)
+		return nil
+	}
+	if old, err := db.memIndex.Get(string(key)); err == nil {
+		// 按序列号判断新旧，不依赖文件顺序与时钟；旧格式记录没有序列号，仍按回放顺序覆盖
+		// 序列号相同只出现在 blob GC 搬移时，搬移后的记录可能先于原记录回放
+		if old.Seq != 0 && entry.Seq != 0 &&
+			(old.Seq > entry.Seq || (old.Seq == entry.Seq && db.blobs.relocated(string(key), flags, value))) {
+			db.stats.addDead(entry)
+			return nil
+		}
+		db.stats.addDead(old)
+	} else if seq, ok := db.loadDeleted[string(key)]; ok && entry.Seq != 0 && seq > entry.Seq {
+		// 键已被另一个文件中更新的删除标记删除
+		db.stats.addDead(entry)
+		return nil
+	}
+
+	if err := db.blobs.apply(string(key), flags, value); err != nil {
+		return err
+	}
+
+	// 如果是删除标记，则删除索引
+	if storage2.RecordType(flags) == FlagDeleted {
+		db.stats.addDead(entry)
+		_ = db.memIndex.Del(string(key))
+		if db.loadDeleted != nil && entry.Seq != 0 {
+			db.loadDeleted[string(key)] = entry.Seq
+		}
+		return nil
+	}
+	delete(db.loadDeleted, string(key))
+
+	if err := db.memIndex.Put(string(key), entry); err != nil {
+		return fmt.Errorf("update index failed: %w", err)
+	}
 	return nil
 }
 
@@ -218,9 +335,16 @@ func (db *Bitcask) Put(key string, value []byte) error {
 		return err_def.ErrEmptyKey
 	}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	unlock := db.lockKey(key)
+	defer unlock()
+
+	return db.putLocked(key, value)
+}
 
+// putLocked 写入键值对，调用方需持有 mu 读锁与键锁
+func (db *Bitcask) putLocked(key string, value []byte) error {
 	// 构造记录并直接使用FileManager的异步写入
 	record := &storage2.Record{
 		Timestamp: time.Now().UnixNano(),
@@ -230,6 +354,13 @@ func (db *Bitcask) Put(key string, value []byte) error {
 			Value: value,
 		},
 	}
+	// 大值先写入 blob 文件，数据文件中只写指针
+	if db.separated(value) {
+		defer db.pinBlobs()()
+	}
+	if err := db.separateValue(record); err != nil {
+		return err
+	}
 
 	// 直接使用FileManager的异步写入
 	respCh := db.fm.WriteAsync(record)
@@ -238,21 +369,44 @@ func (db *Bitcask) Put(key string, value []byte) error {
 		return fmt.Errorf("write record failed: %w", resp.Err)
 	}
 
+	defer db.commitLock()()
+	return db.commitPut(key, value, record, resp.Entry)
+}
+
+// commitPut 写入落盘后更新文件统计、内存索引、缓存与布隆过滤器，调用方需持有键锁与 commitMu 读锁
+// record 为写入数据文件的记录，值分离存储时其值为 blob 指针
+func (db *Bitcask) commitPut(key string, value []byte, record *storage2.Record, entry storage2.Entry) error {
+	// 更新文件统计，被覆盖的旧记录计为无效
+	db.stats.addTotal(entry)
+	old, err := db.memIndex.Get(key)
+	existed := err == nil
+	if existed {
+		db.stats.addDead(old)
+	}
+
+	db.preserveForSnapshots(key)
+
 	// 更新内存索引
-	if err := db.memIndex.Put(key, resp.Entry); err != nil {
+	if err := db.memIndex.Put(key, entry); err != nil {
 		return fmt.Errorf("update index failed: %w", err)
 	}
+	db.advanceCommitSeq(entry.Seq)
+	if err := db.blobs.apply(key, record.Flags, record.Value); err != nil {
+		return err
+	}
 
-	// 更新内存缓存
+	// 更新内存缓存，分块写入的值不进入缓存
 	if db.memCache != nil {
-		if err := db.memCache.Insert(key, value); err != nil {
+		if record.Flags&storage2.FlagChunked != 0 {
+			_ = db.memCache.Delete(key)
+		} else if err := db.memCache.Insert(key, value); err != nil {
 			return fmt.Errorf("update cache failed: %w", err)
 		}
 	}
 
-	// 更新布隆过滤器
-	if db.filter != nil {
-		_ = db.filter.Add(record.Key)
+	// 更新布隆过滤器，过滤器对每个存在的键只计数一次，覆盖写入不再添加
+	if !existed {
+		_ = db.filter.Load().Add([]byte(key))
 	}
 
 	return nil
@@ -271,10 +425,8 @@ func (db *Bitcask) Get(key string) ([]byte, error) {
 	defer db.mu.RUnlock()
 
 	// 检查布隆过滤器
-	if db.filter != nil {
-		if !db.filter.Contains([]byte(key)) {
-			return nil, err_def.ErrKeyNotFound
-		}
+	if !db.filter.Load().Contains([]byte(key)) {
+		return nil, err_def.ErrKeyNotFound
 	}
 
 	// 检查内存缓存
@@ -290,25 +442,85 @@ func (db *Bitcask) Get(key string) ([]byte, error) {
 		return nil, err_def.ErrKeyNotFound
 	}
 
-	// 直接使用FileManager的读取
-	record, err := db.fm.Read(entry)
+	record, err := db.readRecord(entry)
 	if err != nil {
-		return nil, fmt.Errorf("read record failed: %w", err)
+		return nil, err
 	}
 
 	// 检查删除标记
-	if record.Flags == FlagDeleted {
+	if storage2.RecordType(record.Flags) == FlagDeleted {
 		return nil, err_def.ErrKeyNotFound
 	}
 
-	// 更新缓存
-	if db.memCache != nil {
-		_ = db.memCache.Insert(key, record.Value)
+	// 更新缓存，读取期间键可能已被并发写入覆盖，此时不能用旧值填充缓存
+	if db.memCache != nil && record.Flags&storage2.FlagChunked == 0 {
+		unlock := db.lockKey(key)
+		if cur, err := db.memIndex.Get(key); err == nil && cur.FileID == entry.FileID && cur.Offset == entry.Offset {
+			_ = db.memCache.Insert(key, record.Value)
+		}
+		unlock()
 	}
 
 	return record.Value, nil
 }
 
+// GetVersion 读取键值及其版本（最后一次写入的序列号），键不存在时返回 ErrKeyNotFound
+// 版本可作为 ApplyBatchIf 的前置条件，用于乐观事务的冲突检测
+func (db *Bitcask) GetVersion(key string) ([]byte, uint64, error) {
+	if db.closed {
+		return nil, 0, err_def.ErrDBClosed
+	}
+	if len(key) == 0 {
+		return nil, 0, err_def.ErrEmptyKey
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entry, err := db.memIndex.Get(key)
+	if err != nil {
+		return nil, 0, err_def.ErrKeyNotFound
+	}
+	record, err := db.readRecord(entry)
+	if err != nil {
+		return nil, 0, err
+	}
+	return record.Value, entry.Seq, nil
+}
+
+// lockKey 锁定键所在的分段，返回解锁函数
+func (db *Bitcask) lockKey(key string) func() {
+	mu := &db.keyLocks[keyStripe(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockKeys 按段号顺序锁住多个键所在的分段，避免并发批量写入互相死锁
+func (db *Bitcask) lockKeys(keys []string) func() {
+	var held [keyLockCount]bool
+	for _, key := range keys {
+		held[keyStripe(key)] = true
+	}
+	for i := range held {
+		if held[i] {
+			db.keyLocks[i].Lock()
+		}
+	}
+	return func() {
+		for i := range held {
+			if held[i] {
+				db.keyLocks[i].Unlock()
+			}
+		}
+	}
+}
+
+func keyStripe(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32() % keyLockCount
+}
+
 // Del 删除键值对
 func (db *Bitcask) Del(key string) error {
 	if db.closed {
@@ -318,17 +530,17 @@ func (db *Bitcask) Del(key string) error {
 		return err_def.ErrEmptyKey
 	}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	unlock := db.lockKey(key)
+	defer unlock()
 
 	// 先检查键是否存在
 	//if _, err := db.memIndex.Get(key); err != nil {
 	//	return ErrKeyNotFound
 	//}
-	if db.filter != nil {
-		if !db.filter.Contains([]byte(key)) {
-			return err_def.ErrKeyNotFound
-		}
+	if !db.filter.Load().Contains([]byte(key)) {
+		return err_def.ErrKeyNotFound
 	}
 
 	// 写入删除标记记录
@@ -348,10 +560,33 @@ func (db *Bitcask) Del(key string) error {
 		return fmt.Errorf("write delete record failed: %w", resp.Err)
 	}
 
+	defer db.commitLock()()
+	return db.commitDel(key, resp.Entry)
+}
+
+// commitDel 删除标记落盘后更新文件统计、内存索引、缓存与布隆过滤器，调用方需持有键锁与 commitMu 读锁
+func (db *Bitcask) commitDel(key string, entry storage2.Entry) error {
+	// 删除标记与被删除的旧记录都计为无效
+	db.stats.addTotal(entry)
+	db.stats.addDead(entry)
+	old, err := db.memIndex.Get(key)
+	existed := err == nil
+	if existed {
+		db.stats.addDead(old)
+	}
+
+	db.preserveForSnapshots(key)
+
 	// 从内存索引删除
 	if err := db.memIndex.Del(key); err != nil {
 		return fmt.Errorf("remove from index failed: %w", err)
 	}
+	db.advanceCommitSeq(entry.Seq)
+	// 从布隆过滤器移除，删除的键不再通过过滤器
+	if existed {
+		_ = db.filter.Load().Remove([]byte(key))
+	}
+	db.blobs.remove(key)
 
 	// 从缓存删除
 	if db.memCache != nil {
@@ -383,169 +618,223 @@ func (db *Bitcask) ListKeys() ([]string, error) {
 	return keys, nil
 }
 
-// Fold 遍历所有键值对
+// Fold 在快照上遍历所有键值对，遍历期间的写入不可见，也不会被遍历阻塞
 func (db *Bitcask) Fold(f func(key string, value []byte) bool) error {
-	if db.closed {
-		return err_def.ErrDBClosed
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		return err
 	}
+	defer snap.Release()
 
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	return snap.Iterate("", f)
+}
 
-	return db.memIndex.Foreach(func(key string, entry storage2.Entry) bool {
-		record, err := db.fm.Read(entry)
-		if err != nil {
-			return false
-		}
-		if record.Flags == FlagDeleted {
-			return true
-		}
-		return f(key, record.Value)
-	})
+// mergeMove 合并过程中被搬移的有效记录
+type mergeMove struct {
+	key      string
+	oldEntry storage2.Entry
+	newEntry storage2.Entry
 }
 
-// Merge 合并数据文件，删除无效记录
+// Merge 合并无效数据占比达到 MinMergeRatio 的非活动文件
+// 重写过程不阻塞读写，只在切换索引时短暂持有写锁；
+// 合并输出通过清单原子替换旧文件，数据目录中不属于清单的文件（如 TTL 元数据）不受影响
 func (db *Bitcask) Merge() error {
 	if db.closed {
 		return err_def.ErrDBClosed
 	}
+	if db.cfg.ReadOnly {
+		return err_def.ErrReadOnly
+	}
 
 	if !db.mergeRunning.CompareAndSwap(false, true) {
 		return fmt.Errorf("merge is already running")
 	}
 	defer db.mergeRunning.Store(false)
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	if db.closed {
+		return err_def.ErrDBClosed
+	}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	// 创建合并目录
-	mergeDir := filepath.Join(filepath.Dir(db.cfg.DataDir), "merge")
-	if err := os.MkdirAll(mergeDir, 0755); err != nil {
-		return fmt.Errorf("create merge directory failed: %w", err)
+	inputs := db.pickMergeFiles()
+	if len(inputs) == 0 {
+		return nil
 	}
+	// 参与合并的文件是最早的若干文件时，更早的记录已不存在，删除标记无需保留；
+	// 多个写入通道时较晚创建的文件也可能包含更早的记录，删除标记总是保留
+	dropTombstones := db.fm.Lanes() == 1 && isPrefix(inputs, db.fm.LiveFileIDs())
 
-	// 创建新的文件管理器
-	mergeFM, err := file_manager.NewFileManager(
-		mergeDir,
-		db.cfg.MaxFileSize,
-		db.cfg.MaxOpenFiles,
-		db.cfg.SyncInterval,
-	)
+	mw, err := db.fm.NewMergeWriter()
 	if err != nil {
-		return fmt.Errorf("create merge file manager failed: %w", err)
+		return fmt.Errorf("create merge writer failed: %w", err)
 	}
 
-	// 遍历所有有效的键值对
-	err = db.memIndex.Foreach(func(key string, entry storage2.Entry) bool {
-		record, err := db.fm.Read(entry)
+	var (
+		moves   []mergeMove
+		written []storage2.Entry
+	)
+	for _, fileID := range inputs {
+		file, err := db.fm.GetFile(fileID)
 		if err != nil {
-			return false
-		}
-		if record.Flags == FlagDeleted {
-			return true
+			mw.Abort()
+			return fmt.Errorf("open merge input %d failed: %w", fileID, err)
 		}
 
-		// 写入新文件
-		respCh := mergeFM.WriteAsync(record)
-		resp := <-respCh
-		if resp.Err != nil {
-			err = resp.Err
-			return false
+		err = scanValidRecords(file, func(r *storage2.Record, offset int64, _ uint32) error {
+			if err := db.fm.OpenRecord(r, fileID, offset); err != nil {
+				return err
+			}
+			if storage2.RecordType(r.Flags) == FlagBatchCommit {
+				return nil
+			}
+			key := string(r.Key)
+			cur, err := db.memIndex.Get(key)
+			if storage2.RecordType(r.Flags) == FlagDeleted {
+				// 键已被重新写入时，删除标记不再需要
+				if dropTombstones || err == nil {
+					return nil
+				}
+				entry, err := mw.Write(r)
+				if err != nil {
+					return err
+				}
+				written = append(written, entry)
+				db.stats.addTotal(entry)
+				db.stats.addDead(entry)
+				return nil
+			}
+
+			// 只搬移索引仍指向的记录
+			if err != nil || cur.FileID != fileID || cur.Offset != offset {
+				return nil
+			}
+			entry, err := mw.Write(r)
+			if err != nil {
+				return err
+			}
+			written = append(written, entry)
+			db.stats.addTotal(entry)
+			moves = append(moves, mergeMove{key: key, oldEntry: cur, newEntry: entry})
+			return nil
+		})
+		if err != nil {
+			mw.Abort()
+			db.stats.remove(entryFileIDs(written))
+			return fmt.Errorf("merge file %d failed: %w", fileID, err)
 		}
-		return true
-	})
+	}
 
+	outputs, err := db.fm.PrepareMerge(mw)
 	if err != nil {
-		return fmt.Errorf("merge failed: %w", err)
+		db.stats.remove(entryFileIDs(written))
+		return fmt.Errorf("prepare merge failed: %w", err)
 	}
 
-	// 关闭合并文件管理器
-	if err := mergeFM.Close(); err != nil {
-		return fmt.Errorf("close merge file manager failed: %w", err)
+	// 短暂持有写锁: 提交清单并切换索引，期间没有读请求引用旧文件
+	db.mu.Lock()
+	if err := db.fm.CommitMerge(inputs, outputs); err != nil {
+		db.mu.Unlock()
+		db.stats.remove(outputs)
+		return fmt.Errorf("commit merge failed: %w", err)
+	}
+	for _, m := range moves {
+		// 合并期间被覆盖或删除的键，保留新值，搬移的记录计为无效
+		cur, err := db.memIndex.Get(m.key)
+		if err != nil || cur.FileID != m.oldEntry.FileID || cur.Offset != m.oldEntry.Offset {
+			db.stats.addDead(m.newEntry)
+			continue
+		}
+		if err := db.memIndex.Put(m.key, m.newEntry); err != nil {
+			db.stats.addDead(m.newEntry)
+		}
 	}
+	db.stats.remove(inputs)
+	// 过滤器只在合并时调整大小，此时持有写锁，没有进行中的写入
+	filterErr := db.compactFilter()
+	db.mu.Unlock()
 
-	// 替换原始文件
-	if err := db.fm.Close(); err != nil {
-		return fmt.Errorf("close original file manager failed: %w", err)
-	}
+	db.removeObsolete()
+	return filterErr
+}
 
-	if err := os.RemoveAll(db.cfg.DataDir); err != nil {
-		return fmt.Errorf("remove original directory failed: %w", err)
+// pickMergeFiles 按回放顺序挑选无效数据占比达到阈值的非活动文件
+func (db *Bitcask) pickMergeFiles() []int {
+	stats := db.stats.snapshot()
+	var inputs []int
+	for _, id := range db.fm.LiveFileIDs() {
+		if db.fm.IsActive(id) {
+			continue
+		}
+		// 无效数据过多，仍使用旧密钥（未加密）需要重新加密，或文件格式需要升级
+		if st, ok := stats[id]; ok && (st.InvalidRatio() >= db.cfg.MinMergeRatio || st.StaleKey || st.Outdated) {
+			inputs = append(inputs, id)
+		}
 	}
+	return inputs
+}
 
-	if err := os.Rename(mergeDir, db.cfg.DataDir); err != nil {
-		return fmt.Errorf("rename merge directory failed: %w", err)
+// isPrefix 判断 ids 是否恰好是 files 的前缀
+func isPrefix(ids, files []int) bool {
+	if len(ids) > len(files) {
+		return false
 	}
-
-	// 重新打开文件管理器
-	fm, err := file_manager.NewFileManager(
-		db.cfg.DataDir,
-		db.cfg.MaxFileSize,
-		db.cfg.MaxOpenFiles,
-		db.cfg.SyncInterval,
-	)
-	if err != nil {
-		return fmt.Errorf("reopen file manager failed: %w", err)
+	for i, id := range ids {
+		if files[i] != id {
+			return false
+		}
 	}
+	return true
+}
 
-	db.fm = fm
-	return nil
+func entryFileIDs(entries []storage2.Entry) []int {
+	seen := make(map[int]struct{})
+	var ids []int
+	for _, e := range entries {
+		if _, ok := seen[e.FileID]; !ok {
+			seen[e.FileID] = struct{}{}
+			ids = append(ids, e.FileID)
+		}
+	}
+	return ids
 }
 
 func (db *Bitcask) autoMerge() {
 	for {
 		select {
 		case <-db.mergeTicker.C:
-			if ratio, err := db.EstimateInvalidRatio(); err == nil {
-				if ratio >= db.cfg.MinMergeRatio {
-					_ = db.Merge()
-				}
-			}
+			_ = db.Merge()
+			_ = db.GCBlobs()
 		case <-db.mergeStopChan:
 			return
 		}
 	}
 }
 
-func (db *Bitcask) EstimateInvalidRatio() (float64, error) {
+// EstimateInvalidRatio 返回每个数据文件的无效数据占比
+func (db *Bitcask) EstimateInvalidRatio() (map[int]float64, error) {
 	if db.closed {
-		return 0, err_def.ErrDBClosed
-	}
-
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	var totalSize, validSize int64
-
-	files, err := os.ReadDir(db.cfg.DataDir)
-	if err != nil {
-		return 0, fmt.Errorf("read directory failed: %w", err)
-	}
-
-	for _, f := range files {
-		info, err := f.Info()
-		if err != nil {
-			continue
-		}
-		totalSize += info.Size()
-	}
-
-	if totalSize == 0 {
-		return 0, nil
+		return nil, err_def.ErrDBClosed
 	}
 
-	err = db.memIndex.Foreach(func(_ string, entry storage2.Entry) bool {
-		validSize += int64(entry.Size)
-		return true
-	})
-	if err != nil {
-		return 0, fmt.Errorf("foreach failed: %w", err)
+	stats := db.stats.snapshot()
+	ratios := make(map[int]float64, len(stats))
+	for id, st := range stats {
+		ratios[id] = st.InvalidRatio()
 	}
+	return ratios, nil
+}
 
-	return 1 - float64(validSize)/float64(totalSize), nil
+// ObsoleteFiles 返回已被合并或 blob GC 替换、等待删除的数据文件与 blob 文件数
+// 只读打开的进程或未释放的快照存在期间，这些文件保留在磁盘上
+func (db *Bitcask) ObsoleteFiles() (data, blobs int) {
+	return db.fm.ObsoleteFiles()
 }
 
 func (db *Bitcask) StartMerge(interval time.Duration) {
+	if db.cfg.ReadOnly {
+		return
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -570,8 +859,8 @@ func (db *Bitcask) StopMerge() {
 	}
 }
 
-func (db *Bitcask) GetFilter() *util.ShardedBloomFilter {
-	return db.filter
+func (db *Bitcask) GetFilter() *util.CountingBloomFilter {
+	return db.filter.Load()
 }
 
 func (db *Bitcask) GetMemIndex() storage2.MemIndex[string, storage2.Entry] {
@@ -582,6 +871,21 @@ func (db *Bitcask) GetDataDir() string {
 	return db.cfg.DataDir
 }
 
+// SealMetadata 按数据文件的加密配置加密数据目录中的其他元数据文件（如 TTL 元数据）
+func (db *Bitcask) SealMetadata(data []byte) ([]byte, error) {
+	return db.fm.SealBlob(data)
+}
+
+// OpenMetadata 解密 SealMetadata 的结果，未加密的旧文件原样返回
+func (db *Bitcask) OpenMetadata(data []byte) ([]byte, error) {
+	return db.fm.OpenBlob(data)
+}
+
+// LastSequence 返回最近写入数据文件的记录序列号，序列号不超过它的记录均已写入
+func (db *Bitcask) LastSequence() uint64 {
+	return db.fm.LastSequence()
+}
+
 // Sync 同步数据到磁盘
 func (db *Bitcask) Sync() error {
 	if db.closed {
@@ -591,10 +895,25 @@ func (db *Bitcask) Sync() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if current := db.fm.GetActiveFile(); current != nil {
-		return current.File.Sync()
+	// 先同步 blob 文件，落盘的指针不会引用未落盘的 blob
+	if err := db.fm.SyncBlob(); err != nil {
+		return err
+	}
+	return db.fm.SyncActive()
+}
+
+// CacheStats 返回内存缓存的命中、未命中与淘汰计数，未开启缓存时 ok 为 false
+func (db *Bitcask) CacheStats() (stats storage2.CacheStats, ok bool) {
+	c, ok := db.memCache.(storage2.StatsMemCache[string, []byte])
+	if !ok {
+		return storage2.CacheStats{}, false
 	}
-	return nil
+	return c.Stats(), true
+}
+
+// cacheEntrySize 缓存项按键与值的长度计算占用
+func cacheEntrySize(key string, value []byte) int64 {
+	return int64(len(key) + len(value))
 }
 
 // Close 关闭数据库
@@ -603,6 +922,15 @@ func (db *Bitcask) Close() error {
 		return err_def.ErrDBClosed
 	}
 
+	// 中止并等待进行中的校验，校验恢复损坏键时需要写入
+	close(db.scrub.stop)
+	db.scrub.mu.Lock()
+	defer db.scrub.mu.Unlock()
+
+	// 等待进行中的合并结束
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -611,7 +939,18 @@ func (db *Bitcask) Close() error {
 		db.mergeTicker = nil
 		close(db.mergeStopChan)
 	}
+	if db.scrub.ticker != nil {
+		db.scrub.ticker.Stop()
+		db.scrub.ticker = nil
+	}
 
 	db.closed = true
-	return db.fm.Close()
+	if err := db.fm.Close(); err != nil {
+		return err
+	}
+	// 数据全部落盘后再保存过滤器，保存失败时下次打开重建即可
+	if !db.cfg.ReadOnly {
+		_ = db.saveFilter()
+	}
+	return nil
 }
@@ -0,0 +1,1984 @@
+package bitcask
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T, dir string, opts ...storage2.Option) *Bitcask {
+	t.Helper()
+	opts = append([]storage2.Option{
+		storage2.WithDataDir(dir),
+		storage2.WithAutoMerge(false),
+		storage2.WithMemIndexShardCount(4),
+	}, opts...)
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	return db
+}
+
+func TestHintFileReload(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.NoError(t, db.Del("key-7"))
+	require.NoError(t, db.Close())
+
+	ids, err := file_manager.ListDataFileIDs(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(ids), 1, "small MaxFileSize should force rotation")
+	for _, id := range ids {
+		_, err := os.Stat(file_manager.HintPath(dir, id))
+		assert.NoError(t, err, "hint file for data file %d", id)
+	}
+
+	// 损坏其中一个 hint 文件，加载时应回退到全量扫描
+	require.NoError(t, os.WriteFile(file_manager.HintPath(dir, ids[0]), []byte("garbage"), 0644))
+
+	db = openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	defer db.Close()
+
+	for i := 0; i < 50; i++ {
+		val, err := db.Get(fmt.Sprintf("key-%d", i))
+		if i == 7 {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(val))
+	}
+
+	// 回退扫描后应重新生成有效的 hint 文件
+	_, err = file_manager.ReadHintFile(dir, ids[0])
+	assert.NoError(t, err)
+}
+
+func TestMergeKeepsForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	foreign := filepath.Join(dir, "ttl.data")
+	require.NoError(t, os.WriteFile(foreign, []byte("ttl"), 0644))
+
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 20; i++ {
+			require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d-%d", i, round))))
+		}
+	}
+	require.NoError(t, db.Del("key-3"))
+
+	before, err := file_manager.ListDataFileIDs(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Merge())
+	after, err := file_manager.ListDataFileIDs(dir)
+	require.NoError(t, err)
+	assert.Less(t, len(after), len(before))
+
+	check := func(db *Bitcask) {
+		for i := 0; i < 20; i++ {
+			val, err := db.Get(fmt.Sprintf("key-%d", i))
+			if i == 3 {
+				assert.Error(t, err)
+				continue
+			}
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value-%d-2", i), string(val))
+		}
+	}
+	check(db)
+	require.NoError(t, db.Put("key-after", []byte("v")))
+	require.NoError(t, db.Close())
+
+	_, err = os.Stat(foreign)
+	assert.NoError(t, err, "merge must not remove files outside the manifest")
+	_, err = os.Stat(filepath.Join(dir, file_manager.MergeDirName))
+	assert.True(t, os.IsNotExist(err))
+
+	db = openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	defer db.Close()
+	check(db)
+	val, err := db.Get("key-after")
+	require.NoError(t, err)
+	assert.Equal(t, "v", string(val))
+}
+
+func TestManifestRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDB(t, dir)
+	require.NoError(t, db.Put("a", []byte("1")))
+	require.NoError(t, db.Put("b", []byte("2")))
+	require.NoError(t, db.Close())
+
+	m, err := file_manager.LoadManifest(dir)
+	require.NoError(t, err)
+
+	// 模拟合并中途崩溃: 一个未提交的输出文件和一个已提交但未删除的旧文件
+	pending, obsolete := 100, 101
+	require.NoError(t, os.WriteFile(file_manager.DataFilePath(dir, pending), []byte("partial"), 0644))
+	require.NoError(t, os.WriteFile(file_manager.DataFilePath(dir, obsolete), []byte("stale"), 0644))
+	m.Pending = []int{pending}
+	m.Obsolete = []int{obsolete}
+	require.NoError(t, m.Save(dir))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, file_manager.MergeDirName), 0755))
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	for _, id := range []int{pending, obsolete} {
+		_, err := os.Stat(file_manager.DataFilePath(dir, id))
+		assert.True(t, os.IsNotExist(err), "file %d should be removed", id)
+	}
+	_, err = os.Stat(filepath.Join(dir, file_manager.MergeDirName))
+	assert.True(t, os.IsNotExist(err))
+
+	val, err := db.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(val))
+	val, err = db.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(val))
+}
+
+func TestMergeSelectsGarbageFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithMinMergeRatio(0.5))
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("cold-%d", i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	coldFiles := db.fm.LiveFileIDs()
+	coldFiles = coldFiles[:len(coldFiles)-1]
+	require.NotEmpty(t, coldFiles)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put("hot", []byte(fmt.Sprintf("hot-%d", i))))
+	}
+
+	ratios, err := db.EstimateInvalidRatio()
+	require.NoError(t, err)
+	for _, id := range coldFiles {
+		assert.Zero(t, ratios[id], "cold file %d", id)
+	}
+
+	// 合并期间继续写入
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_ = db.Put(fmt.Sprintf("during-%d", i), []byte("x"))
+		}
+	}()
+	require.NoError(t, db.Merge())
+	<-done
+
+	live := db.fm.LiveFileIDs()
+	for _, id := range coldFiles {
+		assert.Contains(t, live, id, "cold file %d should not be rewritten", id)
+	}
+	ratios, err = db.EstimateInvalidRatio()
+	require.NoError(t, err)
+	for id, ratio := range ratios {
+		if id != db.fm.GetActiveFile().ID {
+			assert.Less(t, ratio, 0.5, "file %d", id)
+		}
+	}
+
+	check := func(db *Bitcask) {
+		for i := 0; i < 20; i++ {
+			val, err := db.Get(fmt.Sprintf("cold-%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value-%d", i), string(val))
+		}
+		for i := 0; i < 50; i++ {
+			val, err := db.Get(fmt.Sprintf("during-%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, "x", string(val))
+		}
+		val, err := db.Get("hot")
+		require.NoError(t, err)
+		assert.Equal(t, "hot-99", string(val))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db = openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithMinMergeRatio(0.5))
+	defer db.Close()
+	check(db)
+}
+
+func TestIterator(t *testing.T) {
+	tests := []struct {
+		name    string
+		indexDS storage2.MemIndexType
+	}{
+		{"BTree", storage2.BTree},
+		{"SkipList", storage2.SkipList},
+		{"ART", storage2.ART},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t, t.TempDir(), storage2.WithMemIndexDS(tt.indexDS))
+			defer db.Close()
+
+			for _, k := range []string{"b:3", "a:1", "b:1", "c:1", "b:2", "b:4"} {
+				require.NoError(t, db.Put(k, []byte("v-"+k)))
+			}
+			require.NoError(t, db.Del("b:4"))
+
+			collect := func(it *Iterator) []string {
+				var keys []string
+				for ; it.Valid(); it.Next() {
+					keys = append(keys, it.Key())
+				}
+				return keys
+			}
+
+			it, err := db.NewIterator(IteratorOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a:1", "b:1", "b:2", "b:3", "c:1"}, collect(it))
+			it.Close()
+
+			it, err = db.NewIterator(IteratorOptions{Prefix: "b:"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"b:1", "b:2", "b:3"}, collect(it))
+			it.Close()
+
+			it, err = db.NewIterator(IteratorOptions{Prefix: "b:", Reverse: true})
+			require.NoError(t, err)
+			defer it.Close()
+			assert.Equal(t, []string{"b:3", "b:2", "b:1"}, collect(it))
+
+			it.Seek("b:25")
+			require.True(t, it.Valid())
+			assert.Equal(t, "b:2", it.Key())
+			it.Prev()
+			assert.Equal(t, "b:3", it.Key())
+			val, err := it.Value()
+			require.NoError(t, err)
+			assert.Equal(t, "v-b:3", string(val))
+
+			it, err = db.NewIterator(IteratorOptions{})
+			require.NoError(t, err)
+			defer it.Close()
+			it.Seek("b:25")
+			assert.Equal(t, "b:3", it.Key())
+			it.Seek("z")
+			assert.False(t, it.Valid())
+		})
+	}
+
+	t.Run("Batches", func(t *testing.T) {
+		// 键数超过一批，跨批次前进与后退
+		db := openTestDB(t, t.TempDir(), storage2.WithMemIndexDS(storage2.BTree), storage2.WithMemIndexShardCount(4))
+		defer db.Close()
+
+		var want []string
+		for i := 0; i < 3*iteratorBatch+7; i++ {
+			key := fmt.Sprintf("k:%04d", i)
+			want = append(want, key)
+			require.NoError(t, db.Put(key, []byte(key)))
+		}
+		require.NoError(t, db.Put("j", []byte("j")))
+		require.NoError(t, db.Put("l", []byte("l")))
+
+		it, err := db.NewIterator(IteratorOptions{Prefix: "k:"})
+		require.NoError(t, err)
+		defer it.Close()
+		var got []string
+		for ; it.Valid(); it.Next() {
+			got = append(got, it.Key())
+		}
+		assert.Equal(t, want, got)
+		for i := len(want) - 1; i >= 0; i-- {
+			it.Prev()
+			require.True(t, it.Valid())
+			assert.Equal(t, want[i], it.Key())
+		}
+		it.Prev()
+		assert.False(t, it.Valid())
+
+		rev, err := db.NewIterator(IteratorOptions{Prefix: "k:", Reverse: true})
+		require.NoError(t, err)
+		defer rev.Close()
+		got = got[:0]
+		for ; rev.Valid(); rev.Next() {
+			got = append(got, rev.Key())
+		}
+		require.Len(t, got, len(want))
+		for i, key := range got {
+			assert.Equal(t, want[len(want)-1-i], key)
+		}
+		rev.Seek("k:0100")
+		assert.Equal(t, "k:0100", rev.Key())
+		rev.Seek("k:~")
+		assert.Equal(t, want[len(want)-1], rev.Key())
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		// 创建后的写入对迭代器不可见，Value 与 Key 来自同一时刻
+		db := openTestDB(t, t.TempDir(), storage2.WithMemIndexDS(storage2.SkipList), storage2.WithMemIndexShardCount(4))
+		defer db.Close()
+
+		var want []string
+		for i := 0; i < 2*iteratorBatch; i++ {
+			key := fmt.Sprintf("k:%04d", i)
+			want = append(want, key)
+			require.NoError(t, db.Put(key, []byte("old")))
+		}
+		it, err := db.NewIterator(IteratorOptions{})
+		require.NoError(t, err)
+		defer it.Close()
+
+		for i := 0; i < 2*iteratorBatch; i++ {
+			key := fmt.Sprintf("k:%04d", i)
+			if i%2 == 0 {
+				require.NoError(t, db.Del(key))
+			} else {
+				require.NoError(t, db.Put(key, []byte("new")))
+			}
+		}
+		for i := 0; i < 3*iteratorBatch; i++ {
+			require.NoError(t, db.Put(fmt.Sprintf("k:%04d+", i), []byte("new")))
+		}
+
+		var got []string
+		for ; it.Valid(); it.Next() {
+			got = append(got, it.Key())
+			val, err := it.Value()
+			require.NoError(t, err)
+			assert.Equal(t, "old", string(val))
+		}
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("SwissTable", func(t *testing.T) {
+		db := openTestDB(t, t.TempDir(), storage2.WithMemIndexDS(storage2.SwissTable))
+		defer db.Close()
+		_, err := db.NewIterator(IteratorOptions{})
+		assert.ErrorIs(t, err, err_def.ErrIndexUnordered)
+	})
+}
+
+func TestDurabilityModes(t *testing.T) {
+	modes := []storage2.DurabilityMode{
+		storage2.DurabilityNone,
+		storage2.DurabilityInterval,
+		storage2.DurabilityAlways,
+		storage2.DurabilityGroup,
+	}
+
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			dir := t.TempDir()
+			opts := []storage2.Option{storage2.WithDurability(mode), storage2.WithMaxFileSize(4096)}
+			db := openTestDB(t, dir, opts...)
+
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						assert.NoError(t, db.Put(fmt.Sprintf("w%d-%d", w, i), []byte(fmt.Sprintf("value-%d-%d", w, i))))
+					}
+				}(w)
+			}
+			wg.Wait()
+			require.NoError(t, db.Close())
+
+			db = openTestDB(t, dir, opts...)
+			defer db.Close()
+			for w := 0; w < 8; w++ {
+				for i := 0; i < 50; i++ {
+					val, err := db.Get(fmt.Sprintf("w%d-%d", w, i))
+					require.NoError(t, err)
+					assert.Equal(t, fmt.Sprintf("value-%d-%d", w, i), string(val))
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverTornTail(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	activeID := db.fm.GetActiveFile().ID
+	require.NoError(t, db.Close())
+
+	// 模拟掉电: 活动文件尾部只写入了半条记录，hint 未提交
+	path := file_manager.DataFilePath(dir, activeID)
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 9, 'k'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(file_manager.HintPath(dir, activeID)))
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+
+	report := db.RecoveryReport()
+	require.Len(t, report.Files, 1)
+	assert.Equal(t, ActionTruncated, report.Files[0].Action)
+	assert.Equal(t, int64(21), report.LostBytes)
+	assert.Equal(t, 1, report.LostKeys)
+
+	stat2, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, stat.Size(), stat2.Size())
+	for i := 0; i < 10; i++ {
+		_, err := db.Get(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+	}
+}
+
+func TestRecoveryPolicies(t *testing.T) {
+	// 在第一个非活动文件的第二条记录中间写坏一个字节
+	prepare := func(t *testing.T) (string, int) {
+		dir := t.TempDir()
+		db := openTestDB(t, dir, storage2.WithMaxFileSize(512))
+		for i := 0; i < 30; i++ {
+			require.NoError(t, db.Put(fmt.Sprintf("key-%02d", i), []byte("some-value")))
+		}
+		require.NoError(t, db.Close())
+
+		ids := db.fm.LiveFileIDs()
+		require.Greater(t, len(ids), 2)
+		path := file_manager.DataFilePath(dir, ids[0])
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		recordSize := storage2.HeaderSize + storage2.SeqSize + len("key-00") + len("some-value") + 8
+		data[file_manager.FileHeaderSize+recordSize+storage2.HeaderSize+2] ^= 0xff
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		require.NoError(t, os.Remove(file_manager.HintPath(dir, ids[0])))
+		return dir, ids[0]
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		dir, _ := prepare(t)
+		_, err := Open(storage2.WithDataDir(dir), storage2.WithAutoMerge(false), storage2.WithMaxFileSize(512))
+		assert.Error(t, err)
+	})
+
+	t.Run("salvage", func(t *testing.T) {
+		dir, fileID := prepare(t)
+		db := openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithRecoveryPolicy(storage2.RecoverySalvage))
+		defer db.Close()
+
+		report := db.RecoveryReport()
+		require.Len(t, report.Files, 1)
+		assert.Equal(t, fileID, report.Files[0].FileID)
+		assert.Equal(t, ActionSalvaged, report.Files[0].Action)
+		assert.Equal(t, 1, report.LostKeys)
+
+		_, err := db.Get("key-01")
+		assert.Error(t, err)
+		for _, k := range []string{"key-00", "key-02", "key-29"} {
+			_, err := db.Get(k)
+			assert.NoError(t, err, k)
+		}
+
+		// 变更流跳过保留下来的损坏区间，之后的记录照常回放
+		sub, err := db.Subscribe(FromSequence(0))
+		require.NoError(t, err)
+		defer sub.Close()
+		var keys []string
+		for len(keys) == 0 || keys[len(keys)-1] != "key-29" {
+			select {
+			case ev := <-sub.Events():
+				keys = append(keys, ev.Key)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out after %v", keys)
+			}
+		}
+		want := []string{"key-00"}
+		for i := 2; i < 30; i++ {
+			want = append(want, fmt.Sprintf("key-%02d", i))
+		}
+		assert.Equal(t, want, keys)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		dir, fileID := prepare(t)
+		db := openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithRecoveryPolicy(storage2.RecoverySkip))
+		defer db.Close()
+
+		report := db.RecoveryReport()
+		require.Len(t, report.Files, 1)
+		assert.Equal(t, fileID, report.Files[0].FileID)
+		assert.Equal(t, ActionQuarantined, report.Files[0].Action)
+		_, err := os.Stat(report.Files[0].QuarantinePath)
+		assert.NoError(t, err)
+
+		_, err = db.Get("key-00")
+		assert.NoError(t, err)
+		_, err = db.Get("key-02")
+		assert.Error(t, err)
+		_, err = db.Get("key-29")
+		assert.NoError(t, err)
+
+		// 原文件截断到损坏位置，合并不会找回之后的记录
+		stat, err := os.Stat(file_manager.DataFilePath(dir, fileID))
+		require.NoError(t, err)
+		assert.Equal(t, report.Files[0].Offset, stat.Size())
+		// 覆盖文件中剩下的键，使其被合并
+		require.NoError(t, db.Put("key-00", []byte("new-value")))
+		require.NoError(t, db.Merge())
+		assert.NotContains(t, db.fm.LiveFileIDs(), fileID)
+		_, err = db.Get("key-02")
+		assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+		require.NoError(t, db.Close())
+
+		// 重新打开时不再有损坏需要处理
+		db = openTestDB(t, dir, storage2.WithMaxFileSize(512))
+		defer db.Close()
+		assert.Empty(t, db.RecoveryReport().Files)
+		_, err = db.Get("key-02")
+		assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+		_, err = db.Get("key-00")
+		assert.NoError(t, err)
+	})
+}
+
+// rotatingKeys 持有多个密钥的 KeyProvider，用于测试密钥轮换
+type rotatingKeys struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (p *rotatingKeys) CurrentKey() (uint32, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *rotatingKeys) Key(id uint32) ([]byte, error) {
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %d", id)
+}
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := []byte(strings.Repeat("k", 32))
+	newKey := []byte(strings.Repeat("n", 32))
+
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithKeyProvider(&storage2.StaticKeyProvider{ID: 1, Secret: oldKey}))
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("secret-key-%d", i), []byte(fmt.Sprintf("secret-value-%d", i))))
+	}
+	require.NoError(t, db.Del("secret-key-0"))
+	require.NoError(t, db.Close())
+
+	// 合并重写后的文件同样不含明文
+	assertNoPlaintext := func() {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			require.NoError(t, err)
+			assert.NotContains(t, string(data), "secret-", e.Name())
+		}
+	}
+
+	check := func(db *Bitcask) {
+		_, err := db.Get("secret-key-0")
+		assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+		for i := 1; i < 20; i++ {
+			val, err := db.Get(fmt.Sprintf("secret-key-%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("secret-value-%d", i), string(val))
+		}
+	}
+
+	// 轮换密钥后旧文件仍可读，合并用新密钥重写
+	provider := &rotatingKeys{current: 2, keys: map[uint32][]byte{1: oldKey, 2: newKey}}
+	db = openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithOpenMemCache(false), storage2.WithKeyProvider(provider))
+	check(db)
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+	assertNoPlaintext()
+
+	// 旧密钥下线后数据仍完整
+	db = openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithOpenMemCache(false), storage2.WithKeyProvider(&storage2.StaticKeyProvider{ID: 2, Secret: newKey}))
+	defer db.Close()
+	check(db)
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.NoError(t, db.Del("key-0"))
+
+	cpDir := filepath.Join(t.TempDir(), "cp")
+	cp, err := db.Checkpoint(cpDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, cp.Files)
+
+	// 检查点之后的写入不影响检查点
+	require.NoError(t, db.Put("key-1", []byte("changed")))
+	require.NoError(t, db.Put("after", []byte("x")))
+	require.NoError(t, db.Merge())
+
+	_, err = db.Checkpoint(cpDir)
+	assert.Error(t, err, "checkpoint directory must be empty")
+	require.NoError(t, db.Close())
+
+	check := func(db *Bitcask) {
+		_, err := db.Get("key-0")
+		assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+		for i := 1; i < 30; i++ {
+			val, err := db.Get(fmt.Sprintf("key-%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value-%d", i), string(val))
+		}
+		_, err = db.Get("after")
+		assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+	}
+
+	restoreDir := filepath.Join(t.TempDir(), "restore")
+	_, err = RestoreCheckpoint(cpDir, restoreDir)
+	require.NoError(t, err)
+	_, err = RestoreCheckpoint(cpDir, restoreDir)
+	assert.Error(t, err, "restore must not overwrite existing data")
+
+	restored := openTestDB(t, restoreDir)
+	check(restored)
+	require.NoError(t, restored.Put("new", []byte("y")))
+	require.NoError(t, restored.Close())
+
+	// 恢复后的写入不影响检查点
+	snapshot := openTestDB(t, cpDir)
+	defer snapshot.Close()
+	check(snapshot)
+	_, err = snapshot.Get("new")
+	assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+}
+
+func TestApplyBatch(t *testing.T) {
+	markerSize := int64(storage2.HeaderSize + storage2.SeqSize + 8 + 4 + 8)
+
+	tests := []struct {
+		name    string
+		cut     int64 // 从文件末尾截掉的字节数，模拟崩溃
+		visible bool
+	}{
+		{name: "committed", cut: 0, visible: true},
+		{name: "missing commit", cut: markerSize, visible: false},
+		{name: "torn commit", cut: markerSize / 2, visible: false},
+		{name: "torn record", cut: markerSize + 5, visible: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := openTestDB(t, dir)
+			require.NoError(t, db.Put("before", []byte("v")))
+			require.NoError(t, db.Put("gone", []byte("v")))
+			require.NoError(t, db.ApplyBatch([]BatchOp{
+				{Key: "h:_len_", Value: []byte("2")},
+				{Key: "h:a", Value: []byte("1")},
+				{Key: "h:b", Value: []byte("2")},
+				{Key: "gone", Delete: true},
+				{Key: "missing", Delete: true},
+			}))
+			val, err := db.Get("h:a")
+			require.NoError(t, err)
+			assert.Equal(t, "1", string(val))
+
+			activeID := db.fm.GetActiveFile().ID
+			require.NoError(t, db.Close())
+
+			if tt.cut > 0 {
+				path := file_manager.DataFilePath(dir, activeID)
+				stat, err := os.Stat(path)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(path, stat.Size()-tt.cut))
+				require.NoError(t, os.Remove(file_manager.HintPath(dir, activeID)))
+			}
+
+			db = openTestDB(t, dir)
+			defer db.Close()
+			val, err = db.Get("before")
+			require.NoError(t, err)
+			assert.Equal(t, "v", string(val))
+
+			for _, key := range []string{"h:_len_", "h:a", "h:b"} {
+				_, err := db.Get(key)
+				if tt.visible {
+					assert.NoError(t, err, key)
+				} else {
+					assert.ErrorIs(t, err, err_def.ErrKeyNotFound, key)
+				}
+			}
+			_, err = db.Get("gone")
+			if tt.visible {
+				assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// 合并后批次的结果保持不变
+			require.NoError(t, db.Merge())
+			_, err = db.Get("h:a")
+			assert.Equal(t, tt.visible, err == nil)
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMinMergeRatio(0.1))
+	assert.Zero(t, db.LastSequence())
+
+	require.NoError(t, db.Put("a", []byte("1")))
+	require.NoError(t, db.Put("b", []byte("2")))
+	require.NoError(t, db.ApplyBatch([]BatchOp{{Key: "c", Value: []byte("3")}, {Key: "d", Value: []byte("4")}}))
+	require.NoError(t, db.Del("a"))
+	// 批次内两条记录加提交标记共占用三个序列号
+	assert.Equal(t, uint64(6), db.LastSequence())
+
+	entry, err := db.memIndex.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), entry.Seq)
+	activeID := db.fm.GetActiveFile().ID
+	require.NoError(t, db.Close())
+
+	// hint 与全量扫描都能恢复序列号
+	db = openTestDB(t, dir, storage2.WithMinMergeRatio(0.1))
+	assert.Equal(t, uint64(6), db.LastSequence())
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Remove(file_manager.HintPath(dir, activeID)))
+	db = openTestDB(t, dir, storage2.WithMinMergeRatio(0.1))
+	assert.Equal(t, uint64(6), db.LastSequence())
+	entry, err = db.memIndex.Get("d")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), entry.Seq)
+
+	// 合并丢弃删除标记后序列号不会回退
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+	db = openTestDB(t, dir)
+	defer db.Close()
+	assert.Equal(t, uint64(6), db.LastSequence())
+	require.NoError(t, db.Put("e", []byte("5")))
+	assert.Equal(t, uint64(7), db.LastSequence())
+	entry, err = db.memIndex.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), entry.Seq)
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithMinMergeRatio(0.1))
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%02d", i), []byte(fmt.Sprintf("old-%d", i))))
+	}
+	snap, err := db.NewSnapshot()
+	require.NoError(t, err)
+	assert.Equal(t, db.LastSequence(), snap.Sequence())
+
+	// 快照之后的覆盖、删除与新增都不可见
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%02d", i), []byte(fmt.Sprintf("new-%d", i))))
+	}
+	require.NoError(t, db.Del("key-10"))
+	require.NoError(t, db.ApplyBatch([]BatchOp{{Key: "key-11", Delete: true}, {Key: "extra", Value: []byte("x")}}))
+
+	val, err := snap.Get("key-00")
+	require.NoError(t, err)
+	assert.Equal(t, "old-0", string(val))
+	val, err = snap.Get("key-10")
+	require.NoError(t, err)
+	assert.Equal(t, "old-10", string(val))
+	_, err = snap.Get("extra")
+	assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+	val, err = db.Get("key-00")
+	require.NoError(t, err)
+	assert.Equal(t, "new-0", string(val))
+
+	// 合并不会删除快照引用的旧文件
+	before := db.fm.LiveFileIDs()
+	require.NoError(t, db.Merge())
+	var removed []int
+	for _, id := range before {
+		if !slices.Contains(db.fm.LiveFileIDs(), id) {
+			removed = append(removed, id)
+		}
+	}
+	require.NotEmpty(t, removed)
+	for _, id := range removed {
+		assert.FileExists(t, file_manager.DataFilePath(dir, id))
+	}
+
+	var keys []string
+	require.NoError(t, snap.Iterate("key-", func(key string, value []byte) bool {
+		keys = append(keys, key)
+		var i int
+		_, err := fmt.Sscanf(key, "key-%d", &i)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("old-%d", i), string(value))
+		return true
+	}))
+	assert.Len(t, keys, 20)
+	assert.True(t, slices.IsSorted(keys))
+
+	snap.Release()
+	_, err = snap.Get("key-00")
+	assert.ErrorIs(t, err, err_def.ErrSnapshotReleased)
+	for _, id := range removed {
+		assert.NoFileExists(t, file_manager.DataFilePath(dir, id))
+	}
+
+	// Fold 基于快照，遍历当前状态
+	count := 0
+	require.NoError(t, db.Fold(func(key string, value []byte) bool {
+		count++
+		return true
+	}))
+	assert.Equal(t, 19, count)
+}
+
+func TestSnapshotDuringSync(t *testing.T) {
+	db := openTestDB(t, t.TempDir(), storage2.WithDurability(storage2.DurabilityAlways))
+	defer db.Close()
+	require.NoError(t, db.Put("k", []byte("old")))
+
+	// 模拟阻塞在落盘中的写入：持有 mu 读锁与键锁，尚未更新索引
+	db.mu.RLock()
+	unlock := db.lockKey("k")
+
+	type result struct {
+		snap *Snapshot
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		snap, err := db.NewSnapshot()
+		done <- result{snap, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NewSnapshot blocked by a writer waiting for fsync")
+	}
+	require.NoError(t, res.err)
+	defer res.snap.Release()
+
+	// 写入完成后更新索引，快照仍读到创建时的值
+	require.NoError(t, db.putLocked("k", []byte("new")))
+	unlock()
+	db.mu.RUnlock()
+
+	val, err := res.snap.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(val))
+	val, err = db.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(val))
+}
+
+func TestApplyBatchIf(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	require.NoError(t, db.Put("a", []byte("1")))
+	val, seq, err := db.GetVersion("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(val))
+	_, _, err = db.GetVersion("b")
+	assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+
+	// 读取后未被修改，条件成立
+	conds := []BatchCond{{Key: "a", Seq: seq, Exists: true}, {Key: "b"}}
+	require.NoError(t, db.ApplyBatchIf([]BatchOp{{Key: "a", Value: []byte("2")}}, conds))
+
+	// a 已被上一次提交修改，b 仍不存在，条件不成立时不写入
+	err = db.ApplyBatchIf([]BatchOp{{Key: "b", Value: []byte("x")}}, conds)
+	assert.ErrorIs(t, err, err_def.ErrTxnConflict)
+	_, err = db.Get("b")
+	assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+
+	// 读取时不存在的键被并发创建
+	require.NoError(t, db.Put("b", []byte("y")))
+	err = db.ApplyBatchIf([]BatchOp{{Key: "c", Value: []byte("z")}}, []BatchCond{{Key: "b"}})
+	assert.ErrorIs(t, err, err_def.ErrTxnConflict)
+
+	// 并发的读改写在冲突后重试，不丢失更新
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for {
+					val, seq, err := db.GetVersion("counter")
+					exists := err == nil
+					n := 0
+					if exists {
+						_, _ = fmt.Sscanf(string(val), "%d", &n)
+					}
+					op := BatchOp{Key: "counter", Value: []byte(fmt.Sprintf("%d", n+1))}
+					err = db.ApplyBatchIf([]BatchOp{op}, []BatchCond{{Key: "counter", Seq: seq, Exists: exists}})
+					if err == nil {
+						break
+					}
+					require.ErrorIs(t, err, err_def.ErrTxnConflict)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, "400", string(val))
+}
+
+func TestSubscribe(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	defer db.Close()
+
+	next := func(t *testing.T, sub *Subscription) ChangeEvent {
+		t.Helper()
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("subscription ended: %v", sub.Err())
+			}
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for change event")
+			return ChangeEvent{}
+		}
+	}
+
+	require.NoError(t, db.Put("a", []byte("1")))
+	require.NoError(t, db.Put("b", []byte("2")))
+	require.NoError(t, db.ApplyBatch([]BatchOp{{Key: "c", Value: []byte("3")}, {Key: "d", Value: []byte("4")}}))
+	require.NoError(t, db.Del("a"))
+
+	// 从头回放历史记录，提交标记不出现在变更流中
+	sub, err := db.Subscribe(FromSequence(0))
+	require.NoError(t, err)
+	want := []struct {
+		op    ChangeOp
+		key   string
+		value string
+	}{
+		{ChangePut, "a", "1"},
+		{ChangePut, "b", "2"},
+		{ChangePut, "c", "3"},
+		{ChangePut, "d", "4"},
+		{ChangeDelete, "a", ""},
+	}
+	var last ChangeEvent
+	for _, w := range want {
+		ev := next(t, sub)
+		assert.Equal(t, w.op, ev.Op)
+		assert.Equal(t, w.key, ev.Key)
+		assert.Equal(t, w.value, string(ev.Value))
+		assert.Greater(t, ev.Seq, last.Seq)
+		last = ev
+	}
+
+	// 跟随新的写入，跨越多个文件
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("live-%d", i), []byte(strings.Repeat("v", 32))))
+	}
+	require.Greater(t, len(db.fm.LiveFileIDs()), 2)
+	for i := 0; i < 30; i++ {
+		ev := next(t, sub)
+		assert.Equal(t, fmt.Sprintf("live-%d", i), ev.Key)
+		assert.Greater(t, ev.Seq, last.Seq)
+		last = ev
+	}
+	sub.Close()
+	assert.NoError(t, sub.Err())
+
+	// 从事件游标恢复，只收到之后的变更
+	require.NoError(t, db.ApplyBatch([]BatchOp{{Key: "x", Value: []byte("1")}, {Key: "y", Delete: true}}))
+	require.NoError(t, db.Put("z", []byte("2")))
+	sub, err = db.Subscribe(last.Position)
+	require.NoError(t, err)
+	ev := next(t, sub)
+	assert.Equal(t, "x", ev.Key)
+	ev = next(t, sub)
+	assert.Equal(t, ChangeDelete, ev.Op)
+	assert.Equal(t, "y", ev.Key)
+
+	// 从批次中途的游标恢复，不会重复收到已发送的记录
+	mid := ev.Position
+	ev = next(t, sub)
+	assert.Equal(t, "z", ev.Key)
+	sub.Close()
+
+	sub, err = db.Subscribe(mid)
+	require.NoError(t, err)
+	ev = next(t, sub)
+	assert.Equal(t, "z", ev.Key)
+	sub.Close()
+
+	// 从当前末尾订阅，不回放历史记录，直接从活动文件跟随之后的写入
+	sub, err = db.Subscribe(FromLatest())
+	require.NoError(t, err)
+	require.NoError(t, db.Put("latest", []byte("1")))
+	ev = next(t, sub)
+	assert.Equal(t, "latest", ev.Key)
+	assert.Equal(t, db.fm.GetActiveFile().ID, ev.Position.FileID)
+	sub.Close()
+
+	// 游标所在文件被合并删除后按序列号回放合并后保留的记录
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Put("z", []byte(strings.Repeat("z", 32))))
+	}
+	require.NoError(t, db.Del("x"))
+	require.NoError(t, db.Merge())
+	require.NotContains(t, db.fm.LiveFileIDs(), mid.FileID)
+	require.NoError(t, db.Put("end", []byte("1")))
+	sub, err = db.Subscribe(mid)
+	require.NoError(t, err)
+	last = ChangeEvent{Seq: mid.Seq}
+	for last.Key != "end" {
+		ev = next(t, sub)
+		assert.Greater(t, ev.Seq, last.Seq)
+		last = ev
+	}
+	sub.Close()
+
+	// 关闭数据库后订阅结束
+	sub, err = db.Subscribe(FromSequence(db.LastSequence()))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	for range sub.Events() {
+	}
+	assert.ErrorIs(t, sub.Err(), err_def.ErrDBClosed)
+}
+
+func TestBlobSeparation(t *testing.T) {
+	dir := t.TempDir()
+	opts := []storage2.Option{
+		storage2.WithMaxFileSize(4096),
+		storage2.WithOpenMemCache(false),
+		storage2.WithBlobThreshold(1024),
+		storage2.WithBlobGCRatio(0.5),
+	}
+	bigValue := func(key string, round int) []byte {
+		return []byte(strings.Repeat(fmt.Sprintf("%s/%d;", key, round), 2000/len(key))[:2000])
+	}
+
+	db := openTestDB(t, dir, opts...)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("big-%d", i), bigValue(fmt.Sprintf("big-%d", i), 0)))
+	}
+	require.NoError(t, db.Put("small", []byte("tiny")))
+
+	// 大值写入 blob 文件，数据文件只保存指针
+	_, ok := db.blobs.get("small")
+	assert.False(t, ok)
+	_, ok = db.blobs.get("big-0")
+	assert.True(t, ok)
+	blobIDs, err := file_manager.ListBlobFileIDs(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(blobIDs), 1)
+	dataIDs, err := file_manager.ListDataFileIDs(dir)
+	require.NoError(t, err)
+	for _, id := range dataIDs {
+		data, err := os.ReadFile(file_manager.DataFilePath(dir, id))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "big-0/0;")
+	}
+
+	_, version, err := db.GetVersion("big-5")
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("big-%d", i), bigValue(fmt.Sprintf("big-%d", i), 1)))
+	}
+	require.NoError(t, db.Del("big-4"))
+
+	stats, err := db.BlobStats()
+	require.NoError(t, err)
+	assert.Equal(t, stats[blobIDs[0]].TotalBytes, stats[blobIDs[0]].DeadBytes)
+
+	check := func(db *Bitcask) {
+		t.Helper()
+		for i := 0; i < 6; i++ {
+			key := fmt.Sprintf("big-%d", i)
+			val, err := db.Get(key)
+			switch {
+			case i == 4:
+				assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+			case i < 4:
+				require.NoError(t, err)
+				assert.Equal(t, bigValue(key, 1), val)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, bigValue(key, 0), val)
+			}
+		}
+		val, err := db.Get("small")
+		require.NoError(t, err)
+		assert.Equal(t, "tiny", string(val))
+	}
+
+	// GC 回收旧 blob 文件，仍被引用的 blob 搬移后版本不变
+	require.NoError(t, db.GCBlobs())
+	for _, id := range blobIDs {
+		_, err := os.Stat(file_manager.BlobFilePath(dir, id))
+		assert.True(t, os.IsNotExist(err), "blob file %d should be removed", id)
+	}
+	check(db)
+	_, moved, err := db.GetVersion("big-5")
+	require.NoError(t, err)
+	assert.Equal(t, version, moved)
+
+	// 回放历史时跳过已回收的旧值，搬移的指针不会重复出现
+	sub, err := db.Subscribe(FromSequence(0))
+	require.NoError(t, err)
+	var last ChangeEvent
+	for last.Seq < db.LastSequence() {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("subscription ended: %v", sub.Err())
+			}
+			assert.Greater(t, ev.Seq, last.Seq)
+			if ev.Key == "big-5" {
+				assert.Equal(t, bigValue("big-5", 0), ev.Value)
+			}
+			last = ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for change event")
+		}
+	}
+	sub.Close()
+
+	// 合并只搬移指针，hint 与全量扫描都能重建 blob 引用
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+	db = openTestDB(t, dir, opts...)
+	check(db)
+	require.NoError(t, db.Close())
+	dataIDs, err = file_manager.ListDataFileIDs(dir)
+	require.NoError(t, err)
+	for _, id := range dataIDs {
+		_ = os.Remove(file_manager.HintPath(dir, id))
+	}
+	db = openTestDB(t, dir, opts...)
+	check(db)
+	stats, err = db.BlobStats()
+	require.NoError(t, err)
+	assert.NotEmpty(t, stats)
+
+	// 检查点包含 blob 文件
+	cpDir := filepath.Join(t.TempDir(), "cp")
+	cp, err := db.Checkpoint(cpDir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, cp.Blobs)
+	require.NoError(t, db.Close())
+	restored := openTestDB(t, cpDir, opts...)
+	defer restored.Close()
+	check(restored)
+
+	// 加密与压缩同样作用于 blob 文件
+	encDir := t.TempDir()
+	encOpts := append(slices.Clone(opts),
+		storage2.WithEncryptionKey([]byte(strings.Repeat("k", 32))),
+		storage2.WithCompression(storage2.CompressionFlate, 128))
+	enc := openTestDB(t, encDir, encOpts...)
+	require.NoError(t, enc.Put("big-0", bigValue("big-0", 0)))
+	require.NoError(t, enc.Close())
+	blobIDs, err = file_manager.ListBlobFileIDs(encDir)
+	require.NoError(t, err)
+	require.Len(t, blobIDs, 1)
+	data, err := os.ReadFile(file_manager.BlobFilePath(encDir, blobIDs[0]))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "big-0")
+	assert.Less(t, len(data), 2000)
+	enc = openTestDB(t, encDir, encOpts...)
+	defer enc.Close()
+	val, err := enc.Get("big-0")
+	require.NoError(t, err)
+	assert.Equal(t, bigValue("big-0", 0), val)
+}
+
+// patternReader 生成指定长度的确定性内容，用于流式写入大值
+type patternReader struct {
+	n, pos int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.pos >= r.n {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), r.n-r.pos)]
+	for i := range p {
+		p[i] = byte((r.pos + int64(i)) % 251)
+	}
+	r.pos += int64(len(p))
+	return len(p), nil
+}
+
+func TestStreamValues(t *testing.T) {
+	dir := t.TempDir()
+	opts := []storage2.Option{storage2.WithMaxFileSize(16 << 20), storage2.WithOpenMemCache(false)}
+	size := int64(storage2.MaxValueSize + 5<<20)
+
+	sum := func(r io.Reader) [32]byte {
+		h := sha256.New()
+		_, err := io.Copy(h, r)
+		require.NoError(t, err)
+		return [32]byte(h.Sum(nil))
+	}
+	want := sum(&patternReader{n: size})
+	check := func(db *Bitcask) {
+		t.Helper()
+		_, err := db.Get("artifact")
+		assert.ErrorIs(t, err, err_def.ErrValueTooLarge)
+		rc, err := db.GetReader("artifact")
+		require.NoError(t, err)
+		assert.Equal(t, want, sum(rc))
+		require.NoError(t, rc.Close())
+
+		val, err := db.Get("chunked-small")
+		require.NoError(t, err)
+		assert.Equal(t, "streamed", string(val))
+	}
+
+	db := openTestDB(t, dir, opts...)
+	require.NoError(t, db.PutReader("artifact", &patternReader{n: size}))
+	require.NoError(t, db.PutReader("chunked-small", strings.NewReader("streamed")))
+	require.NoError(t, db.Put("plain", []byte("value")))
+	check(db)
+
+	// 未分块写入的值同样可以流式读取
+	rc, err := db.GetReader("plain")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(data))
+	require.NoError(t, rc.Close())
+
+	// 合并只搬移清单，hint 与全量扫描都能重建分块引用
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+	db = openTestDB(t, dir, opts...)
+	check(db)
+	require.NoError(t, db.Close())
+	dataIDs, err := file_manager.ListDataFileIDs(dir)
+	require.NoError(t, err)
+	for _, id := range dataIDs {
+		_ = os.Remove(file_manager.HintPath(dir, id))
+	}
+	db = openTestDB(t, dir, opts...)
+	defer db.Close()
+	check(db)
+
+	// 覆盖后所有分块一起失效；读取中的 reader 固定旧值，GC 推迟到关闭后删除文件
+	rc, err = db.GetReader("artifact")
+	require.NoError(t, err)
+	require.NoError(t, db.Put("artifact", []byte("small")))
+	stats, err := db.BlobStats()
+	require.NoError(t, err)
+	var dead int64
+	for _, st := range stats {
+		dead += st.DeadBytes
+	}
+	assert.Greater(t, dead, size)
+	blobIDs, err := file_manager.ListBlobFileIDs(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(blobIDs), 1)
+	require.NoError(t, db.GCBlobs())
+	assert.Equal(t, want, sum(rc))
+	require.NoError(t, rc.Close())
+	for _, id := range blobIDs[:len(blobIDs)-1] {
+		_, err := os.Stat(file_manager.BlobFilePath(dir, id))
+		assert.True(t, os.IsNotExist(err), "blob file %d should be removed", id)
+	}
+	val, err := db.Get("artifact")
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(val))
+	val, err = db.Get("chunked-small")
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(val))
+
+	// 写入中途失败时旧值不变
+	require.Error(t, db.PutReader("chunked-small", io.MultiReader(&patternReader{n: 5 << 20}, iotest.ErrReader(errors.New("boom")))))
+	val, err = db.Get("chunked-small")
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(val))
+}
+
+func TestMmapReads(t *testing.T) {
+	dir := t.TempDir()
+	budget := int64(2048)
+	opts := []storage2.Option{
+		storage2.WithMaxFileSize(1024),
+		storage2.WithOpenMemCache(false),
+		storage2.WithMmapReads(true, budget),
+	}
+	db := openTestDB(t, dir, opts...)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	for i := 0; i < 100; i += 3 {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("updated-%d", i))))
+	}
+
+	check := func(db *Bitcask) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			want := fmt.Sprintf("value-%d", i)
+			if i%3 == 0 {
+				want = fmt.Sprintf("updated-%d", i)
+			}
+			val, err := db.Get(fmt.Sprintf("key-%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, want, string(val))
+		}
+	}
+
+	check(db)
+	assert.Positive(t, db.fm.MappedBytes())
+
+	// 合并删除旧文件时解除其映射，之后从新文件读取
+	require.NoError(t, db.Merge())
+	check(db)
+	assert.LessOrEqual(t, db.fm.MappedBytes(), budget)
+
+	// 快照固定的旧文件在读取期间不会被解除映射
+	snap, err := db.NewSnapshot()
+	require.NoError(t, err)
+	require.NoError(t, db.Put("key-1", []byte("after")))
+	require.NoError(t, db.Merge())
+	val, err := snap.Get("key-1")
+	require.NoError(t, err)
+	assert.Equal(t, "value-1", string(val))
+	snap.Release()
+	require.NoError(t, db.Close())
+
+	db = openTestDB(t, dir, opts...)
+	defer db.Close()
+	val, err = db.Get("key-1")
+	require.NoError(t, err)
+	assert.Equal(t, "after", string(val))
+}
+
+func TestWriteLanes(t *testing.T) {
+	dir := t.TempDir()
+	opts := []storage2.Option{
+		storage2.WithWriteLanes(4),
+		storage2.WithOpenMemCache(false),
+	}
+	db := openTestDB(t, dir, opts...)
+
+	sub, err := db.Subscribe(FromSequence(0))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	// 多个通道并发写入
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.NoError(t, db.Put(fmt.Sprintf("key-%d-%d", w, i), []byte(fmt.Sprintf("value-%d-%d", w, i))))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 变更流合并各通道的写入，按序列号递增发送
+	var last uint64
+	for n := 0; n < 800; n++ {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("subscription ended: %v", sub.Err())
+			}
+			assert.Greater(t, ev.Seq, last)
+			last = ev.Seq
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for change event")
+		}
+	}
+	assert.Equal(t, db.LastSequence(), last)
+
+	// 从各通道当前的末尾订阅，只收到之后的写入
+	latest, err := db.Subscribe(FromLatest())
+	require.NoError(t, err)
+	require.NoError(t, db.Put("latest", []byte("1")))
+	select {
+	case ev, ok := <-latest.Events():
+		require.True(t, ok)
+		assert.Equal(t, "latest", ev.Key)
+		assert.Greater(t, ev.Seq, last)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change event")
+	}
+	latest.Close()
+
+	// 批量写入整体进入第一个键所在的通道，其中的旧写入可能位于回放顺序更靠后的文件，
+	// 之后在另一个通道中删除该键，重新打开时删除不会被旧写入覆盖
+	active := db.fm.ActiveFileIDs()
+	laneKey := func(fileID int) string {
+		for w := 0; w < 8; w++ {
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if entry, _ := db.memIndex.Get(key); entry.FileID == fileID {
+					return key
+				}
+			}
+		}
+		t.Fatalf("no key in file %d", fileID)
+		return ""
+	}
+	first, later := laneKey(active[0]), laneKey(active[len(active)-1])
+	require.NoError(t, db.ApplyBatch([]BatchOp{{Key: later, Value: []byte("batch")}, {Key: first, Value: []byte("old")}}))
+	require.NoError(t, db.Del(first))
+
+	cpDir := filepath.Join(t.TempDir(), "cp")
+	cp, err := db.Checkpoint(cpDir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, cp.Sealed)
+	require.NoError(t, db.Put("after", []byte("x")))
+	require.NoError(t, db.Close())
+
+	check := func(db *Bitcask) {
+		t.Helper()
+		_, err := db.Get(first)
+		assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+		val, err := db.Get(later)
+		require.NoError(t, err)
+		assert.Equal(t, "batch", string(val))
+		for w := 0; w < 8; w++ {
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if key == first || key == later {
+					continue
+				}
+				val, err := db.Get(key)
+				require.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value-%d-%d", w, i), string(val))
+			}
+		}
+	}
+
+	// 重新打开时按序列号合并各通道的文件，序列号不回退
+	db = openTestDB(t, dir, opts...)
+	check(db)
+	val, err := db.Get("after")
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(val))
+	seq := db.LastSequence()
+	require.NoError(t, db.Put("again", []byte("y")))
+	assert.Greater(t, db.LastSequence(), seq)
+	require.NoError(t, db.Close())
+
+	// 以单个通道重新打开同样可以读取
+	db = openTestDB(t, dir, storage2.WithOpenMemCache(false))
+	check(db)
+	require.NoError(t, db.Close())
+
+	restoreDir := filepath.Join(t.TempDir(), "restore")
+	_, err = RestoreCheckpoint(cpDir, restoreDir)
+	require.NoError(t, err)
+	restored := openTestDB(t, restoreDir, opts...)
+	defer restored.Close()
+	check(restored)
+	_, err = restored.Get("after")
+	assert.ErrorIs(t, err, err_def.ErrKeyNotFound)
+}
+
+func TestBlobRelocationAcrossLanes(t *testing.T) {
+	dir := t.TempDir()
+	opts := []storage2.Option{
+		storage2.WithWriteLanes(2),
+		storage2.WithMaxFileSize(4096),
+		storage2.WithOpenMemCache(false),
+		storage2.WithBlobThreshold(1024),
+		storage2.WithBlobGCRatio(0.3),
+	}
+	db := openTestDB(t, dir, opts...)
+
+	// 找到分别写入清单中靠前、靠后两个活动文件的键
+	order := map[int]int{}
+	for i, id := range db.fm.LiveFileIDs() {
+		order[id] = i
+	}
+	var early, late string
+	for i := 0; early == "" || late == ""; i++ {
+		key := fmt.Sprintf("probe-%d", i)
+		require.NoError(t, db.Put(key, []byte("p")))
+		entry, err := db.memIndex.Get(key)
+		require.NoError(t, err)
+		if order[entry.FileID] == 0 {
+			early = key
+		} else {
+			late = key
+		}
+	}
+
+	// 批量写入进入第一个键所在的通道，early 的大值指针因此写在靠后的文件中
+	big := bytes.Repeat([]byte("v"), 1500)
+	require.NoError(t, db.ApplyBatch([]BatchOp{{Key: late, Value: []byte("x")}, {Key: early, Value: big}}))
+	before, err := db.memIndex.Get(early)
+	require.NoError(t, err)
+	require.Equal(t, 1, order[before.FileID])
+	blob, ok := db.blobs.get(early)
+	require.True(t, ok)
+
+	// 同一 blob 文件中的另一个大值被覆盖，文件达到回收比例并在下一次写入时封存
+	require.NoError(t, db.Put("filler", bytes.Repeat([]byte("f"), 1500)))
+	require.NoError(t, db.Put("filler", bytes.Repeat([]byte("g"), 1500)))
+
+	// 搬移后的指针以原序列号写入 early 自己的通道，即清单中靠前的文件
+	require.NoError(t, db.GCBlobs())
+	_, err = os.Stat(file_manager.BlobFilePath(dir, blob.ptrs[0].FileID))
+	require.True(t, os.IsNotExist(err))
+	after, err := db.memIndex.Get(early)
+	require.NoError(t, err)
+	require.Equal(t, 0, order[after.FileID])
+	assert.Equal(t, before.Seq, after.Seq)
+	require.NoError(t, db.Close())
+
+	// 重新打开时搬移后的记录先回放，序列号相同的原记录不能覆盖它
+	db = openTestDB(t, dir, opts...)
+	defer db.Close()
+	val, err := db.Get(early)
+	require.NoError(t, err)
+	assert.Equal(t, big, val)
+	val, err = db.Get("filler")
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("g"), 1500), val)
+
+	// 回放历史时同样发送搬移后的记录
+	sub, err := db.Subscribe(FromSequence(before.Seq - 1))
+	require.NoError(t, err)
+	defer sub.Close()
+	select {
+	case ev, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription ended: %v", sub.Err())
+		}
+		assert.Equal(t, before.Seq, ev.Seq)
+		assert.Equal(t, big, ev.Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change event")
+	}
+}
+
+func TestMemCachePolicies(t *testing.T) {
+	for _, ds := range []storage2.MemCacheType{storage2.LFU, storage2.ARC, storage2.WTinyLFU} {
+		t.Run(string(ds), func(t *testing.T) {
+			db := openTestDB(t, t.TempDir(),
+				storage2.WithMemCacheDS(ds),
+				storage2.WithMemCacheBytes(64<<10),
+			)
+			defer db.Close()
+
+			value := []byte(strings.Repeat("v", 1<<10))
+			for i := 0; i < 100; i++ {
+				require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), value))
+			}
+			// 超过缓存容量的值不会进入缓存，每次读取都未命中
+			require.NoError(t, db.Put("big", make([]byte, 128<<10)))
+			for round := 0; round < 3; round++ {
+				for i := 70; i < 100; i++ {
+					got, err := db.Get(fmt.Sprintf("key-%d", i))
+					require.NoError(t, err)
+					assert.Equal(t, value, got)
+				}
+				_, err := db.Get("big")
+				require.NoError(t, err)
+			}
+
+			stats, ok := db.CacheStats()
+			require.True(t, ok)
+			assert.Positive(t, stats.Hits)
+			assert.GreaterOrEqual(t, stats.Misses, uint64(3))
+			assert.Positive(t, stats.Evictions)
+			assert.LessOrEqual(t, stats.Bytes, int64(64<<10))
+		})
+	}
+}
+
+func TestBloomFilterDeleteAndReload(t *testing.T) {
+	dir := t.TempDir()
+	filterPath := filepath.Join(dir, FilterFileName)
+
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(4096))
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	// 覆盖写入不重复计数
+	require.NoError(t, db.Put("key-0", []byte("again")))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Del(fmt.Sprintf("key-%d", i)))
+	}
+	assert.EqualValues(t, 100, db.GetFilter().Len())
+
+	// 删除后的键绝大多数不再通过过滤器，重复删除直接返回不存在
+	passed := 0
+	for i := 0; i < 100; i++ {
+		if db.GetFilter().Contains([]byte(fmt.Sprintf("key-%d", i))) {
+			passed++
+		}
+	}
+	assert.Less(t, passed, 5)
+	assert.ErrorIs(t, db.Del("key-1"), err_def.ErrKeyNotFound)
+	require.NoError(t, db.Close())
+
+	stale, err := os.ReadFile(filterPath)
+	require.NoError(t, err)
+
+	// 正常关闭后重新打开直接使用保存的过滤器，文件读取后删除
+	db = openTestDB(t, dir)
+	_, err = os.Stat(filterPath)
+	assert.True(t, os.IsNotExist(err))
+	assert.EqualValues(t, 100, db.GetFilter().Len())
+	for i := 100; i < 200; i++ {
+		require.True(t, db.GetFilter().Contains([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	require.NoError(t, db.Put("late", []byte("value")))
+	require.NoError(t, db.Close())
+
+	// 之后还有写入的过期过滤器不会被使用
+	require.NoError(t, os.WriteFile(filterPath, stale, 0644))
+	db = openTestDB(t, dir)
+	got, err := db.Get("late")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), got)
+	assert.EqualValues(t, 101, db.GetFilter().Len())
+	require.NoError(t, db.Close())
+}
+
+func TestBloomFilterResizedByMerge(t *testing.T) {
+	db := openTestDB(t, t.TempDir(),
+		storage2.WithMaxFileSize(4096),
+		storage2.WithBloomFilter(64, 0.01),
+	)
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	expected, fpr := db.GetFilter().Config()
+	assert.EqualValues(t, 64, expected)
+	assert.Equal(t, 0.01, fpr)
+
+	// 键数超过预期，合并后按实际键数重建
+	for i := 0; i < 800; i++ {
+		require.NoError(t, db.Del(fmt.Sprintf("key-%d", i)))
+	}
+	require.NoError(t, db.Merge())
+	expected, _ = db.GetFilter().Config()
+	assert.EqualValues(t, 200, expected)
+	assert.EqualValues(t, 200, db.GetFilter().Len())
+	for i := 800; i < 1000; i++ {
+		got, err := db.Get(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), got)
+	}
+}
+
+func TestDirLock(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	// 同一目录只能有一个写入者
+	_, err := Open(storage2.WithDataDir(dir), storage2.WithAutoMerge(false))
+	assert.ErrorIs(t, err, err_def.ErrDirLocked)
+
+	require.NoError(t, db.Close())
+	db = openTestDB(t, dir)
+	require.NoError(t, db.Close())
+}
+
+func TestReadOnlyOpen(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(1024))
+	defer db.Close()
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 30; i++ {
+			require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d-%d", i, round))))
+		}
+	}
+
+	// 写入者运行期间只读打开
+	ro := openTestDB(t, dir, storage2.WithReadOnly(true))
+	check := func(round int) {
+		for i := 0; i < 30; i++ {
+			got, err := ro.Get(fmt.Sprintf("key-%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value-%d-%d", i, round), string(got))
+		}
+	}
+	check(2)
+
+	assert.ErrorIs(t, ro.Put("new", []byte("v")), err_def.ErrReadOnly)
+	assert.ErrorIs(t, ro.Del("key-1"), err_def.ErrReadOnly)
+	assert.ErrorIs(t, ro.Merge(), err_def.ErrReadOnly)
+	assert.ErrorIs(t, ro.GCBlobs(), err_def.ErrReadOnly)
+	_, err := ro.Checkpoint(filepath.Join(t.TempDir(), "cp"))
+	assert.ErrorIs(t, err, err_def.ErrReadOnly)
+	require.NoError(t, ro.Sync())
+
+	// 只读方打开期间，合并替换的旧文件保留，已加载的数据仍可读取
+	before := db.fm.LiveFileIDs()
+	require.NoError(t, db.Put("key-0", []byte("after-merge")))
+	require.NoError(t, db.Merge())
+	replaced := 0
+	for _, id := range before {
+		if !slices.Contains(db.fm.LiveFileIDs(), id) {
+			replaced++
+			_, err := os.Stat(file_manager.DataFilePath(dir, id))
+			assert.NoError(t, err, "file %d removed while a reader is open", id)
+		}
+	}
+	require.Positive(t, replaced)
+	retained, _ := db.ObsoleteFiles()
+	assert.Equal(t, replaced, retained)
+	check(2)
+	require.NoError(t, ro.Close())
+
+	// 只读方关闭后旧文件被删除
+	db.removeObsolete()
+	for _, id := range before {
+		if !slices.Contains(db.fm.LiveFileIDs(), id) {
+			_, err := os.Stat(file_manager.DataFilePath(dir, id))
+			assert.True(t, os.IsNotExist(err), "file %d should be removed", id)
+		}
+	}
+	retained, _ = db.ObsoleteFiles()
+	assert.Zero(t, retained)
+	got, err := db.Get("key-0")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("after-merge"), got)
+}
+
+func TestReadOnlyWithoutLockFile(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	require.NoError(t, db.Put("key", []byte("value")))
+	require.NoError(t, db.Close())
+
+	// 只读目录中缺少 LOCK.readers 时仍可只读打开，且不会创建锁文件
+	require.NoError(t, os.Remove(filepath.Join(dir, file_manager.ReaderLockFileName)))
+	require.NoError(t, os.Chmod(dir, 0555))
+	t.Cleanup(func() { _ = os.Chmod(dir, 0755) })
+
+	ro := openTestDB(t, dir, storage2.WithReadOnly(true))
+	got, err := ro.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(got))
+	require.NoError(t, ro.Close())
+	assert.NoFileExists(t, filepath.Join(dir, file_manager.ReaderLockFileName))
+}
+
+func TestReadOnlyDoesNotModifyFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(1024))
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	require.NoError(t, db.Close())
+
+	// 模拟上次活动文件未写完的尾部，只读打开时忽略而不截断
+	active := db.fm.ActiveFileIDs()[0]
+	f, err := os.OpenFile(file_manager.DataFilePath(dir, active), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("torn"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	// hint 文件缺失时只读打开也不补写
+	require.NoError(t, os.Remove(file_manager.HintPath(dir, db.fm.LiveFileIDs()[0])))
+
+	listing := func() map[string]int64 {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		files := make(map[string]int64)
+		for _, e := range entries {
+			info, err := e.Info()
+			require.NoError(t, err)
+			files[e.Name()] = info.Size()
+		}
+		return files
+	}
+	want := listing()
+
+	ro := openTestDB(t, dir, storage2.WithReadOnly(true), storage2.WithAutoMerge(true))
+	for i := 0; i < 50; i++ {
+		_, err := ro.Get(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, ro.Close())
+	assert.Equal(t, want, listing())
+
+	_, err = Open(storage2.WithDataDir(filepath.Join(dir, "missing")), storage2.WithReadOnly(true))
+	assert.Error(t, err)
+}
+
+func TestLegacyFileUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%02d", i), []byte("some-value")))
+	}
+	require.NoError(t, db.Close())
+
+	// 去掉文件头并删除 hint，模拟旧版本写出的数据文件
+	ids := db.fm.LiveFileIDs()
+	require.Greater(t, len(ids), 2)
+	for _, id := range ids {
+		path := file_manager.DataFilePath(dir, id)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data[file_manager.FileHeaderSize:], 0644))
+		require.NoError(t, os.Remove(file_manager.HintPath(dir, id)))
+	}
+
+	db = openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	for i := 0; i < 40; i++ {
+		_, err := db.Get(fmt.Sprintf("key-%02d", i))
+		require.NoError(t, err)
+	}
+	stats := db.stats.snapshot()
+	assert.True(t, stats[ids[0]].Outdated)
+	require.NoError(t, db.Put("key-00", []byte("new-value")))
+
+	// 合并按当前格式重写所有旧格式文件
+	require.NoError(t, db.Merge())
+	for _, id := range db.fm.LiveFileIDs() {
+		header, err := db.fm.FileHeader(id)
+		require.NoError(t, err)
+		assert.Equal(t, file_manager.FileVersion, header.Version, "file %d", id)
+	}
+	require.NoError(t, db.Close())
+
+	db = openTestDB(t, dir, storage2.WithMaxFileSize(512))
+	defer db.Close()
+	value, err := db.Get("key-00")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new-value"), value)
+	for i := 1; i < 40; i++ {
+		value, err := db.Get(fmt.Sprintf("key-%02d", i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("some-value"), value)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithOpenMemCache(false), storage2.WithScrubber(0, 0))
+	defer db.Close()
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%02d", i), []byte("some-value")))
+	}
+
+	require.NoError(t, db.Scrub())
+	stats := db.ScrubStats()
+	assert.Equal(t, int64(1), stats.Passes)
+	assert.Greater(t, stats.FilesScanned, int64(1))
+	assert.Zero(t, stats.CorruptRanges)
+
+	// 写坏第一个文件中第二条记录的值
+	fileID := db.fm.LiveFileIDs()[0]
+	entry, err := db.memIndex.Get("key-01")
+	require.NoError(t, err)
+	require.Equal(t, fileID, entry.FileID)
+	f, err := os.OpenFile(file_manager.DataFilePath(dir, fileID), os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("XX"), entry.Offset+int64(entry.Size)-10)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = db.Get("key-01")
+	require.Error(t, err)
+
+	// 未设置恢复方式时只报告
+	require.NoError(t, db.Scrub())
+	stats = db.ScrubStats()
+	require.Len(t, stats.LastCorrupt, 1)
+	assert.Equal(t, fileID, stats.LastCorrupt[0].FileID)
+	assert.Equal(t, entry.Offset, stats.LastCorrupt[0].Offset)
+	assert.Equal(t, int64(entry.Size), stats.LastCorrupt[0].Length)
+	assert.Equal(t, []string{"key-01"}, stats.LastCorrupt[0].Keys)
+	assert.Zero(t, stats.RepairedKeys)
+
+	var requested []string
+	db.SetScrubRepair(func(keys []string) (map[string][]byte, error) {
+		requested = append(requested, keys...)
+		return map[string][]byte{"key-01": []byte("some-value")}, nil
+	})
+	require.NoError(t, db.Scrub())
+	assert.Equal(t, []string{"key-01"}, requested)
+	stats = db.ScrubStats()
+	assert.Equal(t, int64(3), stats.Passes)
+	assert.Equal(t, int64(2), stats.CorruptRanges)
+	assert.Equal(t, int64(1), stats.RepairedKeys)
+	value, err := db.Get("key-01")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("some-value"), value)
+
+	// 键已指向新写入的记录，损坏区间仍被报告但不再请求恢复
+	requested = nil
+	require.NoError(t, db.Scrub())
+	assert.Empty(t, requested)
+	assert.Empty(t, db.ScrubStats().LastCorrupt[0].Keys)
+}
+
+func TestScrubStopsOnClose(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir, storage2.WithMaxFileSize(512), storage2.WithScrubber(0, 64))
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%02d", i), []byte("some-value")))
+	}
+
+	// 限速下一轮需要数十秒，关闭时应立即中止
+	done := make(chan error, 1)
+	go func() { done <- db.Scrub() }()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, db.Close())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.ErrorIs(t, <-done, err_def.ErrDBClosed)
+}
@@ -0,0 +1,411 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// blobRefs 记录值分离存储的键当前引用的 blob，并按 blob 文件统计仍被引用的字节数
+// 只有大值的键会出现在这里，加载时从数据文件或 hint 中的指针重建；
+// blob 文件的总长度即文件长度，其余部分（被覆盖、删除或写入指针失败的 blob）都是可回收的空间
+type blobRefs struct {
+	mu      sync.Mutex
+	refs    map[string]blobRef
+	live    map[int]int64
+	count   atomic.Int64 // len(refs)，没有大值时写入无需加锁
+	writers map[uint64]int
+	nextID  uint64
+}
+
+// blobRef 键引用的 blob：FlagBlob 记录引用一条，FlagChunked 记录按顺序引用各分块
+type blobRef struct {
+	flags uint32
+	size  int64
+	ptrs  []file_manager.BlobPointer
+}
+
+// encode 编码为指针记录的值
+func (r blobRef) encode() []byte {
+	if r.flags&storage2.FlagChunked != 0 {
+		return file_manager.ChunkManifest{Size: r.size, Chunks: r.ptrs}.Encode()
+	}
+	return r.ptrs[0].Encode()
+}
+
+func newBlobRefs() *blobRefs {
+	return &blobRefs{
+		refs:    make(map[string]blobRef),
+		live:    make(map[int]int64),
+		writers: make(map[uint64]int),
+	}
+}
+
+// set 记录键引用的 blob，之前引用的 blob 不再计入
+func (b *blobRefs) set(key string, ref blobRef) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unref(key)
+	b.refs[key] = ref
+	for _, ptr := range ref.ptrs {
+		b.live[ptr.FileID] += int64(ptr.Size)
+	}
+	b.count.Store(int64(len(b.refs)))
+}
+
+// remove 键被删除或覆盖为小值，之前引用的 blob 不再计入
+func (b *blobRefs) remove(key string) {
+	if b.count.Load() == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unref(key)
+	b.count.Store(int64(len(b.refs)))
+}
+
+func (b *blobRefs) unref(key string) {
+	old, ok := b.refs[key]
+	if !ok {
+		return
+	}
+	for _, ptr := range old.ptrs {
+		b.live[ptr.FileID] -= int64(ptr.Size)
+	}
+	delete(b.refs, key)
+}
+
+func (b *blobRefs) get(key string) (blobRef, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ref, ok := b.refs[key]
+	return ref, ok
+}
+
+// references 键当前是否引用 ptr
+func (b *blobRefs) references(key string, ptr file_manager.BlobPointer) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Contains(b.refs[key].ptrs, ptr)
+}
+
+// liveBytes 返回 blob 文件中仍被引用的字节数
+func (b *blobRefs) liveBytes(fileID int) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.live[fileID]
+}
+
+// drop 移除已删除 blob 文件的统计
+func (b *blobRefs) drop(fileIDs []int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range fileIDs {
+		delete(b.live, id)
+	}
+}
+
+// pin 登记一个正在写入 blob、尚未写入指针记录的写入方，from 为开始时的活动 blob 文件编号，
+// 写入方的 blob 只会落在不小于 from 的文件中，完成前 GC 不会回收这些文件；返回的函数用于注销
+func (b *blobRefs) pin(from int) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.writers[id] = from
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.writers, id)
+	}
+}
+
+// pinnedFrom 返回正在写入的写入方中最小的起始文件编号，没有写入方时返回 math.MaxInt
+func (b *blobRefs) pinnedFrom() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	from := math.MaxInt
+	for _, id := range b.writers {
+		from = min(from, id)
+	}
+	return from
+}
+
+// apply 按加载或写入的记录更新键引用的 blob
+func (b *blobRefs) apply(key string, flags uint32, value []byte) error {
+	if storage2.RecordType(flags) == FlagDeleted || !storage2.HasBlobRef(flags) {
+		b.remove(key)
+		return nil
+	}
+	ref, err := decodeBlobRef(flags, value)
+	if err != nil {
+		return err
+	}
+	b.set(key, ref)
+	return nil
+}
+
+// relocated 加载时遇到与索引中序列号相同的记录，判断键当前引用的 blob 是否由 GC 搬移自该记录引用的 blob
+// GC 以原序列号写入搬移后的指针，多个写入通道时它可能落在清单中更靠前的文件里，不能按回放顺序判断；
+// 搬移总是写入当前的 blob 文件末尾，位置晚于之前写入的所有 blob，引用位置更靠后的一条即搬移后的记录
+func (b *blobRefs) relocated(key string, flags uint32, value []byte) bool {
+	if !storage2.HasBlobRef(flags) {
+		return false
+	}
+	cur, ok := b.get(key)
+	if !ok {
+		return false
+	}
+	ref, err := decodeBlobRef(flags, value)
+	if err != nil {
+		return false
+	}
+	return blobAfter(cur.last(), ref.last())
+}
+
+// decodeBlobRef 解码指针记录的值
+func decodeBlobRef(flags uint32, value []byte) (blobRef, error) {
+	ref := blobRef{flags: flags & (storage2.FlagBlob | storage2.FlagChunked)}
+	if flags&storage2.FlagChunked != 0 {
+		m, err := file_manager.DecodeChunkManifest(value)
+		if err != nil {
+			return blobRef{}, err
+		}
+		ref.size, ref.ptrs = m.Size, m.Chunks
+	} else {
+		ptr, err := file_manager.DecodeBlobPointer(value)
+		if err != nil {
+			return blobRef{}, err
+		}
+		ref.size, ref.ptrs = int64(ptr.Size), []file_manager.BlobPointer{ptr}
+	}
+	return ref, nil
+}
+
+// last 返回引用的 blob 中写入位置最靠后的一条
+func (r blobRef) last() file_manager.BlobPointer {
+	var last file_manager.BlobPointer
+	for _, ptr := range r.ptrs {
+		if blobAfter(ptr, last) {
+			last = ptr
+		}
+	}
+	return last
+}
+
+// blobAfter 判断 a 是否在 b 之后写入，blob 文件编号只增不减，同一文件内按偏移先后
+func blobAfter(a, b file_manager.BlobPointer) bool {
+	if a.FileID != b.FileID {
+		return a.FileID > b.FileID
+	}
+	return a.Offset > b.Offset
+}
+
+// pinBlobs 登记正在写入 blob 的写入方，写入指针记录后调用返回的函数
+func (db *Bitcask) pinBlobs() func() {
+	return db.blobs.pin(db.fm.ActiveBlobFileID())
+}
+
+// separated 值是否需要分离存储到 blob 文件
+func (db *Bitcask) separated(value []byte) bool {
+	return db.cfg.BlobThreshold > 0 && len(value) >= db.cfg.BlobThreshold
+}
+
+// separateValue 值长度达到 BlobThreshold 时先将值写入 blob 文件，再把记录的值替换为 blob 指针
+// 调用方需在写入前通过 pinBlobs 登记，直到指针记录写入后注销
+func (db *Bitcask) separateValue(r *storage2.Record) error {
+	if !db.separated(r.Value) {
+		return nil
+	}
+	ptr, err := db.fm.WriteBlob(r)
+	if err != nil {
+		return fmt.Errorf("write blob failed: %w", err)
+	}
+	r.Value = ptr.Encode()
+	r.Flags |= storage2.FlagBlob
+	return nil
+}
+
+// BlobStats 返回每个 blob 文件的空间统计，DeadBytes 为不再被引用的字节数
+func (db *Bitcask) BlobStats() (map[int]FileStat, error) {
+	if db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+
+	stats := make(map[int]FileStat)
+	for _, id := range db.fm.LiveBlobFileIDs() {
+		size, err := db.fm.BlobFileSize(id)
+		if err != nil {
+			return nil, err
+		}
+		live := min(db.blobs.liveBytes(id), size)
+		stats[id] = FileStat{TotalBytes: size, DeadBytes: size - live}
+	}
+	return stats, nil
+}
+
+// blobMove GC 搬移的一条 blob
+type blobMove struct {
+	key      string
+	old, new file_manager.BlobPointer
+}
+
+// GCBlobs 重写不再被引用的数据占比达到 BlobGCRatio、或需要用当前密钥重新加密的 blob 文件
+// 仍被引用的 blob 搬到新的 blob 文件，再以原序列号写入新的指针记录，版本与变更流都不受影响；
+// 旧文件在没有快照引用后删除
+func (db *Bitcask) GCBlobs() error {
+	if db.closed {
+		return err_def.ErrDBClosed
+	}
+	if db.cfg.ReadOnly {
+		return err_def.ErrReadOnly
+	}
+
+	// 与合并、检查点互斥，检查点期间不会删除文件
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	if db.closed {
+		return err_def.ErrDBClosed
+	}
+
+	inputs, err := db.pickBlobFiles()
+	if err != nil || len(inputs) == 0 {
+		return err
+	}
+
+	// 按键收集搬移，分块写入的值的多个分块一起切换
+	moves := make(map[string]map[file_manager.BlobPointer]file_manager.BlobPointer)
+	var keys []string
+	for _, fileID := range inputs {
+		err := db.fm.ScanBlobFile(fileID, func(r *storage2.Record, ptr file_manager.BlobPointer) error {
+			key := string(r.Key)
+			if !db.blobs.references(key, ptr) {
+				return nil
+			}
+			moved, err := db.fm.WriteBlob(r)
+			if err != nil {
+				return err
+			}
+			if moves[key] == nil {
+				moves[key] = make(map[file_manager.BlobPointer]file_manager.BlobPointer)
+				keys = append(keys, key)
+			}
+			moves[key][ptr] = moved
+			return nil
+		})
+		var corrupt *file_manager.CorruptRecordError
+		if errors.As(err, &corrupt) {
+			// 损坏之后的 blob 无法搬移，保留文件等待人工处理
+			return fmt.Errorf("blob file %d: %w", fileID, err)
+		}
+		if err != nil {
+			return fmt.Errorf("gc blob file %d failed: %w", fileID, err)
+		}
+	}
+	if err := db.fm.SyncBlob(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := db.relocateBlobs(key, moves[key]); err != nil {
+			return fmt.Errorf("relocate blob of key %q failed: %w", key, err)
+		}
+	}
+
+	if err := db.fm.RetireBlobs(inputs); err != nil {
+		return err
+	}
+	// 写锁等待仍在按旧指针读取的 Get 完成
+	db.mu.Lock()
+	db.blobs.drop(inputs)
+	db.mu.Unlock()
+	db.removeObsolete()
+	return nil
+}
+
+// pickBlobFiles 挑选需要 GC 的已封存 blob 文件，跳过仍有写入方未写入指针的文件
+func (db *Bitcask) pickBlobFiles() ([]int, error) {
+	active := db.fm.ActiveBlobFileID()
+	pinned := db.blobs.pinnedFrom()
+	var inputs []int
+	for _, id := range db.fm.LiveBlobFileIDs() {
+		if id == active || id >= pinned {
+			continue
+		}
+		size, err := db.fm.BlobFileSize(id)
+		if err != nil {
+			return nil, err
+		}
+		live := db.blobs.liveBytes(id)
+		if size == 0 || float64(size-live)/float64(size) >= db.cfg.BlobGCRatio {
+			inputs = append(inputs, id)
+			continue
+		}
+		stale, err := db.fm.BlobNeedsRekey(id)
+		if err != nil {
+			return nil, err
+		}
+		if stale {
+			inputs = append(inputs, id)
+		}
+	}
+	return inputs, nil
+}
+
+// relocateBlobs 键仍引用被搬移的 blob 时，写入指向新 blob 的指针记录并切换索引
+// 新记录沿用旧记录的序列号与时间戳，值没有变化，事务版本与变更流都不受影响
+func (db *Bitcask) relocateBlobs(key string, moved map[file_manager.BlobPointer]file_manager.BlobPointer) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	unlock := db.lockKey(key)
+	defer unlock()
+
+	cur, ok := db.blobs.get(key)
+	if !ok {
+		return nil
+	}
+	ref := blobRef{flags: cur.flags, size: cur.size, ptrs: slices.Clone(cur.ptrs)}
+	replaced := 0
+	for i, ptr := range ref.ptrs {
+		if to, ok := moved[ptr]; ok {
+			ref.ptrs[i] = to
+			replaced++
+		}
+	}
+	if replaced != len(moved) {
+		// 搬移期间被覆盖或删除，新 blob 不被引用，随下一次 GC 回收
+		return nil
+	}
+	old, err := db.memIndex.Get(key)
+	if err != nil {
+		return nil
+	}
+
+	record := &storage2.Record{
+		Timestamp: old.Timestamp,
+		Seq:       old.Seq,
+		Flags:     FlagNormal | ref.flags,
+		KVItem: storage2.KVItem{
+			Key:   []byte(key),
+			Value: ref.encode(),
+		},
+	}
+	resp := <-db.fm.WriteAsync(record)
+	if resp.Err != nil {
+		return resp.Err
+	}
+
+	db.stats.addTotal(resp.Entry)
+	db.stats.addDead(old)
+	if err := db.memIndex.Put(key, resp.Entry); err != nil {
+		return fmt.Errorf("update index failed: %w", err)
+	}
+	db.blobs.set(key, ref)
+	return nil
+}
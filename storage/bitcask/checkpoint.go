@@ -0,0 +1,30 @@
+package bitcask
+
+import (
+	"github.com/FinnTew/FincasKV/err_def"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+)
+
+// Checkpoint 在线创建一致性检查点
+// 封存活动文件后将所有数据文件及 hint 硬链接到 dir，期间写入不受影响，
+// 检查点包含调用前已提交的全部写入，可直接作为数据目录打开或通过 RestoreCheckpoint 恢复；
+// extras 在检查点描述文件写入前调用，用于写入上层的元数据，失败时检查点不生效
+func (db *Bitcask) Checkpoint(dir string, extras ...file_manager.CheckpointExtra) (*file_manager.Checkpoint, error) {
+	if db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+
+	// 合并会删除旧文件，创建期间不允许合并
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	if db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+
+	return db.fm.Checkpoint(dir, extras...)
+}
+
+// RestoreCheckpoint 将检查点目录 src 恢复为数据目录 dataDir，需在打开数据库之前调用
+func RestoreCheckpoint(src, dataDir string) (*file_manager.Checkpoint, error) {
+	return file_manager.RestoreCheckpoint(src, dataDir)
+}
@@ -0,0 +1,89 @@
+package bitcask
+
+import (
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"sync"
+)
+
+// FileStat 单个数据文件的空间统计
+type FileStat struct {
+	TotalBytes int64 // 文件中所有记录的字节数
+	DeadBytes  int64 // 已被覆盖、删除的记录及删除标记的字节数
+	StaleKey   bool  // 含有未加密或使用旧密钥加密的记录，需要合并重写
+	Outdated   bool  // 文件格式早于当前版本，需要合并重写
+}
+
+// InvalidRatio 无效数据占比
+func (s FileStat) InvalidRatio() float64 {
+	if s.TotalBytes == 0 {
+		return 0
+	}
+	return float64(s.DeadBytes) / float64(s.TotalBytes)
+}
+
+// fileStats 按文件统计有效/无效字节数，在写入、覆盖、删除时增量更新
+type fileStats struct {
+	mu    sync.Mutex
+	files map[int]*FileStat
+}
+
+func newFileStats() *fileStats {
+	return &fileStats{files: make(map[int]*FileStat)}
+}
+
+func (s *fileStats) get(fileID int) *FileStat {
+	st, ok := s.files[fileID]
+	if !ok {
+		st = &FileStat{}
+		s.files[fileID] = st
+	}
+	return st
+}
+
+// addTotal 记录新写入的一条记录
+func (s *fileStats) addTotal(entry storage2.Entry) {
+	s.mu.Lock()
+	s.get(entry.FileID).TotalBytes += int64(entry.Size)
+	s.mu.Unlock()
+}
+
+// addDead 将一条记录标记为无效
+func (s *fileStats) addDead(entry storage2.Entry) {
+	s.mu.Lock()
+	s.get(entry.FileID).DeadBytes += int64(entry.Size)
+	s.mu.Unlock()
+}
+
+// markStale 标记文件需要用当前密钥重写
+func (s *fileStats) markStale(fileID int) {
+	s.mu.Lock()
+	s.get(fileID).StaleKey = true
+	s.mu.Unlock()
+}
+
+// markOutdated 标记文件需要按当前格式重写
+func (s *fileStats) markOutdated(fileID int) {
+	s.mu.Lock()
+	s.get(fileID).Outdated = true
+	s.mu.Unlock()
+}
+
+// remove 移除已被删除文件的统计
+func (s *fileStats) remove(fileIDs []int) {
+	s.mu.Lock()
+	for _, id := range fileIDs {
+		delete(s.files, id)
+	}
+	s.mu.Unlock()
+}
+
+// snapshot 返回当前统计的副本
+func (s *fileStats) snapshot() map[int]FileStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[int]FileStat, len(s.files))
+	for id, st := range s.files {
+		res[id] = *st
+	}
+	return res
+}
@@ -0,0 +1,147 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/util"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// FilterFileName 正常关闭时保存的布隆过滤器
+// 格式: crc32(4) | 保存时的序列号(8) | 过滤器数据，校验和覆盖其后的全部内容
+const FilterFileName = "bloom.filter"
+
+const filterHeaderSize = 4 + 8
+
+// newFilter 按配置创建计数布隆过滤器，键数超过预期时按键数创建
+func (db *Bitcask) newFilter(keys int64) (*util.CountingBloomFilter, error) {
+	filter, err := util.NewCountingBloomFilter(util.BloomConfig{
+		ExpectedElements:  max(db.cfg.BloomExpectedElements, uint64(keys)),
+		FalsePositiveRate: db.cfg.BloomFalsePositiveRate,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create filter failed: %w", err)
+	}
+	return filter, nil
+}
+
+// loadFilter 打开时恢复布隆过滤器，需在加载数据文件之后调用
+// 保存的过滤器只有在之后没有新的写入、键数与内存索引一致、配置未变时才直接使用，否则按内存索引重建。
+// 文件读取后即删除，运行期间崩溃时下次打开必然重建，不会用到过期的过滤器；只读打开时保留文件
+func (db *Bitcask) loadFilter() error {
+	path := filepath.Join(db.cfg.DataDir, FilterFileName)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if db.cfg.ReadOnly {
+			if filter, ok := db.decodeFilter(data); ok {
+				db.filter.Store(filter)
+				return nil
+			}
+			return db.rebuildFilter()
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove filter file failed: %w", err)
+		}
+		if filter, ok := db.decodeFilter(data); ok {
+			db.filter.Store(filter)
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("read filter file failed: %w", err)
+	}
+	return db.rebuildFilter()
+}
+
+// decodeFilter 解析并校验保存的过滤器
+func (db *Bitcask) decodeFilter(data []byte) (*util.CountingBloomFilter, bool) {
+	if len(data) < filterHeaderSize || crc32.ChecksumIEEE(data[4:]) != binary.LittleEndian.Uint32(data) {
+		return nil, false
+	}
+	if binary.LittleEndian.Uint64(data[4:]) != db.fm.LastSequence() {
+		return nil, false
+	}
+	filter := &util.CountingBloomFilter{}
+	if err := filter.UnmarshalBinary(data[filterHeaderSize:]); err != nil {
+		return nil, false
+	}
+	if expected, fpr := filter.Config(); expected < db.cfg.BloomExpectedElements || fpr != db.cfg.BloomFalsePositiveRate {
+		return nil, false
+	}
+	if filter.Len() != db.countKeys() {
+		return nil, false
+	}
+	return filter, true
+}
+
+// rebuildFilter 按内存索引中的键重建布隆过滤器
+func (db *Bitcask) rebuildFilter() error {
+	filter, err := db.newFilter(db.countKeys())
+	if err != nil {
+		return err
+	}
+	err = db.memIndex.Foreach(func(key string, _ storage2.Entry) bool {
+		_ = filter.Add([]byte(key))
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("rebuild filter failed: %w", err)
+	}
+	db.filter.Store(filter)
+	return nil
+}
+
+// compactFilter 合并后按当前键数重新确定过滤器大小：
+// 键数超过容量时扩大以恢复误判率，大量删除后容量远超所需时缩小，调用方需持有 mu 写锁
+func (db *Bitcask) compactFilter() error {
+	keys := db.filter.Load().Len()
+	expected, _ := db.filter.Load().Config()
+	target := max(db.cfg.BloomExpectedElements, uint64(keys))
+	if uint64(keys) <= expected && expected <= 2*target {
+		return nil
+	}
+	return db.rebuildFilter()
+}
+
+// saveFilter 关闭时保存布隆过滤器，下次打开时无需重建；先写临时文件再重命名
+func (db *Bitcask) saveFilter() error {
+	data, err := db.filter.Load().MarshalBinary()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, filterHeaderSize, filterHeaderSize+len(data))
+	binary.LittleEndian.PutUint64(buf[4:], db.fm.LastSequence())
+	buf = append(buf, data...)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+
+	path := filepath.Join(db.cfg.DataDir, FilterFileName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// countKeys 返回内存索引中的键数
+func (db *Bitcask) countKeys() int64 {
+	var n int64
+	_ = db.memIndex.Foreach(func(string, storage2.Entry) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -0,0 +1,297 @@
+package bitcask
+
+import (
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"sort"
+	"strings"
+)
+
+// iteratorBatch 迭代器每次从索引读取的键数
+const iteratorBatch = 64
+
+// IteratorOptions 迭代器配置
+type IteratorOptions struct {
+	Prefix  string // 只遍历带该前缀的键，为空时遍历全部键
+	Reverse bool   // 按键降序遍历
+}
+
+// Iterator 按键序遍历 Bitcask，要求内存索引为 BTree、SkipList 或 ART，前缀范围依赖按字节序比较的比较器
+// 迭代器基于创建时的快照，Key 与 Value 都取自快照，之后的写入不可见；
+// 游标每次从有序索引中当前位置之后读取一批键，不在创建时收集整个范围。用完后必须调用 Close 释放快照
+type Iterator struct {
+	db      *Bitcask
+	snap    *Snapshot
+	prefix  string
+	reverse bool
+	items   []iteratorItem // 当前读入的一批键，按升序排列
+	pos     int            // 当前位置，-1 与 len(items) 表示越过了这批键的两端
+}
+
+type iteratorItem struct {
+	key   string
+	entry storage2.Entry
+}
+
+// NewIterator 创建迭代器，初始位置为第一个键（Reverse 时为最后一个键）
+// 内存索引无序（SwissTable）时返回 ErrIndexUnordered
+func (db *Bitcask) NewIterator(opts IteratorOptions) (*Iterator, error) {
+	if db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+	if !db.memIndex.Ordered() {
+		return nil, err_def.ErrIndexUnordered
+	}
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	it := &Iterator{
+		db:      db,
+		snap:    snap,
+		prefix:  opts.Prefix,
+		reverse: opts.Reverse,
+	}
+	it.Rewind()
+	return it, nil
+}
+
+// Rewind 回到遍历起点
+func (it *Iterator) Rewind() {
+	if !it.reverse {
+		it.seekAscend(it.prefix, true)
+		return
+	}
+	if end, ok := prefixEnd(it.prefix); ok {
+		it.seekDescend(end, false)
+	} else {
+		it.seekLast()
+	}
+}
+
+// Seek 正序时定位到第一个不小于 key 的键，逆序时定位到最后一个不大于 key 的键
+func (it *Iterator) Seek(key string) {
+	less := it.db.memIndex.Less
+	if !it.reverse {
+		if less(key, it.prefix) {
+			key = it.prefix
+		}
+		it.seekAscend(key, true)
+		return
+	}
+	switch {
+	case strings.HasPrefix(key, it.prefix):
+		it.seekDescend(key, true)
+	case less(key, it.prefix):
+		it.load(nil, false)
+	default:
+		// key 大于范围内所有的键
+		it.Rewind()
+	}
+}
+
+// Next 沿遍历方向前进一步
+func (it *Iterator) Next() {
+	if it.reverse {
+		it.stepDescend()
+	} else {
+		it.stepAscend()
+	}
+}
+
+// Prev 沿遍历方向后退一步
+func (it *Iterator) Prev() {
+	if it.reverse {
+		it.stepAscend()
+	} else {
+		it.stepDescend()
+	}
+}
+
+// Valid 当前位置是否有效
+func (it *Iterator) Valid() bool {
+	return it.pos >= 0 && it.pos < len(it.items)
+}
+
+// Key 返回当前键
+func (it *Iterator) Key() string {
+	if !it.Valid() {
+		return ""
+	}
+	return it.items[it.pos].key
+}
+
+// Value 读取当前键在快照中的值
+func (it *Iterator) Value() ([]byte, error) {
+	if !it.Valid() {
+		return nil, err_def.ErrKeyNotFound
+	}
+	if it.snap.released.Load() {
+		return nil, err_def.ErrSnapshotReleased
+	}
+	return it.snap.read(it.items[it.pos].entry)
+}
+
+// Close 释放迭代器及其快照
+func (it *Iterator) Close() {
+	it.snap.Release()
+	it.items = nil
+	it.pos = -1
+}
+
+// stepAscend 移到下一个更大的键，越过当前一批键时从索引读取下一批
+func (it *Iterator) stepAscend() {
+	switch {
+	case it.pos < len(it.items)-1:
+		it.pos++
+	case it.pos == len(it.items)-1 && len(it.items) > 0:
+		last := it.items[it.pos].key
+		if items := it.scanAscend(last, false); len(items) > 0 {
+			it.load(items, false)
+		} else {
+			// 已经没有更大的键，保留这批键以便 Prev 返回
+			it.pos = len(it.items)
+		}
+	}
+}
+
+// stepDescend 移到上一个更小的键，越过当前一批键时从索引读取上一批
+func (it *Iterator) stepDescend() {
+	switch {
+	case it.pos > 0:
+		it.pos--
+	case it.pos == 0 && len(it.items) > 0:
+		first := it.items[0].key
+		if items := it.scanDescend(first, false); len(items) > 0 {
+			it.load(items, true)
+		} else {
+			it.pos = -1
+		}
+	}
+}
+
+// seekAscend 定位到第一个不小于 pivot 的键，inclusive 为 false 时不含 pivot 本身
+func (it *Iterator) seekAscend(pivot string, inclusive bool) {
+	it.load(it.scanAscend(pivot, inclusive), false)
+}
+
+// seekDescend 定位到最后一个不大于 pivot 的键，inclusive 为 false 时不含 pivot 本身
+func (it *Iterator) seekDescend(pivot string, inclusive bool) {
+	it.load(it.scanDescend(pivot, inclusive), true)
+}
+
+// seekLast 定位到最大的键
+func (it *Iterator) seekLast() {
+	it.load(it.fetch(true, "", true, true), true)
+}
+
+// scanAscend 读取 pivot 之后的一批键，inclusive 为 false 时不含 pivot 本身
+func (it *Iterator) scanAscend(pivot string, inclusive bool) []iteratorItem {
+	return it.fetch(false, pivot, inclusive, false)
+}
+
+// scanDescend 读取 pivot 之前的一批键，inclusive 为 false 时不含 pivot 本身
+func (it *Iterator) scanDescend(pivot string, inclusive bool) []iteratorItem {
+	return it.fetch(true, pivot, inclusive, false)
+}
+
+// load 换成新的一批键，atEnd 为 true 时定位到最后一个
+func (it *Iterator) load(items []iteratorItem, atEnd bool) {
+	it.items = items
+	if atEnd {
+		it.pos = len(items) - 1
+	} else {
+		it.pos = 0
+	}
+}
+
+// fetch 沿 desc 指定的方向从 pivot 开始读取一批键，last 为 true 时从最大的键开始、忽略 pivot；
+// 读到的索引项按快照保存的旧索引项修正为快照中的状态，返回按升序排列的结果。
+// 一批键全部在快照创建后写入时继续读取下一批，直到读到快照中存在的键或范围结束
+func (it *Iterator) fetch(desc bool, pivot string, inclusive, last bool) []iteratorItem {
+	db, s := it.db, it.snap
+	less := db.memIndex.Less
+	// beyond 判断 a 是否沿遍历方向在 b 之后
+	beyond := func(a, b string) bool {
+		if desc {
+			return less(a, b)
+		}
+		return less(b, a)
+	}
+
+	for {
+		// started 判断键是否已越过起点，不含起点时跳过 pivot 本身
+		started := func(key string) bool {
+			return last || beyond(key, pivot) || (inclusive && key == pivot)
+		}
+		while := func(key string) bool {
+			return !started(key) || strings.HasPrefix(key, it.prefix)
+		}
+
+		entries := make(map[string]storage2.Entry)
+		var bound string
+		scanned := 0
+		collect := func(key string, entry storage2.Entry) bool {
+			scanned++
+			if started(key) {
+				entries[key] = entry
+				bound = key
+			}
+			return true
+		}
+		db.mu.RLock()
+		// 索引无序时创建迭代器已返回错误，这里的遍历不会失败
+		switch {
+		case last:
+			_ = db.memIndex.DescendLast(while, iteratorBatch+1, collect)
+		case desc:
+			_ = db.memIndex.DescendRange(pivot, while, iteratorBatch+1, collect)
+		default:
+			_ = db.memIndex.AscendRange(pivot, while, iteratorBatch+1, collect)
+		}
+		db.mu.RUnlock()
+		// 读满时索引中可能还有更多的键，本批只覆盖到 bound 为止
+		full := scanned == iteratorBatch+1
+
+		// 索引先于保存的旧索引项读取，读取期间的写入都会被旧索引项覆盖
+		s.mu.Lock()
+		for key, old := range s.overrides {
+			if !strings.HasPrefix(key, it.prefix) || !started(key) || (full && beyond(key, bound)) {
+				continue
+			}
+			if old.exists {
+				entries[key] = old.entry
+			} else {
+				delete(entries, key)
+			}
+		}
+		s.mu.Unlock()
+
+		if len(entries) > 0 || !full {
+			items := make([]iteratorItem, 0, len(entries))
+			for key, entry := range entries {
+				items = append(items, iteratorItem{key: key, entry: entry})
+			}
+			sort.Slice(items, func(i, j int) bool {
+				return less(items[i].key, items[j].key)
+			})
+			return items
+		}
+
+		// 这一批的键都在快照创建后写入，从 bound 之后继续
+		pivot, inclusive, last = bound, false, false
+	}
+}
+
+// prefixEnd 返回按字节序大于所有带 prefix 前缀的键的最小字符串，prefix 为空或全为 0xff 时不存在
+func prefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1]), true
+		}
+	}
+	return "", false
+}
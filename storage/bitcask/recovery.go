@@ -0,0 +1,194 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"os"
+)
+
+// RecoveryAction 加载时对损坏数据采取的处理
+type RecoveryAction string
+
+const (
+	ActionTruncated   RecoveryAction = "truncated"   // 截断上次活动文件未写完的尾部
+	ActionQuarantined RecoveryAction = "quarantined" // 文件复制到隔离目录后截断到损坏位置，之后的内容被丢弃
+	ActionSalvaged    RecoveryAction = "salvaged"    // 跳过损坏区域，继续加载之后的记录
+	ActionIgnored     RecoveryAction = "ignored"     // 只读打开时不修改文件，只在内存中忽略损坏位置之后的内容
+)
+
+// FileRecovery 单处损坏的处理结果
+type FileRecovery struct {
+	FileID         int
+	Action         RecoveryAction
+	Offset         int64  // 损坏区域起始位置
+	LostBytes      int64  // 丢弃的字节数
+	LostKeys       int    // 按记录头估算的丢失记录数
+	QuarantinePath string // 隔离副本路径，仅 ActionQuarantined 时有值
+}
+
+// RecoveryReport 打开数据库时的损坏恢复报告
+type RecoveryReport struct {
+	Files     []FileRecovery
+	LostBytes int64
+	LostKeys  int
+}
+
+func (r *RecoveryReport) add(fr FileRecovery) {
+	r.Files = append(r.Files, fr)
+	r.LostBytes += fr.LostBytes
+	r.LostKeys += fr.LostKeys
+}
+
+// RecoveryReport 返回打开数据库时的损坏恢复报告
+func (db *Bitcask) RecoveryReport() RecoveryReport {
+	return db.recovery
+}
+
+// scanDataFile 扫描数据文件并按恢复策略处理损坏
+// 上次活动文件中无法再找到有效记录的损坏尾部总是直接截断，其余损坏按 Options.Recovery 处理
+func (db *Bitcask) scanDataFile(fileID int, fn func(r *storage2.Record, offset int64, size uint32) error) error {
+	file, err := db.fm.GetFile(fileID)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file %d failed: %w", fileID, err)
+	}
+	size := stat.Size()
+
+	// GetFile 已校验过文件头
+	offset := file_manager.DataStart(file)
+	for {
+		end, err := file_manager.ScanRecordsFrom(file, offset, fn)
+		var ce *file_manager.CorruptRecordError
+		if err != nil && !errors.As(err, &ce) {
+			return err
+		}
+
+		if ce == nil {
+			if end < size {
+				// 不完整的尾部记录
+				return db.recoverTail(fileID, file, end, size, fmt.Errorf("incomplete record at offset %d", end))
+			}
+			return nil
+		}
+
+		next, found := file_manager.FindNextRecord(file, ce.Offset+1, size)
+		if !found {
+			return db.recoverTail(fileID, file, ce.Offset, size, ce)
+		}
+
+		// 文件中间的损坏
+		switch db.cfg.Recovery {
+		case storage2.RecoverySalvage:
+			db.recovery.add(FileRecovery{
+				FileID:    fileID,
+				Action:    ActionSalvaged,
+				Offset:    ce.Offset,
+				LostBytes: next - ce.Offset,
+				LostKeys:  file_manager.EstimateRecords(file, ce.Offset, next),
+			})
+			offset = next
+		case storage2.RecoverySkip:
+			return db.quarantine(fileID, file, ce.Offset, size)
+		default:
+			return fmt.Errorf("data file %d: %w", fileID, ce)
+		}
+	}
+}
+
+// recoverTail 处理从 from 开始到文件末尾的损坏
+func (db *Bitcask) recoverTail(fileID int, file *os.File, from, size int64, cause error) error {
+	if db.cfg.ReadOnly && db.fm.WasActive(fileID) {
+		// 写入者可能正在追加，不完整的尾部是尚未写完的记录
+		return nil
+	}
+	if db.fm.WasActive(fileID) {
+		lost := FileRecovery{
+			FileID:    fileID,
+			Action:    ActionTruncated,
+			Offset:    from,
+			LostBytes: size - from,
+			LostKeys:  file_manager.EstimateRecords(file, from, size),
+		}
+		if err := db.fm.TruncateFile(fileID, from); err != nil {
+			return err
+		}
+		db.recovery.add(lost)
+		return nil
+	}
+
+	switch db.cfg.Recovery {
+	case storage2.RecoverySalvage:
+		db.recovery.add(FileRecovery{
+			FileID:    fileID,
+			Action:    ActionSalvaged,
+			Offset:    from,
+			LostBytes: size - from,
+			LostKeys:  file_manager.EstimateRecords(file, from, size),
+		})
+		return nil
+	case storage2.RecoverySkip:
+		return db.quarantine(fileID, file, from, size)
+	default:
+		return fmt.Errorf("data file %d: %w", fileID, cause)
+	}
+}
+
+// quarantine 复制损坏文件到隔离目录，并把原文件截断到 from
+// 截断后合并、校验与变更流都不会再读到损坏位置之后的记录，重新打开时也不会再次处理
+func (db *Bitcask) quarantine(fileID int, file *os.File, from, size int64) error {
+	if db.cfg.ReadOnly {
+		db.recovery.add(FileRecovery{
+			FileID:    fileID,
+			Action:    ActionIgnored,
+			Offset:    from,
+			LostBytes: size - from,
+			LostKeys:  file_manager.EstimateRecords(file, from, size),
+		})
+		return nil
+	}
+	path, err := db.fm.QuarantineFile(fileID)
+	if err != nil {
+		return fmt.Errorf("quarantine data file %d failed: %w", fileID, err)
+	}
+	lost := FileRecovery{
+		FileID:         fileID,
+		Action:         ActionQuarantined,
+		Offset:         from,
+		LostBytes:      size - from,
+		LostKeys:       file_manager.EstimateRecords(file, from, size),
+		QuarantinePath: path,
+	}
+	if err := db.fm.TruncateFile(fileID, from); err != nil {
+		return err
+	}
+	db.recovery.add(lost)
+	return nil
+}
+
+// scanValidRecords 扫描文件中所有能解码的记录，跳过损坏区域
+// 用于合并等场景，损坏已在加载时按策略处理过：Skip 策略已截断文件，剩下的损坏区域只会是 Salvage 跳过的部分
+func scanValidRecords(file *os.File, fn func(r *storage2.Record, offset int64, size uint32) error) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := file_manager.DataStart(file)
+	for {
+		_, err := file_manager.ScanRecordsFrom(file, offset, fn)
+		var ce *file_manager.CorruptRecordError
+		if !errors.As(err, &ce) {
+			return err
+		}
+		next, found := file_manager.FindNextRecord(file, ce.Offset+1, stat.Size())
+		if !found {
+			return nil
+		}
+		offset = next
+	}
+}
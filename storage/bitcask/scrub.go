@@ -0,0 +1,317 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ScrubRepairFunc 从副本取回损坏记录对应键的当前值，返回结果中不存在的键保持原样；
+// 返回的值不能旧于损坏的记录，否则会用旧值覆盖本地已应用的写入
+type ScrubRepairFunc func(keys []string) (map[string][]byte, error)
+
+// CorruptRange 校验发现的一段损坏区间，Keys 为索引仍指向该区间的键
+type CorruptRange struct {
+	FileID   int
+	Offset   int64
+	Length   int64
+	Keys     []string
+	Repaired int // 已从副本恢复的键数
+}
+
+// ScrubStats 后台校验的累计统计
+type ScrubStats struct {
+	Passes        int64          // 完成的轮数
+	FilesScanned  int64          // 校验过的文件数
+	BytesScanned  int64          // 校验过的字节数
+	CorruptRanges int64          // 发现的损坏区间数
+	CorruptBytes  int64          // 损坏区间的总字节数
+	AffectedKeys  int64          // 索引指向损坏区间的键数
+	RepairedKeys  int64          // 从副本恢复的键数
+	LastPass      time.Time      // 最近一轮完成的时间
+	LastCorrupt   []CorruptRange // 最近一轮发现的损坏区间
+}
+
+// errScrubStopped 数据库关闭，校验中止
+var errScrubStopped = errors.New("scrub stopped")
+
+// scrubber 后台校验的状态，mu 在一轮校验期间持有，关闭时等待其结束
+type scrubber struct {
+	mu     sync.Mutex
+	stop   chan struct{}
+	ticker *time.Ticker
+	repair atomic.Pointer[ScrubRepairFunc]
+
+	statsMu sync.Mutex
+	stats   ScrubStats
+}
+
+// SetScrubRepair 设置从副本恢复损坏键的方式，为 nil 时只报告不恢复
+func (db *Bitcask) SetScrubRepair(fn ScrubRepairFunc) {
+	if fn == nil {
+		db.scrub.repair.Store(nil)
+		return
+	}
+	db.scrub.repair.Store(&fn)
+}
+
+// ScrubStats 返回后台校验的统计
+func (db *Bitcask) ScrubStats() ScrubStats {
+	db.scrub.statsMu.Lock()
+	defer db.scrub.statsMu.Unlock()
+	stats := db.scrub.stats
+	stats.LastCorrupt = append([]CorruptRange(nil), stats.LastCorrupt...)
+	return stats
+}
+
+// Scrub 校验一轮所有已封存的数据文件：逐条检查记录的校验和，损坏区间记入统计与日志，
+// 设置了 ScrubRepairFunc 时从副本取回受影响键的值并重新写入。读取按 ScrubRate 限速，
+// 使用独立的文件句柄，不占用读取缓存与内存映射
+func (db *Bitcask) Scrub() error {
+	if db.closed {
+		return err_def.ErrDBClosed
+	}
+	db.scrub.mu.Lock()
+	defer db.scrub.mu.Unlock()
+
+	limiter := &scrubLimiter{rate: db.cfg.ScrubRate, start: time.Now(), stop: db.scrub.stop}
+	var corrupt []CorruptRange
+	for _, fileID := range db.fm.LiveFileIDs() {
+		if db.fm.IsActive(fileID) {
+			continue
+		}
+		ranges, scanned, err := db.scrubFile(fileID, limiter)
+		if errors.Is(err, errScrubStopped) {
+			return err_def.ErrDBClosed
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(ranges) > 0 {
+			db.collectAffectedKeys(fileID, ranges)
+			for i := range ranges {
+				r := &ranges[i]
+				log.Printf("bitcask scrub: data file %d corrupt at [%d, %d), %d keys affected", r.FileID, r.Offset, r.Offset+r.Length, len(r.Keys))
+				db.repairRange(r)
+			}
+			corrupt = append(corrupt, ranges...)
+		}
+
+		db.scrub.statsMu.Lock()
+		db.scrub.stats.FilesScanned++
+		db.scrub.stats.BytesScanned += scanned
+		for _, r := range ranges {
+			db.scrub.stats.CorruptRanges++
+			db.scrub.stats.CorruptBytes += r.Length
+			db.scrub.stats.AffectedKeys += int64(len(r.Keys))
+			db.scrub.stats.RepairedKeys += int64(r.Repaired)
+		}
+		db.scrub.statsMu.Unlock()
+	}
+
+	db.scrub.statsMu.Lock()
+	db.scrub.stats.Passes++
+	db.scrub.stats.LastPass = time.Now()
+	db.scrub.stats.LastCorrupt = corrupt
+	db.scrub.statsMu.Unlock()
+	return nil
+}
+
+// scrubFile 校验单个数据文件，返回损坏区间与读取的字节数；文件已被合并删除时跳过
+func (db *Bitcask) scrubFile(fileID int, limiter *scrubLimiter) ([]CorruptRange, int64, error) {
+	file, err := os.Open(file_manager.DataFilePath(db.cfg.DataDir, fileID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("open data file %d failed: %w", fileID, err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("stat data file %d failed: %w", fileID, err)
+	}
+	size := stat.Size()
+
+	var ranges []CorruptRange
+	header, err := file_manager.ReadFileHeader(file)
+	offset := header.DataStart()
+	switch {
+	case errors.Is(err, err_def.ErrFileHeaderCorrupt):
+		// 文件头之后的记录仍可校验
+		ranges = append(ranges, CorruptRange{FileID: fileID, Length: file_manager.FileHeaderSize})
+		offset = file_manager.FileHeaderSize
+	case err != nil:
+		log.Printf("bitcask scrub: skip data file %d: %v", fileID, err)
+		return nil, 0, nil
+	}
+
+	for offset < size {
+		end, err := file_manager.ScanRecordsFrom(file, offset, func(_ *storage2.Record, _ int64, size uint32) error {
+			return limiter.wait(int64(size))
+		})
+		var ce *file_manager.CorruptRecordError
+		if err != nil && !errors.As(err, &ce) {
+			return nil, 0, err
+		}
+		if ce == nil {
+			if end < size {
+				// 已封存的文件不应有不完整的尾部
+				ranges = append(ranges, CorruptRange{FileID: fileID, Offset: end, Length: size - end})
+			}
+			break
+		}
+
+		next, found := file_manager.FindNextRecord(file, ce.Offset+1, size)
+		if !found {
+			next = size
+		}
+		ranges = append(ranges, CorruptRange{FileID: fileID, Offset: ce.Offset, Length: next - ce.Offset})
+		if err := limiter.wait(next - ce.Offset); err != nil {
+			return nil, 0, err
+		}
+		offset = next
+	}
+	return ranges, size, nil
+}
+
+// collectAffectedKeys 找出索引指向各损坏区间的键
+func (db *Bitcask) collectAffectedKeys(fileID int, ranges []CorruptRange) {
+	_ = db.memIndex.Foreach(func(key string, entry storage2.Entry) bool {
+		if entry.FileID != fileID {
+			return true
+		}
+		for i := range ranges {
+			if entry.Offset >= ranges[i].Offset && entry.Offset < ranges[i].Offset+ranges[i].Length {
+				ranges[i].Keys = append(ranges[i].Keys, key)
+				break
+			}
+		}
+		return true
+	})
+}
+
+// repairRange 从副本取回损坏区间中键的值并重新写入；
+// 只重写索引仍指向损坏区间的键，取值期间已被覆盖或删除的键保留新的状态
+func (db *Bitcask) repairRange(r *CorruptRange) {
+	repair := db.scrub.repair.Load()
+	if repair == nil || len(r.Keys) == 0 || db.cfg.ReadOnly {
+		return
+	}
+
+	values, err := (*repair)(r.Keys)
+	if err != nil {
+		log.Printf("bitcask scrub: repair data file %d at offset %d failed: %v", r.FileID, r.Offset, err)
+		return
+	}
+	for key, value := range values {
+		ok, err := db.repairKey(key, value, r)
+		if err != nil {
+			log.Printf("bitcask scrub: repair key %q failed: %v", key, err)
+			continue
+		}
+		if ok {
+			r.Repaired++
+		}
+	}
+}
+
+// repairKey 键仍指向损坏区间时写入从副本取回的值
+func (db *Bitcask) repairKey(key string, value []byte, r *CorruptRange) (bool, error) {
+	if db.closed {
+		return false, err_def.ErrDBClosed
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	unlock := db.lockKey(key)
+	defer unlock()
+
+	entry, err := db.memIndex.Get(key)
+	if err != nil || entry.FileID != r.FileID || entry.Offset < r.Offset || entry.Offset >= r.Offset+r.Length {
+		return false, nil
+	}
+	if err := db.putLocked(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StartScrub 每隔 interval 校验一轮，已启动时按新的间隔重新开始
+func (db *Bitcask) StartScrub(interval time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.scrub.ticker != nil {
+		db.scrub.ticker.Reset(interval)
+		return
+	}
+	db.scrub.ticker = time.NewTicker(interval)
+	go db.autoScrub(db.scrub.ticker)
+}
+
+// StopScrub 停止后台校验，进行中的一轮会继续完成
+func (db *Bitcask) StopScrub() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.scrub.ticker != nil {
+		db.scrub.ticker.Stop()
+		db.scrub.ticker = nil
+	}
+}
+
+// autoScrub 按 ticker 定期校验，ticker 被停止后不再触发，数据库关闭时退出
+func (db *Bitcask) autoScrub(ticker *time.Ticker) {
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.Scrub(); err != nil && !errors.Is(err, err_def.ErrDBClosed) {
+				log.Printf("bitcask scrub failed: %v", err)
+			}
+		case <-db.scrub.stop:
+			return
+		}
+	}
+}
+
+// scrubLimiter 按读取的字节数限速，rate 为 0 时不限速
+type scrubLimiter struct {
+	rate  int64
+	start time.Time
+	bytes int64
+	stop  <-chan struct{}
+}
+
+// wait 计入 n 字节，读取超前于速率时等待；数据库关闭时返回 errScrubStopped
+func (l *scrubLimiter) wait(n int64) error {
+	select {
+	case <-l.stop:
+		return errScrubStopped
+	default:
+	}
+	if l.rate <= 0 {
+		return nil
+	}
+	l.bytes += n
+	delay := time.Duration(float64(l.bytes)/float64(l.rate)*float64(time.Second)) - time.Since(l.start)
+	// 攒到一定延迟再等待，避免每条记录都进入计时器
+	if delay < 10*time.Millisecond {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-l.stop:
+		return errScrubStopped
+	}
+}
@@ -0,0 +1,276 @@
+package bitcask
+
+import (
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot 固定在创建时刻的只读视图
+// 创建后第一次被修改的键会把修改前的索引项保存到快照中，快照读取时优先使用保存的旧索引项，
+// 未被修改的键直接读当前索引；快照存在期间合并不会删除旧文件，旧索引项指向的数据始终可读
+type Snapshot struct {
+	db  *Bitcask
+	seq uint64 // 创建时已更新到索引的最大序列号
+
+	mu        sync.Mutex
+	overrides map[string]snapshotEntry // 创建后被修改的键在创建时的状态
+
+	released atomic.Bool
+}
+
+// snapshotEntry 键在快照创建时的索引项，exists 为 false 表示当时不存在
+type snapshotEntry struct {
+	entry  storage2.Entry
+	exists bool
+}
+
+// NewSnapshot 创建快照，用完后必须调用 Release
+func (db *Bitcask) NewSnapshot() (*Snapshot, error) {
+	if db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+
+	// 只排除正在更新索引的写入，仍在落盘的写入在快照登记后才更新索引，其旧索引项会保存到快照中
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+	s := &Snapshot{
+		db:        db,
+		seq:       db.commitSeq.Load(),
+		overrides: make(map[string]snapshotEntry),
+	}
+	db.snapMu.Lock()
+	db.snapshots[s] = struct{}{}
+	db.snapCount.Store(int32(len(db.snapshots)))
+	db.snapMu.Unlock()
+	return s, nil
+}
+
+// commitLock 获取 commitMu 读锁，返回解锁函数
+func (db *Bitcask) commitLock() func() {
+	db.commitMu.RLock()
+	return db.commitMu.RUnlock
+}
+
+// advanceCommitSeq 记录已更新到索引的最大序列号，调用方需持有 commitMu 读锁
+func (db *Bitcask) advanceCommitSeq(seq uint64) {
+	for {
+		cur := db.commitSeq.Load()
+		if seq <= cur || db.commitSeq.CompareAndSwap(cur, seq) {
+			return
+		}
+	}
+}
+
+// Sequence 返回快照创建时已更新到索引的最大序列号；
+// 多个写入并发时，创建时仍在落盘的较小序列号的写入不包含在快照中
+func (s *Snapshot) Sequence() uint64 {
+	return s.seq
+}
+
+// Get 读取快照中的键值
+func (s *Snapshot) Get(key string) ([]byte, error) {
+	if s.released.Load() {
+		return nil, err_def.ErrSnapshotReleased
+	}
+	if len(key) == 0 {
+		return nil, err_def.ErrEmptyKey
+	}
+
+	if s.db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+	entry, err := s.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.read(entry)
+}
+
+// lookup 返回键在快照中的索引项
+func (s *Snapshot) lookup(key string) (storage2.Entry, error) {
+	db := s.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 写入先保存旧索引项再更新索引，因此先读索引再查保存的旧索引项
+	entry, err := db.memIndex.Get(key)
+	exists := err == nil
+	if old, ok := s.override(key); ok {
+		entry, exists = old.entry, old.exists
+	}
+	if !exists {
+		return storage2.Entry{}, err_def.ErrKeyNotFound
+	}
+	return entry, nil
+}
+
+// Iterate 按键升序遍历快照中带有 prefix 前缀的键值，fn 返回 false 时停止
+func (s *Snapshot) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	if s.released.Load() {
+		return err_def.ErrSnapshotReleased
+	}
+
+	if s.db.closed {
+		return err_def.ErrDBClosed
+	}
+	entries, err := s.collect(prefix)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// 旧文件在快照释放前不会被删除，读取值时无需持有锁
+	for _, key := range keys {
+		value, err := s.read(entries[key])
+		if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Keys 按升序返回快照中带有 prefix 前缀的键，不读取值
+func (s *Snapshot) Keys(prefix string) ([]string, error) {
+	if s.released.Load() {
+		return nil, err_def.ErrSnapshotReleased
+	}
+	if s.db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+
+	entries, err := s.collect(prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// collect 收集快照中带有 prefix 前缀的键及其索引项，只在收集期间持有读锁
+func (s *Snapshot) collect(prefix string) (map[string]storage2.Entry, error) {
+	db := s.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entries := make(map[string]storage2.Entry)
+	var err error
+	if db.memIndex.Ordered() {
+		// 有序索引从 prefix 开始遍历，遇到第一个不带前缀的键即停止
+		err = db.memIndex.AscendRange(prefix, func(key string) bool {
+			return strings.HasPrefix(key, prefix)
+		}, 0, func(key string, entry storage2.Entry) bool {
+			entries[key] = entry
+			return true
+		})
+	} else {
+		err = db.memIndex.Foreach(func(key string, entry storage2.Entry) bool {
+			if strings.HasPrefix(key, prefix) {
+				entries[key] = entry
+			}
+			return true
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 索引先于保存的旧索引项读取，遍历期间的写入都会被旧索引项覆盖
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, old := range s.overrides {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if old.exists {
+			entries[key] = old.entry
+		} else {
+			delete(entries, key)
+		}
+	}
+	return entries, nil
+}
+
+// Release 释放快照，之后被推迟的旧文件删除得以进行
+func (s *Snapshot) Release() {
+	if !s.released.CompareAndSwap(false, true) {
+		return
+	}
+
+	db := s.db
+	db.snapMu.Lock()
+	delete(db.snapshots, s)
+	db.snapCount.Store(int32(len(db.snapshots)))
+	db.snapMu.Unlock()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if !db.closed {
+		db.removeObsolete()
+	}
+}
+
+func (s *Snapshot) override(key string) (snapshotEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.overrides[key]
+	return old, ok
+}
+
+func (s *Snapshot) read(entry storage2.Entry) ([]byte, error) {
+	record, err := s.db.readRecord(entry)
+	if err != nil {
+		return nil, err
+	}
+	return record.Value, nil
+}
+
+// preserveForSnapshots 在修改键的索引前，把修改前的状态保存到尚未保存过该键的快照中，调用方需持有键锁
+func (db *Bitcask) preserveForSnapshots(key string) {
+	if db.snapCount.Load() == 0 {
+		return
+	}
+
+	entry, err := db.memIndex.Get(key)
+	old := snapshotEntry{entry: entry, exists: err == nil}
+
+	db.snapMu.Lock()
+	defer db.snapMu.Unlock()
+	for s := range db.snapshots {
+		s.mu.Lock()
+		if _, ok := s.overrides[key]; !ok {
+			s.overrides[key] = old
+		}
+		s.mu.Unlock()
+	}
+}
+
+// removeObsolete 没有快照时删除合并留下的旧文件，有快照时推迟到最后一个快照释放
+func (db *Bitcask) removeObsolete() {
+	db.snapMu.Lock()
+	defer db.snapMu.Unlock()
+	if len(db.snapshots) == 0 {
+		db.fm.RemoveObsolete()
+	}
+}
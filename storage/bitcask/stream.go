@@ -0,0 +1,180 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"io"
+	"time"
+)
+
+// 流式读写超过 MaxValueSize 的值:
+//
+//	blob 文件: chunk(key, value[0:streamChunkSize]) ... chunk(key, value[n*streamChunkSize:])
+//	数据文件: record(FlagChunked, value = size(8) | chunk 指针...)
+//
+// 各分块先写入 blob 文件，最后写入的清单记录使整个值可见，写入中途失败时已写入的分块不被引用，由 blob GC 回收。
+// 合并只搬移清单，删除或覆盖时所有分块一起失效
+
+// streamChunkSize 流式写入时每个分块的长度
+const streamChunkSize = 4 << 20
+
+// PutReader 从 r 读取直到 EOF，分块写入键的值，值的长度不受 MaxValueSize 限制
+// 读取与写入分块期间不持有键锁，写入清单记录时才替换旧值
+func (db *Bitcask) PutReader(key string, r io.Reader) error {
+	if db.closed {
+		return err_def.ErrDBClosed
+	}
+	if len(key) == 0 {
+		return err_def.ErrEmptyKey
+	}
+
+	defer db.pinBlobs()()
+
+	now := time.Now().UnixNano()
+	var manifest file_manager.ChunkManifest
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			ptr, werr := db.fm.WriteBlob(&storage2.Record{
+				Timestamp: now,
+				Flags:     FlagNormal,
+				KVItem: storage2.KVItem{
+					Key:   []byte(key),
+					Value: buf[:n],
+				},
+			})
+			if werr != nil {
+				return fmt.Errorf("write chunk failed: %w", werr)
+			}
+			manifest.Chunks = append(manifest.Chunks, ptr)
+			manifest.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read value failed: %w", err)
+		}
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	unlock := db.lockKey(key)
+	defer unlock()
+
+	record := &storage2.Record{
+		Timestamp: now,
+		Flags:     FlagNormal | storage2.FlagChunked,
+		KVItem: storage2.KVItem{
+			Key:   []byte(key),
+			Value: manifest.Encode(),
+		},
+	}
+	resp := <-db.fm.WriteAsync(record)
+	if resp.Err != nil {
+		return fmt.Errorf("write record failed: %w", resp.Err)
+	}
+	defer db.commitLock()()
+	return db.commitPut(key, nil, record, resp.Entry)
+}
+
+// GetReader 返回按分块读取键值的 io.ReadCloser，读取的是调用时的值，之后的写入不影响读取
+// 未分块写入的值同样可以读取；使用完后必须调用 Close
+func (db *Bitcask) GetReader(key string) (io.ReadCloser, error) {
+	if len(key) == 0 {
+		return nil, err_def.ErrEmptyKey
+	}
+
+	// 读取期间用快照固定旧文件，GC 与合并不会删除仍在读取的分块
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := snap.lookup(key)
+	if err != nil {
+		snap.Release()
+		return nil, err
+	}
+	record, err := db.fm.Read(entry)
+	if err != nil {
+		snap.Release()
+		return nil, fmt.Errorf("read record failed: %w", err)
+	}
+	if record.Flags&storage2.FlagChunked == 0 {
+		snap.Release()
+		return io.NopCloser(bytes.NewReader(record.Value)), nil
+	}
+	manifest, err := file_manager.DecodeChunkManifest(record.Value)
+	if err != nil {
+		snap.Release()
+		return nil, err
+	}
+	return &chunkReader{db: db, snap: snap, key: []byte(key), chunks: manifest.Chunks}, nil
+}
+
+// chunkReader 依次读取分块写入的值的各分块
+type chunkReader struct {
+	db     *Bitcask
+	snap   *Snapshot
+	key    []byte
+	chunks []file_manager.BlobPointer
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.snap.released.Load() {
+		return 0, err_def.ErrSnapshotReleased
+	}
+	for len(r.buf) == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		chunk, err := r.db.fm.ReadBlob(r.chunks[0], r.key)
+		if err != nil {
+			return 0, fmt.Errorf("read chunk failed: %w", err)
+		}
+		r.buf, r.chunks = chunk, r.chunks[1:]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.snap.Release()
+	return nil
+}
+
+// readRecord 读取索引项指向的记录，分块写入的值拼接为完整的值
+// 超过 MaxValueSize 的值返回 ErrValueTooLarge，只能通过 GetReader 读取
+func (db *Bitcask) readRecord(entry storage2.Entry) (*storage2.Record, error) {
+	record, err := db.fm.Read(entry)
+	if err != nil {
+		return nil, fmt.Errorf("read record failed: %w", err)
+	}
+	if record.Flags&storage2.FlagChunked == 0 {
+		return record, nil
+	}
+
+	manifest, err := file_manager.DecodeChunkManifest(record.Value)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Size > int64(storage2.MaxValueSize) {
+		return nil, fmt.Errorf("%w: value of %d bytes must be read with GetReader", err_def.ErrValueTooLarge, manifest.Size)
+	}
+	value := make([]byte, 0, manifest.Size)
+	for _, ptr := range manifest.Chunks {
+		chunk, err := db.fm.ReadBlob(ptr, record.Key)
+		if err != nil {
+			return nil, fmt.Errorf("read chunk failed: %w", err)
+		}
+		value = append(value, chunk...)
+	}
+	record.Value = value
+	return record, nil
+}
@@ -0,0 +1,546 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/FinnTew/FincasKV/storage/file_manager"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// 变更流直接读取数据文件：先按序列号补齐游标之后的历史记录，再从活动文件的当前位置跟随新写入。
+// 订阅者读取多快，变更流就从磁盘读取多快，消费跟不上时不会在内存中堆积事件，也不会阻塞写入
+
+// ChangeOp 变更类型
+type ChangeOp uint8
+
+const (
+	ChangePut ChangeOp = iota + 1
+	ChangeDelete
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "del"
+	default:
+		return "unknown"
+	}
+}
+
+// Position 变更流的游标：从 FileID 文件的 Offset 处继续读取，跳过序列号不超过 Seq 的记录
+// FileID 为负数或文件已被合并删除时，按序列号从所有数据文件中查找 Seq 之后的记录
+type Position struct {
+	FileID int
+	Offset int64
+	Seq    uint64
+	Latest bool // 从订阅时各数据文件的末尾开始，不回放历史记录，忽略其他字段
+}
+
+// FromSequence 返回从序列号 seq 之后开始订阅的游标，seq 为 0 时从最早的记录开始
+func FromSequence(seq uint64) Position {
+	return Position{FileID: -1, Seq: seq}
+}
+
+// FromLatest 返回只跟随订阅之后新写入的游标
+func FromLatest() Position {
+	return Position{Latest: true}
+}
+
+// ChangeEvent 一条已提交的变更，批量写入只在提交后整体出现
+type ChangeEvent struct {
+	Op        ChangeOp
+	Key       string
+	Value     []byte
+	Timestamp int64
+	Seq       uint64
+	Position  Position // 从该事件之后继续订阅的游标
+	Streamed  bool     // 值通过 PutReader 分块写入，不随事件发送，Value 为空
+}
+
+// subscribeBuffer 变更流与订阅者之间的缓冲事件数
+const subscribeBuffer = 64
+
+var (
+	errSubscriptionClosed = errors.New("subscription closed")
+	errFileGone           = errors.New("data file removed by merge")
+)
+
+// Subscription 一个变更流订阅
+type Subscription struct {
+	db      *Bitcask
+	from    Position
+	offsets map[int]int64 // 多个写入通道时各文件开始收集的位置
+	events  chan ChangeEvent
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// Subscribe 订阅 from 之后已提交的变更，先回放历史记录，再跟随新的写入
+// 合并会丢弃被覆盖和删除的旧记录，从较早的游标回放时只能看到合并后仍保留的记录；
+// 没有序列号的旧格式记录只会在从头订阅或按文件位置订阅时出现
+func (db *Bitcask) Subscribe(from Position) (*Subscription, error) {
+	if db.closed {
+		return nil, err_def.ErrDBClosed
+	}
+
+	s := &Subscription{
+		db:     db,
+		from:   from,
+		events: make(chan ChangeEvent, subscribeBuffer),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if from.Latest {
+		// 在返回前确定各文件的末尾，之后的写入都不会被跳过
+		if err := s.seekLatest(); err != nil {
+			return nil, err
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+// seekLatest 把游标定位到各数据文件当前的末尾，不再按序列号回放历史记录
+func (s *Subscription) seekLatest() error {
+	fm := s.db.fm
+	seq := fm.LastSequence()
+	ends, err := fm.EndOffsets()
+	if err != nil {
+		return err
+	}
+	s.from, s.offsets = FromSequence(seq), ends
+	if active := fm.GetActiveFile(); active != nil {
+		if offset, ok := ends[active.ID]; ok {
+			s.from.FileID, s.from.Offset = active.ID, offset
+		}
+	}
+	return nil
+}
+
+// Events 返回变更事件，订阅结束时关闭
+func (s *Subscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Err 返回订阅结束的原因，需在 Events 关闭后调用，主动 Close 时为 nil
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close 结束订阅并等待后台读取退出
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *Subscription) run() {
+	defer close(s.events)
+	defer close(s.done)
+
+	err := s.follow(s.from)
+	if errors.Is(err, errSubscriptionClosed) {
+		err = nil
+	}
+	s.err = err
+}
+
+func (s *Subscription) follow(pos Position) error {
+	if s.db.fm.Lanes() > 1 {
+		return s.followLanes(pos.Seq, s.offsets)
+	}
+	for {
+		if s.dbClosed() {
+			return err_def.ErrDBClosed
+		}
+		if pos.FileID < 0 || !slices.Contains(s.db.fm.LiveFileIDs(), pos.FileID) {
+			next, err := s.catchUp(pos.Seq)
+			if errors.Is(err, errFileGone) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			pos = next
+		}
+
+		next, err := s.tail(pos)
+		if errors.Is(err, errFileGone) {
+			// 正在读取的文件被合并删除，按已发送的序列号重新定位
+			pos = FromSequence(next.Seq)
+			continue
+		}
+		return err
+	}
+}
+
+// catchUp 按序列号顺序发送所有数据文件中 seq 之后的记录，返回之后跟随新写入的位置
+func (s *Subscription) catchUp(seq uint64) (Position, error) {
+	fm := s.db.fm
+	// 先确定上界再列出文件，之后创建的文件只包含更新的写入或合并出的副本
+	limit := fm.LastSequence()
+	files := fm.LiveFileIDs()
+
+	var refs []storage2.Entry
+	end := Position{FileID: -1, Seq: max(seq, limit)}
+	for _, id := range files {
+		found, last, err := s.collect(id, 0, seq, limit)
+		if err != nil {
+			return Position{}, err
+		}
+		refs = append(refs, found...)
+		end.FileID, end.Offset = id, last
+	}
+
+	if _, err := s.emitRefs(refs); err != nil {
+		return Position{}, err
+	}
+	return end, nil
+}
+
+// collect 从 offset 开始扫描文件，返回序列号在 seq 之后、不超过 limit 的已提交记录，以及下次继续扫描的位置
+func (s *Subscription) collect(id int, offset int64, seq, limit uint64) ([]storage2.Entry, int64, error) {
+	fm := s.db.fm
+	var refs, pending []storage2.Entry
+	var pendingStart int64
+	last, err := s.scan(id, offset, func(r *storage2.Record, offset int64, size uint32) error {
+		if r.Seq > limit {
+			return errPause
+		}
+		entry := storage2.Entry{FileID: id, Offset: offset, Size: size, Timestamp: r.Timestamp, Seq: r.Seq}
+		switch {
+		case r.Flags&storage2.FlagBatch != 0:
+			if len(pending) == 0 {
+				pendingStart = offset
+			}
+			pending = append(pending, entry)
+		case storage2.RecordType(r.Flags) == FlagBatchCommit:
+			if err := fm.UnpackRecord(r, id, offset); err != nil {
+				return err
+			}
+			if batchCommitted(r, len(pending)) {
+				for _, e := range pending {
+					if e.Seq > seq || seq == 0 {
+						refs = append(refs, e)
+					}
+				}
+			}
+			pending = pending[:0]
+		default:
+			pending = pending[:0]
+			if r.Seq > seq || seq == 0 {
+				refs = append(refs, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, offset, err
+	}
+	if len(pending) > 0 {
+		last = pendingStart
+	}
+	return refs, last, nil
+}
+
+// emitRefs 按序列号顺序发送记录，返回最后发送的序列号
+func (s *Subscription) emitRefs(refs []storage2.Entry) (uint64, error) {
+	fm := s.db.fm
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].Seq < refs[j].Seq
+	})
+	var sent uint64
+	for i := 0; i < len(refs); i++ {
+		entry := refs[i]
+		// blob GC 搬移时以原序列号写入新指针，同一序列号只发送一条；优先读取最后收集到的一条，
+		// 多个写入通道时它可能是搬移前的原记录，blob 已回收时改读同一序列号的其他记录
+		last := i
+		for entry.Seq != 0 && last+1 < len(refs) && refs[last+1].Seq == entry.Seq {
+			last++
+		}
+		var record *storage2.Record
+		var err error
+		for j := last; j >= i; j-- {
+			entry = refs[j]
+			if record, err = fm.Read(entry); !errors.Is(err, file_manager.ErrBlobReclaimed) {
+				break
+			}
+		}
+		i = last
+		if errors.Is(err, file_manager.ErrBlobReclaimed) {
+			// 值已被覆盖且 blob 已回收，与合并丢弃的旧记录一样跳过
+			continue
+		}
+		if err != nil {
+			if err := fileError(err); errors.Is(err, errFileGone) {
+				return sent, err
+			}
+			return sent, fmt.Errorf("read change record failed: %w", err)
+		}
+		if err := s.emit(record, FromSequence(entry.Seq)); err != nil {
+			return sent, err
+		}
+		sent = max(sent, entry.Seq)
+	}
+	return sent, nil
+}
+
+// followLanes 多个写入通道时各通道的文件同时增长，无法按单个文件的顺序跟随；
+// 每次写入通知后从各文件上次读到的位置收集已确认写入的记录，合并后按序列号顺序发送
+// offsets 为各文件开始收集的位置，为 nil 时从头收集
+func (s *Subscription) followLanes(seq uint64, offsets map[int]int64) error {
+	fm := s.db.fm
+	if offsets == nil {
+		offsets = make(map[int]int64)
+	}
+	for {
+		notify := fm.WriteNotify()
+		if s.dbClosed() {
+			return err_def.ErrDBClosed
+		}
+		// 不超过 limit 的记录都已写入文件，之后收集的记录序列号都更大
+		limit := fm.LastSequence()
+
+		var refs []storage2.Entry
+		next := make(map[int]int64)
+		for _, id := range fm.LiveFileIDs() {
+			found, last, err := s.collect(id, offsets[id], seq, limit)
+			if errors.Is(err, errFileGone) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			refs = append(refs, found...)
+			next[id] = last
+		}
+		sent, err := s.emitRefs(refs)
+		seq = max(seq, sent)
+		if errors.Is(err, errFileGone) {
+			// 记录所在文件被合并删除，已扫描过的位置不再可信，从头按序列号重新收集
+			offsets = make(map[int]int64)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		offsets, seq = next, max(seq, limit)
+
+		select {
+		case <-notify:
+		case <-s.stop:
+			return errSubscriptionClosed
+		case <-fm.Done():
+			return err_def.ErrDBClosed
+		}
+	}
+}
+
+// tail 从 pos 开始按文件顺序跟随写入，文件被合并删除时返回 errFileGone 及已发送到的位置
+func (s *Subscription) tail(pos Position) (Position, error) {
+	fm := s.db.fm
+	for {
+		// 先取通知再读取，读取期间的写入也会唤醒等待
+		notify := fm.WriteNotify()
+		active := fm.GetActiveFile()
+		if active == nil || s.dbClosed() {
+			return pos, err_def.ErrDBClosed
+		}
+		limit := fm.LastSequence()
+
+		var pending []ChangeEvent
+		var pendingStart int64
+		next, err := s.scan(pos.FileID, pos.Offset, func(r *storage2.Record, offset int64, size uint32) error {
+			if r.Seq > limit {
+				return errPause
+			}
+			if err := fm.UnpackRecord(r, pos.FileID, offset); errors.Is(err, file_manager.ErrBlobReclaimed) {
+				// 已被覆盖且 blob 已回收的旧值，或 blob GC 搬移前的指针，跳过
+				if r.Flags&storage2.FlagBatch != 0 {
+					// 占位保持批次记录数，提交时不发送
+					if len(pending) == 0 {
+						pendingStart = offset
+					}
+					pending = append(pending, ChangeEvent{Seq: r.Seq})
+					return nil
+				}
+				pending = pending[:0]
+				pos.Offset = offset + int64(size)
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			switch {
+			case r.Flags&storage2.FlagBatch != 0:
+				if len(pending) == 0 {
+					pendingStart = offset
+				}
+				pending = append(pending, newChangeEvent(r, Position{}))
+				return nil
+			case storage2.RecordType(r.Flags) == FlagBatchCommit:
+				if batchCommitted(r, len(pending)) {
+					// 游标指向批次开头，从中途恢复时重读整个批次并按序列号跳过已发送的记录
+					for _, ev := range pending {
+						if ev.Op == 0 || ev.Seq != 0 && ev.Seq <= pos.Seq {
+							continue
+						}
+						ev.Position = Position{FileID: pos.FileID, Offset: pendingStart, Seq: ev.Seq}
+						if err := s.send(ev); err != nil {
+							return err
+						}
+						pos.Seq = ev.Seq
+					}
+				}
+				pending = pending[:0]
+				pos.Offset = offset + int64(size)
+				return nil
+			default:
+				pending = pending[:0]
+				if r.Seq != 0 && r.Seq <= pos.Seq {
+					pos.Offset = offset + int64(size)
+					return nil
+				}
+				next := Position{FileID: pos.FileID, Offset: offset + int64(size), Seq: max(pos.Seq, r.Seq)}
+				if err := s.emit(r, next); err != nil {
+					return err
+				}
+				pos = next
+				return nil
+			}
+		})
+		if err != nil {
+			return pos, err
+		}
+		// 活动文件中未提交的批次留到下次从批次开头重读；
+		// 文件在读取前已被封存时不会再有写入，末尾残缺的批次直接丢弃，转到下一个文件
+		sealed := pos.FileID != active.ID
+		if len(pending) == 0 || sealed {
+			pos.Offset = next
+		}
+		if sealed {
+			files := fm.LiveFileIDs()
+			i := slices.Index(files, pos.FileID)
+			if i < 0 {
+				return pos, errFileGone
+			}
+			if i+1 < len(files) {
+				pos.FileID, pos.Offset = files[i+1], 0
+				continue
+			}
+		}
+
+		select {
+		case <-notify:
+		case <-s.stop:
+			return pos, errSubscriptionClosed
+		case <-fm.Done():
+			return pos, err_def.ErrDBClosed
+		}
+	}
+}
+
+// errPause 读到尚未确认写入完成的记录，等待下一次写入通知后重读
+var errPause = errors.New("record not yet committed")
+
+// scan 从 offset 开始扫描数据文件，返回已处理记录的结束位置，offset 落在文件头内时从第一条记录开始
+// 读到未确认的记录时停在该记录之前；活动文件中校验失败的记录是正在写入的记录，同样停在它之前；
+// 已封存的文件不再有写入，其中的损坏区间是 RecoverySalvage 打开时保留下来的，跳到之后的第一条有效记录继续
+func (s *Subscription) scan(fileID int, offset int64, fn func(r *storage2.Record, offset int64, size uint32) error) (int64, error) {
+	// 在读取前判断，之后才被封存的文件按活动文件处理，尾部的记录留到下次重读
+	sealed := !s.db.fm.IsActive(fileID)
+	f, err := s.db.fm.GetFile(fileID)
+	if err != nil {
+		return offset, fileError(err)
+	}
+	offset = max(offset, file_manager.DataStart(f))
+
+	for {
+		end, err := file_manager.ScanRecordsFrom(f, offset, fn)
+		var corrupt *file_manager.CorruptRecordError
+		if errors.Is(err, errPause) || (errors.As(err, &corrupt) && !sealed) {
+			return end, nil
+		}
+		if corrupt == nil {
+			return end, fileError(err)
+		}
+
+		stat, err := f.Stat()
+		if err != nil {
+			return end, fileError(err)
+		}
+		next, found := file_manager.FindNextRecord(f, corrupt.Offset+1, stat.Size())
+		if !found {
+			return stat.Size(), nil
+		}
+		offset = next
+	}
+}
+
+// fileError 将文件已被删除或关闭的错误转换为 errFileGone
+func fileError(err error) error {
+	if errors.Is(err, err_def.ErrFileNotFound) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrClosed) {
+		return errFileGone
+	}
+	return err
+}
+
+// dbClosed 后台读取不持有数据库锁，通过文件管理器的停止信号判断数据库是否已关闭
+func (s *Subscription) dbClosed() bool {
+	select {
+	case <-s.db.fm.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// emit 发送一条已解码的数据记录
+func (s *Subscription) emit(r *storage2.Record, next Position) error {
+	return s.send(newChangeEvent(r, next))
+}
+
+func (s *Subscription) send(ev ChangeEvent) error {
+	select {
+	case s.events <- ev:
+		return nil
+	case <-s.stop:
+		return errSubscriptionClosed
+	case <-s.db.fm.Done():
+		return err_def.ErrDBClosed
+	}
+}
+
+func newChangeEvent(r *storage2.Record, pos Position) ChangeEvent {
+	ev := ChangeEvent{
+		Op:        ChangePut,
+		Key:       string(r.Key),
+		Value:     r.Value,
+		Timestamp: r.Timestamp,
+		Seq:       r.Seq,
+		Position:  pos,
+	}
+	if storage2.RecordType(r.Flags) == FlagDeleted {
+		ev.Op, ev.Value = ChangeDelete, nil
+	} else if r.Flags&storage2.FlagChunked != 0 {
+		ev.Value, ev.Streamed = nil, true
+	}
+	return ev
+}
+
+// batchCommitted 判断提交标记是否与之前连续的 count 条批量记录匹配
+func batchCommitted(r *storage2.Record, count int) bool {
+	return len(r.Value) == 4 && int(binary.BigEndian.Uint32(r.Value)) == count
+}
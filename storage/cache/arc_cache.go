@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"container/list"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"sync"
+)
+
+// ARCCache 自适应替换缓存（Adaptive Replacement Cache），容量按字节计算
+// t1 为只访问过一次的项，t2 为访问过多次的项；b1、b2 只记录最近从 t1、t2 淘汰的键与大小，
+// 命中 b1 说明 t1 偏小，命中 b2 说明 t2 偏小，据此调整 t1 的目标大小 p
+type ARCCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int64
+	p        int64
+	sizeOf   SizeFunc[K, V]
+	t1, t2   arcList[K, V]
+	b1, b2   arcList[K, V]
+	items    map[K]*list.Element // 所有在 t1、t2、b1、b2 中的键
+	stats    storage2.CacheStats
+}
+
+type arcEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+	list  *arcList[K, V]
+}
+
+// arcList 链表头部为最近访问的项，size 为其中项的总字节数
+type arcList[K comparable, V any] struct {
+	list.List
+	size  int64
+	ghost bool
+}
+
+func NewARCCache[K comparable, V any](capacity int64, sizeOf SizeFunc[K, V]) *ARCCache[K, V] {
+	c := &ARCCache[K, V]{
+		capacity: capacity,
+		sizeOf:   sizeOf,
+		items:    make(map[K]*list.Element),
+	}
+	c.b1.ghost, c.b2.ghost = true, true
+	return c
+}
+
+func (c *ARCCache[K, V]) Insert(key K, value V) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := sizeOf(c.sizeOf, key, value)
+	elem, ok := c.items[key]
+	if ok {
+		e := elem.Value.(*arcEntry[K, V])
+		switch e.list {
+		case &c.b1:
+			// t1 偏小，增大其目标
+			c.p = min(c.capacity, c.p+size*max(1, c.b2.size/max(c.b1.size, 1)))
+		case &c.b2:
+			c.p = max(0, c.p-size*max(1, c.b1.size/max(c.b2.size, 1)))
+		}
+		// 已缓存或曾被淘汰过的项再次写入，都视为多次访问
+		c.unlink(elem)
+		delete(c.items, key)
+	}
+	if size > c.capacity {
+		return nil
+	}
+
+	target := &c.t1
+	if ok {
+		target = &c.t2
+	}
+	c.replace(size, target == &c.t2)
+	c.push(target, &arcEntry[K, V]{key: key, value: value, size: size})
+	c.trimGhosts()
+	return nil
+}
+
+func (c *ARCCache[K, V]) Find(key K) (V, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok || elem.Value.(*arcEntry[K, V]).list.ghost {
+		c.stats.Misses++
+		var zero V
+		return zero, errNotFound("ARC", key)
+	}
+	c.stats.Hits++
+	e := elem.Value.(*arcEntry[K, V])
+	c.unlink(elem)
+	c.push(&c.t2, e)
+	return e.value, nil
+}
+
+func (c *ARCCache[K, V]) Delete(key K) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok || elem.Value.(*arcEntry[K, V]).list.ghost {
+		return errNotFound("ARC", key)
+	}
+	c.unlink(elem)
+	delete(c.items, key)
+	return nil
+}
+
+func (c *ARCCache[K, V]) Exist(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	return ok && !elem.Value.(*arcEntry[K, V]).list.ghost
+}
+
+func (c *ARCCache[K, V]) Stats() storage2.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.t1.Len() + c.t2.Len()
+	stats.Bytes = c.t1.size + c.t2.size
+	return stats
+}
+
+// replace 淘汰项直到放得下 size 字节，t1 超过目标 p 时从 t1 淘汰，否则从 t2 淘汰
+func (c *ARCCache[K, V]) replace(size int64, toT2 bool) {
+	for c.t1.size+c.t2.size+size > c.capacity {
+		from, ghost := &c.t2, &c.b2
+		if c.t1.Len() > 0 && (c.t1.size > c.p || (toT2 && c.t1.size == c.p) || c.t2.Len() == 0) {
+			from, ghost = &c.t1, &c.b1
+		}
+		elem := from.Back()
+		e := elem.Value.(*arcEntry[K, V])
+		c.unlink(elem)
+		var zero V
+		e.value = zero
+		c.items[e.key] = c.push(ghost, e)
+		c.stats.Evictions++
+	}
+}
+
+// trimGhosts 限制 b1、b2 记录的总量：t1+b1 不超过容量，四个链表合计不超过两倍容量
+func (c *ARCCache[K, V]) trimGhosts() {
+	for c.b1.Len() > 0 && c.t1.size+c.b1.size > c.capacity {
+		c.dropGhost(&c.b1)
+	}
+	for c.b2.Len() > 0 && c.t1.size+c.t2.size+c.b1.size+c.b2.size > 2*c.capacity {
+		c.dropGhost(&c.b2)
+	}
+}
+
+func (c *ARCCache[K, V]) dropGhost(l *arcList[K, V]) {
+	elem := l.Back()
+	c.unlink(elem)
+	delete(c.items, elem.Value.(*arcEntry[K, V]).key)
+}
+
+func (c *ARCCache[K, V]) push(l *arcList[K, V], e *arcEntry[K, V]) *list.Element {
+	e.list = l
+	l.size += e.size
+	elem := l.PushFront(e)
+	c.items[e.key] = elem
+	return elem
+}
+
+func (c *ARCCache[K, V]) unlink(elem *list.Element) {
+	e := elem.Value.(*arcEntry[K, V])
+	e.list.Remove(elem)
+	e.list.size -= e.size
+}
@@ -0,0 +1,19 @@
+package cache
+
+import (
+	"fmt"
+)
+
+// SizeFunc 计算缓存项占用的字节数，为 nil 时每项计为 1，容量即为项数
+type SizeFunc[K comparable, V any] func(key K, value V) int64
+
+func sizeOf[K comparable, V any](f SizeFunc[K, V], key K, value V) int64 {
+	if f == nil {
+		return 1
+	}
+	return max(f(key, value), 1)
+}
+
+func errNotFound[K comparable](kind string, key K) error {
+	return fmt.Errorf("cannot find value [%v] into %s cache", key, kind)
+}
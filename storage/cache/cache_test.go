@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func byteSize(key string, value []byte) int64 {
+	return int64(len(key) + len(value))
+}
+
+func TestSizedCaches(t *testing.T) {
+	caches := []struct {
+		name string
+		new  func(capacity int64) storage2.StatsMemCache[string, []byte]
+	}{
+		{"LFU", func(c int64) storage2.StatsMemCache[string, []byte] { return NewLFUCache[string, []byte](c, byteSize) }},
+		{"ARC", func(c int64) storage2.StatsMemCache[string, []byte] { return NewARCCache[string, []byte](c, byteSize) }},
+		{"W-TinyLFU", func(c int64) storage2.StatsMemCache[string, []byte] {
+			return NewWTinyLFUCache[string, []byte](c, byteSize)
+		}},
+	}
+
+	for _, tc := range caches {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.new(1 << 16)
+
+			require.NoError(t, c.Insert("a", []byte("1")))
+			val, err := c.Find("a")
+			require.NoError(t, err)
+			assert.Equal(t, "1", string(val))
+			assert.True(t, c.Exist("a"))
+			require.NoError(t, c.Insert("a", []byte("22")))
+			val, err = c.Find("a")
+			require.NoError(t, err)
+			assert.Equal(t, "22", string(val))
+			require.NoError(t, c.Delete("a"))
+			assert.Error(t, c.Delete("a"))
+			_, err = c.Find("a")
+			assert.Error(t, err)
+			assert.False(t, c.Exist("a"))
+
+			stats := c.Stats()
+			assert.Equal(t, uint64(2), stats.Hits)
+			assert.Equal(t, uint64(1), stats.Misses)
+			assert.Zero(t, stats.Entries)
+			assert.Zero(t, stats.Bytes)
+
+			// 超过容量的值不缓存
+			require.NoError(t, c.Insert("huge", make([]byte, 1<<17)))
+			assert.False(t, c.Exist("huge"))
+
+			// 大小各异的写入与读取，占用始终不超过容量
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("key-%d", rnd.Intn(500))
+				if rnd.Intn(2) == 0 {
+					require.NoError(t, c.Insert(key, make([]byte, rnd.Intn(4096))))
+				} else {
+					_, _ = c.Find(key)
+				}
+				require.LessOrEqual(t, c.Stats().Bytes, int64(1<<16))
+			}
+			stats = c.Stats()
+			assert.Positive(t, stats.Evictions)
+			assert.Positive(t, stats.Hits)
+			assert.Positive(t, stats.Misses)
+			assert.Positive(t, stats.Entries)
+		})
+	}
+}
+
+// 经常访问的键不会被一次性扫描大量冷数据冲掉
+func TestCacheScanResistance(t *testing.T) {
+	caches := []struct {
+		name string
+		c    storage2.StatsMemCache[string, []byte]
+	}{
+		{"LFU", NewLFUCache[string, []byte](100, nil)},
+		{"ARC", NewARCCache[string, []byte](100, nil)},
+		{"W-TinyLFU", NewWTinyLFUCache[string, []byte](100, nil)},
+	}
+	for _, tc := range caches {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.c
+			for round := 0; round < 5; round++ {
+				for i := 0; i < 20; i++ {
+					key := fmt.Sprintf("hot-%d", i)
+					if _, err := c.Find(key); err != nil {
+						require.NoError(t, c.Insert(key, nil))
+					}
+				}
+			}
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("scan-%d", i)
+				_, _ = c.Find(key)
+				require.NoError(t, c.Insert(key, nil))
+			}
+			hot := 0
+			for i := 0; i < 20; i++ {
+				if c.Exist(fmt.Sprintf("hot-%d", i)) {
+					hot++
+				}
+			}
+			assert.GreaterOrEqual(t, hot, 15)
+			assert.LessOrEqual(t, c.Stats().Entries, 100)
+		})
+	}
+}
+
+func TestLRUCacheStats(t *testing.T) {
+	c := NewLRUCache[string, int](2)
+	require.NoError(t, c.Insert("a", 1))
+	require.NoError(t, c.Insert("b", 2))
+	require.NoError(t, c.Insert("c", 3))
+	_, err := c.Find("a")
+	assert.Error(t, err)
+	_, err = c.Find("c")
+	require.NoError(t, err)
+	require.NoError(t, c.Delete("b"))
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 1, stats.Entries)
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"container/list"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"sync"
+)
+
+// LFUCache 按访问频率淘汰的缓存，频率相同时淘汰最久未访问的项，容量按字节计算
+// 每个频率一条链表，插入、查找、淘汰都是 O(1)
+type LFUCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	sizeOf   SizeFunc[K, V]
+	items    map[K]*list.Element
+	freqs    map[int]*list.List
+	minFreq  int
+	stats    storage2.CacheStats
+}
+
+type lfuEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+	freq  int
+}
+
+func NewLFUCache[K comparable, V any](capacity int64, sizeOf SizeFunc[K, V]) *LFUCache[K, V] {
+	return &LFUCache[K, V]{
+		capacity: capacity,
+		sizeOf:   sizeOf,
+		items:    make(map[K]*list.Element),
+		freqs:    make(map[int]*list.List),
+	}
+}
+
+func (c *LFUCache[K, V]) Insert(key K, value V) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := sizeOf(c.sizeOf, key, value)
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	// 超过总容量的项不缓存
+	if size > c.capacity {
+		return nil
+	}
+	for c.size+size > c.capacity {
+		c.evict()
+	}
+
+	c.items[key] = c.bucket(1).PushFront(&lfuEntry[K, V]{key: key, value: value, size: size, freq: 1})
+	c.minFreq = 1
+	c.size += size
+	return nil
+}
+
+func (c *LFUCache[K, V]) Find(key K) (V, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		var zero V
+		return zero, errNotFound("LFU", key)
+	}
+	c.stats.Hits++
+	c.touch(elem)
+	return elem.Value.(*lfuEntry[K, V]).value, nil
+}
+
+func (c *LFUCache[K, V]) Delete(key K) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return errNotFound("LFU", key)
+	}
+	c.remove(elem)
+	return nil
+}
+
+func (c *LFUCache[K, V]) Exist(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+func (c *LFUCache[K, V]) Stats() storage2.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries, stats.Bytes = len(c.items), c.size
+	return stats
+}
+
+// touch 将项移到下一个频率的链表
+func (c *LFUCache[K, V]) touch(elem *list.Element) {
+	e := elem.Value.(*lfuEntry[K, V])
+	c.unlink(elem)
+	if e.freq == c.minFreq && c.freqs[e.freq] == nil {
+		c.minFreq++
+	}
+	e.freq++
+	c.items[e.key] = c.bucket(e.freq).PushFront(e)
+}
+
+// evict 淘汰最低频率中最久未访问的项
+func (c *LFUCache[K, V]) evict() {
+	l := c.freqs[c.minFreq]
+	for l == nil {
+		// 删除项后 minFreq 可能失效，向上查找
+		c.minFreq++
+		l = c.freqs[c.minFreq]
+	}
+	c.remove(l.Back())
+	c.stats.Evictions++
+}
+
+func (c *LFUCache[K, V]) remove(elem *list.Element) {
+	e := elem.Value.(*lfuEntry[K, V])
+	c.unlink(elem)
+	delete(c.items, e.key)
+	c.size -= e.size
+}
+
+// unlink 从频率链表中摘除，链表为空时删除
+func (c *LFUCache[K, V]) unlink(elem *list.Element) {
+	freq := elem.Value.(*lfuEntry[K, V]).freq
+	l := c.freqs[freq]
+	l.Remove(elem)
+	if l.Len() == 0 {
+		delete(c.freqs, freq)
+	}
+}
+
+func (c *LFUCache[K, V]) bucket(freq int) *list.List {
+	l, ok := c.freqs[freq]
+	if !ok {
+		l = list.New()
+		c.freqs[freq] = l
+	}
+	return l
+}
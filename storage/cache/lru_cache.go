@@ -1,13 +1,17 @@
 package cache
 
 import (
-	"fmt"
+	storage2 "github.com/FinnTew/FincasKV/storage"
 	lru "github.com/hashicorp/golang-lru/v2"
-	"log"
+	"sync/atomic"
 )
 
 type LRUCache[K comparable, V any] struct {
 	*lru.Cache[K, V]
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
 }
 
 func NewLRUCache[K comparable, V any](size int) *LRUCache[K, V] {
@@ -18,9 +22,8 @@ func NewLRUCache[K comparable, V any](size int) *LRUCache[K, V] {
 }
 
 func (c *LRUCache[K, V]) Insert(key K, value V) error {
-	evicted := c.Add(key, value)
-	if evicted {
-		log.Printf("LRUCache: evicted when insert {key=%v value=%v}", key, value)
+	if evicted := c.Add(key, value); evicted {
+		c.evictions.Add(1)
 	}
 	return nil
 }
@@ -29,14 +32,16 @@ func (c *LRUCache[K, V]) Find(key K) (V, error) {
 	value, exist := c.Get(key)
 	var zero V
 	if !exist {
-		return zero, fmt.Errorf("cannot find value [%v] into LRU cache", key)
+		c.misses.Add(1)
+		return zero, errNotFound("LRU", key)
 	}
+	c.hits.Add(1)
 	return value, nil
 }
 
 func (c *LRUCache[K, V]) Delete(key K) error {
 	if present := c.Remove(key); !present {
-		return fmt.Errorf("cannot find value [%v] into LRU cache", key)
+		return errNotFound("LRU", key)
 	}
 	return nil
 }
@@ -44,3 +49,15 @@ func (c *LRUCache[K, V]) Delete(key K) error {
 func (c *LRUCache[K, V]) Exist(key K) bool {
 	return c.Contains(key)
 }
+
+// Stats 返回统计信息，LRU 按项数限制容量，Bytes 为项数
+func (c *LRUCache[K, V]) Stats() storage2.CacheStats {
+	n := c.Len()
+	return storage2.CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   n,
+		Bytes:     int64(n),
+	}
+}
@@ -0,0 +1,271 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"hash/fnv"
+	"sync"
+)
+
+// WTinyLFUCache W-TinyLFU 缓存，容量按字节计算
+// 新项先进入占容量 1% 的 LRU 窗口，被挤出窗口时与主区中最该淘汰的项比较估计的访问频率，更高时才进入主区；
+// 主区为分段 LRU：试用段（20%）命中后升入保护段（80%），保护段溢出的项退回试用段。
+// 访问频率由 4 位计数的 Count-Min Sketch 估计，计数达到样本数后整体减半，使旧的热点逐渐冷却
+type WTinyLFUCache[K comparable, V any] struct {
+	mu        sync.Mutex
+	sizeOf    SizeFunc[K, V]
+	window    tinyLFUSegment[K, V]
+	probation tinyLFUSegment[K, V]
+	protected tinyLFUSegment[K, V]
+	items     map[K]*list.Element
+	sketch    *countMinSketch
+	stats     storage2.CacheStats
+}
+
+type tinyLFUEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	size    int64
+	segment *tinyLFUSegment[K, V]
+}
+
+type tinyLFUSegment[K comparable, V any] struct {
+	list.List
+	size     int64
+	capacity int64
+}
+
+func NewWTinyLFUCache[K comparable, V any](capacity int64, sizeOf SizeFunc[K, V]) *WTinyLFUCache[K, V] {
+	windowCap := max(capacity/100, 1)
+	mainCap := max(capacity-windowCap, 1)
+	protectedCap := mainCap * 8 / 10
+	c := &WTinyLFUCache[K, V]{
+		sizeOf: sizeOf,
+		items:  make(map[K]*list.Element),
+		// 按平均每项 1KB 估计项数，只影响频率估计的精度
+		sketch: newCountMinSketch(int(min(max(capacity>>10, 1<<10), 1<<20))),
+	}
+	c.window.capacity = windowCap
+	c.probation.capacity = mainCap - protectedCap
+	c.protected.capacity = protectedCap
+	return c
+}
+
+func (c *WTinyLFUCache[K, V]) Insert(key K, value V) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sketch.increment(sketchHash(key))
+	size := sizeOf(c.sizeOf, key, value)
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*tinyLFUEntry[K, V])
+		c.unlink(elem)
+		delete(c.items, key)
+		// 已缓存的项原地更新
+		if size <= e.segment.capacity {
+			e.value, e.size = value, size
+			c.push(e.segment, e)
+			c.rebalance()
+			return nil
+		}
+	}
+	if size > c.window.capacity+c.probation.capacity+c.protected.capacity {
+		return nil
+	}
+
+	c.push(&c.window, &tinyLFUEntry[K, V]{key: key, value: value, size: size})
+	c.rebalance()
+	return nil
+}
+
+func (c *WTinyLFUCache[K, V]) Find(key K) (V, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sketch.increment(sketchHash(key))
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		var zero V
+		return zero, errNotFound("W-TinyLFU", key)
+	}
+	c.stats.Hits++
+	e := elem.Value.(*tinyLFUEntry[K, V])
+	c.unlink(elem)
+	if e.segment == &c.probation {
+		// 试用段命中，升入保护段
+		c.push(&c.protected, e)
+		c.rebalance()
+	} else {
+		c.push(e.segment, e)
+	}
+	return e.value, nil
+}
+
+func (c *WTinyLFUCache[K, V]) Delete(key K) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return errNotFound("W-TinyLFU", key)
+	}
+	c.unlink(elem)
+	delete(c.items, key)
+	return nil
+}
+
+func (c *WTinyLFUCache[K, V]) Exist(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+func (c *WTinyLFUCache[K, V]) Stats() storage2.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.window.size + c.probation.size + c.protected.size
+	return stats
+}
+
+// rebalance 保护段溢出的项退回试用段，主区溢出（原地更新变大）时从试用段淘汰，窗口溢出的项经准入判断进入主区
+func (c *WTinyLFUCache[K, V]) rebalance() {
+	for c.protected.size > c.protected.capacity {
+		elem := c.protected.Back()
+		c.unlink(elem)
+		c.push(&c.probation, elem.Value.(*tinyLFUEntry[K, V]))
+	}
+	for c.probation.size+c.protected.size > c.probation.capacity+c.protected.capacity {
+		elem := c.probation.Back()
+		c.unlink(elem)
+		c.evict(elem.Value.(*tinyLFUEntry[K, V]))
+	}
+	for c.window.size > c.window.capacity {
+		elem := c.window.Back()
+		c.unlink(elem)
+		c.admit(elem.Value.(*tinyLFUEntry[K, V]))
+	}
+}
+
+// admit 主区放不下候选项时，依次与主区最该淘汰的项比较频率，候选项更频繁才淘汰对方，否则丢弃候选项
+func (c *WTinyLFUCache[K, V]) admit(candidate *tinyLFUEntry[K, V]) {
+	mainCap := c.probation.capacity + c.protected.capacity
+	freq := c.sketch.estimate(sketchHash(candidate.key))
+	for c.probation.size+c.protected.size+candidate.size > mainCap {
+		victimElem := c.probation.Back()
+		if victimElem == nil {
+			victimElem = c.protected.Back()
+		}
+		victim := victimElem.Value.(*tinyLFUEntry[K, V])
+		if freq <= c.sketch.estimate(sketchHash(victim.key)) {
+			c.evict(candidate)
+			return
+		}
+		c.unlink(victimElem)
+		c.evict(victim)
+	}
+	c.push(&c.probation, candidate)
+}
+
+func (c *WTinyLFUCache[K, V]) evict(e *tinyLFUEntry[K, V]) {
+	delete(c.items, e.key)
+	c.stats.Evictions++
+}
+
+func (c *WTinyLFUCache[K, V]) push(s *tinyLFUSegment[K, V], e *tinyLFUEntry[K, V]) {
+	e.segment = s
+	s.size += e.size
+	c.items[e.key] = s.PushFront(e)
+}
+
+func (c *WTinyLFUCache[K, V]) unlink(elem *list.Element) {
+	e := elem.Value.(*tinyLFUEntry[K, V])
+	e.segment.Remove(elem)
+	e.segment.size -= e.size
+}
+
+// countMinSketch 4 行 4 位计数器的 Count-Min Sketch，每个字节存两个计数器
+type countMinSketch struct {
+	rows       [4][]byte
+	mask       uint32
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	n := 1
+	for n < width {
+		n <<= 1
+	}
+	s := &countMinSketch{mask: uint32(n - 1), sampleSize: 10 * n}
+	for i := range s.rows {
+		s.rows[i] = make([]byte, n/2)
+	}
+	return s
+}
+
+// index 第 i 行中计数器的位置
+func (s *countMinSketch) index(h uint32, i int) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b + uint32(i)*0x9e3779b9
+	h ^= h >> 13
+	return h & s.mask
+}
+
+func (s *countMinSketch) get(row int, idx uint32) byte {
+	return (s.rows[row][idx/2] >> ((idx & 1) * 4)) & 0x0f
+}
+
+func (s *countMinSketch) increment(h uint32) {
+	added := false
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.get(i, idx) < 15 {
+			s.rows[i][idx/2] += 1 << ((idx & 1) * 4)
+			added = true
+		}
+	}
+	if added {
+		s.additions++
+		if s.additions >= s.sampleSize {
+			s.reset()
+		}
+	}
+}
+
+func (s *countMinSketch) estimate(h uint32) byte {
+	est := byte(15)
+	for i := range s.rows {
+		est = min(est, s.get(i, s.index(h, i)))
+	}
+	return est
+}
+
+// reset 所有计数减半
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j, b := range s.rows[i] {
+			s.rows[i][j] = (b >> 1) & 0x77
+		}
+	}
+	s.additions /= 2
+}
+
+// sketchHash 计算键在频率草图中的哈希，字符串键直接按字节计算 FNV-1a，不产生内存分配
+func sketchHash[K comparable](key K) uint32 {
+	if k, ok := any(key).(string); ok {
+		h := uint32(2166136261)
+		for i := 0; i < len(k); i++ {
+			h ^= uint32(k[i])
+			h *= 16777619
+		}
+		return h
+	}
+	f := fnv.New32a()
+	f.Write([]byte(fmt.Sprintf("%v", key)))
+	return f.Sum32()
+}
@@ -0,0 +1,388 @@
+package file_manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 大值分离存储:
+//
+//	数据文件: record(FlagBlob, value = fileID(4) | offset(8) | size(4))
+//	blob 文件: record(value)
+//
+//	流式写入的值: record(FlagChunked, value = size(8) | blob 指针...)，每个分块是一条 blob 记录
+//
+// blob 文件中的记录与数据文件格式相同，保留键以便 GC 判断记录是否仍被引用，同样按配置压缩、加密；
+// blob 文件与数据文件共用文件编号，加密 nonce 不会重复。
+// 合并数据文件时只搬移指针，大值不随之重写；blob 文件只追加，由 GC 把仍被引用的记录搬到新文件后整体删除。
+// 每次启动都写入新的 blob 文件，上次运行的 blob 文件不再追加
+
+// BlobPointerSize blob 指针的编码长度
+const BlobPointerSize = 16
+
+// BlobPointer 指向 blob 文件中一条记录
+type BlobPointer struct {
+	FileID int
+	Offset int64
+	Size   uint32
+}
+
+// Encode 编码为数据文件记录的值
+func (p BlobPointer) Encode() []byte {
+	buf := make([]byte, BlobPointerSize)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(p.FileID))
+	binary.BigEndian.PutUint64(buf[4:12], uint64(p.Offset))
+	binary.BigEndian.PutUint32(buf[12:16], p.Size)
+	return buf
+}
+
+// DecodeBlobPointer 解析数据文件记录中的 blob 指针
+func DecodeBlobPointer(data []byte) (BlobPointer, error) {
+	if len(data) != BlobPointerSize {
+		return BlobPointer{}, fmt.Errorf("%w: blob pointer length %d", err_def.ErrDataLengthInvalid, len(data))
+	}
+	return BlobPointer{
+		FileID: int(binary.BigEndian.Uint32(data[0:4])),
+		Offset: int64(binary.BigEndian.Uint64(data[4:12])),
+		Size:   binary.BigEndian.Uint32(data[12:16]),
+	}, nil
+}
+
+// ChunkManifest 分块写入的值的清单，按顺序列出各分块的 blob 指针
+type ChunkManifest struct {
+	Size   int64
+	Chunks []BlobPointer
+}
+
+// Encode 编码为数据文件记录的值
+func (m ChunkManifest) Encode() []byte {
+	buf := make([]byte, 8, 8+len(m.Chunks)*BlobPointerSize)
+	binary.BigEndian.PutUint64(buf, uint64(m.Size))
+	for _, ptr := range m.Chunks {
+		buf = append(buf, ptr.Encode()...)
+	}
+	return buf
+}
+
+// DecodeChunkManifest 解析数据文件记录中的分块清单
+func DecodeChunkManifest(data []byte) (ChunkManifest, error) {
+	if len(data) < 8 || (len(data)-8)%BlobPointerSize != 0 {
+		return ChunkManifest{}, fmt.Errorf("%w: chunk manifest length %d", err_def.ErrDataLengthInvalid, len(data))
+	}
+	m := ChunkManifest{
+		Size:   int64(binary.BigEndian.Uint64(data[0:8])),
+		Chunks: make([]BlobPointer, 0, (len(data)-8)/BlobPointerSize),
+	}
+	for pos := 8; pos < len(data); pos += BlobPointerSize {
+		ptr, _ := DecodeBlobPointer(data[pos : pos+BlobPointerSize])
+		m.Chunks = append(m.Chunks, ptr)
+	}
+	return m, nil
+}
+
+// ErrBlobReclaimed 指针引用的 blob 文件已被 GC 删除，只会出现在已被覆盖的旧记录上
+var ErrBlobReclaimed = errors.New("blob has been reclaimed")
+
+// BlobFilePath 返回 blob 文件路径
+func BlobFilePath(dir string, fileID int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%d%s", storage2.BlobFilePrefix, fileID, storage2.BlobFileSuffix))
+}
+
+// ListBlobFileIDs 按编号升序列出目录下的 blob 文件
+func ListBlobFileIDs(dir string) ([]int, error) {
+	return listFileIDs(dir, storage2.BlobFilePrefix, storage2.BlobFileSuffix)
+}
+
+// WriteBlob 将明文记录写入 blob 文件，返回指向它的指针，记录的值可以已经压缩
+// always、group 模式下返回前 fsync，保证指针落盘时 blob 已经落盘
+func (fm *FileManager) WriteBlob(r *storage2.Record) (BlobPointer, error) {
+	plain := *fm.compressRecord(r)
+	plain.Seq = 0
+	plain.Flags &^= storage2.FlagEncrypted | storage2.FlagBatch | storage2.FlagBlob | storage2.FlagChunked
+	if err := checkRecord(&plain); err != nil {
+		return BlobPointer{}, err
+	}
+
+	var key *cipherKey
+	size := storage2.HeaderSize + len(plain.Key) + len(plain.Value) + 8
+	if fm.cipher != nil {
+		if 4+len(plain.Key)+len(plain.Value)+16 > storage2.MaxValueSize {
+			return BlobPointer{}, fmt.Errorf("%w: encrypted record exceeds maximum %d", err_def.ErrValueTooLarge, storage2.MaxValueSize)
+		}
+		k, err := fm.cipher.current()
+		if err != nil {
+			return BlobPointer{}, err
+		}
+		key, size = k, sealedSize(&plain)-storage2.SeqSize
+	}
+
+	fm.blobMu.Lock()
+	defer fm.blobMu.Unlock()
+
+	select {
+	case <-fm.stopChan:
+		return BlobPointer{}, err_def.ErrDBClosed
+	default:
+	}
+	if fm.readOnly {
+		return BlobPointer{}, err_def.ErrReadOnly
+	}
+
+	current := fm.blobFile
+	if current == nil || (current.Offset.Load() > 0 && current.Offset.Load()+int64(size) > fm.maxFileSize) {
+		var err error
+		if current, err = fm.rotateBlob(); err != nil {
+			return BlobPointer{}, err
+		}
+	}
+
+	offset := current.Offset.Load()
+	stored := &plain
+	if key != nil {
+		stored = key.seal(&plain, current.ID, offset)
+	}
+	data, err := encodeRecord(stored)
+	if err != nil {
+		return BlobPointer{}, err
+	}
+
+	current.Offset.Add(int64(len(data)))
+	if n, err := current.File.WriteAt(data, offset); err != nil || n != len(data) {
+		// 写了一半的文件不再追加，下次写入换到新文件
+		_ = current.File.Close()
+		current.Closed.Store(true)
+		fm.blobFile = nil
+		return BlobPointer{}, err_def.ErrWriteFailed
+	}
+	if fm.durability == storage2.DurabilityAlways || fm.durability == storage2.DurabilityGroup {
+		if err := current.File.Sync(); err != nil {
+			return BlobPointer{}, fmt.Errorf("%w: sync blob failed: %v", err_def.ErrWriteFailed, err)
+		}
+	}
+	return BlobPointer{FileID: current.ID, Offset: offset, Size: uint32(len(data))}, nil
+}
+
+// rotateBlob 封存当前 blob 文件并创建新文件，调用方需持有 blobMu
+func (fm *FileManager) rotateBlob() (*storage2.DataFile, error) {
+	if old := fm.blobFile; old != nil && !old.Closed.Load() {
+		old.Closed.Store(true)
+		_ = old.File.Sync()
+		_ = old.File.Close()
+	}
+	fm.blobFile = nil
+
+	fileID := fm.allocFileID()
+	path := BlobFilePath(fm.dir, fileID)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("create blob file failed: %w", err)
+	}
+
+	// 与数据文件一样先创建再登记，崩溃后未登记的文件不会被引用
+	fm.manifestMu.Lock()
+	fm.manifest.Blobs = append(fm.manifest.Blobs, fileID)
+	err = fm.saveManifest()
+	if err != nil {
+		fm.manifest.Blobs = fm.manifest.Blobs[:len(fm.manifest.Blobs)-1]
+	}
+	fm.manifestMu.Unlock()
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("save manifest failed: %w", err)
+	}
+
+	df := &storage2.DataFile{ID: fileID, Path: path, File: f}
+	fm.blobFile = df
+	return df, nil
+}
+
+// SyncBlob 对正在写入的 blob 文件做 fsync
+func (fm *FileManager) SyncBlob() error {
+	fm.blobMu.Lock()
+	defer fm.blobMu.Unlock()
+	if fm.blobFile == nil || fm.blobFile.Closed.Load() {
+		return nil
+	}
+	if err := fm.blobFile.File.Sync(); err != nil {
+		return fmt.Errorf("%w: sync blob failed: %v", err_def.ErrWriteFailed, err)
+	}
+	return nil
+}
+
+// closeBlob 封存正在写入的 blob 文件
+func (fm *FileManager) closeBlob() {
+	fm.blobMu.Lock()
+	defer fm.blobMu.Unlock()
+	if current := fm.blobFile; current != nil && !current.Closed.Load() {
+		current.Closed.Store(true)
+		_ = current.File.Sync()
+		_ = current.File.Close()
+	}
+	fm.blobFile = nil
+}
+
+// ActiveBlobFileID 返回正在写入的 blob 文件编号，没有时返回 -1
+func (fm *FileManager) ActiveBlobFileID() int {
+	fm.blobMu.Lock()
+	defer fm.blobMu.Unlock()
+	if fm.blobFile == nil {
+		return -1
+	}
+	return fm.blobFile.ID
+}
+
+// LiveBlobFileIDs 返回当前有效的 blob 文件编号
+func (fm *FileManager) LiveBlobFileIDs() []int {
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+	ids := make([]int, len(fm.manifest.Blobs))
+	copy(ids, fm.manifest.Blobs)
+	return ids
+}
+
+// GetBlobFile 返回 blob 文件的读句柄，与数据文件共用句柄缓存
+func (fm *FileManager) GetBlobFile(fileID int) (*os.File, error) {
+	return fm.openCached(fileID, BlobFilePath(fm.dir, fileID), nil)
+}
+
+// ReadBlob 读取指针指向的 blob 记录并还原为明文，key 用于校验指针与记录匹配
+func (fm *FileManager) ReadBlob(ptr BlobPointer, key []byte) ([]byte, error) {
+	file, err := fm.GetBlobFile(ptr.FileID)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, ptr.Size)
+	if _, err := file.ReadAt(buf, ptr.Offset); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: unexpected EOF (blob fileID=%d)", err_def.ErrReadFailed, ptr.FileID)
+		}
+		return nil, fmt.Errorf("%w: %v", err_def.ErrReadFailed, err)
+	}
+
+	r, err := DecodeRecord(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := fm.UnpackRecord(r, ptr.FileID, ptr.Offset); err != nil {
+		return nil, fmt.Errorf("%w: %v", err_def.ErrReadFailed, err)
+	}
+	if !bytes.Equal(r.Key, key) {
+		return nil, fmt.Errorf("%w: blob record key mismatch", err_def.ErrChecksumMismatch)
+	}
+	return r.Value, nil
+}
+
+// resolveBlob 将 blob 指针记录的值替换为 blob 中的值，并清除 FlagBlob
+func (fm *FileManager) resolveBlob(r *storage2.Record) error {
+	if r.Flags&storage2.FlagBlob == 0 {
+		return nil
+	}
+	ptr, err := DecodeBlobPointer(r.Value)
+	if err != nil {
+		return err
+	}
+	value, err := fm.ReadBlob(ptr, r.Key)
+	if errors.Is(err, err_def.ErrFileNotFound) {
+		return fmt.Errorf("%w: key %q, blob fileID=%d", ErrBlobReclaimed, r.Key, ptr.FileID)
+	}
+	if err != nil {
+		return fmt.Errorf("read blob of key %q failed: %w", r.Key, err)
+	}
+	r.Value = value
+	r.Flags &^= storage2.FlagBlob
+	return nil
+}
+
+// BlobFileSize 返回 blob 文件的长度
+func (fm *FileManager) BlobFileSize(fileID int) (int64, error) {
+	stat, err := os.Stat(BlobFilePath(fm.dir, fileID))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// ScanBlobFile 顺序扫描 blob 文件，fn 收到的记录已解密，值仍保持压缩
+func (fm *FileManager) ScanBlobFile(fileID int, fn func(r *storage2.Record, ptr BlobPointer) error) error {
+	file, err := fm.GetBlobFile(fileID)
+	if err != nil {
+		return err
+	}
+	return ScanRecords(file, func(r *storage2.Record, offset int64, size uint32) error {
+		if err := fm.OpenRecord(r, fileID, offset); err != nil {
+			return err
+		}
+		return fn(r, BlobPointer{FileID: fileID, Offset: offset, Size: size})
+	})
+}
+
+// BlobNeedsRekey 判断 blob 文件中是否有未加密或使用旧密钥加密的记录，只读取记录头部
+func (fm *FileManager) BlobNeedsRekey(fileID int) (bool, error) {
+	if fm.cipher == nil {
+		return false, nil
+	}
+	file, err := fm.GetBlobFile(fileID)
+	if err != nil {
+		return false, err
+	}
+
+	header := make([]byte, storage2.HeaderSize+storage2.SeqSize+keyIDSize)
+	for offset := int64(0); ; {
+		n, err := file.ReadAt(header, offset)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if n < storage2.HeaderSize {
+			return false, nil
+		}
+		size, ok := recordSizeOf(header)
+		if !ok {
+			return false, nil
+		}
+		flags := binary.BigEndian.Uint32(header[8:12])
+		keyStart := storage2.RecordHeaderSize(flags)
+		if n < keyStart+keyIDSize {
+			return false, nil
+		}
+		if fm.NeedsRekey(flags, header[keyStart:keyStart+keyIDSize]) {
+			return true, nil
+		}
+		offset += size
+	}
+}
+
+// RetireBlobs 将已被 GC 重写的 blob 文件移出有效集合，文件在 RemoveObsolete 中删除
+func (fm *FileManager) RetireBlobs(ids []int) error {
+	if fm.readOnly {
+		return err_def.ErrReadOnly
+	}
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+
+	retired := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		retired[id] = struct{}{}
+	}
+	prev, prevObsolete := fm.manifest.Blobs, fm.manifest.ObsoleteBlobs
+	var blobs []int
+	for _, id := range prev {
+		if _, ok := retired[id]; !ok {
+			blobs = append(blobs, id)
+		}
+	}
+	fm.manifest.Blobs = blobs
+	fm.manifest.ObsoleteBlobs = append(append([]int(nil), prevObsolete...), ids...)
+	if err := fm.saveManifest(); err != nil {
+		fm.manifest.Blobs, fm.manifest.ObsoleteBlobs = prev, prevObsolete
+		return fmt.Errorf("save manifest failed: %w", err)
+	}
+	return nil
+}
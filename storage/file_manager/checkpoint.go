@@ -0,0 +1,264 @@
+package file_manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// CheckpointFileName 检查点描述文件名，位于检查点目录下
+	CheckpointFileName = "CHECKPOINT"
+
+	checkpointVersion = 1
+)
+
+// Checkpoint 检查点描述
+// Files 为检查点包含的数据文件，按回放顺序排列；
+// ActiveFileID/ActiveOffset 为创建检查点时被封存的活动文件及其长度，此前提交的写入都包含在检查点中；
+// 多个写入通道时 Sealed 为各通道被封存的活动文件，ActiveFileID 为其中最后一个；
+// Blobs 为检查点包含的 blob 文件，正在写入的 blob 文件末尾可能多出之后写入的 blob，它们不会被引用
+type Checkpoint struct {
+	Version      int          `json:"version"`
+	CreatedAt    time.Time    `json:"created_at"`
+	Files        []int        `json:"files"`
+	Blobs        []int        `json:"blobs,omitempty"`
+	ActiveFileID int          `json:"active_file_id"`
+	ActiveOffset int64        `json:"active_offset"`
+	Sealed       []SealedFile `json:"sealed,omitempty"`
+}
+
+// SealedFile 创建检查点时被封存的活动文件及其长度
+type SealedFile struct {
+	FileID int   `json:"file_id"`
+	Offset int64 `json:"offset"`
+}
+
+// CheckpointExtra 在检查点描述文件写入前向检查点目录写入上层的文件，如 TTL 元数据
+type CheckpointExtra func(dir string) error
+
+// Checkpoint 在 dir 下创建检查点：封存活动文件，将所有已封存的数据文件及 hint、blob 文件硬链接到 dir
+// dir 必须不存在或为空目录，不在同一文件系统时退化为复制；extras 在描述文件写入前依次调用，
+// 描述文件最后写入，任一步失败时目录中没有描述文件，不会被当作检查点恢复。
+// 调用方需保证期间没有合并在删除文件
+func (fm *FileManager) Checkpoint(dir string, extras ...CheckpointExtra) (*Checkpoint, error) {
+	if err := prepareCheckpointDir(dir); err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{}
+	err := fm.sealAll(func(sealed []SealedFile) error {
+		return fm.captureCheckpoint(cp, sealed)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range cp.Files {
+		if err := linkOrCopy(DataFilePath(fm.dir, id), DataFilePath(dir, id)); err != nil {
+			return nil, fmt.Errorf("link data file %d failed: %w", id, err)
+		}
+		// hint 文件只是加速加载，缺失时加载会回退到扫描数据文件
+		err := linkOrCopy(HintPath(fm.dir, id), HintPath(dir, id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("link hint file %d failed: %w", id, err)
+		}
+	}
+
+	for _, id := range cp.Blobs {
+		if err := linkOrCopy(BlobFilePath(fm.dir, id), BlobFilePath(dir, id)); err != nil {
+			return nil, fmt.Errorf("link blob file %d failed: %w", id, err)
+		}
+	}
+
+	m := &Manifest{Files: cp.Files, Blobs: cp.Blobs}
+	for _, id := range append(cp.Files, cp.Blobs...) {
+		m.NextID = max(m.NextID, id+1)
+	}
+	if err := m.Save(dir); err != nil {
+		return nil, err
+	}
+	for _, extra := range extras {
+		if err := extra(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := cp.save(dir); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// captureCheckpoint 在所有通道的活动文件封存后记录检查点包含的文件，此时各通道暂停写入
+func (fm *FileManager) captureCheckpoint(cp *Checkpoint, sealed []SealedFile) error {
+	// 各通道新的活动文件（或为空的活动文件）不包含在检查点中
+	var files []int
+	for _, id := range fm.LiveFileIDs() {
+		if !fm.IsActive(id) {
+			files = append(files, id)
+		}
+	}
+
+	cp.Version = checkpointVersion
+	cp.CreatedAt = time.Now()
+	cp.Files = files
+	cp.Blobs = fm.LiveBlobFileIDs()
+	cp.ActiveFileID = -1
+	if n := len(sealed); n > 0 {
+		cp.ActiveFileID, cp.ActiveOffset = sealed[n-1].FileID, sealed[n-1].Offset
+	}
+	if len(fm.lanes) > 1 {
+		cp.Sealed = sealed
+	}
+	return nil
+}
+
+// LoadCheckpoint 读取检查点目录下的描述文件
+func LoadCheckpoint(dir string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointFileName))
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("parse checkpoint failed: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+	return cp, nil
+}
+
+func (cp *Checkpoint) save(dir string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, CheckpointFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// RestoreCheckpoint 将检查点恢复到数据目录 dst，dst 中不能已有数据
+// 恢复时复制文件而不是硬链接，之后的运行不会影响检查点本身
+func RestoreCheckpoint(src, dst string) (*Checkpoint, error) {
+	cp, err := LoadCheckpoint(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareRestoreDir(dst); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		// 检查点描述文件留在检查点目录，其余文件（数据、hint、清单及上层的元数据）全部复制
+		if !e.Type().IsRegular() || e.Name() == CheckpointFileName {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return nil, fmt.Errorf("restore %s failed: %w", e.Name(), err)
+		}
+	}
+
+	sealed := cp.Sealed
+	if len(sealed) == 0 && cp.ActiveFileID >= 0 && len(cp.Files) > 0 {
+		sealed = []SealedFile{{FileID: cp.ActiveFileID, Offset: cp.ActiveOffset}}
+	}
+	for _, sf := range sealed {
+		if err := truncateTo(DataFilePath(dst, sf.FileID), sf.Offset); err != nil {
+			return nil, fmt.Errorf("checkpoint data file %d: %w", sf.FileID, err)
+		}
+	}
+	return cp, syncDir(dst)
+}
+
+// truncateTo 将文件截断到创建检查点时记录的长度
+func truncateTo(path string, size int64) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if stat.Size() < size {
+		return fmt.Errorf("shorter than recorded offset %d", size)
+	}
+	if stat.Size() > size {
+		return os.Truncate(path, size)
+	}
+	return nil
+}
+
+// prepareCheckpointDir 创建检查点目录，已存在时必须为空
+func prepareCheckpointDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err == nil {
+		if len(entries) > 0 {
+			return fmt.Errorf("checkpoint directory %s is not empty", dir)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// prepareRestoreDir 创建恢复目标目录，已有数据文件或清单时拒绝覆盖
+func prepareRestoreDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ids, err := ListDataFileIDs(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, ManifestFileName)); len(ids) > 0 || err == nil {
+		return fmt.Errorf("data directory %s already contains data", dir)
+	}
+	return nil
+}
+
+// linkOrCopy 硬链接文件，跨文件系统时复制
+func linkOrCopy(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+	var le *os.LinkError
+	if errors.As(err, &le) && (errors.Is(le.Err, syscall.EXDEV) || errors.Is(le.Err, syscall.EPERM)) {
+		return copyFile(src, dst)
+	}
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
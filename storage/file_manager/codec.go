@@ -0,0 +1,131 @@
+package file_manager
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"io"
+	"sync"
+)
+
+// 压缩与解压器的内部状态较大，按编码复用，避免每条记录重新分配
+var (
+	flateWriterPool = sync.Pool{
+		New: func() interface{} {
+			w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+			return w
+		},
+	}
+	zlibWriterPool = sync.Pool{
+		New: func() interface{} {
+			return zlib.NewWriter(nil)
+		},
+	}
+	// 解压器创建时需要读取输入，池中没有时在 decompressRecord 中新建
+	flateReaderPool sync.Pool
+	zlibReaderPool  sync.Pool
+)
+
+// codecID 将配置的压缩算法映射为记录头部中的编码编号
+func codecID(compression storage2.CompressionType) (storage2.CodecID, error) {
+	switch compression {
+	case "", storage2.CompressionNone:
+		return storage2.CodecNone, nil
+	case storage2.CompressionFlate:
+		return storage2.CodecFlate, nil
+	case storage2.CompressionZlib:
+		return storage2.CodecZlib, nil
+	default:
+		return 0, fmt.Errorf("unsupported compression: %s", compression)
+	}
+}
+
+// compressRecord 值长度达到阈值且压缩后更小时，返回压缩后的记录副本，否则返回原记录
+func (fm *FileManager) compressRecord(r *storage2.Record) *storage2.Record {
+	// blob 指针记录的值只是指针，压缩的是 blob 文件中的值
+	if fm.codec == storage2.CodecNone || len(r.Value) < fm.compressThreshold || storage2.CodecOf(r.Flags) != storage2.CodecNone || storage2.HasBlobRef(r.Flags) {
+		return r
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch fm.codec {
+	case storage2.CodecFlate:
+		fw := flateWriterPool.Get().(*flate.Writer)
+		defer flateWriterPool.Put(fw)
+		fw.Reset(&buf)
+		w = fw
+	case storage2.CodecZlib:
+		zw := zlibWriterPool.Get().(*zlib.Writer)
+		defer zlibWriterPool.Put(zw)
+		zw.Reset(&buf)
+		w = zw
+	default:
+		return r
+	}
+	if _, err := w.Write(r.Value); err != nil {
+		return r
+	}
+	if err := w.Close(); err != nil || buf.Len() >= len(r.Value) {
+		return r
+	}
+
+	compressed := *r
+	compressed.Value = buf.Bytes()
+	compressed.Flags = r.Flags&^storage2.FlagCodecMask | uint32(fm.codec)<<storage2.FlagCodecShift
+	return &compressed
+}
+
+// decompressRecord 按头部编码解压记录的值，并清除编码标记
+func decompressRecord(r *storage2.Record) error {
+	codec := storage2.CodecOf(r.Flags)
+	if codec == storage2.CodecNone {
+		return nil
+	}
+
+	var pool *sync.Pool
+	switch codec {
+	case storage2.CodecFlate:
+		pool = &flateReaderPool
+	case storage2.CodecZlib:
+		pool = &zlibReaderPool
+	default:
+		return fmt.Errorf("unknown value codec %d", codec)
+	}
+
+	src := bytes.NewReader(r.Value)
+	var rd io.ReadCloser
+	if cached, ok := pool.Get().(io.ReadCloser); ok {
+		// zlib 的解压器同样实现了 Reset(io.Reader, []byte) error
+		if err := cached.(flate.Resetter).Reset(src, nil); err != nil {
+			return fmt.Errorf("decompress value failed: %w", err)
+		}
+		rd = cached
+	} else if codec == storage2.CodecFlate {
+		rd = flate.NewReader(src)
+	} else {
+		zr, err := zlib.NewReader(src)
+		if err != nil {
+			return fmt.Errorf("decompress value failed: %w", err)
+		}
+		rd = zr
+	}
+	defer func() {
+		_ = rd.Close()
+		pool.Put(rd)
+	}()
+
+	value, err := io.ReadAll(io.LimitReader(rd, int64(storage2.MaxValueSize)+1))
+	if err != nil {
+		return fmt.Errorf("decompress value failed: %w", err)
+	}
+	if len(value) > storage2.MaxValueSize {
+		return fmt.Errorf("decompressed value exceeds maximum %d", storage2.MaxValueSize)
+	}
+
+	r.Value = value
+	r.Flags &^= storage2.FlagCodecMask
+	return nil
+}
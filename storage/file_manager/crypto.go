@@ -0,0 +1,348 @@
+package file_manager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"sync"
+)
+
+// 加密记录的布局:
+//
+//	Key   = keyID(4)
+//	Value = AES-GCM(keyLen(4) | key | value)
+//
+// nonce = fileID(4) | offset(8)，由记录在文件中的位置决定，文件编号不复用、文件只追加，因此不会重复；
+// 附加数据为头部的 timestamp 与 flags，防止篡改记录类型。
+// 合并搬移记录时位置改变，必须用当前密钥重新加密，密钥轮换因此由合并完成。
+// hint 中的键、元数据文件分别使用由同一密钥派生的独立子密钥。
+
+const (
+	keyIDSize = 4
+	nonceSize = 12
+)
+
+var (
+	// blobMagic 加密元数据文件的魔数，没有魔数的文件视为未加密的旧文件
+	blobMagic = []byte("FKVE")
+
+	ErrNoKeyProvider = errors.New("data is encrypted but no key provider is configured")
+)
+
+// cipherKey 由一个主密钥派生出的各用途 AEAD
+type cipherKey struct {
+	id     uint32
+	record cipher.AEAD
+	hint   cipher.AEAD
+	blob   cipher.AEAD
+}
+
+// recordCipher 按编号缓存各密钥的 AEAD
+type recordCipher struct {
+	provider storage2.KeyProvider
+
+	mu   sync.RWMutex
+	keys map[uint32]*cipherKey
+}
+
+func newRecordCipher(provider storage2.KeyProvider) (*recordCipher, error) {
+	c := &recordCipher{
+		provider: provider,
+		keys:     make(map[uint32]*cipherKey),
+	}
+	// 提前校验当前密钥
+	if _, err := c.current(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// current 返回当前用于加密的密钥
+func (c *recordCipher) current() (*cipherKey, error) {
+	id, secret, err := c.provider.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("get current encryption key failed: %w", err)
+	}
+
+	c.mu.RLock()
+	k, ok := c.keys[id]
+	c.mu.RUnlock()
+	if ok {
+		return k, nil
+	}
+	return c.add(id, secret)
+}
+
+// get 按编号返回密钥
+func (c *recordCipher) get(id uint32) (*cipherKey, error) {
+	c.mu.RLock()
+	k, ok := c.keys[id]
+	c.mu.RUnlock()
+	if ok {
+		return k, nil
+	}
+
+	secret, err := c.provider.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("get encryption key %d failed: %w", id, err)
+	}
+	return c.add(id, secret)
+}
+
+func (c *recordCipher) add(id uint32, secret []byte) (*cipherKey, error) {
+	k := &cipherKey{id: id}
+	var err error
+	if k.record, err = deriveAEAD(secret, "record"); err != nil {
+		return nil, err
+	}
+	if k.hint, err = deriveAEAD(secret, "hint"); err != nil {
+		return nil, err
+	}
+	if k.blob, err = deriveAEAD(secret, "blob"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.keys[id] = k
+	c.mu.Unlock()
+	return k, nil
+}
+
+// deriveAEAD 用 HMAC-SHA256 派生与主密钥等长的子密钥
+func deriveAEAD(secret []byte, label string) (cipher.AEAD, error) {
+	switch len(secret) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("invalid encryption key length %d, must be 16, 24 or 32", len(secret))
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	block, err := aes.NewCipher(mac.Sum(nil)[:len(secret)])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// positionNonce 由记录位置生成 nonce
+func positionNonce(fileID int, offset int64) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint32(nonce[0:4], uint32(fileID))
+	binary.BigEndian.PutUint64(nonce[4:12], uint64(offset))
+	return nonce
+}
+
+func recordAAD(r *storage2.Record) []byte {
+	aad := make([]byte, 12)
+	binary.BigEndian.PutUint64(aad[0:8], uint64(r.Timestamp))
+	binary.BigEndian.PutUint32(aad[8:12], r.Flags)
+	return aad
+}
+
+// sealedSize 加密后记录的编码长度
+func sealedSize(r *storage2.Record) int {
+	return storage2.HeaderSize + storage2.SeqSize + keyIDSize + 4 + len(r.Key) + len(r.Value) + 16 + 8
+}
+
+// seal 用密钥 k 加密位于 (fileID, offset) 的记录，返回加密后的记录副本
+func (k *cipherKey) seal(r *storage2.Record, fileID int, offset int64) *storage2.Record {
+	plain := make([]byte, 4+len(r.Key)+len(r.Value))
+	binary.BigEndian.PutUint32(plain[0:4], uint32(len(r.Key)))
+	copy(plain[4:], r.Key)
+	copy(plain[4+len(r.Key):], r.Value)
+
+	sealed := &storage2.Record{
+		Timestamp: r.Timestamp,
+		Seq:       r.Seq,
+		Flags:     r.Flags | storage2.FlagEncrypted,
+	}
+	sealed.Key = make([]byte, keyIDSize)
+	binary.BigEndian.PutUint32(sealed.Key, k.id)
+	sealed.Value = k.record.Seal(nil, positionNonce(fileID, offset), plain, recordAAD(sealed))
+	return sealed
+}
+
+// open 解密位于 (fileID, offset) 的记录，原地还原键和值，保留加密标记
+func (c *recordCipher) open(r *storage2.Record, fileID int, offset int64) error {
+	if len(r.Key) != keyIDSize {
+		return fmt.Errorf("%w: invalid encrypted key slot", err_def.ErrChecksumMismatch)
+	}
+	k, err := c.get(binary.BigEndian.Uint32(r.Key))
+	if err != nil {
+		return err
+	}
+	plain, err := k.record.Open(nil, positionNonce(fileID, offset), r.Value, recordAAD(r))
+	if err != nil {
+		return fmt.Errorf("%w: decrypt record failed", err_def.ErrChecksumMismatch)
+	}
+	if len(plain) < 4 {
+		return fmt.Errorf("%w: decrypted record too short", err_def.ErrChecksumMismatch)
+	}
+	keyLen := binary.BigEndian.Uint32(plain[0:4])
+	if uint64(keyLen) > uint64(len(plain)-4) {
+		return fmt.Errorf("%w: invalid decrypted key length", err_def.ErrChecksumMismatch)
+	}
+	r.Key = plain[4 : 4+keyLen]
+	r.Value = plain[4+keyLen:]
+	return nil
+}
+
+// sealHintKey 加密 hint 中的键，格式为 keyID(4) | AES-GCM(key)
+func (k *cipherKey) sealHintKey(key []byte, fileID int, offset int64) []byte {
+	out := make([]byte, keyIDSize, keyIDSize+len(key)+16)
+	binary.BigEndian.PutUint32(out, k.id)
+	return k.hint.Seal(out, positionNonce(fileID, offset), key, nil)
+}
+
+func (c *recordCipher) openHintKey(stored []byte, fileID int, offset int64) ([]byte, error) {
+	if len(stored) < keyIDSize {
+		return nil, ErrHintCorrupt
+	}
+	k, err := c.get(binary.BigEndian.Uint32(stored))
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.hint.Open(nil, positionNonce(fileID, offset), stored[keyIDSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decrypt hint key failed", ErrHintCorrupt)
+	}
+	return key, nil
+}
+
+// sealBlob 加密任意元数据，格式为 magic(4) | keyID(4) | nonce(12) | AES-GCM(data)，nonce 随机生成
+func (c *recordCipher) sealBlob(data []byte) ([]byte, error) {
+	k, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(blobMagic)+keyIDSize+nonceSize, len(blobMagic)+keyIDSize+nonceSize+len(data)+16)
+	copy(out, blobMagic)
+	binary.BigEndian.PutUint32(out[len(blobMagic):], k.id)
+	nonce := out[len(blobMagic)+keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.blob.Seal(out, nonce, data, out[:len(blobMagic)+keyIDSize]), nil
+}
+
+func (c *recordCipher) openBlob(data []byte) ([]byte, error) {
+	header := len(blobMagic) + keyIDSize + nonceSize
+	if len(data) < header {
+		return nil, fmt.Errorf("encrypted blob too short")
+	}
+	k, err := c.get(binary.BigEndian.Uint32(data[len(blobMagic):]))
+	if err != nil {
+		return nil, err
+	}
+	nonce := data[len(blobMagic)+keyIDSize : header]
+	plain, err := k.blob.Open(nil, nonce, data[header:], data[:len(blobMagic)+keyIDSize])
+	if err != nil {
+		return nil, fmt.Errorf("decrypt blob failed: %w", err)
+	}
+	return plain, nil
+}
+
+/* ------------------------------- FileManager 接口 -------------------------------- */
+
+// OpenRecord 解密扫描得到的原始记录，未加密的记录原样返回
+func (fm *FileManager) OpenRecord(r *storage2.Record, fileID int, offset int64) error {
+	if r.Flags&storage2.FlagEncrypted == 0 {
+		return nil
+	}
+	if fm.cipher == nil {
+		return ErrNoKeyProvider
+	}
+	return fm.cipher.open(r, fileID, offset)
+}
+
+// UnpackRecord 将扫描得到的原始记录还原为明文：解密、解压值，并读出分离存储的值
+func (fm *FileManager) UnpackRecord(r *storage2.Record, fileID int, offset int64) error {
+	if err := fm.OpenRecord(r, fileID, offset); err != nil {
+		return err
+	}
+	if err := decompressRecord(r); err != nil {
+		return err
+	}
+	return fm.resolveBlob(r)
+}
+
+// OpenHintKey 返回 hint 记录中的明文键
+func (fm *FileManager) OpenHintKey(fileID int, h HintEntry) ([]byte, error) {
+	if h.Flags&storage2.FlagEncrypted == 0 {
+		return h.Key, nil
+	}
+	if fm.cipher == nil {
+		return nil, ErrNoKeyProvider
+	}
+	return fm.cipher.openHintKey(h.Key, fileID, h.Offset)
+}
+
+// NewHintEntry 为已解密的记录生成 hint，加密记录的键在 hint 中同样加密
+func (fm *FileManager) NewHintEntry(r *storage2.Record, fileID int, offset int64, size uint32) (HintEntry, error) {
+	h := HintEntry{
+		Key:       r.Key,
+		Flags:     r.Flags,
+		Timestamp: r.Timestamp,
+		Seq:       r.Seq,
+		Offset:    offset,
+		Size:      size,
+	}
+	if storage2.HasBlobRef(r.Flags) {
+		h.Value = r.Value
+	}
+	if r.Flags&storage2.FlagEncrypted == 0 {
+		return h, nil
+	}
+	if fm.cipher == nil {
+		return HintEntry{}, ErrNoKeyProvider
+	}
+	k, err := fm.cipher.current()
+	if err != nil {
+		return HintEntry{}, err
+	}
+	h.Key = k.sealHintKey(r.Key, fileID, offset)
+	return h, nil
+}
+
+// NeedsRekey 判断原始记录或 hint 是否需要用当前密钥重写: 开启加密后的未加密记录，或使用了旧密钥的记录
+// storedKey 为解密前记录或 hint 中的键
+func (fm *FileManager) NeedsRekey(flags uint32, storedKey []byte) bool {
+	if fm.cipher == nil {
+		return false
+	}
+	if flags&storage2.FlagEncrypted == 0 {
+		return true
+	}
+	k, err := fm.cipher.current()
+	if err != nil || len(storedKey) < keyIDSize {
+		return false
+	}
+	return binary.BigEndian.Uint32(storedKey) != k.id
+}
+
+// SealBlob 加密元数据文件内容，未开启加密时原样返回
+func (fm *FileManager) SealBlob(data []byte) ([]byte, error) {
+	if fm.cipher == nil {
+		return data, nil
+	}
+	return fm.cipher.sealBlob(data)
+}
+
+// OpenBlob 解密 SealBlob 的结果，没有加密魔数的旧文件原样返回
+func (fm *FileManager) OpenBlob(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, blobMagic) {
+		return data, nil
+	}
+	if fm.cipher == nil {
+		return nil, ErrNoKeyProvider
+	}
+	return fm.cipher.openBlob(data)
+}
@@ -10,6 +10,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,46 +23,167 @@ type FileManager struct {
 	maxFileSize  int64
 	maxOpenFiles int
 	syncInterval time.Duration
+	durability   storage2.DurabilityMode
+	laneCount    int
+	readOnly     bool // 只读打开：不创建、不轮转、不修改任何文件，也不 fsync
 
-	// 活动文件相关
-	activeFile atomic.Value // 存 *DataFile
-	fileID     atomic.Int32 // 下一个文件ID
-	fileMu     sync.Mutex   // 轮转文件的锁
+	// 数据目录锁，读写打开时 dirLock 为 LOCK 的排它锁，只读打开时为 LOCK.readers 的共享锁
+	dirLock    *os.File
+	readerLock *os.File // 写入者持有的 LOCK.readers 句柄，删除旧文件前加排它锁
+
+	// 值压缩
+	compression       storage2.CompressionType
+	codec             storage2.CodecID
+	compressThreshold int
+
+	// 静态加密，未配置密钥时 cipher 为 nil
+	keyProvider storage2.KeyProvider
+	cipher      *recordCipher
+
+	// 序列号由写线程在编码时分配，seq 为最近分配的，written 为不超过它的记录都已写入文件的最大序列号；加载时从清单及已有记录中恢复
+	seq     atomic.Uint64
+	written atomic.Uint64
+	seqMu   sync.Mutex // 多个写入通道时保护各通道已分配未写入的序列号
+
+	// 写入通知，有订阅者等待时才分配，written 推进后关闭并置空
+	notifyMu sync.Mutex
+	notifyCh chan struct{}
+
+	// 写入通道，每个通道有独立的写线程与活动文件，键按哈希分配到通道
+	lanes  []*writeLane
+	fileID atomic.Int32 // 下一个文件ID
+
+	// 正在写入的 blob 文件，第一次写入大值时创建
+	blobMu   sync.Mutex
+	blobFile *storage2.DataFile
+
+	// 上次运行时各通道的活动文件，可能存在未写完的尾部记录
+	prevActive []int
+
+	// 数据文件清单，记录有效文件集合及合并进度
+	manifest   *Manifest
+	manifestMu sync.Mutex
 
 	// 打开的文件管理
 	openFiles    *lru.Cache[int, *os.File]
-	sync.RWMutex // 读多写少，用于 openFiles 的双检锁
+	mmap         *mmapCache // 已封存数据文件的只读映射，为 nil 时不映射
+	sync.RWMutex            // 读多写少，用于 openFiles 的双检锁
 
 	// 异步写线程
-	writeChan  chan AsyncWriteReq
 	stopChan   chan struct{}
 	wg         sync.WaitGroup // 用于等待异步写线程退出
 	syncTicker *time.Ticker   // 定期 Sync 定时器
 }
 
+// writeLane 一个写入通道，只由自己的写线程写入活动文件
+type writeLane struct {
+	id         int
+	writeChan  chan AsyncWriteReq
+	activeFile atomic.Value // 存 *DataFile
+	fileMu     sync.Mutex   // 轮转文件的锁
+	hintWriter *HintWriter  // 活动文件对应的 hint 写入器，轮转时提交
+	pendingSeq uint64       // 已分配、尚未写入的最小序列号，0 表示没有，受 fm.seqMu 保护
+}
+
+// active 获取通道当前的活动文件
+func (l *writeLane) active() *storage2.DataFile {
+	val := l.activeFile.Load()
+	if val == nil {
+		return nil
+	}
+	return val.(*storage2.DataFile)
+}
+
 // AsyncWriteReq/Resp
 type AsyncWriteReq struct {
-	DataByte []byte
-	Resp     chan AsyncWriteResp
+	Key       []byte // 以下字段用于生成 hint 记录
+	Flags     uint32
+	Timestamp int64
+	Resp      chan AsyncWriteResp
+
+	seal bool          // 仅封存当前活动文件，不写入数据
+	hold chan struct{} // 封存后暂停写入直到关闭，用于同时封存所有通道
+
+	batch []AsyncWriteReq // 批量写入的各条记录，一次写入同一个文件的连续位置
+
+	// 序列号按写入顺序分配，加密的 nonce 取决于写入位置，因此记录在写线程确定位置后才编码
+	record *storage2.Record
+	key    *cipherKey // 开启加密时使用的密钥
+	size   int        // 编码后的长度
+	seq    uint64     // 编码时分配的序列号
+}
+
+// length 记录编码后的长度
+func (req *AsyncWriteReq) length() int {
+	return req.size
 }
 
 type AsyncWriteResp struct {
-	Entry storage2.Entry
-	Err   error
+	Entry   storage2.Entry
+	Entries []storage2.Entry // 批量写入时各条记录的位置
+	Err     error
+}
+
+// Option FileManager 的可选配置
+type Option func(fm *FileManager)
+
+// WithDurability 设置写入持久化模式，默认为 interval
+func WithDurability(mode storage2.DurabilityMode) Option {
+	return func(fm *FileManager) {
+		fm.durability = mode
+	}
 }
 
+// WithCompression 对长度不小于 threshold 的值使用指定算法压缩
+func WithCompression(compression storage2.CompressionType, threshold int) Option {
+	return func(fm *FileManager) {
+		fm.compression = compression
+		fm.compressThreshold = threshold
+	}
+}
+
+// WithKeyProvider 开启静态加密
+func WithKeyProvider(provider storage2.KeyProvider) Option {
+	return func(fm *FileManager) {
+		fm.keyProvider = provider
+	}
+}
+
+// WithMmap 以只读内存映射读取已封存的数据文件，映射总字节数不超过 budget
+func WithMmap(budget int64) Option {
+	return func(fm *FileManager) {
+		if budget > 0 {
+			fm.mmap = newMmapCache(budget)
+		}
+	}
+}
+
+// WithReadOnly 以只读方式打开数据目录，可与写入者同时打开，看到的是打开时已写入的数据
+func WithReadOnly() Option {
+	return func(fm *FileManager) {
+		fm.readOnly = true
+	}
+}
+
+// WithWriteLanes 使用 n 个写入通道并行写入，每个通道有独立的活动文件，默认为 1
+func WithWriteLanes(n int) Option {
+	return func(fm *FileManager) {
+		fm.laneCount = max(n, 1)
+	}
+}
+
+// maxGroupSize group 模式下单次合并的最大写请求数
+const maxGroupSize = 256
+
 // NewFileManager 创建 FileManager 并完成初始化
 func NewFileManager(
 	dataDir string,
 	maxFileSize int64,
 	maxOpenFiles int,
 	syncInterval time.Duration,
+	opts ...Option,
 ) (*FileManager, error) {
 
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
-	}
-
 	cache, err := lru.New[int, *os.File](maxOpenFiles)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create lru cache: %w", err)
@@ -72,79 +195,186 @@ func NewFileManager(
 		maxOpenFiles: maxOpenFiles,
 		syncInterval: syncInterval,
 		openFiles:    cache,
-		writeChan:    make(chan AsyncWriteReq, 1024),
 		stopChan:     make(chan struct{}),
 		syncTicker:   time.NewTicker(syncInterval),
+		durability:   storage2.DurabilityInterval,
+		laneCount:    1,
+	}
+	for _, opt := range opts {
+		opt(fm)
+	}
+	if fm.readOnly {
+		if _, err := os.Stat(dataDir); err != nil {
+			return nil, fmt.Errorf("open data directory failed: %w", err)
+		}
+	} else if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := fm.acquireDirLock(); err != nil {
+		return nil, err
+	}
+	fm.lanes = make([]*writeLane, fm.laneCount)
+	for i := range fm.lanes {
+		fm.lanes[i] = &writeLane{id: i, writeChan: make(chan AsyncWriteReq, 1024)}
+	}
+	if fm.codec, err = codecID(fm.compression); err != nil {
+		fm.releaseDirLock()
+		return nil, err
+	}
+	if fm.keyProvider != nil {
+		if fm.cipher, err = newRecordCipher(fm.keyProvider); err != nil {
+			fm.releaseDirLock()
+			return nil, err
+		}
 	}
 
 	// 初始化，找出目前已有的最大文件编号 + 1
 	if err := fm.initialize(); err != nil {
+		fm.releaseDirLock()
 		return nil, err
 	}
+	if fm.readOnly {
+		fm.syncTicker.Stop()
+		return fm, nil
+	}
 
-	// 启动异步写线程
-	fm.wg.Add(1)
-	go fm.processWrites()
+	// 启动各通道的异步写线程
+	for _, l := range fm.lanes {
+		fm.wg.Add(1)
+		go fm.processWrites(l)
+	}
 
-	// 启动定时 fsync 线程
-	fm.wg.Add(1)
-	go fm.autoSync()
+	// 启动定时 fsync 线程，其余模式在写线程中 fsync 或完全不 fsync
+	if fm.durability == storage2.DurabilityInterval {
+		fm.wg.Add(1)
+		go fm.autoSync()
+	}
 
 	return fm, nil
 }
 
-// initialize 找出现存文件的最大编号，打开它作为活动文件，或创建新文件
+// initialize 恢复清单，找出现存文件的最大编号，创建新的活动文件
+// 上次的活动文件不再追加写入，而是作为不可变文件封存，
+// 这样每个非活动文件都能拥有完整的 hint 文件
 func (fm *FileManager) initialize() error {
-	files, err := os.ReadDir(fm.dir)
+	if err := fm.recoverManifest(); err != nil {
+		return err
+	}
+
+	// 目录中可能残留清单之外的文件，编号同样不能复用；已删除文件的编号由清单中的 NextID 保证不复用
+	ids, err := ListDataFileIDs(fm.dir)
+	if err != nil {
+		return err
+	}
+	blobIDs, err := ListBlobFileIDs(fm.dir)
 	if err != nil {
-		return fmt.Errorf("read directory failed: %w", err)
+		return err
 	}
+	ids = append(append(ids, blobIDs...), fm.manifest.Blobs...)
 	var maxID int
-	for _, f := range files {
-		var id int
-		_, err := fmt.Sscanf(f.Name(), storage2.FilePrefix+"%d"+storage2.FileSuffix, &id)
-		if err == nil && id > maxID {
+	for _, id := range append(ids, fm.manifest.Files...) {
+		if id > maxID {
 			maxID = id
 		}
 	}
 	// 设置下一个可用文件 ID
-	fm.fileID.Store(int32(maxID + 1))
+	fm.fileID.Store(int32(max(maxID+1, fm.manifest.NextID)))
+	// 合并可能丢弃序列号最大的记录，清单中保存的序列号保证不会回退
+	fm.seq.Store(fm.manifest.LastSeq)
+	fm.written.Store(fm.manifest.LastSeq)
+
+	// 清单记录了各通道的活动文件，旧版本清单中活动文件总是最后一个文件
+	fm.prevActive = fm.manifest.Active
+	if len(fm.prevActive) == 0 && len(fm.manifest.Files) > 0 {
+		fm.prevActive = fm.manifest.Files[len(fm.manifest.Files)-1:]
+	}
+	fm.manifest.Active = make([]int, len(fm.lanes))
+	if fm.readOnly {
+		return nil
+	}
 
-	if maxID > 0 {
-		// 重新打开旧的最大编号文件，用于后续追加
-		filePath := filepath.Join(fm.dir, fmt.Sprintf("%s%d%s", storage2.FilePrefix, maxID, storage2.FileSuffix))
-		file, err := os.OpenFile(filePath, os.O_RDWR, 0644)
-		if err != nil {
-			return fmt.Errorf("open active file failed: %w", err)
+	reuse := 0
+	for _, l := range fm.lanes {
+		// 上次的活动文件为空时直接复用，避免每次启动都产生空文件
+		reused := false
+		for ; reuse < len(fm.prevActive) && !reused; reuse++ {
+			var err error
+			if reused, err = fm.reuseEmpty(l, fm.prevActive[reuse]); err != nil {
+				return err
+			}
 		}
-		stat, err := file.Stat()
-		if err != nil {
-			file.Close()
-			return fmt.Errorf("stat file failed: %w", err)
-		}
-		df := &storage2.DataFile{
-			ID:   maxID,
-			Path: filePath,
-			File: file,
-		}
-		df.Offset.Store(stat.Size()) // 活动文件的当前写偏移
-		fm.activeFile.Store(df)
-		fm.openFiles.Add(maxID, file)
-	} else {
-		// 没有任何历史文件，创建新的
-		if _, err := fm.rotateFile(); err != nil {
+		if reused {
+			continue
+		}
+		// 创建新的活动文件
+		if _, err := fm.rotateFile(l); err != nil {
 			return fmt.Errorf("initial rotate failed: %v", err)
 		}
 	}
 	return nil
 }
 
+// reuseEmpty 文件为空时将其复用为通道的活动文件
+func (fm *FileManager) reuseEmpty(l *writeLane, fileID int) (bool, error) {
+	if !fm.manifest.Contains(fileID) {
+		return false, nil
+	}
+	filePath := DataFilePath(fm.dir, fileID)
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return false, fmt.Errorf("stat file failed: %w", err)
+	}
+	if stat.Size() != 0 && stat.Size() != FileHeaderSize {
+		return false, nil
+	}
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0644)
+	if err != nil {
+		return false, fmt.Errorf("open active file failed: %w", err)
+	}
+	// 只有文件头的文件同样为空；旧格式的空文件补写文件头
+	var start int64
+	if stat.Size() == FileHeaderSize {
+		h, err := ReadFileHeader(file)
+		if err != nil || h.Outdated() {
+			file.Close()
+			return false, nil
+		}
+		start = h.DataStart()
+	} else if start, err = writeFileHeader(file, fm.newFileHeader()); err != nil {
+		file.Close()
+		return false, err
+	}
+	hw, err := NewHintWriter(fm.dir, fileID)
+	if err != nil {
+		file.Close()
+		return false, err
+	}
+	df := &storage2.DataFile{
+		ID:   fileID,
+		Path: filePath,
+		File: file,
+	}
+	df.Offset.Store(start)
+	l.activeFile.Store(df)
+	l.hintWriter = hw
+	fm.openFiles.Add(fileID, file)
+	fm.manifest.Active[l.id] = fileID
+	return true, nil
+}
+
 // WriteAsync 对外提供的异步写入接口，返回结果的 chan
+// 记录已带有序列号时保留原序列号，用于搬移 blob 后重写指针，否则按写入顺序分配
 func (fm *FileManager) WriteAsync(r *storage2.Record) <-chan AsyncWriteResp {
 	result := make(chan AsyncWriteResp, 1)
+	if fm.readOnly {
+		result <- AsyncWriteResp{Err: err_def.ErrReadOnly}
+		close(result)
+		return result
+	}
 
-	// 编码成二进制
-	data, err := encodeRecord(r)
+	// 按配置压缩值后编码成二进制
+	r = fm.compressRecord(r)
+	req, err := fm.newWriteReq(r)
 	if err != nil {
 		// 如果编码失败，直接返回错误
 		go func() {
@@ -154,13 +384,8 @@ func (fm *FileManager) WriteAsync(r *storage2.Record) <-chan AsyncWriteResp {
 		return result
 	}
 
-	req := AsyncWriteReq{
-		DataByte: data,
-		Resp:     make(chan AsyncWriteResp, 1),
-	}
-
 	select {
-	case fm.writeChan <- req:
+	case fm.laneFor(r.Key).writeChan <- req:
 		// 异步读出写结果再转发
 		go func() {
 			res := <-req.Resp
@@ -177,16 +402,244 @@ func (fm *FileManager) WriteAsync(r *storage2.Record) <-chan AsyncWriteResp {
 	return result
 }
 
-// processWrites 消费 fm.writeChan，执行实际写入
-func (fm *FileManager) processWrites() {
+// WriteBatchAsync 将一组记录作为整体写入，所有记录位于同一个文件的连续位置，并通过一次写入提交
+// 返回结果的 Entries 与 records 一一对应
+func (fm *FileManager) WriteBatchAsync(records []*storage2.Record) <-chan AsyncWriteResp {
+	result := make(chan AsyncWriteResp, 1)
+	if fm.readOnly {
+		result <- AsyncWriteResp{Err: err_def.ErrReadOnly}
+		close(result)
+		return result
+	}
+
+	req := AsyncWriteReq{
+		batch: make([]AsyncWriteReq, 0, len(records)),
+		Resp:  make(chan AsyncWriteResp, 1),
+	}
+	for _, r := range records {
+		sub, err := fm.newWriteReq(fm.compressRecord(r))
+		if err != nil {
+			result <- AsyncWriteResp{Err: err}
+			close(result)
+			return result
+		}
+		req.batch = append(req.batch, sub)
+	}
+
+	// 批量写入整体进入第一条记录所在的通道，保证位于同一个文件
+	select {
+	case fm.laneFor(records[0].Key).writeChan <- req:
+		go func() {
+			res := <-req.Resp
+			result <- res
+			close(result)
+		}()
+	case <-fm.stopChan:
+		result <- AsyncWriteResp{Err: err_def.ErrDBClosed}
+		close(result)
+	}
+	return result
+}
+
+// laneFor 按键的哈希选择写入通道，同一个键总是进入同一个通道
+func (fm *FileManager) laneFor(key []byte) *writeLane {
+	if len(fm.lanes) == 1 {
+		return fm.lanes[0]
+	}
+	// FNV-1a
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return fm.lanes[h%uint32(len(fm.lanes))]
+}
+
+// newWriteReq 构造写请求，编码推迟到写线程中进行
+func (fm *FileManager) newWriteReq(r *storage2.Record) (AsyncWriteReq, error) {
+	if err := checkRecord(r); err != nil {
+		return AsyncWriteReq{}, err
+	}
+	req := AsyncWriteReq{
+		Key:       r.Key,
+		Flags:     r.Flags,
+		Timestamp: r.Timestamp,
+		Resp:      make(chan AsyncWriteResp, 1),
+		record:    r,
+		size:      storage2.HeaderSize + storage2.SeqSize + len(r.Key) + len(r.Value) + 8,
+	}
+
+	if fm.cipher == nil {
+		return req, nil
+	}
+
+	if 4+len(r.Key)+len(r.Value)+16 > storage2.MaxValueSize {
+		return AsyncWriteReq{}, fmt.Errorf("%w: encrypted record exceeds maximum %d", err_def.ErrValueTooLarge, storage2.MaxValueSize)
+	}
+	key, err := fm.cipher.current()
+	if err != nil {
+		return AsyncWriteReq{}, err
+	}
+	req.key, req.size = key, sealedSize(r)
+	return req, nil
+}
+
+// encodeAt 分配序列号并返回写入 (fileID, offset) 处的记录编码，由写线程调用
+func (fm *FileManager) encodeAt(l *writeLane, req *AsyncWriteReq, fileID int, offset int64) []byte {
+	r := *req.record
+	if r.Seq == 0 {
+		r.Seq = fm.allocSeq(l)
+	}
+	req.seq = r.Seq
+
+	stored := &r
+	if req.key != nil {
+		stored = req.key.seal(&r, fileID, offset)
+	}
+	// 长度已在 newWriteReq 中校验，这里不会失败
+	data, _ := encodeRecord(stored)
+	return data
+}
+
+// allocSeq 为通道分配下一个序列号，并记录通道中尚未写入的最小序列号
+func (fm *FileManager) allocSeq(l *writeLane) uint64 {
+	if len(fm.lanes) == 1 {
+		return fm.seq.Add(1)
+	}
+	fm.seqMu.Lock()
+	defer fm.seqMu.Unlock()
+	seq := fm.seq.Add(1)
+	if l.pendingSeq == 0 {
+		l.pendingSeq = seq
+	}
+	return seq
+}
+
+// LastSequence 返回已写入文件的序列号，不超过它的记录都已写入；多个通道时取各通道尚未写入的最小序列号之前
+func (fm *FileManager) LastSequence() uint64 {
+	return fm.written.Load()
+}
+
+// WriteNotify 返回在下一次写入完成时关闭的 channel，FileManager 关闭时同样会关闭
+// 调用方应先获取 channel 再检查数据，避免错过通知
+func (fm *FileManager) WriteNotify() <-chan struct{} {
+	fm.notifyMu.Lock()
+	defer fm.notifyMu.Unlock()
+	select {
+	case <-fm.stopChan:
+		// 已关闭时不再保存，避免之后被 notifyWaiters 重复关闭
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	default:
+	}
+	if fm.notifyCh == nil {
+		fm.notifyCh = make(chan struct{})
+	}
+	return fm.notifyCh
+}
+
+// Done 返回 FileManager 关闭时关闭的 channel
+func (fm *FileManager) Done() <-chan struct{} {
+	return fm.stopChan
+}
+
+// advanceWritten 写入文件后推进已写入的序列号并唤醒等待的订阅者，由写线程调用，写入失败时同样调用以释放已分配的序列号
+// 保留原序列号的记录可能小于已分配的序列号；单个通道时写线程是唯一的分配者，写入后已分配的序列号都已落入文件，
+// 多个通道时不能越过其他通道已分配、尚未写入的序列号
+func (fm *FileManager) advanceWritten(l *writeLane) {
+	if len(fm.lanes) == 1 {
+		fm.written.Store(fm.seq.Load())
+		fm.notifyWaiters()
+		return
+	}
+
+	fm.seqMu.Lock()
+	l.pendingSeq = 0
+	written := fm.seq.Load()
+	for _, other := range fm.lanes {
+		if other.pendingSeq != 0 {
+			written = min(written, other.pendingSeq-1)
+		}
+	}
+	if written > fm.written.Load() {
+		fm.written.Store(written)
+	}
+	fm.seqMu.Unlock()
+	fm.notifyWaiters()
+}
+
+func (fm *FileManager) notifyWaiters() {
+	fm.notifyMu.Lock()
+	if fm.notifyCh != nil {
+		close(fm.notifyCh)
+		fm.notifyCh = nil
+	}
+	fm.notifyMu.Unlock()
+}
+
+// ObserveSequence 加载已有记录时推进序列号，保证之后分配的序列号大于所有已有记录
+func (fm *FileManager) ObserveSequence(seq uint64) {
+	for {
+		cur := fm.seq.Load()
+		if seq <= cur || fm.seq.CompareAndSwap(cur, seq) {
+			break
+		}
+	}
+	for {
+		cur := fm.written.Load()
+		if seq <= cur || fm.written.CompareAndSwap(cur, seq) {
+			return
+		}
+	}
+}
+
+// hintAt 生成写入 (fileID, offset) 处记录的 hint，加密记录的键同样加密
+func (fm *FileManager) hintAt(req *AsyncWriteReq, fileID int, offset int64) HintEntry {
+	h := HintEntry{
+		Key:       req.Key,
+		Flags:     req.Flags,
+		Timestamp: req.Timestamp,
+		Seq:       req.seq,
+		Offset:    offset,
+		Size:      uint32(req.length()),
+	}
+	if storage2.HasBlobRef(req.Flags) {
+		h.Value = req.record.Value
+	}
+	if req.key != nil {
+		h.Key = req.key.sealHintKey(req.Key, fileID, offset)
+		h.Flags |= storage2.FlagEncrypted
+	}
+	return h
+}
+
+// processWrites 消费通道的 writeChan，执行实际写入
+func (fm *FileManager) processWrites(l *writeLane) {
 	defer fm.wg.Done()
 	for {
 		select {
-		case req, ok := <-fm.writeChan:
+		case req, ok := <-l.writeChan:
 			if !ok {
 				return
 			}
-			entry, err := fm.syncWrite(req.DataByte)
+			if req.seal {
+				fm.handleSeal(l, req)
+				continue
+			}
+			if req.batch != nil {
+				fm.handleBatch(l, req)
+				continue
+			}
+			if fm.durability == storage2.DurabilityGroup {
+				fm.processGroup(l, req)
+				continue
+			}
+
+			entry, err := fm.syncWrite(l, req)
+			if err == nil && fm.durability == storage2.DurabilityAlways {
+				err = fm.syncActive(l)
+			}
 			req.Resp <- AsyncWriteResp{Entry: entry, Err: err}
 			close(req.Resp)
 		case <-fm.stopChan:
@@ -195,16 +648,207 @@ func (fm *FileManager) processWrites() {
 	}
 }
 
+// processGroup 收集队列中已到达的写请求，合并为一次写入和一次 fsync
+func (fm *FileManager) processGroup(l *writeLane, first AsyncWriteReq) {
+	group := []AsyncWriteReq{first}
+	var pending *AsyncWriteReq // 收集时遇到的封存或批量写入请求，需在本组之后单独执行
+collect:
+	for len(group) < maxGroupSize {
+		select {
+		case req, ok := <-l.writeChan:
+			if !ok {
+				break collect
+			}
+			if req.seal || req.batch != nil {
+				pending = &req
+				break collect
+			}
+			group = append(group, req)
+		default:
+			break collect
+		}
+	}
+
+	entries, errs := fm.writeGroup(l, group)
+	syncErr := fm.syncActive(l)
+	for i, req := range group {
+		err := errs[i]
+		if err == nil {
+			err = syncErr
+		}
+		req.Resp <- AsyncWriteResp{Entry: entries[i], Err: err}
+		close(req.Resp)
+	}
+
+	if pending != nil {
+		if pending.seal {
+			fm.handleSeal(l, *pending)
+		} else {
+			fm.handleBatch(l, *pending)
+		}
+	}
+}
+
+// writeGroup 将一组记录拼接后写入活动文件，空间不足时轮转并拆分
+func (fm *FileManager) writeGroup(l *writeLane, group []AsyncWriteReq) ([]storage2.Entry, []error) {
+	entries := make([]storage2.Entry, len(group))
+	errs := make([]error, len(group))
+
+	for i := 0; i < len(group); {
+		current := l.active()
+		if current == nil {
+			for ; i < len(group); i++ {
+				errs[i] = err_def.ErrFileNotFound
+			}
+			break
+		}
+		offset := current.Offset.Load()
+		if current.Closed.Load() || (offset > FileHeaderSize && offset+int64(group[i].length()) > fm.maxFileSize) {
+			if _, err := fm.rotateFile(l); err != nil {
+				for ; i < len(group); i++ {
+					errs[i] = err
+				}
+				break
+			}
+			continue
+		}
+
+		// 尽量多地拼接能放入当前文件的记录，单条超限的记录独占一个文件
+		j, size := i, int64(0)
+		for j < len(group) && (j == i || offset+size+int64(group[j].length()) <= fm.maxFileSize) {
+			size += int64(group[j].length())
+			j++
+		}
+		buf := make([]byte, 0, size)
+		for k := i; k < j; k++ {
+			buf = append(buf, fm.encodeAt(l, &group[k], current.ID, offset+int64(len(buf)))...)
+		}
+
+		current.Offset.Add(size)
+		if n, err := current.File.WriteAt(buf, offset); err != nil || n != len(buf) {
+			_ = current.File.Close()
+			current.Closed.Store(true)
+			fm.advanceWritten(l)
+			for k := i; k < j; k++ {
+				errs[k] = err_def.ErrWriteFailed
+			}
+			i = j
+			continue
+		}
+		fm.advanceWritten(l)
+
+		pos := offset
+		for k := i; k < j; k++ {
+			req := &group[k]
+			size := uint32(req.length())
+			if l.hintWriter != nil {
+				_ = l.hintWriter.Write(fm.hintAt(req, current.ID, pos))
+			}
+			entries[k] = storage2.Entry{
+				FileID:    current.ID,
+				Offset:    pos,
+				Size:      size,
+				Timestamp: req.Timestamp,
+				Seq:       req.seq,
+			}
+			pos += int64(size)
+		}
+		i = j
+	}
+	return entries, errs
+}
+
+// handleBatch 处理批量写入请求，由写线程调用
+func (fm *FileManager) handleBatch(l *writeLane, req AsyncWriteReq) {
+	entries, err := fm.writeBatch(l, req.batch)
+	if err == nil && (fm.durability == storage2.DurabilityAlways || fm.durability == storage2.DurabilityGroup) {
+		err = fm.syncActive(l)
+	}
+	req.Resp <- AsyncWriteResp{Entries: entries, Err: err}
+	close(req.Resp)
+}
+
+// writeBatch 将一组记录拼接后一次写入活动文件，不会拆分到多个文件；空间不足时先轮转
+func (fm *FileManager) writeBatch(l *writeLane, batch []AsyncWriteReq) ([]storage2.Entry, error) {
+	var length int64
+	for i := range batch {
+		length += int64(batch[i].length())
+	}
+
+	for {
+		current := l.active()
+		if current == nil {
+			return nil, err_def.ErrFileNotFound
+		}
+		offset := current.Offset.Load()
+		if current.Closed.Load() || (offset > FileHeaderSize && offset+length > fm.maxFileSize) {
+			if _, err := fm.rotateFile(l); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		buf := make([]byte, 0, length)
+		for i := range batch {
+			buf = append(buf, fm.encodeAt(l, &batch[i], current.ID, offset+int64(len(buf)))...)
+		}
+
+		current.Offset.Add(length)
+		if n, err := current.File.WriteAt(buf, offset); err != nil || n != len(buf) {
+			_ = current.File.Close()
+			current.Closed.Store(true)
+			fm.advanceWritten(l)
+			if _, rotateErr := fm.rotateFile(l); rotateErr != nil {
+				return nil, rotateErr
+			}
+			return nil, err_def.ErrWriteFailed
+		}
+		fm.advanceWritten(l)
+
+		entries := make([]storage2.Entry, len(batch))
+		pos := offset
+		for i := range batch {
+			req := &batch[i]
+			size := uint32(req.length())
+			if l.hintWriter != nil {
+				_ = l.hintWriter.Write(fm.hintAt(req, current.ID, pos))
+			}
+			entries[i] = storage2.Entry{
+				FileID:    current.ID,
+				Offset:    pos,
+				Size:      size,
+				Timestamp: req.Timestamp,
+				Seq:       req.seq,
+			}
+			pos += int64(size)
+		}
+		return entries, nil
+	}
+}
+
+// syncActive 对通道的活动文件做 fsync
+func (fm *FileManager) syncActive(l *writeLane) error {
+	current := l.active()
+	if current == nil || current.Closed.Load() {
+		return nil
+	}
+	if err := current.File.Sync(); err != nil {
+		return fmt.Errorf("%w: sync failed: %v", err_def.ErrWriteFailed, err)
+	}
+	return nil
+}
+
 // syncWrite 内部真正执行写入的函数
-func (fm *FileManager) syncWrite(data []byte) (storage2.Entry, error) {
+func (fm *FileManager) syncWrite(l *writeLane, req AsyncWriteReq) (storage2.Entry, error) {
+	length := int64(req.length())
 	for {
-		current := fm.GetActiveFile()
+		current := l.active()
 		if current == nil {
 			return storage2.Entry{}, err_def.ErrFileNotFound
 		}
 		if current.Closed.Load() {
 			// 已关闭，进行轮转
-			_, err := fm.rotateFile()
+			_, err := fm.rotateFile(l)
 			if err != nil {
 				return storage2.Entry{}, err
 			}
@@ -213,17 +857,18 @@ func (fm *FileManager) syncWrite(data []byte) (storage2.Entry, error) {
 
 		// 检查剩余空间，如果不够则轮转
 		offsetNow := current.Offset.Load()
-		if offsetNow+int64(len(data)) > fm.maxFileSize {
-			_, err := fm.rotateFile()
+		if offsetNow > FileHeaderSize && offsetNow+length > fm.maxFileSize {
+			_, err := fm.rotateFile(l)
 			if err != nil {
 				return storage2.Entry{}, err
 			}
 			continue
 		}
 
-		// 计算写入起始位置
-		newOffset := current.Offset.Add(int64(len(data)))
-		writePos := newOffset - int64(len(data))
+		// 写入起始位置，只有写线程会推进 Offset
+		writePos := offsetNow
+		data := fm.encodeAt(l, &req, current.ID, writePos)
+		current.Offset.Add(length)
 
 		// 执行写入
 		n, err := current.File.WriteAt(data, writePos)
@@ -231,26 +876,73 @@ func (fm *FileManager) syncWrite(data []byte) (storage2.Entry, error) {
 			// 写失败，则关闭当前文件并轮转
 			_ = current.File.Close()
 			current.Closed.Store(true)
+			fm.advanceWritten(l)
 
-			_, rotateErr := fm.rotateFile()
+			_, rotateErr := fm.rotateFile(l)
 			if rotateErr != nil {
 				return storage2.Entry{}, rotateErr
 			}
 			return storage2.Entry{}, err_def.ErrWriteFailed
 		}
+		fm.advanceWritten(l)
+
+		// 写成功，追加 hint 记录；hint 写失败不影响数据写入，加载时会回退到全量扫描
+		if l.hintWriter != nil {
+			_ = l.hintWriter.Write(fm.hintAt(&req, current.ID, writePos))
+		}
 
-		// 写成功，返回对应的索引信息
+		// 返回对应的索引信息
 		return storage2.Entry{
 			FileID:    current.ID,
 			Offset:    writePos,
 			Size:      uint32(len(data)),
-			Timestamp: time.Now().UnixNano(),
+			Timestamp: req.Timestamp,
+			Seq:       req.seq,
 		}, nil
 	}
 }
 
 // Read 按 Entry 信息从对应的文件偏移处读出数据并解码
 func (fm *FileManager) Read(entry storage2.Entry) (*storage2.Record, error) {
+	record, err := fm.readRecord(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// 二次校验
+	if success, err := validateChecksum(record); !success || err != nil {
+		return nil, err_def.ErrChecksumInvalid
+	}
+
+	// 透明解密、解压并读出分离存储的值，未加密、未压缩的旧记录原样返回
+	if err := fm.OpenRecord(record, entry.FileID, entry.Offset); err != nil {
+		return nil, fmt.Errorf("%w: %v", err_def.ErrReadFailed, err)
+	}
+	if err := decompressRecord(record); err != nil {
+		return nil, fmt.Errorf("%w: %v", err_def.ErrReadFailed, err)
+	}
+	if err := fm.resolveBlob(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// readRecord 读出并解码 Entry 指向的记录，已封存的文件优先从映射中读取
+// 解码时复制出键值，返回后映射可以安全解除
+func (fm *FileManager) readRecord(entry storage2.Entry) (*storage2.Record, error) {
+	if fm.mmap != nil {
+		if !fm.IsActive(entry.FileID) {
+			if data, release, ok := fm.mmap.acquire(entry.FileID, DataFilePath(fm.dir, entry.FileID)); ok {
+				defer release()
+				end := entry.Offset + int64(entry.Size)
+				if entry.Offset < 0 || end > int64(len(data)) {
+					return nil, fmt.Errorf("%w: unexpected EOF (fileID=%d)", err_def.ErrReadFailed, entry.FileID)
+				}
+				return DecodeRecord(data[entry.Offset:end])
+			}
+		}
+	}
+
 	file, err := fm.GetFile(entry.FileID)
 	if err != nil {
 		return nil, err
@@ -265,67 +957,320 @@ func (fm *FileManager) Read(entry storage2.Entry) (*storage2.Record, error) {
 		}
 		return nil, fmt.Errorf("%w: %v", err_def.ErrReadFailed, err)
 	}
+	return DecodeRecord(buf)
+}
 
-	record, err := DecodeRecord(buf)
-	if err != nil {
-		return nil, err
-	}
-
-	// 二次校验
-	if success, err := validateChecksum(record); !success || err != nil {
-		return nil, err_def.ErrChecksumInvalid
+// MappedBytes 返回当前内存映射的总字节数，未开启映射时为 0
+func (fm *FileManager) MappedBytes() int64 {
+	if fm.mmap == nil {
+		return 0
 	}
-	return record, nil
+	return fm.mmap.mappedBytes()
 }
 
-// rotateFile 轮转当前活跃文件，创建新文件并设为活跃文件
-func (fm *FileManager) rotateFile() (*storage2.DataFile, error) {
-	fm.fileMu.Lock()
-	defer fm.fileMu.Unlock()
+// rotateFile 轮转通道当前的活跃文件，创建新文件并设为活跃文件
+func (fm *FileManager) rotateFile(l *writeLane) (*storage2.DataFile, error) {
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
 
-	oldFile := fm.GetActiveFile()
+	oldFile := l.active()
 	if oldFile != nil && !oldFile.Closed.Load() {
 		// 并非已经关闭则先关闭
 		oldFile.Closed.Store(true)
 		_ = oldFile.File.Sync()
 		_ = oldFile.File.Close()
 	}
+	if oldFile != nil {
+		// 缓存中的句柄已关闭，移除后读取时会重新打开
+		fm.Lock()
+		fm.openFiles.Remove(oldFile.ID)
+		fm.Unlock()
+	}
+	// 旧文件已封存，提交其 hint 文件
+	fm.commitHint(l)
 
-	fileID := int(fm.fileID.Load())
-	path := filepath.Join(fm.dir, fmt.Sprintf("%s%d%s", storage2.FilePrefix, fileID, storage2.FileSuffix))
+	fileID := fm.allocFileID()
+	path := DataFilePath(fm.dir, fileID)
 
 	// 以追加方式打开新文件，以便按 Offset 写
 	newF, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("create file failed: %w", err)
 	}
+	// 文件头在登记到清单前写好并刷盘，清单中的文件不会只有半个文件头
+	start, err := writeFileHeader(newF, fm.newFileHeader())
+	if err != nil {
+		_ = newF.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	// 文件创建后再登记到清单，崩溃后未登记的空文件不会被加载
+	fm.manifestMu.Lock()
+	prevActive := fm.manifest.Active[l.id]
+	fm.manifest.Files = append(fm.manifest.Files, fileID)
+	fm.manifest.Active[l.id] = fileID
+	err = fm.saveManifest()
+	if err != nil {
+		fm.manifest.Files = fm.manifest.Files[:len(fm.manifest.Files)-1]
+		fm.manifest.Active[l.id] = prevActive
+	}
+	fm.manifestMu.Unlock()
+	if err != nil {
+		_ = newF.Close()
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("save manifest failed: %w", err)
+	}
+
+	hw, err := NewHintWriter(fm.dir, fileID)
+	if err != nil {
+		_ = newF.Close()
+		return nil, err
+	}
+	l.hintWriter = hw
 
 	df := &storage2.DataFile{
 		ID:   fileID,
 		Path: path,
 		File: newF,
 	}
-	df.Offset.Store(0)
+	df.Offset.Store(start)
 
 	// 更新活跃文件
-	fm.activeFile.Store(df)
+	l.activeFile.Store(df)
 	fm.openFiles.Add(fileID, newF)
-	fm.fileID.Add(1)
 
 	return df, nil
 }
 
-// GetActiveFile 获取当前活跃文件
-func (fm *FileManager) GetActiveFile() *storage2.DataFile {
-	val := fm.activeFile.Load()
-	if val == nil {
+// allocFileID 分配一个新的文件编号，活动文件与合并输出文件共用
+func (fm *FileManager) allocFileID() int {
+	return int(fm.fileID.Add(1)) - 1
+}
+
+// Rotate 封存所有通道的活动文件，之后的写入进入新文件
+// 通过写入队列执行，保证在此之前提交的写入都落在被封存的文件中
+func (fm *FileManager) Rotate() error {
+	return fm.sealAll(nil)
+}
+
+// sealAll 向每个通道发送封存请求，所有通道都封存后以被封存的文件调用 fn，期间各通道暂停写入
+// 暂停保证 fn 看到的已封存文件恰好包含之前提交的全部写入
+func (fm *FileManager) sealAll(fn func(sealed []SealedFile) error) error {
+	if fm.readOnly {
+		return err_def.ErrReadOnly
+	}
+	hold := make(chan struct{})
+	defer close(hold)
+
+	var (
+		sealed   []SealedFile
+		firstErr error
+	)
+	for _, l := range fm.lanes {
+		req := AsyncWriteReq{seal: true, hold: hold, Resp: make(chan AsyncWriteResp, 1)}
+		select {
+		case l.writeChan <- req:
+			resp := <-req.Resp
+			if resp.Err != nil && firstErr == nil {
+				firstErr = resp.Err
+			}
+			if resp.Err == nil && resp.Entry.FileID >= 0 {
+				sealed = append(sealed, SealedFile{FileID: resp.Entry.FileID, Offset: resp.Entry.Offset})
+			}
+		case <-fm.stopChan:
+			return err_def.ErrDBClosed
+		}
+	}
+	if firstErr != nil || fn == nil {
+		return firstErr
+	}
+	return fn(sealed)
+}
+
+// sealActive 通道的活动文件非空时轮转，由写线程调用
+func (fm *FileManager) sealActive(l *writeLane) error {
+	if current := l.active(); current != nil && !current.Closed.Load() && current.Offset.Load() <= FileHeaderSize {
 		return nil
 	}
-	return val.(*storage2.DataFile)
+	_, err := fm.rotateFile(l)
+	return err
+}
+
+// handleSeal 处理封存请求，由写线程调用，请求带有 hold 时等到其关闭后才继续写入
+// 响应的 Entry 为被封存的文件及其长度，活动文件为空无需封存时 FileID 为 -1
+func (fm *FileManager) handleSeal(l *writeLane, req AsyncWriteReq) {
+	prev := l.active()
+	err := fm.sealActive(l)
+	resp := AsyncWriteResp{Entry: storage2.Entry{FileID: -1}, Err: err}
+	if prev != nil && l.active() != prev {
+		resp.Entry = storage2.Entry{FileID: prev.ID, Offset: prev.Offset.Load()}
+	}
+	req.Resp <- resp
+	close(req.Resp)
+	if req.hold != nil {
+		select {
+		case <-req.hold:
+		case <-fm.stopChan:
+		}
+	}
+}
+
+// LiveFileIDs 按回放顺序返回当前有效的数据文件编号，包含活动文件
+func (fm *FileManager) LiveFileIDs() []int {
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+	ids := make([]int, len(fm.manifest.Files))
+	copy(ids, fm.manifest.Files)
+	return ids
+}
+
+// EndOffsets 返回各有效数据文件当前的末尾位置，活动文件为已分配的写入位置
+// 先读取 LastSequence 再调用时，不超过该序列号的记录都位于返回的位置之前
+func (fm *FileManager) EndOffsets() (map[int]int64, error) {
+	active := make(map[int]int64, len(fm.lanes))
+	for _, l := range fm.lanes {
+		if current := l.active(); current != nil {
+			active[current.ID] = current.Offset.Load()
+		}
+	}
+
+	ends := make(map[int]int64)
+	for _, id := range fm.LiveFileIDs() {
+		if offset, ok := active[id]; ok {
+			ends[id] = offset
+			continue
+		}
+		file, err := fm.GetFile(id)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("stat data file %d failed: %w", id, err)
+		}
+		ends[id] = stat.Size()
+	}
+	return ends, nil
+}
+
+// ReadOnly 是否以只读方式打开
+func (fm *FileManager) ReadOnly() bool {
+	return fm.readOnly
+}
+
+// WasActive 判断文件是否为上次运行时某个通道的活动文件
+// 这些文件可能因崩溃留下不完整的尾部，加载时可以安全截断；只读打开时则可能正被写入者追加
+func (fm *FileManager) WasActive(fileID int) bool {
+	return slices.Contains(fm.prevActive, fileID)
+}
+
+// TruncateFile 将非活动文件截断到 size，用于丢弃损坏的尾部
+func (fm *FileManager) TruncateFile(fileID int, size int64) error {
+	if fm.readOnly {
+		return err_def.ErrReadOnly
+	}
+	if fm.IsActive(fileID) {
+		return fmt.Errorf("cannot truncate active file %d", fileID)
+	}
+	file, err := fm.GetFile(fileID)
+	if err != nil {
+		return err
+	}
+	if fm.mmap != nil {
+		fm.mmap.remove(fileID)
+	}
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("truncate file %d failed: %w", fileID, err)
+	}
+	return file.Sync()
+}
+
+// QuarantineFile 将数据文件复制到隔离目录，保留现场供人工排查
+func (fm *FileManager) QuarantineFile(fileID int) (string, error) {
+	if fm.readOnly {
+		return "", err_def.ErrReadOnly
+	}
+	dir := filepath.Join(fm.dir, QuarantineDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create quarantine directory failed: %w", err)
+	}
+
+	src, err := os.Open(DataFilePath(fm.dir, fileID))
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst := filepath.Join(dir, fmt.Sprintf("%s%d%s.%d", storage2.FilePrefix, fileID, storage2.FileSuffix, time.Now().Unix()))
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return "", err
+	}
+	return dst, out.Close()
+}
+
+// GetActiveFile 获取当前活跃文件，多个写入通道时返回第一个通道的活动文件
+func (fm *FileManager) GetActiveFile() *storage2.DataFile {
+	return fm.lanes[0].active()
+}
+
+// ActiveFileIDs 返回各通道当前活动文件的编号
+func (fm *FileManager) ActiveFileIDs() []int {
+	ids := make([]int, 0, len(fm.lanes))
+	for _, l := range fm.lanes {
+		if current := l.active(); current != nil {
+			ids = append(ids, current.ID)
+		}
+	}
+	return ids
+}
+
+// IsActive 判断文件是否为某个通道当前的活动文件
+func (fm *FileManager) IsActive(fileID int) bool {
+	for _, l := range fm.lanes {
+		if current := l.active(); current != nil && current.ID == fileID {
+			return true
+		}
+	}
+	return false
+}
+
+// Lanes 返回写入通道数
+func (fm *FileManager) Lanes() int {
+	return len(fm.lanes)
+}
+
+// SyncActive 对所有通道的活动文件做 fsync
+func (fm *FileManager) SyncActive() error {
+	for _, l := range fm.lanes {
+		l.fileMu.Lock()
+		err := fm.syncActive(l)
+		l.fileMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetFile 根据 fileID 从缓存中获取文件指针，若无则重新打开并放入缓存
+// 首次打开时校验文件头，损坏或版本不受支持的文件返回错误
 func (fm *FileManager) GetFile(fileID int) (*os.File, error) {
+	return fm.openCached(fileID, DataFilePath(fm.dir, fileID), fm.checkFileHeader)
+}
+
+// openCached 从缓存中获取文件句柄，未命中时打开 path 并放入缓存；数据文件与 blob 文件编号不重复，共用缓存
+// check 不为 nil 时在放入缓存前校验新打开的文件
+func (fm *FileManager) openCached(fileID int, path string, check func(fileID int, file *os.File) error) (*os.File, error) {
 	// 读锁检测
 	fm.RLock()
 	if file, ok := fm.openFiles.Get(fileID); ok {
@@ -343,8 +1288,11 @@ func (fm *FileManager) GetFile(fileID int) (*os.File, error) {
 		return file, nil
 	}
 
-	path := filepath.Join(fm.dir, fmt.Sprintf("%s%d%s", storage2.FilePrefix, fileID, storage2.FileSuffix))
-	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	flag := os.O_RDWR
+	if fm.readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0644)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, fmt.Errorf("%w: fileID=%d", err_def.ErrFileNotFound, fileID)
@@ -357,6 +1305,12 @@ func (fm *FileManager) GetFile(fileID int) (*os.File, error) {
 		file.Close()
 		return nil, fmt.Errorf("file stat failed (ID=%d): %w", fileID, err)
 	}
+	if check != nil {
+		if err := check(fileID, file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
 
 	fm.openFiles.Add(fileID, file)
 	return file, nil
@@ -368,11 +1322,9 @@ func (fm *FileManager) autoSync() {
 	for {
 		select {
 		case <-fm.syncTicker.C:
-			fm.fileMu.Lock()
-			if current := fm.GetActiveFile(); current != nil && !current.Closed.Load() {
-				_ = current.File.Sync()
-			}
-			fm.fileMu.Unlock()
+			// 先同步 blob 文件，保证落盘的指针引用的 blob 已经落盘
+			_ = fm.SyncBlob()
+			_ = fm.SyncActive()
 
 		case <-fm.stopChan:
 			return
@@ -387,56 +1339,220 @@ func (fm *FileManager) Close() error {
 	close(fm.stopChan)
 
 	// 关闭写入通道，让 processWrites 退出
-	close(fm.writeChan)
+	for _, l := range fm.lanes {
+		close(l.writeChan)
+	}
 	// 等待异步协程退出
 	fm.wg.Wait()
-
-	// 再加锁确保没有人再写文件
-	fm.fileMu.Lock()
-	defer fm.fileMu.Unlock()
-
-	// 关闭活跃文件
-	if current := fm.GetActiveFile(); current != nil && !current.Closed.Load() {
-		current.Closed.Store(true)
-		_ = current.File.Sync()
-		_ = current.File.Close()
+	fm.notifyWaiters()
+	fm.closeBlob()
+
+	for _, l := range fm.lanes {
+		// 再加锁确保没有人再写文件
+		l.fileMu.Lock()
+		// 关闭活跃文件
+		if current := l.active(); current != nil && !current.Closed.Load() {
+			current.Closed.Store(true)
+			_ = current.File.Sync()
+			_ = current.File.Close()
+		}
+		// 活动文件下次打开时会被封存，此时 hint 已完整，直接提交
+		fm.commitHint(l)
+		l.fileMu.Unlock()
 	}
 
 	// 将缓存里的文件都关闭
 	fm.Lock()
 	for _, file := range fm.openFiles.Values() {
-		_ = file.Sync()
+		if !fm.readOnly {
+			_ = file.Sync()
+		}
 		_ = file.Close()
 	}
 	fm.openFiles.Purge()
 	fm.Unlock()
 
+	if fm.mmap != nil {
+		fm.mmap.close()
+	}
+	fm.releaseDirLock()
 	return nil
 }
 
+// commitHint 提交通道当前活动文件的 hint，调用方需持有通道的 fileMu
+func (fm *FileManager) commitHint(l *writeLane) {
+	if l.hintWriter == nil {
+		return
+	}
+	_ = l.hintWriter.Commit()
+	l.hintWriter = nil
+}
+
 /* ------------------------------- 工具方法 -------------------------------- */
 
+// CorruptRecordError 扫描数据文件时遇到无法解码或校验失败的记录
+type CorruptRecordError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptRecordError) Error() string {
+	return fmt.Sprintf("corrupt record at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *CorruptRecordError) Unwrap() error {
+	return e.Err
+}
+
+// ScanRecords 从头顺序扫描数据文件，对每条完整记录回调 fn
+// 遇到不完整的尾部记录时停止，遇到校验失败时返回 *CorruptRecordError
+func ScanRecords(r io.ReaderAt, fn func(r *storage2.Record, offset int64, size uint32) error) error {
+	_, err := ScanRecordsFrom(r, 0, fn)
+	return err
+}
+
+// ScanRecordsFrom 从 start 开始顺序扫描，返回最后一条完整记录的结束位置
+func ScanRecordsFrom(r io.ReaderAt, start int64, fn func(r *storage2.Record, offset int64, size uint32) error) (int64, error) {
+	offset := start
+	header := make([]byte, storage2.HeaderSize)
+	for {
+		// 读取头部信息
+		n, err := r.ReadAt(header, offset)
+		if err != nil && err != io.EOF {
+			return offset, fmt.Errorf("read header failed: %w", err)
+		}
+		if n < storage2.HeaderSize {
+			return offset, nil
+		}
+
+		// 解析头部
+		recordSize, ok := recordSizeOf(header)
+		if !ok {
+			return offset, &CorruptRecordError{Offset: offset, Err: err_def.ErrDataLengthInvalid}
+		}
+
+		// 读取完整记录
+		record := make([]byte, recordSize)
+		n, err = r.ReadAt(record, offset)
+		if err != nil && err != io.EOF {
+			return offset, fmt.Errorf("read record failed: %w", err)
+		}
+		if n < int(recordSize) {
+			return offset, nil
+		}
+
+		// 解码记录
+		rec, err := DecodeRecord(record)
+		if err != nil {
+			return offset, &CorruptRecordError{Offset: offset, Err: err}
+		}
+
+		if err := fn(rec, offset, uint32(recordSize)); err != nil {
+			return offset, err
+		}
+		offset += recordSize
+	}
+}
+
+// FindNextRecord 从 from 开始逐字节查找下一条能完整解码的记录，用于跳过损坏区域
+func FindNextRecord(r io.ReaderAt, from, size int64) (int64, bool) {
+	header := make([]byte, storage2.HeaderSize)
+	for offset := from; offset+int64(storage2.HeaderSize)+8 <= size; offset++ {
+		if n, _ := r.ReadAt(header, offset); n < storage2.HeaderSize {
+			return 0, false
+		}
+		recordSize, ok := recordSizeOf(header)
+		if !ok || binary.BigEndian.Uint32(header[12:16]) == 0 {
+			continue
+		}
+		if offset+recordSize > size {
+			continue
+		}
+		record := make([]byte, recordSize)
+		if n, _ := r.ReadAt(record, offset); int64(n) < recordSize {
+			continue
+		}
+		if _, err := DecodeRecord(record); err == nil {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// EstimateRecords 按头部长度字段估算 [from, to) 区间内的记录数，至少为 1
+func EstimateRecords(r io.ReaderAt, from, to int64) int {
+	count := 0
+	header := make([]byte, storage2.HeaderSize)
+	for offset := from; offset < to; {
+		count++
+		if n, _ := r.ReadAt(header, offset); n < storage2.HeaderSize {
+			break
+		}
+		recordSize, ok := recordSizeOf(header)
+		if !ok {
+			break
+		}
+		offset += recordSize
+	}
+	return max(count, 1)
+}
+
+// recordSizeOf 由记录头部计算整条记录（含序列号与校验和）的长度，长度字段非法时返回 false
+func recordSizeOf(header []byte) (int64, bool) {
+	flags := binary.BigEndian.Uint32(header[8:12])
+	keyLen := binary.BigEndian.Uint32(header[12:16])
+	valueLen := binary.BigEndian.Uint32(header[16:20])
+	if keyLen > uint32(storage2.MaxKeySize) || valueLen > uint32(storage2.MaxValueSize) {
+		return 0, false
+	}
+	return int64(storage2.RecordHeaderSize(flags)) + int64(keyLen) + int64(valueLen) + 8, true
+}
+
+// ListDataFileIDs 按编号升序列出目录下的数据文件
+func ListDataFileIDs(dir string) ([]int, error) {
+	return listFileIDs(dir, storage2.FilePrefix, storage2.FileSuffix)
+}
+
+// listFileIDs 按编号升序列出目录下名为 prefix + 编号 + suffix 的文件
+func listFileIDs(dir, prefix, suffix string) ([]int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory failed: %w", err)
+	}
+
+	var ids []int
+	for _, f := range files {
+		var id int
+		// Sscanf 不校验后缀之后的内容，这里重新拼接比较以排除 hint 等同前缀文件
+		if _, err := fmt.Sscanf(f.Name(), prefix+"%d"+suffix, &id); err != nil {
+			continue
+		}
+		if f.Name() != fmt.Sprintf("%s%d%s", prefix, id, suffix) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
 // encodeRecord 将 Record 编码为二进制格式
 // 格式: [Timestamp(8)|Flags(4)|KeyLen(4)|ValueLen(4)|Key(?)|Value(?)|Checksum(8)]
 func encodeRecord(r *storage2.Record) ([]byte, error) {
 	// 1. 输入验证
-	if r == nil {
-		return nil, err_def.ErrNilRecord
-	}
-	if len(r.Key) == 0 {
-		return nil, err_def.ErrEmptyKey
-	}
-	if len(r.Key) > storage2.MaxKeySize {
-		return nil, fmt.Errorf("%w: key length %d exceeds maximum %d", err_def.ErrKeyTooLarge, len(r.Key), storage2.MaxKeySize)
-	}
-	if len(r.Value) > storage2.MaxValueSize {
-		return nil, fmt.Errorf("%w: value length %d exceeds maximum %d", err_def.ErrValueTooLarge, len(r.Value), storage2.MaxValueSize)
+	if err := checkRecord(r); err != nil {
+		return nil, err
 	}
 
-	// 2. 计算长度
+	// 2. 计算长度，有序列号时头部之后紧跟序列号
+	flags := r.Flags &^ storage2.FlagSeq
+	if r.Seq != 0 {
+		flags |= storage2.FlagSeq
+	}
+	headerSize := storage2.RecordHeaderSize(flags)
 	keyLen := len(r.Key)
 	valueLen := len(r.Value)
-	dataSize := storage2.HeaderSize + keyLen + valueLen
+	dataSize := headerSize + keyLen + valueLen
 	totalSize := dataSize + 8 // 加上校验和的8字节
 
 	// 3. 分配并填充缓冲区
@@ -444,13 +1560,16 @@ func encodeRecord(r *storage2.Record) ([]byte, error) {
 
 	// 写入头部信息
 	binary.BigEndian.PutUint64(buf[0:8], uint64(r.Timestamp))
-	binary.BigEndian.PutUint32(buf[8:12], r.Flags)
+	binary.BigEndian.PutUint32(buf[8:12], flags)
 	binary.BigEndian.PutUint32(buf[12:16], uint32(keyLen))
 	binary.BigEndian.PutUint32(buf[16:20], uint32(valueLen))
+	if r.Seq != 0 {
+		binary.BigEndian.PutUint64(buf[storage2.HeaderSize:], r.Seq)
+	}
 
 	// 写入键值对
-	copy(buf[storage2.HeaderSize:storage2.HeaderSize+keyLen], r.Key)
-	copy(buf[storage2.HeaderSize+keyLen:dataSize], r.Value)
+	copy(buf[headerSize:headerSize+keyLen], r.Key)
+	copy(buf[headerSize+keyLen:dataSize], r.Value)
 
 	// 计算并写入校验和
 	checksum := crc64.Checksum(buf[:dataSize], crc64.MakeTable(crc64.ISO))
@@ -459,6 +1578,23 @@ func encodeRecord(r *storage2.Record) ([]byte, error) {
 	return buf, nil
 }
 
+// checkRecord 校验记录的键值长度
+func checkRecord(r *storage2.Record) error {
+	if r == nil {
+		return err_def.ErrNilRecord
+	}
+	if len(r.Key) == 0 {
+		return err_def.ErrEmptyKey
+	}
+	if len(r.Key) > storage2.MaxKeySize {
+		return fmt.Errorf("%w: key length %d exceeds maximum %d", err_def.ErrKeyTooLarge, len(r.Key), storage2.MaxKeySize)
+	}
+	if len(r.Value) > storage2.MaxValueSize {
+		return fmt.Errorf("%w: value length %d exceeds maximum %d", err_def.ErrValueTooLarge, len(r.Value), storage2.MaxValueSize)
+	}
+	return nil
+}
+
 // DecodeRecord 从二进制数据解析记录
 func DecodeRecord(data []byte) (*storage2.Record, error) {
 	// 1. 基础长度检查
@@ -473,7 +1609,8 @@ func DecodeRecord(data []byte) (*storage2.Record, error) {
 	valueLen := binary.BigEndian.Uint32(data[16:20])
 
 	// 3. 验证长度
-	expectedLen := storage2.HeaderSize + int(keyLen) + int(valueLen) + 8
+	headerSize := storage2.RecordHeaderSize(flags)
+	expectedLen := headerSize + int(keyLen) + int(valueLen) + 8
 	if len(data) != expectedLen {
 		return nil, fmt.Errorf("%w: got %d bytes, expected %d", err_def.ErrDataLengthInvalid, len(data), expectedLen)
 	}
@@ -495,8 +1632,13 @@ func DecodeRecord(data []byte) (*storage2.Record, error) {
 		return nil, fmt.Errorf("%w: stored=%x, calculated=%x", err_def.ErrChecksumMismatch, storedChecksum, calculatedChecksum)
 	}
 
-	// 6. 提取键值对
-	keyStart := storage2.HeaderSize
+	// 6. 提取序列号与键值对，序列号标记只存在于磁盘格式中
+	var seq uint64
+	if flags&storage2.FlagSeq != 0 {
+		seq = binary.BigEndian.Uint64(data[storage2.HeaderSize:headerSize])
+		flags &^= storage2.FlagSeq
+	}
+	keyStart := headerSize
 	keyEnd := keyStart + int(keyLen)
 	valueStart := keyEnd
 	valueEnd := valueStart + int(valueLen)
@@ -510,6 +1652,7 @@ func DecodeRecord(data []byte) (*storage2.Record, error) {
 	// 7. 构造并返回记录
 	return &storage2.Record{
 		Timestamp: timestamp,
+		Seq:       seq,
 		Flags:     flags,
 		Checksum:  storedChecksum,
 		KVItem: storage2.KVItem{
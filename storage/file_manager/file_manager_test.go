@@ -1,9 +1,258 @@
 package file_manager
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFileManager(t *testing.T) {
 	// TODO: use gomonkey to mock
 }
+
+func openTestFM(t *testing.T, dir string, maxFileSize int64, opts ...Option) *FileManager {
+	t.Helper()
+	fm, err := NewFileManager(dir, maxFileSize, 16, time.Second, opts...)
+	require.NoError(t, err)
+	return fm
+}
+
+func writeTestRecord(t *testing.T, fm *FileManager, key string, value []byte) storage2.Entry {
+	t.Helper()
+	resp := <-fm.WriteAsync(&storage2.Record{
+		Timestamp: time.Now().UnixNano(),
+		KVItem: storage2.KVItem{
+			Key:   []byte(key),
+			Value: value,
+		},
+	})
+	require.NoError(t, resp.Err)
+	return resp.Entry
+}
+
+func readTestRecord(t *testing.T, fm *FileManager, entry storage2.Entry) []byte {
+	t.Helper()
+	record, err := fm.Read(entry)
+	require.NoError(t, err)
+	return record.Value
+}
+
+func TestFileHeaderValidation(t *testing.T) {
+	prepare := func(t *testing.T, modify func(data []byte) []byte) (string, int) {
+		dir := t.TempDir()
+		fm := openTestFM(t, dir, 512)
+		for i := 0; i < 30; i++ {
+			writeTestRecord(t, fm, fmt.Sprintf("key-%02d", i), []byte("some-value"))
+		}
+		require.NoError(t, fm.Close())
+
+		id := fm.LiveFileIDs()[0]
+		path := DataFilePath(dir, id)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, modify(data), 0644))
+		return dir, id
+	}
+
+	t.Run("current", func(t *testing.T) {
+		dir, id := prepare(t, func(data []byte) []byte { return data })
+		fm := openTestFM(t, dir, 512)
+		defer fm.Close()
+		header, err := fm.FileHeader(id)
+		require.NoError(t, err)
+		assert.Equal(t, FileVersion, header.Version)
+		assert.False(t, header.Outdated())
+		assert.Equal(t, int64(FileHeaderSize), header.DataStart())
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		// 没有文件头的旧格式文件，记录从偏移 0 开始
+		dir, id := prepare(t, func(data []byte) []byte { return data[FileHeaderSize:] })
+		fm := openTestFM(t, dir, 512)
+		defer fm.Close()
+		header, err := fm.FileHeader(id)
+		require.NoError(t, err)
+		assert.True(t, header.Outdated())
+		assert.Zero(t, header.DataStart())
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		dir, id := prepare(t, func(data []byte) []byte {
+			data[10] ^= 0xff
+			return data
+		})
+		fm := openTestFM(t, dir, 512)
+		defer fm.Close()
+		_, err := fm.FileHeader(id)
+		assert.ErrorIs(t, err, err_def.ErrFileHeaderCorrupt)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		dir, id := prepare(t, func(data []byte) []byte {
+			header := data[:FileHeaderSize]
+			binary.BigEndian.PutUint16(header[4:6], FileVersion+1)
+			binary.BigEndian.PutUint32(header[FileHeaderSize-4:], crc32.ChecksumIEEE(header[:FileHeaderSize-4]))
+			return data
+		})
+		fm := openTestFM(t, dir, 512)
+		defer fm.Close()
+		_, err := fm.FileHeader(id)
+		assert.ErrorIs(t, err, err_def.ErrFileVersionUnsupported)
+	})
+}
+
+func TestCompression(t *testing.T) {
+	value := []byte(strings.Repeat(`{"field":"value","n":1}`, 64))
+
+	for _, compression := range []storage2.CompressionType{storage2.CompressionFlate, storage2.CompressionZlib} {
+		t.Run(string(compression), func(t *testing.T) {
+			dir := t.TempDir()
+			fm := openTestFM(t, dir, 1<<20, WithCompression(compression, 128))
+			big := writeTestRecord(t, fm, "big", value)
+			small := writeTestRecord(t, fm, "small", []byte("tiny"))
+			others := make([]storage2.Entry, 4)
+			for i := range others {
+				others[i] = writeTestRecord(t, fm, fmt.Sprintf("big-%d", i), bytes.Repeat([]byte{byte('a' + i)}, 256))
+			}
+			assert.Less(t, int(big.Size), len(value))
+
+			header, err := fm.FileHeader(big.FileID)
+			require.NoError(t, err)
+			assert.NotEqual(t, storage2.CodecNone, header.Codec)
+			require.NoError(t, fm.Close())
+
+			// 关闭压缩后，已压缩的记录仍可读取
+			fm = openTestFM(t, dir, 1<<20)
+			defer fm.Close()
+			assert.Equal(t, value, readTestRecord(t, fm, big))
+			assert.Equal(t, "tiny", string(readTestRecord(t, fm, small)))
+			// 复用的解压器读取不同的值
+			for i, entry := range others {
+				assert.Equal(t, bytes.Repeat([]byte{byte('a' + i)}, 256), readTestRecord(t, fm, entry))
+			}
+		})
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte(strings.Repeat("k", 32))
+
+	fm := openTestFM(t, dir, 512, WithKeyProvider(&storage2.StaticKeyProvider{ID: 1, Secret: key}))
+	entries := make([]storage2.Entry, 20)
+	for i := range entries {
+		entries[i] = writeTestRecord(t, fm, fmt.Sprintf("secret-key-%d", i), []byte(fmt.Sprintf("secret-value-%d", i)))
+	}
+	header, err := fm.FileHeader(entries[0].FileID)
+	require.NoError(t, err)
+	assert.True(t, header.Encrypted)
+	require.NoError(t, fm.Close())
+
+	// 键与值都不以明文落盘
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range files {
+		if e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secret-", e.Name())
+	}
+
+	// 缺少密钥时拒绝打开加密文件，密钥错误时无法解密
+	fm = openTestFM(t, dir, 512)
+	_, err = fm.GetFile(entries[0].FileID)
+	assert.ErrorIs(t, err, ErrNoKeyProvider)
+	require.NoError(t, fm.Close())
+	fm = openTestFM(t, dir, 512, WithKeyProvider(&storage2.StaticKeyProvider{ID: 1, Secret: []byte(strings.Repeat("n", 32))}))
+	_, err = fm.Read(entries[0])
+	assert.Error(t, err)
+	require.NoError(t, fm.Close())
+
+	fm = openTestFM(t, dir, 512, WithKeyProvider(&storage2.StaticKeyProvider{ID: 1, Secret: key}))
+	defer fm.Close()
+	for i, entry := range entries {
+		record, err := fm.Read(entry)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("secret-key-%d", i), string(record.Key))
+		assert.Equal(t, fmt.Sprintf("secret-value-%d", i), string(record.Value))
+	}
+}
+
+func TestMmapReads(t *testing.T) {
+	dir := t.TempDir()
+	budget := int64(2048)
+	fm := openTestFM(t, dir, 1024, WithMmap(budget))
+	entries := make([]storage2.Entry, 100)
+	for i := range entries {
+		entries[i] = writeTestRecord(t, fm, fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	require.NoError(t, fm.Rotate())
+
+	// 读取封存文件时建立映射，映射总量不超过预算
+	for i, entry := range entries {
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(readTestRecord(t, fm, entry)))
+	}
+	assert.Positive(t, fm.MappedBytes())
+	assert.LessOrEqual(t, fm.MappedBytes(), budget)
+
+	// 活动文件不映射
+	active := writeTestRecord(t, fm, "active", []byte("value"))
+	mapped := fm.MappedBytes()
+	assert.Equal(t, "value", string(readTestRecord(t, fm, active)))
+	assert.Equal(t, mapped, fm.MappedBytes())
+
+	require.NoError(t, fm.Close())
+	assert.Zero(t, fm.MappedBytes())
+}
+
+func TestWriteLanes(t *testing.T) {
+	dir := t.TempDir()
+	fm := openTestFM(t, dir, 1<<20, WithWriteLanes(2))
+	assert.Equal(t, 2, fm.Lanes())
+	require.Len(t, fm.ActiveFileIDs(), 2)
+
+	// 键按哈希分配到各通道的活动文件，同一个键总是写入同一个通道
+	entries := map[string]storage2.Entry{}
+	seqs := map[uint64]bool{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		first := writeTestRecord(t, fm, key, []byte("v1"))
+		second := writeTestRecord(t, fm, key, []byte(fmt.Sprintf("value-%d", i)))
+		assert.Equal(t, first.FileID, second.FileID)
+		assert.Greater(t, second.Seq, first.Seq)
+		entries[key] = second
+		seqs[first.Seq], seqs[second.Seq] = true, true
+	}
+	files := map[int]bool{}
+	for _, entry := range entries {
+		files[entry.FileID] = true
+	}
+	assert.Len(t, files, 2)
+	assert.Len(t, seqs, 200)
+	assert.Equal(t, uint64(200), fm.LastSequence())
+	require.NoError(t, fm.Close())
+
+	// 重新打开时各通道换用新的活动文件，上次的活动文件封存后仍可读取
+	fm = openTestFM(t, dir, 1<<20, WithWriteLanes(2))
+	defer fm.Close()
+	require.Len(t, fm.ActiveFileIDs(), 2)
+	for id := range files {
+		assert.False(t, fm.IsActive(id))
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(readTestRecord(t, fm, entries[key])))
+	}
+}
@@ -0,0 +1,150 @@
+package file_manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// fileMagic 数据文件魔数
+	fileMagic uint32 = 0x464B4446 // "FKDF"
+	// FileVersionLegacy 没有文件头的旧格式，记录从偏移 0 开始
+	FileVersionLegacy uint16 = 0
+	// FileVersion 当前写入的数据文件格式版本，格式变化时递增，旧版本文件在合并时重写
+	FileVersion uint16 = 1
+	// FileHeaderSize 文件头: magic(4) + version(2) + flags(2) + createdAt(8) + 保留(12) + crc32(4)，记录紧随其后
+	FileHeaderSize = 32
+)
+
+// 文件头 flags：低 4 位为创建时配置的压缩编码，其余为开关
+const (
+	fileFlagCodecMask uint16 = 0x0f
+	fileFlagEncrypted uint16 = 1 << 4
+)
+
+// FileHeader 数据文件头，记录格式版本与创建时的编码配置
+// 每条记录仍带有自己的编码、加密标记，文件头中的配置用于判断文件是否需要重写
+type FileHeader struct {
+	Version   uint16
+	Codec     storage2.CodecID
+	Encrypted bool
+	CreatedAt int64 // 创建时间，UnixNano
+}
+
+// DataStart 第一条记录的偏移，旧格式文件没有文件头，从 0 开始
+func (h FileHeader) DataStart() int64 {
+	if h.Version == FileVersionLegacy {
+		return 0
+	}
+	return FileHeaderSize
+}
+
+// Outdated 文件格式早于当前版本，需要合并重写
+func (h FileHeader) Outdated() bool {
+	return h.Version < FileVersion
+}
+
+// newFileHeader 按当前配置生成新文件的文件头
+func (fm *FileManager) newFileHeader() FileHeader {
+	return FileHeader{
+		Version:   FileVersion,
+		Codec:     fm.codec,
+		Encrypted: fm.cipher != nil,
+		CreatedAt: time.Now().UnixNano(),
+	}
+}
+
+func encodeFileHeader(h FileHeader) []byte {
+	buf := make([]byte, FileHeaderSize)
+	flags := uint16(h.Codec) & fileFlagCodecMask
+	if h.Encrypted {
+		flags |= fileFlagEncrypted
+	}
+	binary.BigEndian.PutUint32(buf[0:4], fileMagic)
+	binary.BigEndian.PutUint16(buf[4:6], h.Version)
+	binary.BigEndian.PutUint16(buf[6:8], flags)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(h.CreatedAt))
+	binary.BigEndian.PutUint32(buf[FileHeaderSize-4:], crc32.ChecksumIEEE(buf[:FileHeaderSize-4]))
+	return buf
+}
+
+// writeFileHeader 在新文件开头写入文件头并刷盘，返回第一条记录的偏移
+func writeFileHeader(f *os.File, h FileHeader) (int64, error) {
+	if _, err := f.WriteAt(encodeFileHeader(h), 0); err != nil {
+		return 0, fmt.Errorf("write file header failed: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("sync file header failed: %w", err)
+	}
+	return FileHeaderSize, nil
+}
+
+// ReadFileHeader 读取并校验数据文件头
+// 开头不是魔数的文件视为旧格式；魔数正确但校验失败返回 ErrFileHeaderCorrupt，版本高于当前支持的返回 ErrFileVersionUnsupported。
+// 旧格式文件的第一条记录以时间戳开头，与魔数及校验和同时吻合的概率可以忽略
+func ReadFileHeader(r io.ReaderAt) (FileHeader, error) {
+	buf := make([]byte, FileHeaderSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return FileHeader{}, fmt.Errorf("read file header failed: %w", err)
+	}
+	if n < 4 || binary.BigEndian.Uint32(buf[0:4]) != fileMagic {
+		return FileHeader{Version: FileVersionLegacy}, nil
+	}
+	if n < FileHeaderSize {
+		// 文件头未写完，新文件在登记到清单前已写好文件头，这里按旧格式处理，由恢复流程截断
+		return FileHeader{Version: FileVersionLegacy}, nil
+	}
+	if crc32.ChecksumIEEE(buf[:FileHeaderSize-4]) != binary.BigEndian.Uint32(buf[FileHeaderSize-4:]) {
+		return FileHeader{}, err_def.ErrFileHeaderCorrupt
+	}
+
+	flags := binary.BigEndian.Uint16(buf[6:8])
+	h := FileHeader{
+		Version:   binary.BigEndian.Uint16(buf[4:6]),
+		Codec:     storage2.CodecID(flags & fileFlagCodecMask),
+		Encrypted: flags&fileFlagEncrypted != 0,
+		CreatedAt: int64(binary.BigEndian.Uint64(buf[8:16])),
+	}
+	if h.Version == FileVersionLegacy || h.Version > FileVersion {
+		return FileHeader{}, fmt.Errorf("%w: version %d", err_def.ErrFileVersionUnsupported, h.Version)
+	}
+	return h, nil
+}
+
+// FileHeader 读取数据文件的文件头
+func (fm *FileManager) FileHeader(fileID int) (FileHeader, error) {
+	file, err := fm.GetFile(fileID)
+	if err != nil {
+		return FileHeader{}, err
+	}
+	return ReadFileHeader(file)
+}
+
+// DataStart 返回数据文件中第一条记录的偏移，文件头损坏时返回 0，由扫描按损坏记录处理
+func DataStart(r io.ReaderAt) int64 {
+	h, err := ReadFileHeader(r)
+	if err != nil {
+		return 0
+	}
+	return h.DataStart()
+}
+
+// checkFileHeader 打开数据文件时校验文件头，拒绝损坏或更高版本的文件，避免按错误的格式解析
+func (fm *FileManager) checkFileHeader(fileID int, file *os.File) error {
+	h, err := ReadFileHeader(file)
+	if err != nil {
+		return fmt.Errorf("data file %d: %w", fileID, err)
+	}
+	if h.Encrypted && fm.cipher == nil {
+		return fmt.Errorf("data file %d: %w", fileID, ErrNoKeyProvider)
+	}
+	return nil
+}
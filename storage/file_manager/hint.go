@@ -0,0 +1,224 @@
+package file_manager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"hash/crc64"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// hintMagic hint 文件魔数
+	hintMagic uint32 = 0x464B4854 // "FKHT"
+	// hintVersion hint 文件格式版本，格式变化时递增，旧版本 hint 视为失效
+	hintVersion uint32 = 3
+	// hintFileHeaderSize hint 文件头: magic(4) + version(4)
+	hintFileHeaderSize = 8
+	// hintEntryHeaderSize hint 条目头: timestamp(8) + flags(4) + keyLen(4) + offset(8) + size(4) + seq(8)
+	hintEntryHeaderSize = 36
+)
+
+// ErrHintCorrupt hint 文件损坏或版本不匹配，调用方应回退到全量扫描数据文件
+var ErrHintCorrupt = errors.New("hint file corrupt")
+
+var hintCrcTable = crc64.MakeTable(crc64.ISO)
+
+// HintEntry hint 文件中的一条索引记录，对应数据文件中的一条记录（不含 value）
+// Value 只在 FlagBlob、FlagChunked 记录中保存 blob 指针或分块清单，加载时据此重建 blob 文件的引用统计
+type HintEntry struct {
+	Key       []byte
+	Value     []byte
+	Flags     uint32
+	Timestamp int64
+	Offset    int64
+	Size      uint32
+	Seq       uint64
+}
+
+// HintPath 返回数据文件对应的 hint 文件路径
+func HintPath(dir string, fileID int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%d%s", storage2.FilePrefix, fileID, storage2.HintSuffix))
+}
+
+// DataFilePath 返回数据文件路径
+func DataFilePath(dir string, fileID int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%d%s", storage2.FilePrefix, fileID, storage2.FileSuffix))
+}
+
+// HintWriter 以追加方式写入 hint 文件，先写临时文件，Commit 时原子重命名
+type HintWriter struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	broken bool
+}
+
+// NewHintWriter 为指定数据文件创建 hint 写入器
+func NewHintWriter(dir string, fileID int) (*HintWriter, error) {
+	path := HintPath(dir, fileID)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("create hint file failed: %w", err)
+	}
+
+	hw := &HintWriter{
+		path:   path,
+		file:   f,
+		writer: bufio.NewWriter(f),
+	}
+
+	header := make([]byte, hintFileHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], hintMagic)
+	binary.BigEndian.PutUint32(header[4:8], hintVersion)
+	if _, err := hw.writer.Write(header); err != nil {
+		hw.Abort()
+		return nil, fmt.Errorf("write hint header failed: %w", err)
+	}
+
+	return hw, nil
+}
+
+// Write 追加一条 hint 记录
+// 格式: [Timestamp(8)|Flags(4)|KeyLen(4)|Offset(8)|Size(4)|Seq(8)|Key(?)|ValueLen(4)|Value(?)|Checksum(8)]，
+// ValueLen 与 Value 仅出现在 FlagBlob、FlagChunked 记录中
+func (hw *HintWriter) Write(e HintEntry) error {
+	if hw.broken {
+		return ErrHintCorrupt
+	}
+	valueLen := 0
+	if storage2.HasBlobRef(e.Flags) {
+		valueLen = 4 + len(e.Value)
+	}
+
+	buf := make([]byte, hintEntryHeaderSize+len(e.Key)+valueLen+8)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(e.Timestamp))
+	binary.BigEndian.PutUint32(buf[8:12], e.Flags)
+	binary.BigEndian.PutUint32(buf[12:16], uint32(len(e.Key)))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(e.Offset))
+	binary.BigEndian.PutUint32(buf[24:28], e.Size)
+	binary.BigEndian.PutUint64(buf[28:36], e.Seq)
+	copy(buf[hintEntryHeaderSize:], e.Key)
+	if valueLen > 0 {
+		binary.BigEndian.PutUint32(buf[hintEntryHeaderSize+len(e.Key):], uint32(len(e.Value)))
+		copy(buf[hintEntryHeaderSize+len(e.Key)+4:], e.Value)
+	}
+	dataSize := hintEntryHeaderSize + len(e.Key) + valueLen
+	binary.BigEndian.PutUint64(buf[dataSize:], crc64.Checksum(buf[:dataSize], hintCrcTable))
+
+	if _, err := hw.writer.Write(buf); err != nil {
+		// hint 只是加速手段，写失败后放弃该文件，加载时回退到全量扫描
+		hw.broken = true
+		return err
+	}
+	return nil
+}
+
+// Commit 刷盘并将临时文件重命名为正式 hint 文件
+func (hw *HintWriter) Commit() error {
+	if hw.broken {
+		hw.Abort()
+		return ErrHintCorrupt
+	}
+	if err := hw.writer.Flush(); err != nil {
+		hw.Abort()
+		return err
+	}
+	if err := hw.file.Sync(); err != nil {
+		hw.Abort()
+		return err
+	}
+	if err := hw.file.Close(); err != nil {
+		_ = os.Remove(hw.path + ".tmp")
+		return err
+	}
+	return os.Rename(hw.path+".tmp", hw.path)
+}
+
+// Abort 放弃本次写入并删除临时文件
+func (hw *HintWriter) Abort() {
+	hw.broken = true
+	_ = hw.file.Close()
+	_ = os.Remove(hw.path + ".tmp")
+}
+
+// WriteHintFile 将一组 hint 记录一次性写入 hint 文件
+func WriteHintFile(dir string, fileID int, entries []HintEntry) error {
+	hw, err := NewHintWriter(dir, fileID)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := hw.Write(e); err != nil {
+			hw.Abort()
+			return err
+		}
+	}
+	return hw.Commit()
+}
+
+// ReadHintFile 读取并校验整个 hint 文件
+// 文件不存在时返回 os.ErrNotExist，内容损坏时返回 ErrHintCorrupt
+func ReadHintFile(dir string, fileID int) ([]HintEntry, error) {
+	data, err := os.ReadFile(HintPath(dir, fileID))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < hintFileHeaderSize ||
+		binary.BigEndian.Uint32(data[0:4]) != hintMagic ||
+		binary.BigEndian.Uint32(data[4:8]) != hintVersion {
+		return nil, ErrHintCorrupt
+	}
+
+	var entries []HintEntry
+	pos := hintFileHeaderSize
+	for pos < len(data) {
+		if len(data)-pos < hintEntryHeaderSize+8 {
+			return nil, fmt.Errorf("%w: truncated entry at %d", ErrHintCorrupt, pos)
+		}
+		flags := binary.BigEndian.Uint32(data[pos+8 : pos+12])
+		keyLen := int(binary.BigEndian.Uint32(data[pos+12 : pos+16]))
+		if keyLen > storage2.MaxKeySize || len(data)-pos < hintEntryHeaderSize+keyLen+8 {
+			return nil, fmt.Errorf("%w: invalid key length at %d", ErrHintCorrupt, pos)
+		}
+		valueLen := 0
+		if storage2.HasBlobRef(flags) {
+			if len(data)-pos < hintEntryHeaderSize+keyLen+4+8 {
+				return nil, fmt.Errorf("%w: truncated value at %d", ErrHintCorrupt, pos)
+			}
+			valueLen = 4 + int(binary.BigEndian.Uint32(data[pos+hintEntryHeaderSize+keyLen:]))
+			if valueLen-4 > storage2.MaxValueSize || len(data)-pos < hintEntryHeaderSize+keyLen+valueLen+8 {
+				return nil, fmt.Errorf("%w: invalid value length at %d", ErrHintCorrupt, pos)
+			}
+		}
+		dataSize := hintEntryHeaderSize + keyLen + valueLen
+		stored := binary.BigEndian.Uint64(data[pos+dataSize : pos+dataSize+8])
+		if crc64.Checksum(data[pos:pos+dataSize], hintCrcTable) != stored {
+			return nil, fmt.Errorf("%w: checksum mismatch at %d", ErrHintCorrupt, pos)
+		}
+
+		key := make([]byte, keyLen)
+		copy(key, data[pos+hintEntryHeaderSize:pos+hintEntryHeaderSize+keyLen])
+		var value []byte
+		if valueLen > 0 {
+			value = make([]byte, valueLen-4)
+			copy(value, data[pos+hintEntryHeaderSize+keyLen+4:pos+dataSize])
+		}
+		entries = append(entries, HintEntry{
+			Key:       key,
+			Value:     value,
+			Timestamp: int64(binary.BigEndian.Uint64(data[pos : pos+8])),
+			Flags:     flags,
+			Offset:    int64(binary.BigEndian.Uint64(data[pos+16 : pos+24])),
+			Size:      binary.BigEndian.Uint32(data[pos+24 : pos+28]),
+			Seq:       binary.BigEndian.Uint64(data[pos+28 : pos+36]),
+		})
+		pos += dataSize + 8
+	}
+
+	return entries, nil
+}
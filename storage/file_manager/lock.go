@@ -0,0 +1,94 @@
+package file_manager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// LockFileName 读写打开时持有排它锁，同一数据目录只能有一个写入者
+	LockFileName = "LOCK"
+	// ReaderLockFileName 只读打开时持有共享锁，写入者删除合并替换的旧文件前需取得排它锁
+	ReaderLockFileName = "LOCK.readers"
+)
+
+// errLockHeld 非阻塞加锁时锁已被其他进程持有
+var errLockHeld = errors.New("lock is held by another process")
+
+// acquireDirLock 读写打开时对 LOCK 加排它锁，只读打开时对 LOCK.readers 加共享锁
+// 只读打开不与写入者互斥，只阻止写入者删除读取方可能仍在使用的文件；共享锁持有到关闭，
+// 期间合并与 blob GC 替换的旧文件都保留在磁盘上，数量见 ObsoleteFiles
+func (fm *FileManager) acquireDirLock() error {
+	if fm.readOnly {
+		// 只读打开不创建锁文件；文件不存在说明还没有写入者运行过，没有需要阻止删除的旧文件
+		f, err := os.Open(filepath.Join(fm.dir, ReaderLockFileName))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("open lock file failed: %w", err)
+		}
+		// 写入者只在删除旧文件时短暂持有排它锁，等待即可
+		if err := lockFile(f, false, true); err != nil {
+			f.Close()
+			return fmt.Errorf("lock data directory failed: %w", err)
+		}
+		fm.dirLock = f
+		return nil
+	}
+
+	f, err := openLockFile(filepath.Join(fm.dir, LockFileName))
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f, true, false); err != nil {
+		f.Close()
+		if errors.Is(err, errLockHeld) {
+			return fmt.Errorf("%w: %s", err_def.ErrDirLocked, fm.dir)
+		}
+		return fmt.Errorf("lock data directory failed: %w", err)
+	}
+	readers, err := openLockFile(filepath.Join(fm.dir, ReaderLockFileName))
+	if err != nil {
+		_ = unlockFile(f)
+		f.Close()
+		return err
+	}
+	fm.dirLock, fm.readerLock = f, readers
+	return nil
+}
+
+// releaseDirLock 释放目录锁，关闭文件即释放 flock
+func (fm *FileManager) releaseDirLock() {
+	for _, f := range []*os.File{fm.readerLock, fm.dirLock} {
+		if f != nil {
+			_ = unlockFile(f)
+			_ = f.Close()
+		}
+	}
+	fm.dirLock, fm.readerLock = nil, nil
+}
+
+// lockReaders 尝试对 LOCK.readers 加排它锁，有只读打开的进程时返回 false
+// 成功时返回的函数用于解锁，持有期间新的只读打开会等待
+func (fm *FileManager) lockReaders() (func(), bool) {
+	if fm.readerLock == nil {
+		return func() {}, true
+	}
+	if err := lockFile(fm.readerLock, true, false); err != nil {
+		return nil, false
+	}
+	return func() { _ = unlockFile(fm.readerLock) }, true
+}
+
+// openLockFile 读写打开时打开锁文件，不存在时创建
+func openLockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file failed: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package file_manager
+
+import "os"
+
+// lockFile 当前平台不支持 flock，不加锁
+func lockFile(*os.File, bool, bool) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
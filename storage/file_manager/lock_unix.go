@@ -0,0 +1,37 @@
+//go:build unix
+
+package file_manager
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile 对文件加 flock，block 为 false 时锁被占用立即返回 errLockHeld
+func lockFile(f *os.File, exclusive, block bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return errLockHeld
+		default:
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
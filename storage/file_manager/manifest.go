@@ -0,0 +1,144 @@
+package file_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// ManifestFileName 清单文件名，记录当前有效的数据文件集合
+	ManifestFileName = "MANIFEST"
+	// MergeDirName 合并输出的临时目录，位于数据目录下
+	MergeDirName = "merge"
+	// QuarantineDirName 损坏数据文件的隔离目录，位于数据目录下
+	QuarantineDirName = "quarantine"
+
+	manifestVersion = 1
+)
+
+// Manifest 数据文件清单
+// Files 按回放顺序排列，加载索引时靠后的文件覆盖靠前的文件；
+// Pending 为合并已写出、尚未提交的文件，恢复时回滚删除；
+// Obsolete 为合并已提交、尚未删除的旧文件，恢复时继续删除
+// NextID 为下一个可分配的文件编号，保证被删除文件的编号不会被复用；
+// LastSeq 为保存时已分配的最大序列号，合并丢弃记录后序列号也不会回退；
+// Blobs 为有效的 blob 文件，ObsoleteBlobs 为已被 GC 重写、尚未删除的 blob 文件；
+// Active 为各写入通道的活动文件，为空时活动文件是 Files 中的最后一个
+type Manifest struct {
+	Version       int    `json:"version"`
+	NextID        int    `json:"next_id,omitempty"`
+	LastSeq       uint64 `json:"last_seq,omitempty"`
+	Files         []int  `json:"files"`
+	Pending       []int  `json:"pending,omitempty"`
+	Obsolete      []int  `json:"obsolete,omitempty"`
+	Blobs         []int  `json:"blobs,omitempty"`
+	ObsoleteBlobs []int  `json:"obsolete_blobs,omitempty"`
+	Active        []int  `json:"active,omitempty"`
+}
+
+// LoadManifest 读取数据目录下的清单文件，不存在时返回 os.ErrNotExist
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse manifest failed: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// Save 先写临时文件再原子重命名，保证任意时刻磁盘上都是完整的清单
+func (m *Manifest) Save(dir string) error {
+	m.Version = manifestVersion
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, ManifestFileName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// saveManifest 记录当前的文件编号与序列号分配进度并保存清单，调用方需持有 manifestMu
+func (fm *FileManager) saveManifest() error {
+	fm.manifest.NextID = max(fm.manifest.NextID, int(fm.fileID.Load()))
+	fm.manifest.LastSeq = max(fm.manifest.LastSeq, fm.seq.Load())
+	return fm.manifest.Save(fm.dir)
+}
+
+// Contains 判断文件是否在有效文件集合中
+func (m *Manifest) Contains(fileID int) bool {
+	for _, id := range m.Files {
+		if id == fileID {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceFiles 用 outputs 替换 inputs，outputs 放在 inputs 中最靠后文件的位置
+// 合并输出只包含各键的最新记录，放在最后一个输入文件处不会被夹在中间的旧文件覆盖
+func (m *Manifest) replaceFiles(inputs, outputs []int) {
+	removed := make(map[int]struct{}, len(inputs))
+	for _, id := range inputs {
+		removed[id] = struct{}{}
+	}
+
+	last := -1
+	for i, id := range m.Files {
+		if _, ok := removed[id]; ok {
+			last = i
+		}
+	}
+
+	files := make([]int, 0, len(m.Files)+len(outputs))
+	for i, id := range m.Files {
+		if _, ok := removed[id]; !ok {
+			files = append(files, id)
+		}
+		if i == last {
+			files = append(files, outputs...)
+		}
+	}
+	if last < 0 {
+		files = append(outputs, files...)
+	}
+	m.Files = files
+}
+
+// syncDir 对目录做 fsync，保证重命名、删除等元数据操作落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,349 @@
+package file_manager
+
+import (
+	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
+	storage2 "github.com/FinnTew/FincasKV/storage"
+	"os"
+	"path/filepath"
+)
+
+// MergeWriter 顺序写入合并输出文件，文件先落在合并目录，提交时再移入数据目录
+type MergeWriter struct {
+	fm  *FileManager
+	dir string
+
+	cur    *os.File
+	curID  int
+	offset int64
+	hint   *HintWriter
+
+	ids []int
+}
+
+// NewMergeWriter 创建合并写入器，会清空上一次残留的合并目录
+func (fm *FileManager) NewMergeWriter() (*MergeWriter, error) {
+	if fm.readOnly {
+		return nil, err_def.ErrReadOnly
+	}
+	dir := filepath.Join(fm.dir, MergeDirName)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("clean merge directory failed: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create merge directory failed: %w", err)
+	}
+	return &MergeWriter{fm: fm, dir: dir}, nil
+}
+
+// Write 写入一条已解密的记录，返回其在合并输出文件中的位置
+// 开启加密时用当前密钥重新加密，旧密钥加密的数据由此完成轮换；
+// 搬移的都是已提交的记录，去掉批量写入标记，输出文件中不含提交标记
+func (w *MergeWriter) Write(r *storage2.Record) (storage2.Entry, error) {
+	plain := *r
+	plain.Flags &^= storage2.FlagEncrypted | storage2.FlagBatch
+
+	var key *cipherKey
+	size := 0
+	if w.fm.cipher != nil {
+		if err := checkRecord(&plain); err != nil {
+			return storage2.Entry{}, err
+		}
+		k, err := w.fm.cipher.current()
+		if err != nil {
+			return storage2.Entry{}, err
+		}
+		key, size = k, sealedSize(&plain)
+	} else {
+		data, err := encodeRecord(&plain)
+		if err != nil {
+			return storage2.Entry{}, err
+		}
+		size = len(data)
+	}
+
+	if w.cur == nil || (w.offset > FileHeaderSize && w.offset+int64(size) > w.fm.maxFileSize) {
+		if err := w.rotate(); err != nil {
+			return storage2.Entry{}, err
+		}
+	}
+
+	hint := HintEntry{
+		Key:       plain.Key,
+		Flags:     plain.Flags,
+		Timestamp: plain.Timestamp,
+		Seq:       plain.Seq,
+		Offset:    w.offset,
+		Size:      uint32(size),
+	}
+	if storage2.HasBlobRef(plain.Flags) {
+		hint.Value = plain.Value
+	}
+	stored := &plain
+	if key != nil {
+		stored = key.seal(&plain, w.curID, w.offset)
+		hint.Key = key.sealHintKey(plain.Key, w.curID, w.offset)
+		hint.Flags |= storage2.FlagEncrypted
+	}
+	data, err := encodeRecord(stored)
+	if err != nil {
+		return storage2.Entry{}, err
+	}
+
+	if _, err := w.cur.WriteAt(data, w.offset); err != nil {
+		return storage2.Entry{}, fmt.Errorf("%w: %v", err_def.ErrWriteFailed, err)
+	}
+	entry := storage2.Entry{
+		FileID:    w.curID,
+		Offset:    w.offset,
+		Size:      uint32(len(data)),
+		Timestamp: r.Timestamp,
+		Seq:       r.Seq,
+	}
+	_ = w.hint.Write(hint)
+	w.offset += int64(len(data))
+	return entry, nil
+}
+
+// rotate 封存当前输出文件并分配新的文件编号
+func (w *MergeWriter) rotate() error {
+	if err := w.seal(); err != nil {
+		return err
+	}
+
+	id := w.fm.allocFileID()
+	f, err := os.OpenFile(DataFilePath(w.dir, id), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create merge file failed: %w", err)
+	}
+	start, err := writeFileHeader(f, w.fm.newFileHeader())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	hw, err := NewHintWriter(w.dir, id)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.cur, w.curID, w.offset, w.hint = f, id, start, hw
+	w.ids = append(w.ids, id)
+	return nil
+}
+
+// seal 刷盘并关闭当前输出文件，同时提交其 hint
+func (w *MergeWriter) seal() error {
+	if w.cur == nil {
+		return nil
+	}
+	if err := w.cur.Sync(); err != nil {
+		return err
+	}
+	if err := w.cur.Close(); err != nil {
+		return err
+	}
+	w.cur = nil
+	if err := w.hint.Commit(); err != nil {
+		return fmt.Errorf("commit merge hint failed: %w", err)
+	}
+	return nil
+}
+
+// Finish 结束写入，返回所有输出文件编号
+func (w *MergeWriter) Finish() ([]int, error) {
+	if err := w.seal(); err != nil {
+		return nil, err
+	}
+	return w.ids, nil
+}
+
+// Abort 放弃合并，删除合并目录
+func (w *MergeWriter) Abort() {
+	if w.cur != nil {
+		_ = w.cur.Close()
+		w.hint.Abort()
+		w.cur = nil
+	}
+	_ = os.RemoveAll(w.dir)
+}
+
+// PrepareMerge 结束写入并将输出文件移入数据目录，此时输出文件尚未生效
+// 之后必须调用 CommitMerge 或 AbortMerge；若中途崩溃，recoverManifest 会回滚这些文件
+func (fm *FileManager) PrepareMerge(w *MergeWriter) ([]int, error) {
+	outputs, err := w.Finish()
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+	defer w.Abort()
+
+	// 记录尚未提交的输出文件，崩溃后据此回滚
+	fm.manifest.Pending = outputs
+	if err := fm.saveManifest(); err != nil {
+		fm.manifest.Pending = nil
+		return nil, fmt.Errorf("save manifest failed: %w", err)
+	}
+
+	for _, id := range outputs {
+		if err := os.Rename(DataFilePath(w.dir, id), DataFilePath(fm.dir, id)); err != nil {
+			fm.rollbackPending()
+			return nil, fmt.Errorf("move merge file %d failed: %w", id, err)
+		}
+		// hint 缺失只会导致下次启动扫描该文件
+		_ = os.Rename(HintPath(w.dir, id), HintPath(fm.dir, id))
+	}
+	if err := syncDir(fm.dir); err != nil {
+		fm.rollbackPending()
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// CommitMerge 提交点: 用 outputs 替换 inputs，inputs 进入待删除列表
+// 旧文件在 RemoveObsolete 中删除，调用方需保证此时已没有读请求引用它们；
+// 被推迟删除的旧文件会累积在清单中，直到下一次 RemoveObsolete
+func (fm *FileManager) CommitMerge(inputs, outputs []int) error {
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+
+	prev, prevObsolete := fm.manifest.Files, fm.manifest.Obsolete
+	fm.manifest.replaceFiles(inputs, outputs)
+	fm.manifest.Pending = nil
+	fm.manifest.Obsolete = append(append([]int(nil), prevObsolete...), inputs...)
+	if err := fm.saveManifest(); err != nil {
+		fm.manifest.Files = prev
+		fm.manifest.Obsolete = prevObsolete
+		fm.manifest.Pending = outputs
+		fm.rollbackPending()
+		return fmt.Errorf("commit manifest failed: %w", err)
+	}
+	return nil
+}
+
+// AbortMerge 放弃已 PrepareMerge 但未提交的输出文件
+func (fm *FileManager) AbortMerge() {
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+	fm.rollbackPending()
+}
+
+// RemoveObsolete 删除已被合并替换的旧文件，失败的文件留在清单中由下次启动继续清理
+func (fm *FileManager) RemoveObsolete() {
+	if fm.readOnly {
+		return
+	}
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+	fm.removeObsolete()
+}
+
+// ObsoleteFiles 返回已被合并或 blob GC 替换、尚未删除的数据文件与 blob 文件数
+// 有只读打开的进程或未释放的快照时，这些文件会一直保留
+func (fm *FileManager) ObsoleteFiles() (data, blobs int) {
+	fm.manifestMu.Lock()
+	defer fm.manifestMu.Unlock()
+	return len(fm.manifest.Obsolete), len(fm.manifest.ObsoleteBlobs)
+}
+
+// rollbackPending 删除未提交的合并输出文件，调用方需持有 manifestMu
+func (fm *FileManager) rollbackPending() {
+	if len(fm.manifest.Pending) == 0 {
+		return
+	}
+	for _, id := range fm.manifest.Pending {
+		_ = os.Remove(DataFilePath(fm.dir, id))
+		_ = os.Remove(HintPath(fm.dir, id))
+	}
+	fm.manifest.Pending = nil
+	_ = fm.saveManifest()
+}
+
+// removeObsolete 删除已被合并替换的旧文件及已被 GC 重写的 blob 文件，调用方需持有 manifestMu
+func (fm *FileManager) removeObsolete() {
+	if len(fm.manifest.Obsolete) == 0 && len(fm.manifest.ObsoleteBlobs) == 0 {
+		return
+	}
+	// 只读打开的进程可能仍在读取旧文件，保留到下次合并或重新打开时再删除
+	unlock, ok := fm.lockReaders()
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	fm.Lock()
+	for _, id := range append(fm.manifest.Obsolete, fm.manifest.ObsoleteBlobs...) {
+		if file, ok := fm.openFiles.Peek(id); ok {
+			_ = file.Close()
+			fm.openFiles.Remove(id)
+		}
+	}
+	fm.Unlock()
+	if fm.mmap != nil {
+		for _, id := range fm.manifest.Obsolete {
+			fm.mmap.remove(id)
+		}
+	}
+
+	var remain []int
+	for _, id := range fm.manifest.Obsolete {
+		if err := os.Remove(DataFilePath(fm.dir, id)); err != nil && !os.IsNotExist(err) {
+			remain = append(remain, id)
+			continue
+		}
+		_ = os.Remove(HintPath(fm.dir, id))
+	}
+	fm.manifest.Obsolete = remain
+
+	var remainBlobs []int
+	for _, id := range fm.manifest.ObsoleteBlobs {
+		if err := os.Remove(BlobFilePath(fm.dir, id)); err != nil && !os.IsNotExist(err) {
+			remainBlobs = append(remainBlobs, id)
+		}
+	}
+	fm.manifest.ObsoleteBlobs = remainBlobs
+	_ = fm.saveManifest()
+}
+
+// recoverManifest 加载清单并完成或回滚上次中断的合并
+// 只会删除清单中登记过的文件，数据目录下的其他文件（如 TTL 元数据）不受影响
+func (fm *FileManager) recoverManifest() error {
+	m, err := LoadManifest(fm.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// 旧版本数据目录没有清单，按文件编号顺序生成
+		ids, err := ListDataFileIDs(fm.dir)
+		if err != nil {
+			return err
+		}
+		m = &Manifest{Files: ids}
+		if fm.readOnly {
+			fm.manifest = m
+			return nil
+		}
+		if err := m.Save(fm.dir); err != nil {
+			return fmt.Errorf("create manifest failed: %w", err)
+		}
+	}
+	fm.manifest = m
+	if fm.readOnly {
+		// 未提交的合并输出不在 Files 中，不会被加载；清理留给写入者
+		return nil
+	}
+
+	// 合并输出未提交，回滚
+	if len(m.Pending) > 0 {
+		fm.rollbackPending()
+	}
+	// 合并已提交但旧文件未删完，继续删除
+	fm.removeObsolete()
+	// 合并目录中的内容都未被提交过
+	_ = os.RemoveAll(filepath.Join(fm.dir, MergeDirName))
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package file_manager
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"sync"
+)
+
+// errMmapUnsupported 当前平台不支持内存映射，读取回退到 ReadAt
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+// mmapCache 只读映射已封存的数据文件，读取时直接切片映射内存，省去 ReadAt 系统调用
+// 映射总字节数不超过 budget，超出时按最近最少使用淘汰；
+// 读取期间持有引用，被淘汰或被删除的映射在最后一个读取方释放后才解除
+type mmapCache struct {
+	mu       sync.Mutex
+	budget   int64
+	mapped   int64
+	regions  map[int]*mmapRegion
+	lru      *list.List // 最近使用的在前
+	disabled bool       // 平台不支持映射
+}
+
+type mmapRegion struct {
+	fileID  int
+	data    []byte
+	refs    int
+	evicted bool
+	elem    *list.Element
+}
+
+func newMmapCache(budget int64) *mmapCache {
+	return &mmapCache{
+		budget:  budget,
+		regions: make(map[int]*mmapRegion),
+		lru:     list.New(),
+	}
+}
+
+// acquire 返回文件的映射及释放函数，文件为空、超过预算或无法映射时返回 false
+// 映射使用单独打开的句柄，建立后即关闭，不占用 openFiles 缓存
+func (c *mmapCache) acquire(fileID int, path string) ([]byte, func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disabled {
+		return nil, nil, false
+	}
+
+	region, ok := c.regions[fileID]
+	if !ok {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, false
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.Size() == 0 || info.Size() > c.budget {
+			return nil, nil, false
+		}
+		size := info.Size()
+		for c.mapped+size > c.budget && c.lru.Len() > 0 {
+			c.evict(c.lru.Back().Value.(*mmapRegion))
+		}
+		data, err := mmapFile(file, size)
+		if errors.Is(err, errMmapUnsupported) {
+			c.disabled = true
+		}
+		if err != nil {
+			return nil, nil, false
+		}
+		region = &mmapRegion{fileID: fileID, data: data}
+		region.elem = c.lru.PushFront(region)
+		c.regions[fileID] = region
+		c.mapped += size
+	} else {
+		c.lru.MoveToFront(region.elem)
+	}
+
+	region.refs++
+	return region.data, func() { c.release(region) }, true
+}
+
+func (c *mmapCache) release(region *mmapRegion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	region.refs--
+	if region.evicted && region.refs == 0 {
+		_ = munmap(region.data)
+	}
+}
+
+// evict 移出缓存，没有读取方时立即解除映射，调用方需持有 mu
+func (c *mmapCache) evict(region *mmapRegion) {
+	if region.evicted {
+		return
+	}
+	region.evicted = true
+	c.lru.Remove(region.elem)
+	delete(c.regions, region.fileID)
+	c.mapped -= int64(len(region.data))
+	if region.refs == 0 {
+		_ = munmap(region.data)
+	}
+}
+
+// remove 文件被删除或截断前解除其映射
+func (c *mmapCache) remove(fileID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if region, ok := c.regions[fileID]; ok {
+		c.evict(region)
+	}
+}
+
+// mappedBytes 返回当前映射的总字节数
+func (c *mmapCache) mappedBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mapped
+}
+
+// close 解除所有映射
+func (c *mmapCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back().Value.(*mmapRegion))
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package file_manager
+
+import "os"
+
+func mmapFile(*os.File, int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap([]byte) error {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package file_manager
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,509 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ARTIndex 自适应基数树（Adaptive Radix Tree）索引，键按字节序排列
+// 共同前缀只存储一次，叶子不保存完整的键，遍历时由路径拼出；
+// 内部节点按子节点数在 4/16/48/256 四种布局间切换，稀疏节点占用更少内存
+type ARTIndex[V any] struct {
+	root *artNode[V]
+	size int
+	mu   sync.RWMutex
+}
+
+// 节点布局
+const (
+	artLeaf uint8 = iota // 没有子节点
+	artNode4
+	artNode16
+	artNode48
+	artNode256
+)
+
+// artNode 树中的一个节点，prefix 为从父节点到此处压缩的路径
+// node4/node16 的 keys 为有序的子节点字节；node48 的 keys 为 256 项的下标表，0 表示没有子节点；node256 直接按字节索引 children
+type artNode[V any] struct {
+	prefix   []byte
+	value    V
+	hasValue bool
+	kind     uint8
+	n        uint16
+	keys     []byte
+	children []*artNode[V]
+}
+
+func NewARTIndex[V any]() *ARTIndex[V] {
+	return &ARTIndex[V]{}
+}
+
+func (t *ARTIndex[V]) Put(key string, value V) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.insert(&t.root, []byte(key), value) {
+		t.size++
+	}
+	return nil
+}
+
+func (t *ARTIndex[V]) Get(key string) (V, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	node, k := t.root, []byte(key)
+	for node != nil {
+		if !bytes.HasPrefix(k, node.prefix) {
+			break
+		}
+		k = k[len(node.prefix):]
+		if len(k) == 0 {
+			if node.hasValue {
+				return node.value, nil
+			}
+			break
+		}
+		node, k = node.child(k[0]), k[1:]
+	}
+	var zero V
+	return zero, fmt.Errorf("key not found: %v", key)
+}
+
+func (t *ARTIndex[V]) Del(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.remove(&t.root, []byte(key)) {
+		return fmt.Errorf("key not found: %v", key)
+	}
+	t.size--
+	return nil
+}
+
+func (t *ARTIndex[V]) Foreach(f func(key string, value V) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.root != nil {
+		walk(t.root, nil, f)
+	}
+	return nil
+}
+
+// AscendGreaterOrEqual 从第一个不小于 pivot 的键开始按字节序升序遍历
+func (t *ARTIndex[V]) AscendGreaterOrEqual(pivot string, f func(key string, value V) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.root != nil {
+		ascend(t.root, nil, []byte(pivot), f)
+	}
+	return nil
+}
+
+// AscendPrefix 按升序遍历带 prefix 前缀的键
+func (t *ARTIndex[V]) AscendPrefix(prefix string, f func(key string, value V) bool) error {
+	return t.AscendGreaterOrEqual(prefix, func(key string, value V) bool {
+		return strings.HasPrefix(key, prefix) && f(key, value)
+	})
+}
+
+// DescendLessOrEqual 从最后一个不大于 pivot 的键开始按字节序降序遍历
+func (t *ARTIndex[V]) DescendLessOrEqual(pivot string, f func(key string, value V) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.root != nil {
+		descend(t.root, nil, []byte(pivot), f)
+	}
+	return nil
+}
+
+// Descend 从最大的键开始按字节序降序遍历
+func (t *ARTIndex[V]) Descend(f func(key string, value V) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.root != nil {
+		walkReverse(t.root, nil, f)
+	}
+	return nil
+}
+
+// Len 返回键的数量
+func (t *ARTIndex[V]) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.size
+}
+
+func (t *ARTIndex[V]) Clear() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root, t.size = nil, 0
+	return nil
+}
+
+// insert 在 *ref 为根的子树中写入 key，返回是否新增了键
+func (t *ARTIndex[V]) insert(ref **artNode[V], key []byte, value V) bool {
+	node := *ref
+	if node == nil {
+		*ref = newArtLeaf(key, value)
+		return true
+	}
+
+	p := commonPrefixLen(node.prefix, key)
+	if p < len(node.prefix) {
+		// 压缩路径在 p 处分叉，插入新的父节点
+		parent := &artNode[V]{prefix: bytes.Clone(node.prefix[:p])}
+		parent.grow()
+		c := node.prefix[p]
+		node.prefix = bytes.Clone(node.prefix[p+1:])
+		parent.addChild(c, node)
+		if p == len(key) {
+			parent.value, parent.hasValue = value, true
+		} else {
+			parent.addChild(key[p], newArtLeaf(key[p+1:], value))
+		}
+		*ref = parent
+		return true
+	}
+
+	key = key[p:]
+	if len(key) == 0 {
+		added := !node.hasValue
+		node.value, node.hasValue = value, true
+		return added
+	}
+	if child := node.childRef(key[0]); child != nil {
+		return t.insert(child, key[1:], value)
+	}
+	node.addChild(key[0], newArtLeaf(key[1:], value))
+	return true
+}
+
+// remove 从 *ref 为根的子树中删除 key，返回键是否存在
+func (t *ARTIndex[V]) remove(ref **artNode[V], key []byte) bool {
+	node := *ref
+	if node == nil || !bytes.HasPrefix(key, node.prefix) {
+		return false
+	}
+	key = key[len(node.prefix):]
+	if len(key) == 0 {
+		if !node.hasValue {
+			return false
+		}
+		var zero V
+		node.value, node.hasValue = zero, false
+	} else {
+		child := node.childRef(key[0])
+		if child == nil || !t.remove(child, key[1:]) {
+			return false
+		}
+		if *child == nil {
+			node.removeChild(key[0])
+		}
+	}
+	compact(ref)
+	return true
+}
+
+// compact 删除后整理节点：没有值与子节点时移除，只剩一个子节点时与其合并路径
+func compact[V any](ref **artNode[V]) {
+	node := *ref
+	if node.hasValue {
+		return
+	}
+	switch node.n {
+	case 0:
+		*ref = nil
+	case 1:
+		var (
+			c     byte
+			child *artNode[V]
+		)
+		node.eachChild(0, func(b byte, n *artNode[V]) bool {
+			c, child = b, n
+			return false
+		})
+		prefix := make([]byte, 0, len(node.prefix)+1+len(child.prefix))
+		prefix = append(append(append(prefix, node.prefix...), c), child.prefix...)
+		child.prefix = prefix
+		*ref = child
+	}
+}
+
+// walk 按升序遍历 node 为根的子树，buf 为到达 node 之前的路径
+func walk[V any](node *artNode[V], buf []byte, f func(key string, value V) bool) bool {
+	buf = append(buf, node.prefix...)
+	if node.hasValue && !f(string(buf), node.value) {
+		return false
+	}
+	return node.eachChild(0, func(c byte, child *artNode[V]) bool {
+		return walk(child, append(buf, c), f)
+	})
+}
+
+// ascend 按升序遍历子树中路径不小于 buf+pivot 的键
+func ascend[V any](node *artNode[V], buf, pivot []byte, f func(key string, value V) bool) bool {
+	m := min(len(node.prefix), len(pivot))
+	switch cmp := bytes.Compare(node.prefix[:m], pivot[:m]); {
+	case cmp < 0:
+		// 整棵子树都小于 pivot
+		return true
+	case cmp > 0 || len(pivot) <= len(node.prefix):
+		// 整棵子树都不小于 pivot
+		return walk(node, buf, f)
+	}
+
+	// 节点自身的键是 pivot 的真前缀，小于 pivot，跳过
+	buf = append(buf, node.prefix...)
+	pivot = pivot[len(node.prefix):]
+	return node.eachChild(int(pivot[0]), func(c byte, child *artNode[V]) bool {
+		if c == pivot[0] {
+			return ascend(child, append(buf, c), pivot[1:], f)
+		}
+		return walk(child, append(buf, c), f)
+	})
+}
+
+// walkReverse 按降序遍历 node 为根的子树，节点自身的键是子节点的前缀，最后访问
+func walkReverse[V any](node *artNode[V], buf []byte, f func(key string, value V) bool) bool {
+	buf = append(buf, node.prefix...)
+	if !node.eachChildReverse(255, func(c byte, child *artNode[V]) bool {
+		return walkReverse(child, append(buf, c), f)
+	}) {
+		return false
+	}
+	return !node.hasValue || f(string(buf), node.value)
+}
+
+// descend 按降序遍历子树中路径不大于 buf+pivot 的键
+func descend[V any](node *artNode[V], buf, pivot []byte, f func(key string, value V) bool) bool {
+	m := min(len(node.prefix), len(pivot))
+	switch cmp := bytes.Compare(node.prefix[:m], pivot[:m]); {
+	case cmp < 0:
+		// 整棵子树都小于 pivot
+		return walkReverse(node, buf, f)
+	case cmp > 0 || len(pivot) < len(node.prefix):
+		// 整棵子树都大于 pivot
+		return true
+	}
+
+	buf = append(buf, node.prefix...)
+	if len(pivot) == len(node.prefix) {
+		// 节点自身的键等于 pivot，子节点都大于 pivot
+		return !node.hasValue || f(string(buf), node.value)
+	}
+	// 节点自身的键是 pivot 的真前缀，小于 pivot，最后访问
+	pivot = pivot[len(node.prefix):]
+	if !node.eachChildReverse(int(pivot[0]), func(c byte, child *artNode[V]) bool {
+		if c == pivot[0] {
+			return descend(child, append(buf, c), pivot[1:], f)
+		}
+		return walkReverse(child, append(buf, c), f)
+	}) {
+		return false
+	}
+	return !node.hasValue || f(string(buf), node.value)
+}
+
+func newArtLeaf[V any](suffix []byte, value V) *artNode[V] {
+	return &artNode[V]{prefix: bytes.Clone(suffix), value: value, hasValue: true}
+}
+
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// child 返回字节 c 对应的子节点
+func (n *artNode[V]) child(c byte) *artNode[V] {
+	if ref := n.childRef(c); ref != nil {
+		return *ref
+	}
+	return nil
+}
+
+// childRef 返回字节 c 对应子节点所在的位置，没有时返回 nil
+func (n *artNode[V]) childRef(c byte) **artNode[V] {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < int(n.n); i++ {
+			if n.keys[i] == c {
+				return &n.children[i]
+			}
+		}
+	case artNode48:
+		if i := n.keys[c]; i != 0 {
+			return &n.children[i-1]
+		}
+	case artNode256:
+		if n.children[c] != nil {
+			return &n.children[c]
+		}
+	}
+	return nil
+}
+
+// addChild 添加字节 c 对应的子节点，节点已满时先扩大布局
+func (n *artNode[V]) addChild(c byte, child *artNode[V]) {
+	if n.full() {
+		n.grow()
+	}
+	switch n.kind {
+	case artNode4, artNode16:
+		i := 0
+		for i < int(n.n) && n.keys[i] < c {
+			i++
+		}
+		copy(n.keys[i+1:n.n+1], n.keys[i:n.n])
+		copy(n.children[i+1:n.n+1], n.children[i:n.n])
+		n.keys[i], n.children[i] = c, child
+	case artNode48:
+		i := 0
+		for n.children[i] != nil {
+			i++
+		}
+		n.children[i] = child
+		n.keys[c] = byte(i + 1)
+	case artNode256:
+		n.children[c] = child
+	}
+	n.n++
+}
+
+// removeChild 删除字节 c 对应的子节点，子节点过少时缩小布局
+func (n *artNode[V]) removeChild(c byte) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < int(n.n); i++ {
+			if n.keys[i] == c {
+				copy(n.keys[i:], n.keys[i+1:n.n])
+				copy(n.children[i:], n.children[i+1:n.n])
+				n.children[n.n-1] = nil
+				break
+			}
+		}
+	case artNode48:
+		n.children[n.keys[c]-1] = nil
+		n.keys[c] = 0
+	case artNode256:
+		n.children[c] = nil
+	}
+	n.n--
+	n.shrink()
+}
+
+// eachChild 从字节 from 开始按升序访问子节点，fn 返回 false 时停止
+func (n *artNode[V]) eachChild(from int, fn func(c byte, child *artNode[V]) bool) bool {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < int(n.n); i++ {
+			if int(n.keys[i]) >= from && !fn(n.keys[i], n.children[i]) {
+				return false
+			}
+		}
+	case artNode48:
+		for c := from; c < 256; c++ {
+			if i := n.keys[c]; i != 0 && !fn(byte(c), n.children[i-1]) {
+				return false
+			}
+		}
+	case artNode256:
+		for c := from; c < 256; c++ {
+			if child := n.children[c]; child != nil && !fn(byte(c), child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// eachChildReverse 从字节 from 开始按降序访问子节点，fn 返回 false 时停止
+func (n *artNode[V]) eachChildReverse(from int, fn func(c byte, child *artNode[V]) bool) bool {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := int(n.n) - 1; i >= 0; i-- {
+			if int(n.keys[i]) <= from && !fn(n.keys[i], n.children[i]) {
+				return false
+			}
+		}
+	case artNode48:
+		for c := from; c >= 0; c-- {
+			if i := n.keys[c]; i != 0 && !fn(byte(c), n.children[i-1]) {
+				return false
+			}
+		}
+	case artNode256:
+		for c := from; c >= 0; c-- {
+			if child := n.children[c]; child != nil && !fn(byte(c), child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (n *artNode[V]) full() bool {
+	switch n.kind {
+	case artLeaf:
+		return true
+	case artNode4:
+		return n.n == 4
+	case artNode16:
+		return n.n == 16
+	case artNode48:
+		return n.n == 48
+	}
+	return false
+}
+
+// grow 换成容量更大的布局
+func (n *artNode[V]) grow() {
+	n.resize(n.kind + 1)
+}
+
+// shrink 子节点数降到较小布局的容量以下时换回较小的布局，留有余量避免反复切换
+func (n *artNode[V]) shrink() {
+	switch {
+	case n.n == 0:
+		n.resize(artLeaf)
+	case n.kind == artNode16 && n.n <= 3,
+		n.kind == artNode48 && n.n <= 12,
+		n.kind == artNode256 && n.n <= 40:
+		n.resize(n.kind - 1)
+	}
+}
+
+// resize 以 kind 布局重建子节点表
+func (n *artNode[V]) resize(kind uint8) {
+	var (
+		keys     []byte
+		children []*artNode[V]
+	)
+	n.eachChild(0, func(c byte, child *artNode[V]) bool {
+		keys, children = append(keys, c), append(children, child)
+		return true
+	})
+
+	n.kind, n.n = kind, 0
+	switch kind {
+	case artLeaf:
+		n.keys, n.children = nil, nil
+	case artNode4:
+		n.keys, n.children = make([]byte, 4), make([]*artNode[V], 4)
+	case artNode16:
+		n.keys, n.children = make([]byte, 16), make([]*artNode[V], 16)
+	case artNode48:
+		n.keys, n.children = make([]byte, 256), make([]*artNode[V], 48)
+	case artNode256:
+		n.keys, n.children = nil, make([]*artNode[V], 256)
+	}
+	for i := range keys {
+		n.addChild(keys[i], children[i])
+	}
+}
@@ -0,0 +1,151 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestARTIndex(t *testing.T) {
+	art := NewARTIndex[int]()
+
+	// 共享前缀、互为前缀的键
+	keys := []string{"hash:user:1:name", "hash:user:1:age", "hash:user:12:name", "hash:user:1", "hash", "", "list:a"}
+	for i, key := range keys {
+		require.NoError(t, art.Put(key, i))
+	}
+	for i, key := range keys {
+		val, err := art.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, i, val)
+	}
+	_, err := art.Get("hash:user")
+	assert.Error(t, err)
+	_, err = art.Get("hash:user:1:nam")
+	assert.Error(t, err)
+	assert.Equal(t, len(keys), art.Len())
+
+	var got []string
+	require.NoError(t, art.AscendPrefix("hash:user:1", func(key string, _ int) bool {
+		got = append(got, key)
+		return true
+	}))
+	assert.Equal(t, []string{"hash:user:1", "hash:user:12:name", "hash:user:1:age", "hash:user:1:name"}, got)
+
+	got = got[:0]
+	require.NoError(t, art.AscendGreaterOrEqual("hash:user:1:b", func(key string, _ int) bool {
+		got = append(got, key)
+		return len(got) < 2
+	}))
+	assert.Equal(t, []string{"hash:user:1:name", "list:a"}, got)
+
+	require.NoError(t, art.Del("hash:user:1"))
+	assert.Error(t, art.Del("hash:user:1"))
+	_, err = art.Get("hash:user:1")
+	assert.Error(t, err)
+	val, err := art.Get("hash:user:1:age")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+
+	require.NoError(t, art.Clear())
+	assert.Zero(t, art.Len())
+	_, err = art.Get("hash")
+	assert.Error(t, err)
+}
+
+func TestARTIndexRandom(t *testing.T) {
+	art := NewARTIndex[int]()
+	ref := map[string]int{}
+	rnd := rand.New(rand.NewSource(1))
+
+	// 子节点数在各布局之间反复增减
+	randomKey := func() string {
+		if rnd.Intn(4) == 0 {
+			return fmt.Sprintf("b:%s", []byte{byte(rnd.Intn(256))})
+		}
+		return fmt.Sprintf("k:%c:%d", 'a'+rnd.Intn(26), rnd.Intn(300))
+	}
+	for i := 0; i < 20000; i++ {
+		key := randomKey()
+		if rnd.Intn(3) == 0 {
+			_, ok := ref[key]
+			err := art.Del(key)
+			assert.Equal(t, ok, err == nil, "delete %q", key)
+			delete(ref, key)
+			continue
+		}
+		require.NoError(t, art.Put(key, i))
+		ref[key] = i
+	}
+
+	want := make([]string, 0, len(ref))
+	for key := range ref {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+
+	var got []string
+	require.NoError(t, art.Foreach(func(key string, value int) bool {
+		assert.Equal(t, ref[key], value)
+		got = append(got, key)
+		return true
+	}))
+	assert.Equal(t, want, got)
+	assert.Equal(t, len(ref), art.Len())
+
+	for _, pivot := range []string{"", "b:\x80", "k:", "k:m", "k:m:15", "k:m:150", "k:m:1~", "k:z:999", "l"} {
+		i := sort.SearchStrings(want, pivot)
+		seek := []string{}
+		require.NoError(t, art.AscendGreaterOrEqual(pivot, func(key string, _ int) bool {
+			seek = append(seek, key)
+			return true
+		}))
+		assert.Equal(t, want[i:], seek, "pivot %q", pivot)
+
+		// 降序从最后一个不大于 pivot 的键开始
+		j := sort.Search(len(want), func(j int) bool { return want[j] > pivot })
+		seek = []string{}
+		require.NoError(t, art.DescendLessOrEqual(pivot, func(key string, _ int) bool {
+			seek = append(seek, key)
+			return true
+		}))
+		require.Len(t, seek, j, "pivot %q", pivot)
+		for k, key := range seek {
+			assert.Equal(t, want[j-1-k], key, "pivot %q", pivot)
+		}
+	}
+
+	got = got[:0]
+	require.NoError(t, art.Descend(func(key string, _ int) bool {
+		got = append(got, key)
+		return true
+	}))
+	require.Len(t, got, len(want))
+	for k, key := range got {
+		assert.Equal(t, want[len(want)-1-k], key)
+	}
+
+	var prefixed []string
+	for _, key := range want {
+		if strings.HasPrefix(key, "k:q:1") {
+			prefixed = append(prefixed, key)
+		}
+	}
+	got = got[:0]
+	require.NoError(t, art.AscendPrefix("k:q:1", func(key string, _ int) bool {
+		got = append(got, key)
+		return true
+	}))
+	assert.Equal(t, prefixed, got)
+
+	// 全部删除后树为空
+	for _, key := range want {
+		require.NoError(t, art.Del(key))
+	}
+	assert.Nil(t, art.root)
+}
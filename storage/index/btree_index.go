@@ -86,6 +86,49 @@ func (b *BTreeIndex[K, V]) Foreach(f func(key K, value V) bool) error {
 	return nil
 }
 
+func (b *BTreeIndex[K, V]) AscendGreaterOrEqual(pivot K, f func(key K, value V) bool) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	item := &Item[K, V]{
+		key:  pivot,
+		less: b.comparator,
+	}
+	b.tree.AscendGreaterOrEqual(item, func(i btree.Item) bool {
+		item := i.(*Item[K, V])
+		return f(item.key, item.value)
+	})
+	return nil
+}
+
+// DescendLessOrEqual 从最后一个不大于 pivot 的键开始按降序遍历
+func (b *BTreeIndex[K, V]) DescendLessOrEqual(pivot K, f func(key K, value V) bool) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	item := &Item[K, V]{
+		key:  pivot,
+		less: b.comparator,
+	}
+	b.tree.DescendLessOrEqual(item, func(i btree.Item) bool {
+		item := i.(*Item[K, V])
+		return f(item.key, item.value)
+	})
+	return nil
+}
+
+// Descend 从最大的键开始按降序遍历
+func (b *BTreeIndex[K, V]) Descend(f func(key K, value V) bool) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	b.tree.Descend(func(i btree.Item) bool {
+		item := i.(*Item[K, V])
+		return f(item.key, item.value)
+	})
+	return nil
+}
+
 func (b *BTreeIndex[K, V]) Clear() error {
 	b.lock.Lock()
 	defer b.lock.Unlock()
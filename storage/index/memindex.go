@@ -1,9 +1,12 @@
 package index
 
 import (
+	"container/heap"
 	"fmt"
+	"github.com/FinnTew/FincasKV/err_def"
 	storage2 "github.com/FinnTew/FincasKV/storage"
 	"hash/fnv"
+	"iter"
 	"log"
 	"math/rand"
 	"sync"
@@ -12,9 +15,28 @@ import (
 type MemIndexShard[K comparable, V any] struct {
 	shardCount int
 	shards     []storage2.MemIndex[K, V]
+	less       func(a, b K) bool // 有序索引的比较器，无序索引为 nil
+	bounds     []K               // 按范围分片时各分片的下界，第 i+1 个分片的键不小于 bounds[i]，按哈希分片时为 nil
 	sync.RWMutex
 }
 
+// ShardOption MemIndexShard 的可选配置
+type ShardOption[K comparable] func(*shardOptions[K])
+
+type shardOptions[K comparable] struct {
+	rangeSharding bool
+	bounds        []K
+}
+
+// WithRangeSharding 按键的范围分片，bounds 为升序排列的分界键，分片数为 len(bounds)+1
+// bounds 为空且键为字符串时按首字节均分；要求索引有序，分片内与分片间都按键序排列
+func WithRangeSharding[K comparable](bounds []K) ShardOption[K] {
+	return func(o *shardOptions[K]) {
+		o.rangeSharding = true
+		o.bounds = bounds
+	}
+}
+
 func NewMemIndexShard[K comparable, V any](
 	memIndexType storage2.MemIndexType,
 	shardCount int,
@@ -23,7 +45,19 @@ func NewMemIndexShard[K comparable, V any](
 	skipListRandSource rand.Source,
 	skipListLessFunc func(a, b K) int,
 	swissTableSize uint32,
+	opts ...ShardOption[K],
 ) *MemIndexShard[K, V] {
+	var o shardOptions[K]
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.rangeSharding {
+		if len(o.bounds) == 0 {
+			o.bounds = defaultBounds[K](shardCount)
+		}
+		shardCount = len(o.bounds) + 1
+	}
+
 	index := &MemIndexShard[K, V]{
 		shardCount: shardCount,
 		shards:     make([]storage2.MemIndex[K, V], shardCount),
@@ -39,6 +73,7 @@ func NewMemIndexShard[K comparable, V any](
 				log.Fatal("BTree less func cannot be nil")
 			}
 			index.shards[i] = NewBTreeIndex[K, V](btreeDegree, btreeLessFunc)
+			index.less = btreeLessFunc
 		case storage2.SkipList:
 			if skipListLessFunc == nil {
 				log.Fatal("SkipList less func cannot be nil")
@@ -48,23 +83,115 @@ func NewMemIndexShard[K comparable, V any](
 			} else {
 				index.shards[i] = NewSkipListIndex[K, V](skipListLessFunc, WithRandSource(skipListRandSource))
 			}
+			index.less = func(a, b K) bool {
+				return skipListLessFunc(a, b) < 0
+			}
 		case storage2.SwissTable:
 			if swissTableSize <= 0 {
 				swissTableSize = 1 << 10
 			}
 			index.shards[i] = NewSwissIndex[K, V](swissTableSize)
+		case storage2.ART:
+			// 基数树按键的字节组织，只支持字符串键，按字节序排列
+			shard, ok := any(NewARTIndex[V]()).(storage2.MemIndex[K, V])
+			if !ok {
+				log.Fatal("ART index requires string keys")
+			}
+			index.shards[i] = shard
+			index.less = func(a, b K) bool {
+				return any(a).(string) < any(b).(string)
+			}
 		default:
 			log.Fatal("Unsupported memIndex type")
 		}
 	}
 
+	if o.rangeSharding {
+		if index.less == nil {
+			log.Fatal("Range sharding requires an ordered memIndex type")
+		}
+		for i := 1; i < len(o.bounds); i++ {
+			if !index.less(o.bounds[i-1], o.bounds[i]) {
+				log.Fatal("Range sharding bounds must be strictly ascending")
+			}
+		}
+		index.bounds = o.bounds
+	}
+
 	return index
 }
 
+// defaultBounds 字符串键按首字节把 0~255 均分为 shardCount 段
+func defaultBounds[K comparable](shardCount int) []K {
+	if _, ok := any(*new(K)).(string); !ok {
+		log.Fatal("Range sharding bounds are required for non-string keys")
+	}
+	bounds := make([]K, 0, shardCount-1)
+	for i := 1; i < shardCount; i++ {
+		b := byte(i * 256 / shardCount)
+		if len(bounds) > 0 && any(bounds[len(bounds)-1]).(string)[0] == b {
+			continue
+		}
+		bounds = append(bounds, any(string([]byte{b})).(K))
+	}
+	return bounds
+}
+
 func (s *MemIndexShard[K, V]) getShard(key K) storage2.MemIndex[K, V] {
-	h := fnv.New32a()
-	h.Write([]byte(fmt.Sprintf("%v", key)))
-	return s.shards[h.Sum32()%uint32(s.shardCount)]
+	return s.shards[s.shardIndex(key)]
+}
+
+// shardIndex 返回键所在的分片，不产生内存分配
+func (s *MemIndexShard[K, V]) shardIndex(key K) int {
+	if s.bounds != nil {
+		// 第一个大于 key 的分界即为分片编号
+		lo, hi := 0, len(s.bounds)
+		for lo < hi {
+			mid := int(uint(lo+hi) >> 1)
+			if s.less(key, s.bounds[mid]) {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		return lo
+	}
+	return int(hashKey(key) % uint32(s.shardCount))
+}
+
+// hashKey 计算键的 FNV-1a 哈希，常见的键类型直接按字节计算
+func hashKey[K comparable](key K) uint32 {
+	h := uint32(2166136261)
+	mix := func(v uint64, n int) {
+		for i := 0; i < n; i++ {
+			h ^= uint32(byte(v >> (8 * i)))
+			h *= 16777619
+		}
+	}
+	switch k := any(key).(type) {
+	case string:
+		for i := 0; i < len(k); i++ {
+			h ^= uint32(k[i])
+			h *= 16777619
+		}
+	case int:
+		mix(uint64(k), 8)
+	case int64:
+		mix(uint64(k), 8)
+	case int32:
+		mix(uint64(k), 4)
+	case uint:
+		mix(uint64(k), 8)
+	case uint64:
+		mix(k, 8)
+	case uint32:
+		mix(uint64(k), 4)
+	default:
+		f := fnv.New32a()
+		f.Write([]byte(fmt.Sprintf("%v", key)))
+		return f.Sum32()
+	}
+	return h
 }
 
 func (s *MemIndexShard[K, V]) Put(key K, value V) error {
@@ -88,9 +215,18 @@ func (s *MemIndexShard[K, V]) Del(key K) error {
 	return shard.Del(key)
 }
 
+// Foreach 遍历所有键，有序索引按键序遍历：按范围分片时依次遍历各分片，按哈希分片时多路归并各分片
 func (s *MemIndexShard[K, V]) Foreach(f func(key K, value V) bool) error {
 	s.RLock()
 	defer s.RUnlock()
+	if s.less != nil && s.bounds == nil && len(s.shards) > 1 {
+		return s.foreachMerged(f)
+	}
+	return s.foreachShards(f)
+}
+
+// foreachShards 依次遍历各分片，调用方需持有读锁
+func (s *MemIndexShard[K, V]) foreachShards(f func(key K, value V) bool) error {
 	for _, shard := range s.shards {
 		stop := false
 		err := shard.Foreach(func(key K, value V) bool {
@@ -113,6 +249,197 @@ func (s *MemIndexShard[K, V]) Foreach(f func(key K, value V) bool) error {
 	return nil
 }
 
+// foreachMerged 以各分片的遍历为迭代器，按键序多路归并，调用方需持有读锁
+func (s *MemIndexShard[K, V]) foreachMerged(f func(key K, value V) bool) error {
+	h := &pullHeap[K, V]{less: s.less}
+	defer func() {
+		for _, it := range h.iters {
+			it.stop()
+		}
+	}()
+	var shardErr error
+	for _, shard := range s.shards {
+		next, stop := iter.Pull2(func(yield func(K, V) bool) {
+			if err := shard.Foreach(yield); err != nil && shardErr == nil {
+				shardErr = err
+			}
+		})
+		it := &pullIter[K, V]{next: next, stop: stop}
+		if it.advance() {
+			h.iters = append(h.iters, it)
+		} else {
+			stop()
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		it := h.iters[0]
+		if !f(it.key, it.value) {
+			return shardErr
+		}
+		if it.advance() {
+			heap.Fix(h, 0)
+		} else {
+			it.stop()
+			heap.Pop(h)
+		}
+	}
+	return shardErr
+}
+
+// Ordered 索引是否支持按键序遍历
+func (s *MemIndexShard[K, V]) Ordered() bool {
+	return s.less != nil
+}
+
+// Less 按索引的比较器比较两个键
+func (s *MemIndexShard[K, V]) Less(a, b K) bool {
+	return s.less(a, b)
+}
+
+// AscendRange 从第一个不小于 pivot 的键开始按升序遍历所有分片，while 返回 false 表示超出范围，
+// limit 大于 0 时最多遍历 limit 个键；各分片先收集范围内的记录再按键序归并，遍历 f 时不持有索引锁
+func (s *MemIndexShard[K, V]) AscendRange(pivot K, while func(key K) bool, limit int, f func(key K, value V) bool) error {
+	if s.less == nil {
+		return err_def.ErrIndexUnordered
+	}
+	s.RLock()
+	from := len(s.shards) - 1
+	if s.bounds != nil {
+		from = s.shardIndex(pivot)
+	}
+	runs, err := s.collectRuns(false, from, func(shard storage2.OrderedMemIndex[K, V], fn func(K, V) bool) error {
+		return shard.AscendGreaterOrEqual(pivot, fn)
+	}, while, limit)
+	s.RUnlock()
+	if err != nil {
+		return err
+	}
+	s.mergeRuns(runs, s.less, limit, f)
+	return nil
+}
+
+// DescendRange 从最后一个不大于 pivot 的键开始按降序遍历所有分片，参数与 AscendRange 相同
+func (s *MemIndexShard[K, V]) DescendRange(pivot K, while func(key K) bool, limit int, f func(key K, value V) bool) error {
+	if s.less == nil {
+		return err_def.ErrIndexUnordered
+	}
+	s.RLock()
+	from := len(s.shards) - 1
+	if s.bounds != nil {
+		from = s.shardIndex(pivot)
+	}
+	runs, err := s.collectRuns(true, from, func(shard storage2.OrderedMemIndex[K, V], fn func(K, V) bool) error {
+		return shard.DescendLessOrEqual(pivot, fn)
+	}, while, limit)
+	s.RUnlock()
+	if err != nil {
+		return err
+	}
+	s.mergeRuns(runs, func(a, b K) bool { return s.less(b, a) }, limit, f)
+	return nil
+}
+
+// DescendLast 从最大的键开始按降序遍历所有分片，参数与 AscendRange 相同
+func (s *MemIndexShard[K, V]) DescendLast(while func(key K) bool, limit int, f func(key K, value V) bool) error {
+	if s.less == nil {
+		return err_def.ErrIndexUnordered
+	}
+	s.RLock()
+	runs, err := s.collectRuns(true, len(s.shards)-1, func(shard storage2.OrderedMemIndex[K, V], fn func(K, V) bool) error {
+		return shard.Descend(fn)
+	}, while, limit)
+	s.RUnlock()
+	if err != nil {
+		return err
+	}
+	s.mergeRuns(runs, func(a, b K) bool { return s.less(b, a) }, limit, f)
+	return nil
+}
+
+// collectRuns 用 seek 收集各分片范围内的记录，每个分片最多 limit 条，调用方需持有读锁
+// 按范围分片时从第 from 个分片开始沿遍历方向依次收集，只返回一个序列，超出范围或凑够 limit 条后不再访问之后的分片；
+// 按哈希分片时每个分片返回一个序列，由 mergeRuns 归并
+func (s *MemIndexShard[K, V]) collectRuns(
+	desc bool,
+	from int,
+	seek func(shard storage2.OrderedMemIndex[K, V], fn func(K, V) bool) error,
+	while func(key K) bool,
+	limit int,
+) ([][]indexItem[K, V], error) {
+	collect := func(shard storage2.MemIndex[K, V], run []indexItem[K, V]) ([]indexItem[K, V], bool, error) {
+		ordered, ok := shard.(storage2.OrderedMemIndex[K, V])
+		if !ok {
+			return nil, false, err_def.ErrIndexUnordered
+		}
+		done := false
+		err := seek(ordered, func(key K, value V) bool {
+			if !while(key) || (limit > 0 && len(run) >= limit) {
+				done = true
+				return false
+			}
+			run = append(run, indexItem[K, V]{key: key, value: value})
+			return true
+		})
+		return run, done, err
+	}
+
+	if s.bounds != nil {
+		var run []indexItem[K, V]
+		for i := from; i >= 0 && i < len(s.shards); {
+			var done bool
+			var err error
+			if run, done, err = collect(s.shards[i], run); err != nil {
+				return nil, err
+			}
+			if done {
+				break
+			}
+			if desc {
+				i--
+			} else {
+				i++
+			}
+		}
+		return [][]indexItem[K, V]{run}, nil
+	}
+
+	runs := make([][]indexItem[K, V], 0, len(s.shards))
+	for _, shard := range s.shards {
+		run, _, err := collect(shard, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(run) > 0 {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// mergeRuns 按 less 多路归并各有序序列，f 返回 false 或 limit 大于 0 且已遍历 limit 个键时停止
+func (s *MemIndexShard[K, V]) mergeRuns(runs [][]indexItem[K, V], less func(a, b K) bool, limit int, f func(key K, value V) bool) {
+	h := &mergeHeap[K, V]{less: less}
+	for _, run := range runs {
+		if len(run) > 0 {
+			h.runs = append(h.runs, run)
+		}
+	}
+	heap.Init(h)
+	for n := 0; h.Len() > 0 && (limit <= 0 || n < limit); n++ {
+		run := h.runs[0]
+		if !f(run[0].key, run[0].value) {
+			return
+		}
+		if len(run) == 1 {
+			heap.Pop(h)
+		} else {
+			h.runs[0] = run[1:]
+			heap.Fix(h, 0)
+		}
+	}
+}
+
 func (s *MemIndexShard[K, V]) Clear() error {
 	s.Lock()
 	defer s.Unlock()
@@ -139,3 +466,62 @@ func (s *MemIndexShard[K, V]) Clear() error {
 		return nil
 	}
 }
+
+type indexItem[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// mergeHeap 按各有序序列的首个键组成的小顶堆
+type mergeHeap[K comparable, V any] struct {
+	runs [][]indexItem[K, V]
+	less func(a, b K) bool
+}
+
+func (h *mergeHeap[K, V]) Len() int { return len(h.runs) }
+func (h *mergeHeap[K, V]) Less(i, j int) bool {
+	return h.less(h.runs[i][0].key, h.runs[j][0].key)
+}
+func (h *mergeHeap[K, V]) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *mergeHeap[K, V]) Push(x any)    { h.runs = append(h.runs, x.([]indexItem[K, V])) }
+func (h *mergeHeap[K, V]) Pop() any {
+	old := h.runs
+	n := len(old)
+	x := old[n-1]
+	h.runs = old[:n-1]
+	return x
+}
+
+// pullIter 由分片遍历转换成的拉取式迭代器，key/value 为当前位置的记录
+type pullIter[K comparable, V any] struct {
+	key   K
+	value V
+	next  func() (K, V, bool)
+	stop  func()
+}
+
+func (it *pullIter[K, V]) advance() bool {
+	var ok bool
+	it.key, it.value, ok = it.next()
+	return ok
+}
+
+// pullHeap 按各迭代器当前键组成的小顶堆
+type pullHeap[K comparable, V any] struct {
+	iters []*pullIter[K, V]
+	less  func(a, b K) bool
+}
+
+func (h *pullHeap[K, V]) Len() int { return len(h.iters) }
+func (h *pullHeap[K, V]) Less(i, j int) bool {
+	return h.less(h.iters[i].key, h.iters[j].key)
+}
+func (h *pullHeap[K, V]) Swap(i, j int) { h.iters[i], h.iters[j] = h.iters[j], h.iters[i] }
+func (h *pullHeap[K, V]) Push(x any)    { h.iters = append(h.iters, x.(*pullIter[K, V])) }
+func (h *pullHeap[K, V]) Pop() any {
+	old := h.iters
+	n := len(old)
+	x := old[n-1]
+	h.iters = old[:n-1]
+	return x
+}
@@ -1,8 +1,15 @@
 package index
 
 import (
-	"github.com/FinnTew/FincasKV/storage"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/FinnTew/FincasKV/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMemIndexShard(t *testing.T) {
@@ -107,3 +114,115 @@ func TestMemIndexShard(t *testing.T) {
 		})
 	}
 }
+
+func TestMemIndexShardOrdered(t *testing.T) {
+	less := func(a, b string) bool { return a < b }
+	compare := strings.Compare
+
+	keys := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		keys = append(keys, fmt.Sprintf("%c:user:%d", 'a'+i%26, i))
+	}
+	want := append([]string(nil), keys...)
+	sort.Strings(want)
+
+	tests := []struct {
+		name   string
+		ds     storage.MemIndexType
+		opts   []ShardOption[string]
+		shards int
+	}{
+		{"hash btree", storage.BTree, nil, 8},
+		{"hash skiplist", storage.SkipList, nil, 8},
+		{"hash art", storage.ART, nil, 8},
+		{"range btree", storage.BTree, []ShardOption[string]{WithRangeSharding([]string{"f", "m", "m:user:5", "t"})}, 5},
+		{"range art default bounds", storage.ART, []ShardOption[string]{WithRangeSharding[string](nil)}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemIndexShard[string, int](tt.ds, 8, 4, less, rand.NewSource(1), compare, 0, tt.opts...)
+			require.Len(t, s.shards, tt.shards)
+			for i, k := range keys {
+				require.NoError(t, s.Put(k, i))
+			}
+
+			// 全局按键序遍历
+			var got []string
+			require.NoError(t, s.Foreach(func(key string, _ int) bool {
+				got = append(got, key)
+				return true
+			}))
+			assert.Equal(t, want, got)
+
+			// 提前结束遍历
+			got = got[:0]
+			require.NoError(t, s.Foreach(func(key string, _ int) bool {
+				got = append(got, key)
+				return len(got) < 10
+			}))
+			assert.Equal(t, want[:10], got)
+
+			// 范围遍历
+			got = got[:0]
+			require.NoError(t, s.AscendRange("m:", func(key string) bool {
+				return strings.HasPrefix(key, "m:")
+			}, 0, func(key string, _ int) bool {
+				got = append(got, key)
+				return true
+			}))
+			var prefixed []string
+			for _, k := range want {
+				if strings.HasPrefix(k, "m:") {
+					prefixed = append(prefixed, k)
+				}
+			}
+			assert.Equal(t, prefixed, got)
+
+			// 限定数量的范围遍历
+			got = got[:0]
+			require.NoError(t, s.AscendRange("m:", func(key string) bool {
+				return strings.HasPrefix(key, "m:")
+			}, 5, func(key string, _ int) bool {
+				got = append(got, key)
+				return true
+			}))
+			assert.Equal(t, prefixed[:5], got)
+
+			// 降序遍历
+			reversed := make([]string, len(prefixed))
+			for i, k := range prefixed {
+				reversed[len(prefixed)-1-i] = k
+			}
+			got = got[:0]
+			require.NoError(t, s.DescendRange("m:~", func(key string) bool {
+				return strings.HasPrefix(key, "m:")
+			}, 0, func(key string, _ int) bool {
+				got = append(got, key)
+				return true
+			}))
+			assert.Equal(t, reversed, got)
+
+			got = got[:0]
+			require.NoError(t, s.DescendLast(func(string) bool { return true }, 7, func(key string, _ int) bool {
+				got = append(got, key)
+				return true
+			}))
+			require.Len(t, got, 7)
+			for i, k := range got {
+				assert.Equal(t, want[len(want)-1-i], k)
+			}
+		})
+	}
+}
+
+func TestMemIndexShardAllocs(t *testing.T) {
+	less := func(a, b string) bool { return a < b }
+	hashed := NewMemIndexShard[string, int](storage.SwissTable, 16, 4, less, nil, strings.Compare, 0)
+	ranged := NewMemIndexShard[string, int](storage.BTree, 16, 4, less, nil, strings.Compare, 0, WithRangeSharding[string](nil))
+	key := "hash:user:1234:field"
+	assert.Zero(t, testing.AllocsPerRun(100, func() { hashed.getShard(key) }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { ranged.getShard(key) }))
+
+	ints := NewMemIndexShard[int, int](storage.SwissTable, 16, 4, nil, nil, nil, 0)
+	assert.Zero(t, testing.AllocsPerRun(100, func() { ints.getShard(1234) }))
+}
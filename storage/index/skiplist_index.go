@@ -178,6 +178,72 @@ func (sl *SkipListIndex[K, V]) Foreach(f func(key K, value V) bool) error {
 	return nil
 }
 
+func (sl *SkipListIndex[K, V]) AscendGreaterOrEqual(pivot K, f func(key K, value V) bool) error {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	current := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && sl.compare(current.next[i].key, pivot) < 0 {
+			current = current.next[i]
+		}
+	}
+
+	current = current.next[0]
+	for current != nil {
+		if !f(current.key, current.value) {
+			break
+		}
+		current = current.next[0]
+	}
+	return nil
+}
+
+// DescendLessOrEqual 从最后一个不大于 pivot 的键开始按降序遍历
+// 跳表只有前向指针，每一步都从头查找前一个节点
+func (sl *SkipListIndex[K, V]) DescendLessOrEqual(pivot K, f func(key K, value V) bool) error {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	sl.descendFrom(sl.lastWhere(func(key K) bool {
+		return sl.compare(key, pivot) <= 0
+	}), f)
+	return nil
+}
+
+// Descend 从最大的键开始按降序遍历
+func (sl *SkipListIndex[K, V]) Descend(f func(key K, value V) bool) error {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	sl.descendFrom(sl.lastWhere(func(K) bool { return true }), f)
+	return nil
+}
+
+// descendFrom 从 current 开始逐个向前一个节点遍历，调用方需持有读锁
+func (sl *SkipListIndex[K, V]) descendFrom(current *node[K, V], f func(key K, value V) bool) {
+	for current != sl.head {
+		if !f(current.key, current.value) {
+			return
+		}
+		key := current.key
+		current = sl.lastWhere(func(k K) bool {
+			return sl.compare(k, key) < 0
+		})
+	}
+}
+
+// lastWhere 返回最后一个满足 match 的节点，match 须对升序的键先真后假，没有时返回 head
+func (sl *SkipListIndex[K, V]) lastWhere(match func(key K) bool) *node[K, V] {
+	current := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && match(current.next[i].key) {
+			current = current.next[i]
+		}
+	}
+	return current
+}
+
 func (sl *SkipListIndex[K, V]) Clear() error {
 	sl.lock.Lock()
 	defer sl.lock.Unlock()
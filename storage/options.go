@@ -16,21 +16,66 @@ const (
 	BTree      MemIndexType = "btree"
 	SkipList   MemIndexType = "skiplist"
 	SwissTable MemIndexType = "swisstable"
+	ART        MemIndexType = "art"
+)
+
+// ShardingMode 内存索引的分片方式
+type ShardingMode string
+
+const (
+	HashSharding  ShardingMode = "hash"  // 按键的哈希分片，有序索引遍历时多路归并各分片
+	RangeSharding ShardingMode = "range" // 按键的范围分片，要求有序索引，分片依次排列即为键序
+)
+
+// DurabilityMode 写入持久化模式
+type DurabilityMode string
+
+const (
+	DurabilityNone     DurabilityMode = "none"     // 不主动 fsync，由操作系统决定刷盘时机
+	DurabilityInterval DurabilityMode = "interval" // 按 SyncInterval 定期 fsync
+	DurabilityAlways   DurabilityMode = "always"   // 每次写入 fsync 后再返回
+	DurabilityGroup    DurabilityMode = "group"    // 合并并发写入，一次写入一次 fsync 后统一返回
+)
+
+// RecoveryPolicy 加载时遇到非活动文件中损坏记录的处理策略
+type RecoveryPolicy string
+
+const (
+	RecoveryFail    RecoveryPolicy = "fail"    // 拒绝打开
+	RecoverySkip    RecoveryPolicy = "skip"    // 丢弃损坏位置之后的内容，并将文件复制到隔离目录
+	RecoverySalvage RecoveryPolicy = "salvage" // 跳过损坏区域，从下一条有效记录继续加载
+)
+
+// CompressionType 值压缩算法
+type CompressionType string
+
+const (
+	CompressionNone  CompressionType = "none"
+	CompressionFlate CompressionType = "flate"
+	CompressionZlib  CompressionType = "zlib"
 )
 
 type MemCacheType string
 
 const (
-	LRU MemCacheType = "lru"
+	LRU      MemCacheType = "lru"
+	LFU      MemCacheType = "lfu"
+	ARC      MemCacheType = "arc"
+	WTinyLFU MemCacheType = "wtinylfu"
 )
 
 type Options struct {
 	// 基本配置
 	DataDir string
+	// 只读打开：对数据目录加共享锁，可与写入者同时打开；不创建、不轮转文件，不合并、不 fsync。
+	// 共享锁持有到关闭，期间写入者合并替换的旧文件不会删除，长期只读打开会占用额外的磁盘空间
+	ReadOnly bool
 
 	// 内存索引相关
 	MemIndexDS         MemIndexType             // 内存索引数据结构
 	MemIndexShardCount int                      // 内存索引分片数量
+	MemIndexSharding   ShardingMode             // 内存索引分片方式
+	MemIndexBounds     []string                 // 按范围分片时的分界键，为空时按首字节均分
 	BTreeDegree        int                      // B树的度
 	BTreeComparator    BTreeLessFunc[string]    // B树的比较器
 	SkipListRandSource rand.Source              // 跳表的随机源
@@ -38,19 +83,44 @@ type Options struct {
 	SwissTableSize     uint32                   // SwissTable 的大小
 
 	// 内存缓存相关
-	OpenMemCache bool         // 是否开启内存缓存
-	MemCacheDS   MemCacheType // 内存缓存数据结构
-	MemCacheSize int          // 内存缓存大小
+	OpenMemCache  bool         // 是否开启内存缓存
+	MemCacheDS    MemCacheType // 内存缓存数据结构
+	MemCacheSize  int          // 内存缓存大小，LRU 按项数限制
+	MemCacheBytes int64        // 内存缓存的字节数上限，LFU/ARC/W-TinyLFU 按键与值的长度限制
+
+	// 布隆过滤器相关
+	BloomExpectedElements  uint64  // 预期键数量，实际键数更多时在合并或重新打开时按实际数量重建
+	BloomFalsePositiveRate float64 // 期望误判率
 
 	// 文件管理器相关
-	MaxFileSize  int64         // 每个文件的最大大小
-	MaxOpenFiles int           // 最大打开文件数
-	SyncInterval time.Duration // 同步间隔
+	MaxFileSize  int64          // 每个文件的最大大小
+	MaxOpenFiles int            // 最大打开文件数
+	SyncInterval time.Duration  // 同步间隔
+	Durability   DurabilityMode // 持久化模式
+	Recovery     RecoveryPolicy // 损坏记录处理策略
+	MmapReads    bool           // 以只读内存映射读取已封存的数据文件
+	MmapBudget   int64          // 内存映射的总字节数上限
+	WriteLanes   int            // 并行写入的通道数，每个通道有独立的活动文件
+
+	// 压缩相关
+	Compression       CompressionType // 值压缩算法
+	CompressThreshold int             // 值长度达到该阈值才压缩
+
+	// 加密相关
+	KeyProvider KeyProvider // 为 nil 时不加密
+
+	// 大值分离存储相关
+	BlobThreshold int     // 值长度达到该阈值时写入单独的 blob 文件，数据文件只保存指针，0 表示不分离
+	BlobGCRatio   float64 // blob 文件中不再被引用的数据占比达到该值时重写
 
 	// Merge 相关
 	AutoMerge     bool
 	MergeInterval time.Duration
 	MinMergeRatio float64
+
+	// 后台校验相关
+	ScrubInterval time.Duration // 两轮校验之间的间隔，0 表示不启动后台校验
+	ScrubRate     int64         // 校验读取速率上限，字节/秒，0 表示不限速
 }
 
 type Option func(opt *Options)
@@ -64,6 +134,7 @@ func DefaultOptions() *Options {
 		DataDir:            "/tmp/fincas",
 		MemIndexDS:         SwissTable,
 		MemIndexShardCount: 1 << 8,
+		MemIndexSharding:   HashSharding,
 		BTreeDegree:        8,
 		BTreeComparator: func(a, b string) bool {
 			return a < b
@@ -78,16 +149,28 @@ func DefaultOptions() *Options {
 				return 0
 			}
 		},
-		SwissTableSize: 1 << 10,
-		OpenMemCache:   true,
-		MemCacheDS:     LRU,
-		MemCacheSize:   1 << 10,
-		MaxFileSize:    1 << 30,
-		MaxOpenFiles:   10,
-		SyncInterval:   5 * time.Second,
-		AutoMerge:      true,
-		MergeInterval:  time.Hour,
-		MinMergeRatio:  0.3,
+		SwissTableSize:         1 << 10,
+		OpenMemCache:           true,
+		MemCacheDS:             LRU,
+		MemCacheSize:           1 << 10,
+		MemCacheBytes:          64 << 20,
+		BloomExpectedElements:  1 << 18,
+		BloomFalsePositiveRate: 0.01,
+		MaxFileSize:            1 << 30,
+		MaxOpenFiles:           10,
+		SyncInterval:           5 * time.Second,
+		Durability:             DurabilityInterval,
+		Recovery:               RecoveryFail,
+		MmapBudget:             1 << 30,
+		WriteLanes:             1,
+		Compression:            CompressionNone,
+		CompressThreshold:      256,
+		BlobThreshold:          0,
+		BlobGCRatio:            0.5,
+		AutoMerge:              true,
+		MergeInterval:          time.Hour,
+		MinMergeRatio:          0.3,
+		ScrubRate:              8 << 20,
 	}
 }
 
@@ -97,6 +180,12 @@ func WithDataDir(dataDir string) Option {
 	}
 }
 
+func WithReadOnly(readOnly bool) Option {
+	return func(opt *Options) {
+		opt.ReadOnly = readOnly
+	}
+}
+
 func WithMemIndexDS(memIndexDS MemIndexType) Option {
 	return func(opt *Options) {
 		opt.MemIndexDS = memIndexDS
@@ -109,6 +198,13 @@ func WithMemIndexShardCount(memIndexShardCount int) Option {
 	}
 }
 
+func WithMemIndexSharding(mode ShardingMode, bounds []string) Option {
+	return func(opt *Options) {
+		opt.MemIndexSharding = mode
+		opt.MemIndexBounds = bounds
+	}
+}
+
 func WithBTreeDegree(bTreeDegree int) Option {
 	return func(opt *Options) {
 		opt.BTreeDegree = bTreeDegree
@@ -145,6 +241,19 @@ func WithMemCacheDS(memCacheDS MemCacheType) Option {
 	}
 }
 
+func WithMemCacheBytes(memCacheBytes int64) Option {
+	return func(opt *Options) {
+		opt.MemCacheBytes = memCacheBytes
+	}
+}
+
+func WithBloomFilter(expectedElements uint64, falsePositiveRate float64) Option {
+	return func(opt *Options) {
+		opt.BloomExpectedElements = expectedElements
+		opt.BloomFalsePositiveRate = falsePositiveRate
+	}
+}
+
 func WithMemCacheSize(memCacheSize int) Option {
 	return func(opt *Options) {
 		opt.MemCacheSize = memCacheSize
@@ -169,6 +278,63 @@ func WithSyncInterval(interval time.Duration) Option {
 	}
 }
 
+func WithDurability(durability DurabilityMode) Option {
+	return func(opt *Options) {
+		opt.Durability = durability
+	}
+}
+
+func WithRecoveryPolicy(policy RecoveryPolicy) Option {
+	return func(opt *Options) {
+		opt.Recovery = policy
+	}
+}
+
+func WithCompression(compression CompressionType, threshold int) Option {
+	return func(opt *Options) {
+		opt.Compression = compression
+		opt.CompressThreshold = threshold
+	}
+}
+
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(opt *Options) {
+		opt.KeyProvider = provider
+	}
+}
+
+// WithEncryptionKey 使用单个固定密钥加密
+func WithEncryptionKey(key []byte) Option {
+	return func(opt *Options) {
+		opt.KeyProvider = &StaticKeyProvider{ID: 1, Secret: key}
+	}
+}
+
+func WithMmapReads(mmapReads bool, budget int64) Option {
+	return func(opt *Options) {
+		opt.MmapReads = mmapReads
+		opt.MmapBudget = budget
+	}
+}
+
+func WithWriteLanes(lanes int) Option {
+	return func(opt *Options) {
+		opt.WriteLanes = lanes
+	}
+}
+
+func WithBlobThreshold(threshold int) Option {
+	return func(opt *Options) {
+		opt.BlobThreshold = threshold
+	}
+}
+
+func WithBlobGCRatio(ratio float64) Option {
+	return func(opt *Options) {
+		opt.BlobGCRatio = ratio
+	}
+}
+
 func WithAutoMerge(autoMerge bool) Option {
 	return func(opt *Options) {
 		opt.AutoMerge = autoMerge
@@ -186,3 +352,11 @@ func WithMinMergeRatio(minMergeRatio float64) Option {
 		opt.MinMergeRatio = minMergeRatio
 	}
 }
+
+// WithScrubber 启动后台校验，每隔 interval 按不超过 rate 字节/秒的速度校验所有已封存的数据文件
+func WithScrubber(interval time.Duration, rate int64) Option {
+	return func(opt *Options) {
+		opt.ScrubInterval = interval
+		opt.ScrubRate = rate
+	}
+}
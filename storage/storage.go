@@ -1,16 +1,97 @@
 package storage
 
+import "fmt"
+
 var (
 	FilePrefix = "data-"
 	FileSuffix = ".flog"
+	// HintSuffix hint 文件后缀，hint 文件与数据文件同名
+	HintSuffix = ".hint"
+	// BlobFilePrefix/BlobFileSuffix 大值分离存储的 blob 文件名前后缀
+	BlobFilePrefix = "blob-"
+	BlobFileSuffix = ".fblob"
 	// HeaderSize 记录头部大小: timestamp(8) + flags(4) + keyLen(4) + valueLen(4) = 20 bytes
 	HeaderSize = 20
+	// SeqSize 带 FlagSeq 标记的记录在头部之后紧跟 8 字节序列号
+	SeqSize = 8
 	// MaxKeySize 键最大长度 32MB
 	MaxKeySize = 32 << 20
 	// MaxValueSize 值最大长度 32MB
 	MaxValueSize = 32 << 20
 )
 
+// Record.Flags 的位布局: 低 8 位为记录类型，第 8 位为批量写入标记，第 12~15 位为值的压缩编码，第 16 位为加密标记，
+// 第 17 位表示头部带有序列号，仅出现在磁盘格式中，解码后体现为 Record.Seq；
+// 第 18 位表示值存放在 blob 文件中，记录的值为指向它的 blob 指针；
+// 第 19 位表示值分块存放在 blob 文件中，记录的值为分块清单
+const (
+	FlagTypeMask   uint32 = 0xff
+	FlagBatch      uint32 = 1 << 8
+	FlagCodecShift        = 12
+	FlagCodecMask  uint32 = 0xf << FlagCodecShift
+	FlagEncrypted  uint32 = 1 << 16
+	FlagSeq        uint32 = 1 << 17
+	FlagBlob       uint32 = 1 << 18
+	FlagChunked    uint32 = 1 << 19
+)
+
+// RecordHeaderSize 按 Flags 返回记录头部（含序列号）的长度
+func RecordHeaderSize(flags uint32) int {
+	if flags&FlagSeq != 0 {
+		return HeaderSize + SeqSize
+	}
+	return HeaderSize
+}
+
+// RecordType 从 Flags 中取出记录类型
+func RecordType(flags uint32) uint32 {
+	return flags & FlagTypeMask
+}
+
+// HasBlobRef 记录的值是否为 blob 指针或分块清单
+func HasBlobRef(flags uint32) bool {
+	return flags&(FlagBlob|FlagChunked) != 0
+}
+
+// CodecOf 从 Flags 中取出值的压缩编码
+func CodecOf(flags uint32) CodecID {
+	return CodecID((flags & FlagCodecMask) >> FlagCodecShift)
+}
+
+// CodecID 写入记录头部的压缩编码编号
+type CodecID uint8
+
+const (
+	CodecNone CodecID = iota
+	CodecFlate
+	CodecZlib
+)
+
+// KeyProvider 提供静态加密密钥，密钥长度须为 16、24 或 32 字节（AES-128/192/256）
+type KeyProvider interface {
+	// CurrentKey 返回用于加密新数据的密钥及其编号
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key 按编号返回密钥，用于解密旧数据；密钥轮换后旧密钥须在合并重写完成前保持可用
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider 只有一个密钥的 KeyProvider
+type StaticKeyProvider struct {
+	ID     uint32
+	Secret []byte
+}
+
+func (p *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	return p.ID, p.Secret, nil
+}
+
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	if id != p.ID {
+		return nil, fmt.Errorf("unknown encryption key id %d", id)
+	}
+	return p.Secret, nil
+}
+
 type Storage[KeyType comparable, ValueType any] interface {
 	Open(opts ...Options) (Storage[KeyType, ValueType], error)
 	Put(key KeyType, value ValueType) error
@@ -31,9 +112,35 @@ type MemIndex[KeyType comparable, ValueType any] interface {
 	Clear() error
 }
 
+// OrderedMemIndex 支持按键序遍历的内存索引
+type OrderedMemIndex[KeyType comparable, ValueType any] interface {
+	MemIndex[KeyType, ValueType]
+	// AscendGreaterOrEqual 从第一个不小于 pivot 的键开始按升序遍历，f 返回 false 时停止
+	AscendGreaterOrEqual(pivot KeyType, f func(key KeyType, value ValueType) bool) error
+	// DescendLessOrEqual 从最后一个不大于 pivot 的键开始按降序遍历，f 返回 false 时停止
+	DescendLessOrEqual(pivot KeyType, f func(key KeyType, value ValueType) bool) error
+	// Descend 从最大的键开始按降序遍历，f 返回 false 时停止
+	Descend(f func(key KeyType, value ValueType) bool) error
+}
+
 type MemCache[KeyType comparable, ValueType any] interface {
 	Insert(key KeyType, value ValueType) error
 	Find(key KeyType) (ValueType, error)
 	Delete(key KeyType) error
 	Exist(key KeyType) bool
 }
+
+// CacheStats 内存缓存的命中、未命中与淘汰计数及当前占用
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// StatsMemCache 可以报告统计信息的内存缓存
+type StatsMemCache[KeyType comparable, ValueType any] interface {
+	MemCache[KeyType, ValueType]
+	Stats() CacheStats
+}
@@ -13,6 +13,7 @@ type KVItem struct {
 
 type Record struct {
 	Timestamp int64
+	Seq       uint64 // 写入序列号，单调递增；旧格式的记录为 0
 	Checksum  uint64
 	Flags     uint32
 	KVItem
@@ -23,6 +24,7 @@ type Entry struct {
 	Offset    int64
 	Size      uint32
 	Timestamp int64
+	Seq       uint64
 }
 
 type DataFile struct {
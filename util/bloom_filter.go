@@ -24,14 +24,23 @@ const (
 
 // ShardedBloomFilter 分片布隆过滤器
 type ShardedBloomFilter struct {
-	shards    []shard    // 存储分片数据
-	k         uint32     // hash函数个数
-	m         uint64     // 总bit数
-	n         uint64     // 已插入元素个数(原子操作)
-	shardMask uint32     // 分片掩码
-	shardBits uint32     // 每个分片的bit数
-	hashPool  *sync.Pool // hash函数池
-	autoScale bool       // 是否自动扩容
+	shards    []shard      // 存储分片数据
+	k         uint32       // hash函数个数
+	m         uint64       // 总bit数
+	n         uint64       // 已插入元素个数(原子操作)
+	shardMask uint32       // 分片掩码
+	shardBits uint32       // 每个分片的bit数
+	hashPool  *sync.Pool   // hash函数池
+	autoScale bool         // 是否自动扩容
+	frozen    []bloomLayer // 扩容前的分片，只读，查询时逐层检查
+	mu        sync.RWMutex // 扩容时持有写锁
+}
+
+// bloomLayer 扩容前的一层分片
+type bloomLayer struct {
+	shards    []shard
+	shardMask uint32
+	shardBits uint32
 }
 
 // shard 单个分片
@@ -153,12 +162,14 @@ func (bf *ShardedBloomFilter) Add(data []byte) error {
 	}
 
 	// 检查是否需要扩容
-	if bf.autoScale && float64(atomic.LoadUint64(&bf.n))/float64(bf.m) > growthThreshold {
+	if bf.autoScale && bf.overfilled() {
 		if err := bf.grow(); err != nil {
 			return fmt.Errorf("bloom filter grow failed: %v", err)
 		}
 	}
 
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
 	hashValues := bf.hashValues(data)
 	for i := uint32(0); i < bf.k; i++ {
 		shardIndex := hashValues[i] & uint64(bf.shardMask)
@@ -180,12 +191,28 @@ func (bf *ShardedBloomFilter) Contains(data []byte) bool {
 		return false
 	}
 
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
 	hashValues := bf.hashValues(data)
-	for i := uint32(0); i < bf.k; i++ {
-		shardIndex := hashValues[i] & uint64(bf.shardMask)
-		bitIndex := (hashValues[i] >> bf.k) % uint64(bf.shardBits)
+	if layerContains(bf.shards, bf.shardMask, bf.shardBits, bf.k, hashValues) {
+		return true
+	}
+	// 扩容前添加的元素只存在于旧的分片中
+	for _, l := range bf.frozen {
+		if layerContains(l.shards, l.shardMask, l.shardBits, bf.k, hashValues) {
+			return true
+		}
+	}
+	return false
+}
 
-		shard := &bf.shards[shardIndex]
+// layerContains 判断元素的所有 bit 是否都在一层分片中置位
+func layerContains(shards []shard, shardMask, shardBits, k uint32, hashValues []uint64) bool {
+	for i := uint32(0); i < k; i++ {
+		shardIndex := hashValues[i] & uint64(shardMask)
+		bitIndex := (hashValues[i] >> k) % uint64(shardBits)
+
+		shard := &shards[shardIndex]
 		shard.RLock()
 		isSet := (shard.bits[bitIndex/64] & (1 << (bitIndex % 64))) != 0
 		shard.RUnlock()
@@ -198,7 +225,15 @@ func (bf *ShardedBloomFilter) Contains(data []byte) bool {
 }
 
 // grow 扩容操作
+// bit 的位置由分片数与分片大小决定，已有的 bit 无法搬到新的分片中，旧分片保留为只读的一层供查询
 func (bf *ShardedBloomFilter) grow() error {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	// 并发添加可能已经触发过扩容
+	if float64(atomic.LoadUint64(&bf.n))/float64(bf.m) <= growthThreshold {
+		return nil
+	}
+
 	// 创建新的分片数组
 	newShardCount := uint32(len(bf.shards) * growthFactor)
 	newShardBits := bf.shardBits * growthFactor
@@ -208,15 +243,11 @@ func (bf *ShardedBloomFilter) grow() error {
 		newShards[i].bits = make([]uint64, newShardBits/64)
 	}
 
-	// 复制旧数据
-	for i := 0; i < len(bf.shards); i++ {
-		shard := &bf.shards[i]
-		shard.Lock()
-		shard.bits = make([]uint64, newShardBits/64)
-		shard.Unlock()
-	}
-
-	// 原子更新
+	bf.frozen = append(bf.frozen, bloomLayer{
+		shards:    bf.shards,
+		shardMask: bf.shardMask,
+		shardBits: bf.shardBits,
+	})
 	bf.shards = newShards
 	bf.m = uint64(newShardCount) * uint64(newShardBits)
 	bf.shardMask = newShardCount - 1
@@ -225,6 +256,13 @@ func (bf *ShardedBloomFilter) grow() error {
 	return nil
 }
 
+// overfilled 填充率是否超过扩容阈值
+func (bf *ShardedBloomFilter) overfilled() bool {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	return float64(atomic.LoadUint64(&bf.n))/float64(bf.m) > growthThreshold
+}
+
 // hashValues 计算hash值
 func (bf *ShardedBloomFilter) hashValues(data []byte) []uint64 {
 	hashFunc := bf.hashPool.Get().(hash.Hash64)
@@ -243,6 +281,8 @@ func (bf *ShardedBloomFilter) hashValues(data []byte) []uint64 {
 
 // Stats 获取统计信息
 func (bf *ShardedBloomFilter) Stats() map[string]interface{} {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
 	currentN := atomic.LoadUint64(&bf.n)
 	return map[string]interface{}{
 		"total_bits":        bf.m,
@@ -266,6 +306,9 @@ func (bf *ShardedBloomFilter) estimateFPP(n uint64) float64 {
 
 // Reset 重置布隆过滤器
 func (bf *ShardedBloomFilter) Reset() {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	bf.frozen = nil
 	atomic.StoreUint64(&bf.n, 0)
 	for i := range bf.shards {
 		bf.shards[i].Lock()
@@ -307,3 +307,26 @@ func TestHelperFunctions(t *testing.T) {
 		})
 	}
 }
+
+func TestShardedBloomFilter_GrowKeepsElements(t *testing.T) {
+	bf, _ := NewShardedBloomFilter(BloomConfig{
+		ExpectedElements:  100,
+		FalsePositiveRate: 0.01,
+		AutoScale:         true,
+	})
+
+	initialShards := len(bf.shards)
+	for i := 0; i < 5000; i++ {
+		_ = bf.Add([]byte(strconv.Itoa(i)))
+	}
+	if len(bf.shards) <= initialShards {
+		t.Fatalf("Expected growth, shards = %d", len(bf.shards))
+	}
+
+	// 扩容前添加的元素不能被误判为不存在
+	for i := 0; i < 5000; i++ {
+		if !bf.Contains([]byte(strconv.Itoa(i))) {
+			t.Fatalf("element %d lost after grow", i)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// 每个计数器 4 位，一个 uint64 存 16 个计数器
+	countersPerWord = 16
+	// 计数器上限，达到后不再增减，避免溢出导致误删
+	maxCounter = 15
+
+	countingMagic   = "FCBF"
+	countingVersion = 1
+	// 魔数、版本、hash 函数个数、分片数、每分片计数器数、元素个数、预期元素数、误判率
+	countingHeaderSize = 4 + 4 + 4 + 4 + 4 + 8 + 8 + 8
+)
+
+var ErrCorruptFilter = errors.New("corrupt counting bloom filter")
+
+// CountingBloomFilter 支持删除的计数布隆过滤器
+// 每个位置是 4 位计数器，添加时加一、删除时减一，计数器饱和后保持不变；
+// 同一元素的所有计数器落在同一个分片，一次操作只需一把锁。
+// 不会自动扩容，元素数明显超过预期时应按实际数量重建
+type CountingBloomFilter struct {
+	shards    []countingShard
+	k         uint32
+	shardMask uint32
+	shardSize uint32 // 每个分片的计数器数
+	n         atomic.Int64
+	expected  uint64
+	fpr       float64
+}
+
+type countingShard struct {
+	words []uint64
+	sync.RWMutex
+}
+
+// NewCountingBloomFilter 按预期元素数量与误判率创建计数布隆过滤器，NumShards 为 0 时使用默认分片数
+func NewCountingBloomFilter(opts BloomConfig) (*CountingBloomFilter, error) {
+	if err := validateOptions(&opts); err != nil {
+		return nil, err
+	}
+
+	m := calculateOptimalM(opts.ExpectedElements, opts.FalsePositiveRate)
+	k := calculateOptimalK(opts.ExpectedElements, m)
+	if opts.NumHashFuncs > 0 {
+		k = opts.NumHashFuncs
+	}
+	numShards := uint64(opts.NumShards)
+	if numShards == 0 {
+		numShards = defaultShards
+	}
+	numShards = nextPowerOf2(numShards)
+
+	// 每个分片的计数器数向上取整到整字
+	words := (m/numShards + countersPerWord - 1) / countersPerWord
+	return newCountingBloomFilter(k, uint32(numShards), uint32(max(words, 1)*countersPerWord), opts), nil
+}
+
+func newCountingBloomFilter(k, numShards, shardSize uint32, opts BloomConfig) *CountingBloomFilter {
+	bf := &CountingBloomFilter{
+		shards:    make([]countingShard, numShards),
+		k:         k,
+		shardMask: numShards - 1,
+		shardSize: shardSize,
+		expected:  opts.ExpectedElements,
+		fpr:       opts.FalsePositiveRate,
+	}
+	for i := range bf.shards {
+		bf.shards[i].words = make([]uint64, shardSize/countersPerWord)
+	}
+	return bf
+}
+
+// Add 添加元素，同一元素添加几次就需要删除几次
+func (bf *CountingBloomFilter) Add(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty data")
+	}
+	s, h1, h2 := bf.locate(data)
+	s.Lock()
+	for i := uint32(0); i < bf.k; i++ {
+		idx := (h1 + i*h2) % bf.shardSize
+		if counter(s.words, idx) < maxCounter {
+			s.words[idx/countersPerWord] += 1 << (idx % countersPerWord * 4)
+		}
+	}
+	s.Unlock()
+	bf.n.Add(1)
+	return nil
+}
+
+// Remove 删除之前添加过的元素，删除未添加的元素会导致其他元素被误判为不存在
+func (bf *CountingBloomFilter) Remove(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty data")
+	}
+	s, h1, h2 := bf.locate(data)
+	s.Lock()
+	defer s.Unlock()
+	// 先确认所有计数器都不为零，避免删除未添加的元素时破坏计数
+	for i := uint32(0); i < bf.k; i++ {
+		if counter(s.words, (h1+i*h2)%bf.shardSize) == 0 {
+			return fmt.Errorf("element not in filter")
+		}
+	}
+	for i := uint32(0); i < bf.k; i++ {
+		idx := (h1 + i*h2) % bf.shardSize
+		if counter(s.words, idx) < maxCounter {
+			s.words[idx/countersPerWord] -= 1 << (idx % countersPerWord * 4)
+		}
+	}
+	bf.n.Add(-1)
+	return nil
+}
+
+// Contains 判断元素是否可能存在
+func (bf *CountingBloomFilter) Contains(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	s, h1, h2 := bf.locate(data)
+	s.RLock()
+	defer s.RUnlock()
+	for i := uint32(0); i < bf.k; i++ {
+		if counter(s.words, (h1+i*h2)%bf.shardSize) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len 返回当前元素个数
+func (bf *CountingBloomFilter) Len() int64 {
+	return bf.n.Load()
+}
+
+// Reset 清空过滤器
+func (bf *CountingBloomFilter) Reset() {
+	for i := range bf.shards {
+		bf.shards[i].Lock()
+		clear(bf.shards[i].words)
+		bf.shards[i].Unlock()
+	}
+	bf.n.Store(0)
+}
+
+// Stats 获取统计信息
+func (bf *CountingBloomFilter) Stats() map[string]interface{} {
+	n := bf.n.Load()
+	m := uint64(len(bf.shards)) * uint64(bf.shardSize)
+	return map[string]interface{}{
+		"total_counters":     m,
+		"num_items":          n,
+		"num_shards":         len(bf.shards),
+		"counters_per_shard": bf.shardSize,
+		"num_hash_funcs":     bf.k,
+		"expected_elements":  bf.expected,
+		"estimated_fpp":      math.Pow(1-math.Exp(-float64(bf.k)*float64(n)/float64(m)), float64(bf.k)),
+	}
+}
+
+// MarshalBinary 序列化过滤器，末尾附带 CRC32 校验
+func (bf *CountingBloomFilter) MarshalBinary() ([]byte, error) {
+	words := len(bf.shards) * int(bf.shardSize/countersPerWord)
+	buf := make([]byte, countingHeaderSize, countingHeaderSize+words*8+4)
+	copy(buf, countingMagic)
+	binary.LittleEndian.PutUint32(buf[4:], countingVersion)
+	binary.LittleEndian.PutUint32(buf[8:], bf.k)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(bf.shards)))
+	binary.LittleEndian.PutUint32(buf[16:], bf.shardSize)
+	binary.LittleEndian.PutUint64(buf[20:], uint64(bf.n.Load()))
+	binary.LittleEndian.PutUint64(buf[28:], bf.expected)
+	binary.LittleEndian.PutUint64(buf[36:], math.Float64bits(bf.fpr))
+	for i := range bf.shards {
+		s := &bf.shards[i]
+		s.RLock()
+		for _, w := range s.words {
+			buf = binary.LittleEndian.AppendUint64(buf, w)
+		}
+		s.RUnlock()
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+// UnmarshalBinary 从 MarshalBinary 的结果恢复过滤器，数据损坏时返回 ErrCorruptFilter
+func (bf *CountingBloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < countingHeaderSize+4 || string(data[:4]) != countingMagic {
+		return ErrCorruptFilter
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return ErrCorruptFilter
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != countingVersion {
+		return fmt.Errorf("unsupported counting bloom filter version %d", v)
+	}
+
+	k := binary.LittleEndian.Uint32(data[8:])
+	numShards := binary.LittleEndian.Uint32(data[12:])
+	shardSize := binary.LittleEndian.Uint32(data[16:])
+	if k == 0 || !isPowerOfTwo(uint64(numShards)) || shardSize == 0 || shardSize%countersPerWord != 0 ||
+		uint64(len(body)-countingHeaderSize) != uint64(numShards)*uint64(shardSize/countersPerWord)*8 {
+		return ErrCorruptFilter
+	}
+
+	restored := newCountingBloomFilter(k, numShards, shardSize, BloomConfig{
+		ExpectedElements:  binary.LittleEndian.Uint64(data[28:]),
+		FalsePositiveRate: math.Float64frombits(binary.LittleEndian.Uint64(data[36:])),
+	})
+	off := countingHeaderSize
+	for i := range restored.shards {
+		for j := range restored.shards[i].words {
+			restored.shards[i].words[j] = binary.LittleEndian.Uint64(data[off:])
+			off += 8
+		}
+	}
+
+	bf.shards, bf.k, bf.shardMask, bf.shardSize = restored.shards, k, numShards-1, shardSize
+	bf.expected, bf.fpr = restored.expected, restored.fpr
+	bf.n.Store(int64(binary.LittleEndian.Uint64(data[20:])))
+	return nil
+}
+
+// Config 返回创建过滤器时的预期元素数量与误判率
+func (bf *CountingBloomFilter) Config() (expected uint64, fpr float64) {
+	return bf.expected, bf.fpr
+}
+
+// locate 返回元素所在的分片与双重哈希的两个基数
+func (bf *CountingBloomFilter) locate(data []byte) (*countingShard, uint32, uint32) {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	sum := h.Sum64()
+	// 分片用低位，两个基数取自混合后的高位，避免与分片编号相关
+	mixed := sum * 0x9e3779b97f4a7c15
+	return &bf.shards[uint32(sum)&bf.shardMask], uint32(mixed >> 32), uint32(mixed) | 1
+}
+
+// counter 读取第 idx 个计数器
+func counter(words []uint64, idx uint32) uint64 {
+	return words[idx/countersPerWord] >> (idx % countersPerWord * 4) & 0x0f
+}
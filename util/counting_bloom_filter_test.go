@@ -0,0 +1,112 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestCountingBloomFilter_AddRemove(t *testing.T) {
+	bf, err := NewCountingBloomFilter(BloomConfig{
+		ExpectedElements:  1000,
+		FalsePositiveRate: 0.01,
+	})
+	if err != nil {
+		t.Fatalf("NewCountingBloomFilter() error = %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		_ = bf.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 0; i < 500; i++ {
+		if err := bf.Remove([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Remove(%d) error = %v", i, err)
+		}
+	}
+	if bf.Len() != 500 {
+		t.Errorf("Len() = %d, want 500", bf.Len())
+	}
+
+	// 剩余元素都必须存在，已删除的元素绝大多数应判为不存在
+	for i := 500; i < 1000; i++ {
+		if !bf.Contains([]byte(strconv.Itoa(i))) {
+			t.Fatalf("element %d missing after removing others", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 500; i++ {
+		if bf.Contains([]byte(strconv.Itoa(i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 25 {
+		t.Errorf("%d of 500 removed elements still reported present", falsePositives)
+	}
+
+	if absent := []byte("never-added"); !bf.Contains(absent) && bf.Remove(absent) == nil {
+		t.Errorf("Remove of absent element should fail")
+	}
+	if bf.Add(nil) == nil || bf.Remove(nil) == nil || bf.Contains(nil) {
+		t.Errorf("empty data should be rejected")
+	}
+}
+
+func TestCountingBloomFilter_Marshal(t *testing.T) {
+	bf, _ := NewCountingBloomFilter(BloomConfig{
+		ExpectedElements:  1000,
+		FalsePositiveRate: 0.01,
+		NumShards:         4,
+	})
+	for i := 0; i < 300; i++ {
+		_ = bf.Add([]byte(strconv.Itoa(i)))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+	var restored CountingBloomFilter
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+	if restored.Len() != 300 {
+		t.Errorf("Len() = %d, want 300", restored.Len())
+	}
+	if expected, fpr := restored.Config(); expected != 1000 || fpr != 0.01 {
+		t.Errorf("Config() = %d, %v", expected, fpr)
+	}
+	for i := 0; i < 300; i++ {
+		if !restored.Contains([]byte(strconv.Itoa(i))) {
+			t.Fatalf("element %d missing after unmarshal", i)
+		}
+	}
+	if err := restored.Remove([]byte("7")); err != nil || restored.Len() != 299 {
+		t.Errorf("Remove after unmarshal failed: %v, Len() = %d", err, restored.Len())
+	}
+
+	data[len(data)/2] ^= 0xff
+	if err := restored.UnmarshalBinary(data); !errors.Is(err, ErrCorruptFilter) {
+		t.Errorf("UnmarshalBinary() on corrupt data error = %v, want ErrCorruptFilter", err)
+	}
+	if err := restored.UnmarshalBinary(data[:10]); !errors.Is(err, ErrCorruptFilter) {
+		t.Errorf("UnmarshalBinary() on short data error = %v, want ErrCorruptFilter", err)
+	}
+}
+
+func TestCountingBloomFilter_Saturation(t *testing.T) {
+	bf, _ := NewCountingBloomFilter(BloomConfig{
+		ExpectedElements:  10,
+		FalsePositiveRate: 0.01,
+	})
+
+	// 计数器饱和后不再减少，多删几次也不会出现假阴性
+	for i := 0; i < 20; i++ {
+		_ = bf.Add([]byte("hot"))
+	}
+	for i := 0; i < 19; i++ {
+		_ = bf.Remove([]byte("hot"))
+	}
+	if !bf.Contains([]byte("hot")) {
+		t.Errorf("saturated element reported absent")
+	}
+}